@@ -0,0 +1,199 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Bext is the Broadcast Wave Format (BWF) bext chunk of a WAV file,
+// as specified by EBU Tech 3285.
+//
+// Bext maps to and from Vorbis comments with keys prefixed by BWF_,
+// so that broadcast metadata survives WAV to FLAC to WAV conversion.
+type Bext struct {
+	Description         string
+	Originator          string
+	OriginatorReference string
+	// OriginationDate is formatted yyyy-mm-dd.
+	OriginationDate string
+	// OriginationTime is formatted hh:mm:ss.
+	OriginationTime string
+	// TimeReference is the sample count since midnight of the first sample.
+	TimeReference uint64
+	Version       uint16
+	// UMID is the SMPTE unique material identifier.
+	UMID [64]byte
+	// The loudness fields, present in version 2, are in hundredths of a unit.
+	LoudnessValue        int16
+	LoudnessRange        int16
+	MaxTruePeakLevel     int16
+	MaxMomentaryLoudness int16
+	MaxShortTermLoudness int16
+	CodingHistory        string
+}
+
+// BextChunkID is the ID of the bext chunk.
+const BextChunkID = "bext"
+
+// bextFixedSize is the size of the fixed-length part of a bext chunk.
+const bextFixedSize = 602
+
+// ParseBext parses the body of a bext chunk.
+func ParseBext(chunk []byte) (*Bext, error) {
+	if len(chunk) < bextFixedSize {
+		return nil, errors.New("bext chunk is too short")
+	}
+	le := binary.LittleEndian
+	b := &Bext{
+		Description:          fixedString(chunk[0:256]),
+		Originator:           fixedString(chunk[256:288]),
+		OriginatorReference:  fixedString(chunk[288:320]),
+		OriginationDate:      fixedString(chunk[320:330]),
+		OriginationTime:      fixedString(chunk[330:338]),
+		TimeReference:        uint64(le.Uint32(chunk[338:])) | uint64(le.Uint32(chunk[342:]))<<32,
+		Version:              le.Uint16(chunk[346:]),
+		LoudnessValue:        int16(le.Uint16(chunk[412:])),
+		LoudnessRange:        int16(le.Uint16(chunk[414:])),
+		MaxTruePeakLevel:     int16(le.Uint16(chunk[416:])),
+		MaxMomentaryLoudness: int16(le.Uint16(chunk[418:])),
+		MaxShortTermLoudness: int16(le.Uint16(chunk[420:])),
+		CodingHistory:        fixedString(chunk[bextFixedSize:]),
+	}
+	copy(b.UMID[:], chunk[348:412])
+	return b, nil
+}
+
+// Chunk returns the body of the bext chunk, not including the chunk ID and size.
+func (b *Bext) Chunk() []byte {
+	chunk := make([]byte, bextFixedSize, bextFixedSize+len(b.CodingHistory))
+	le := binary.LittleEndian
+	copy(chunk[0:256], b.Description)
+	copy(chunk[256:288], b.Originator)
+	copy(chunk[288:320], b.OriginatorReference)
+	copy(chunk[320:330], b.OriginationDate)
+	copy(chunk[330:338], b.OriginationTime)
+	le.PutUint32(chunk[338:], uint32(b.TimeReference))
+	le.PutUint32(chunk[342:], uint32(b.TimeReference>>32))
+	le.PutUint16(chunk[346:], b.Version)
+	copy(chunk[348:412], b.UMID[:])
+	le.PutUint16(chunk[412:], uint16(b.LoudnessValue))
+	le.PutUint16(chunk[414:], uint16(b.LoudnessRange))
+	le.PutUint16(chunk[416:], uint16(b.MaxTruePeakLevel))
+	le.PutUint16(chunk[418:], uint16(b.MaxMomentaryLoudness))
+	le.PutUint16(chunk[420:], uint16(b.MaxShortTermLoudness))
+	return append(chunk, b.CodingHistory...)
+}
+
+// FixedString returns the string in a NUL-padded field.
+func fixedString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// Comments returns the Vorbis comments representing b.
+// Empty and zero fields are omitted.
+func (b *Bext) Comments() []string {
+	var cs []string
+	add := func(key, value string) {
+		if value != "" {
+			cs = append(cs, key+"="+value)
+		}
+	}
+	addInt := func(key string, value int64) {
+		if value != 0 {
+			add(key, strconv.FormatInt(value, 10))
+		}
+	}
+	add("BWF_DESCRIPTION", b.Description)
+	add("BWF_ORIGINATOR", b.Originator)
+	add("BWF_ORIGINATOR_REFERENCE", b.OriginatorReference)
+	add("BWF_ORIGINATION_DATE", b.OriginationDate)
+	add("BWF_ORIGINATION_TIME", b.OriginationTime)
+	add("BWF_TIME_REFERENCE", strconv.FormatUint(b.TimeReference, 10))
+	add("BWF_VERSION", strconv.Itoa(int(b.Version)))
+	if b.UMID != ([64]byte{}) {
+		add("BWF_UMID", hex.EncodeToString(b.UMID[:]))
+	}
+	addInt("BWF_LOUDNESS_VALUE", int64(b.LoudnessValue))
+	addInt("BWF_LOUDNESS_RANGE", int64(b.LoudnessRange))
+	addInt("BWF_MAX_TRUE_PEAK_LEVEL", int64(b.MaxTruePeakLevel))
+	addInt("BWF_MAX_MOMENTARY_LOUDNESS", int64(b.MaxMomentaryLoudness))
+	addInt("BWF_MAX_SHORT_TERM_LOUDNESS", int64(b.MaxShortTermLoudness))
+	add("BWF_CODING_HISTORY", b.CodingHistory)
+	return cs
+}
+
+// BextFromComments returns the Bext represented by the BWF_ comments of c.
+// If c has no BWF_ comments then nil is returned.
+func BextFromComments(c *VorbisComment) (*Bext, error) {
+	if c == nil {
+		return nil, nil
+	}
+	var b Bext
+	var found bool
+	for _, cmnt := range c.Comments {
+		i := strings.IndexByte(cmnt, '=')
+		if i < 0 || !strings.HasPrefix(strings.ToUpper(cmnt[:i]), "BWF_") {
+			continue
+		}
+		found = true
+		key, value := strings.ToUpper(cmnt[:i]), cmnt[i+1:]
+		var err error
+		switch key {
+		case "BWF_DESCRIPTION":
+			b.Description = value
+		case "BWF_ORIGINATOR":
+			b.Originator = value
+		case "BWF_ORIGINATOR_REFERENCE":
+			b.OriginatorReference = value
+		case "BWF_ORIGINATION_DATE":
+			b.OriginationDate = value
+		case "BWF_ORIGINATION_TIME":
+			b.OriginationTime = value
+		case "BWF_TIME_REFERENCE":
+			b.TimeReference, err = strconv.ParseUint(value, 10, 64)
+		case "BWF_VERSION":
+			var v uint64
+			v, err = strconv.ParseUint(value, 10, 16)
+			b.Version = uint16(v)
+		case "BWF_UMID":
+			var umid []byte
+			if umid, err = hex.DecodeString(value); err == nil && len(umid) > len(b.UMID) {
+				err = errors.New("too long")
+			}
+			copy(b.UMID[:], umid)
+		case "BWF_LOUDNESS_VALUE":
+			b.LoudnessValue, err = parseInt16(value)
+		case "BWF_LOUDNESS_RANGE":
+			b.LoudnessRange, err = parseInt16(value)
+		case "BWF_MAX_TRUE_PEAK_LEVEL":
+			b.MaxTruePeakLevel, err = parseInt16(value)
+		case "BWF_MAX_MOMENTARY_LOUDNESS":
+			b.MaxMomentaryLoudness, err = parseInt16(value)
+		case "BWF_MAX_SHORT_TERM_LOUDNESS":
+			b.MaxShortTermLoudness, err = parseInt16(value)
+		case "BWF_CODING_HISTORY":
+			b.CodingHistory = value
+		}
+		if err != nil {
+			return nil, errors.New("Bad " + key + " comment: " + err.Error())
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+	return &b, nil
+}
+
+func parseInt16(s string) (int16, error) {
+	v, err := strconv.ParseInt(s, 10, 16)
+	return int16(v), err
+}
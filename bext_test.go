@@ -0,0 +1,28 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import "testing"
+
+func TestBextComments(t *testing.T) {
+	b := &Bext{
+		Description:     "Interview",
+		Originator:      "Studio 2",
+		OriginationDate: "2014-01-02",
+		OriginationTime: "03:04:05",
+		TimeReference:   1 << 33,
+		Version:         1,
+		CodingHistory:   "A=PCM,F=48000,W=24,M=stereo\r\n",
+	}
+	b.UMID[0] = 0x06
+	got, err := BextFromComments(&VorbisComment{Comments: b.Comments()})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if *got != *b {
+		t.Errorf("Expected %+v, got %+v", b, got)
+	}
+	if got, err = ParseBext(b.Chunk()); err != nil || *got != *b {
+		t.Errorf("Expected %+v, got %+v, %v", b, got, err)
+	}
+}
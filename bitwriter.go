@@ -0,0 +1,62 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+// A bitWriter accumulates a big-endian stream of bits.
+type bitWriter struct {
+	buf []byte
+	// Acc holds the n most recently written bits that do not yet fill a byte.
+	acc uint64
+	n   uint
+}
+
+// Write writes the low bits of v.
+func (w *bitWriter) write(v uint64, bits uint) {
+	for bits > 32 {
+		bits -= 32
+		w.write(v>>bits, 32)
+	}
+	w.acc = w.acc<<bits | v&(1<<bits-1)
+	w.n += bits
+	for w.n >= 8 {
+		w.n -= 8
+		w.buf = append(w.buf, byte(w.acc>>w.n))
+	}
+}
+
+// WriteSigned writes the two's complement of v in bits.
+func (w *bitWriter) writeSigned(v int32, bits uint) {
+	w.write(uint64(uint32(v)), bits)
+}
+
+// WriteUnary writes q zeros followed by a one.
+func (w *bitWriter) writeUnary(q uint64) {
+	for ; q > 32; q -= 32 {
+		w.write(0, 32)
+	}
+	w.write(1, uint(q)+1)
+}
+
+// WriteRice writes the folded residual u with Rice parameter k.
+func (w *bitWriter) writeRice(u uint32, k uint) {
+	w.writeUnary(uint64(u >> k))
+	w.write(uint64(u), k)
+}
+
+// Align pads the stream with zeros to a byte boundary.
+func (w *bitWriter) align() {
+	if w.n > 0 {
+		w.write(0, 8-w.n)
+	}
+}
+
+// Len returns the number of bits written.
+func (w *bitWriter) len() int {
+	return 8*len(w.buf) + int(w.n)
+}
+
+// Reset empties the writer, keeping its buffer.
+func (w *bitWriter) reset() {
+	w.buf = w.buf[:0]
+	w.acc, w.n = 0, 0
+}
@@ -0,0 +1,80 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"errors"
+	"strconv"
+)
+
+// The functions and MarshalBinary methods in this file serialize metadata into
+// whole metadata blocks, including the 4-byte block header, as written by the
+// Encoder and Retag.  The last-block flag of the header is not set,
+// since it depends on where the block is written.
+
+// MarshalBinary returns the STREAMINFO block.  It returns an error if a field
+// does not fit in its number of bits in the block.
+func (s StreamInfo) MarshalBinary() ([]byte, error) {
+	switch {
+	case s.MinBlock < 0 || s.MinBlock >= 1<<16 || s.MaxBlock < 0 || s.MaxBlock >= 1<<16:
+		return nil, errors.New("Bad STREAMINFO block size bounds")
+	case s.MinFrame < 0 || s.MinFrame >= 1<<24 || s.MaxFrame < 0 || s.MaxFrame >= 1<<24:
+		return nil, errors.New("Bad STREAMINFO frame size bounds")
+	case s.SampleRate <= 0 || s.SampleRate >= 1<<20:
+		return nil, errors.New("Bad STREAMINFO sample rate " + strconv.Itoa(s.SampleRate))
+	case s.NChannels < 1 || s.NChannels > 8:
+		return nil, errors.New("Bad STREAMINFO number of channels " + strconv.Itoa(s.NChannels))
+	case s.BitsPerSample < 4 || s.BitsPerSample > 32:
+		return nil, errors.New("Bad STREAMINFO bits per sample " + strconv.Itoa(s.BitsPerSample))
+	case s.TotalSamples < 0 || s.TotalSamples >= 1<<36:
+		return nil, errors.New("Bad STREAMINFO total samples " + strconv.FormatInt(s.TotalSamples, 10))
+	}
+	return metaDataBlock(BlockStreamInfo, encodeStreamInfo(&s))
+}
+
+// MarshalBinary returns the VORBIS_COMMENT block.
+// It returns a *CommentError if the comments are not valid.
+func (c VorbisComment) MarshalBinary() ([]byte, error) {
+	body, err := encodeVorbisComment(&c)
+	if err != nil {
+		return nil, err
+	}
+	return metaDataBlock(BlockVorbisComment, body)
+}
+
+// MarshalBinary returns the APPLICATION block.
+func (a Application) MarshalBinary() ([]byte, error) {
+	return metaDataBlock(BlockApplication, encodeApplication(a))
+}
+
+// MarshalBinary returns the PICTURE block.
+func (p Picture) MarshalBinary() ([]byte, error) {
+	return metaDataBlock(BlockPicture, encodePicture(p))
+}
+
+// MarshalBinary returns the block with the header given by its Type
+// and the length of its Data.
+func (b RawBlock) MarshalBinary() ([]byte, error) {
+	if b.Type < 0 || b.Type >= invalidBlockType {
+		return nil, errors.New("Bad metadata block type " + strconv.Itoa(b.Type))
+	}
+	return metaDataBlock(BlockType(b.Type), b.Data)
+}
+
+// MarshalSeekTable returns the SEEKTABLE block with the given seek points,
+// which should be sorted by sample number, with placeholders at the end.
+func MarshalSeekTable(points []SeekPoint) ([]byte, error) {
+	return metaDataBlock(BlockSeekTable, encodeSeekTable(points))
+}
+
+// MarshalCueSheet returns the CUESHEET block for the cue sheet, describing
+// audio with the given sample rate and total number of samples.
+// At 44100 Hz the block describes a CD, with the standard 2 second lead-in
+// and at most 99 tracks; otherwise it has no lead-in.
+func MarshalCueSheet(c *CueSheet, sampleRate int, totalSamples int64) ([]byte, error) {
+	body, err := encodeCueSheet(c, sampleRate, totalSamples)
+	if err != nil {
+		return nil, err
+	}
+	return metaDataBlock(BlockCueSheet, body)
+}
@@ -0,0 +1,79 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"crypto/md5"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMarshalBlocks(t *testing.T) {
+	info := StreamInfo{MinBlock: 4096, MaxBlock: 4096, MinFrame: 10, MaxFrame: 9000, SampleRate: 44100, NChannels: 2, BitsPerSample: 16, TotalSamples: 123456, MD5: [md5.Size]byte{1, 2, 3}}
+	cmnt := VorbisComment{Vendor: "test", Comments: []string{"TITLE=blocks"}}
+	app := Application{ID: [4]byte{'t', 'e', 's', 't'}, Data: []byte{1, 2, 3}}
+	pic := Picture{Type: PictureFrontCover, MIME: "image/png", Description: "front", Width: 1, Height: 2, Depth: 24, Data: []byte{4, 5}}
+	points := []SeekPoint{{Sample: 0, Offset: 0, Samples: 4096}, {Sample: PlaceholderPoint}}
+	cue, err := ParseCueSheet(strings.NewReader("FILE \"a.flac\" WAVE\n  TRACK 01 AUDIO\n    INDEX 01 00:00:00\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cueBlock, err := MarshalCueSheet(cue, 44100, 123456)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want MetaData
+	want.StreamInfo = &info
+	want.VorbisComment = &cmnt
+	want.Applications = []Application{app}
+	want.Pictures = []Picture{pic}
+	want.SeekTable = points
+	want.Others = []RawBlock{{Type: int(BlockCueSheet), Data: cueBlock[4:]}, {Type: 100, Data: []byte("other")}}
+
+	var blocks [][]byte
+	for _, m := range []interface {
+		MarshalBinary() ([]byte, error)
+	}{info, cmnt, app, pic, RawBlock{Type: 100, Data: []byte("other")}} {
+		b, err := m.MarshalBinary()
+		if err != nil {
+			t.Fatalf("%T: %v", m, err)
+		}
+		blocks = append(blocks, b)
+	}
+	seekBlock, err := MarshalSeekTable(points)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks = append(blocks[:4], append([][]byte{seekBlock, cueBlock}, blocks[4:]...)...)
+
+	var got MetaData
+	for i, b := range blocks {
+		if b[0]&0x80 != 0 || len(b)-4 != int(b[1])<<16|int(b[2])<<8|int(b[3]) {
+			t.Errorf("block %d: bad header % x", i, b[:4])
+		}
+		if _, _, err := readMetaDataBlock(bytes.NewReader(b), &got); err != nil {
+			t.Fatalf("block %d: %v", i, err)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	bad := []interface {
+		MarshalBinary() ([]byte, error)
+	}{
+		StreamInfo{SampleRate: 0, NChannels: 2, BitsPerSample: 16},
+		StreamInfo{SampleRate: 44100, NChannels: 9, BitsPerSample: 16},
+		StreamInfo{SampleRate: 44100, NChannels: 2, BitsPerSample: 16, TotalSamples: 1 << 36},
+		RawBlock{Type: invalidBlockType},
+		RawBlock{Type: 100, Data: make([]byte, 1<<24)},
+	}
+	for _, m := range bad {
+		if _, err := m.MarshalBinary(); err == nil {
+			t.Errorf("%T: expected an error", m)
+		}
+	}
+}
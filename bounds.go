@@ -0,0 +1,84 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Bounds are the minimum and maximum block sizes, in inter-channel samples,
+// and frame sizes, in bytes, of a stream.
+type Bounds struct {
+	MinBlock int
+	MaxBlock int
+	MinFrame int
+	MaxFrame int
+}
+
+// Bounds returns the block and frame size bounds observed in the frames
+// decoded so far.  Unlike the values in STREAMINFO, which some encoders
+// leave zero or set incorrectly, these are derived from the frames themselves.
+// As in STREAMINFO, MinBlock does not account for the final frame of a
+// stream with more than one frame, which may be shorter than the others.
+func (d *Decoder) Bounds() Bounds {
+	b := d.bounds
+	if b.MinBlock == 0 {
+		// At most one frame has been decoded.
+		b.MinBlock = d.lastBlock
+	}
+	return b
+}
+
+// ObserveFrame updates the observed bounds with a decoded frame,
+// warning if the frame is outside of the bounds given by STREAMINFO.
+func (d *Decoder) observeFrame(blockSize, frameSize int) {
+	// The block size of the most recent frame is only included in MinBlock once
+	// it is known not to be the final frame.
+	if d.lastBlock > 0 && (d.bounds.MinBlock == 0 || d.lastBlock < d.bounds.MinBlock) {
+		d.bounds.MinBlock = d.lastBlock
+	}
+	d.lastBlock = blockSize
+	if blockSize > d.bounds.MaxBlock {
+		d.bounds.MaxBlock = blockSize
+	}
+	if d.bounds.MinFrame == 0 || frameSize < d.bounds.MinFrame {
+		d.bounds.MinFrame = frameSize
+	}
+	if frameSize > d.bounds.MaxFrame {
+		d.bounds.MaxFrame = frameSize
+	}
+
+	if d.warnedBounds || d.opts.Warn == nil {
+		return
+	}
+	switch {
+	case d.MaxBlock > 0 && blockSize > d.MaxBlock:
+		d.warn(errors.New("Frame block size " + strconv.Itoa(blockSize) + " exceeds STREAMINFO maximum " + strconv.Itoa(d.MaxBlock)))
+	case d.MaxFrame > 0 && frameSize > d.MaxFrame:
+		d.warn(errors.New("Frame size " + strconv.Itoa(frameSize) + " exceeds STREAMINFO maximum " + strconv.Itoa(d.MaxFrame)))
+	default:
+		return
+	}
+	d.warnedBounds = true
+}
+
+// CheckBounds warns if the STREAMINFO block size bounds are zero or
+// inconsistent.  Such streams are still decoded.
+func (d *Decoder) checkBounds() {
+	switch {
+	case d.MinBlock == 0 || d.MaxBlock == 0:
+		d.warn(errors.New("STREAMINFO block size bounds are unset"))
+	case d.MinBlock > d.MaxBlock:
+		d.warn(errors.New("STREAMINFO minimum block size exceeds the maximum"))
+	case d.MinFrame > 0 && d.MaxFrame > 0 && d.MinFrame > d.MaxFrame:
+		d.warn(errors.New("STREAMINFO minimum frame size exceeds the maximum"))
+	}
+}
+
+// Warn reports a non-fatal problem with the stream to the Warn option, if set.
+func (d *Decoder) warn(err error) {
+	if d.opts.Warn != nil {
+		d.opts.Warn(err)
+	}
+}
@@ -0,0 +1,97 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"errors"
+	"io"
+)
+
+// A State is the position of a Decoder in a stream, from which decoding can
+// be resumed with ResumeDecoder, without reading the stream's header.
+// Its fields are exported so that it can be serialized, for example with
+// encoding/json, and resumed by another process.
+type State struct {
+	// Offset is the byte offset from the start of the stream
+	// of the frame holding the next sample to decode.
+	Offset int64
+	// FramesOffset is the byte offset of the first frame.
+	FramesOffset int64
+	// Frame is the number of frames decoded before the frame at Offset.
+	Frame int
+	// Sample is the number of the first sample of the frame at Offset.
+	Sample int64
+	// Skip is the number of samples of the frame at Offset
+	// that were already decoded.
+	Skip int
+	// StreamInfo is the STREAMINFO of the stream.
+	StreamInfo StreamInfo
+}
+
+// Checkpoint returns the State of the Decoder, so that the rest of the stream
+// can be decoded by a Decoder from ResumeDecoder.  With the Concatenated
+// option, the offsets are from the start of the first stream, and the State
+// only resumes the rest of the current stream.
+func (d *Decoder) Checkpoint() State {
+	s := State{
+		Offset:       d.r.n,
+		FramesOffset: d.framesOffset,
+		Frame:        d.n,
+		Sample:       d.samples,
+		StreamInfo:   *d.StreamInfo,
+	}
+	if n := d.pendingSamples(); n > 0 {
+		s.Offset = d.frameStart
+		s.Frame--
+		s.Sample -= int64(d.lastBlock)
+		s.Skip = d.lastBlock - int(n)
+	}
+	return s
+}
+
+// ResumeDecoder returns a Decoder that resumes decoding from a State returned
+// by Checkpoint.  The reader must begin at byte s.Offset of the stream,
+// for example a file seeked to that offset, or the body of an HTTP response
+// to a Range request starting there.  The MetaData of the Decoder
+// only has the STREAMINFO of the State.
+//
+// If the reader is an io.Seeker, the Decoder can seek back to the first frame.
+func ResumeDecoder(r io.Reader, s State) (*Decoder, error) {
+	if s.Offset < s.FramesOffset || s.Frame < 0 || s.Sample < 0 || s.Skip < 0 {
+		return nil, errors.New("Bad decoder state")
+	}
+	info := s.StreamInfo
+	d := &Decoder{
+		src:          r,
+		r:            &countingReader{r: r, n: s.Offset},
+		framesOffset: s.FramesOffset,
+		n:            s.Frame,
+		samples:      s.Sample,
+		MetaData:     MetaData{StreamInfo: &info},
+	}
+	if sk, ok := r.(io.Seeker); ok {
+		if cur, err := sk.Seek(0, 1); err == nil {
+			d.base = cur - s.Offset
+			d.seeker = sk
+			d.r.seeker = sk
+		}
+	}
+	if err := d.checkStreamInfo(); err != nil {
+		return nil, err
+	}
+	if s.Skip == 0 {
+		return d, nil
+	}
+	data, err := d.decodeFrame()
+	if err != nil {
+		return nil, err
+	}
+	if s.Skip >= len(data[0]) {
+		return nil, errors.New("Bad decoder state: skipping the whole frame")
+	}
+	for ch := range data {
+		data[ch] = data[ch][s.Skip:]
+	}
+	d.pending = data
+	return d, nil
+}
@@ -0,0 +1,60 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	const n = 50000
+	data := testSignal(2, n, 16)
+	stream := encodeFile(t, data, EncoderOptions{BlockSize: 4096})
+	for _, seek := range []int64{0, 4096 * 3, 10000, n - 1} {
+		d, err := NewDecoder(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.Seek(seek, 0); err != nil {
+			t.Fatal(err)
+		}
+		// Round-trip the State through JSON, as a server might.
+		b, err := json.Marshal(d.Checkpoint())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s State
+		if err := json.Unmarshal(b, &s); err != nil {
+			t.Fatal(err)
+		}
+		r := bytes.NewReader(stream[s.Offset:])
+		d, err = ResumeDecoder(r, s)
+		if err != nil {
+			t.Fatalf("Seek(%d): ResumeDecoder: %v", seek, err)
+		}
+		if d.Pos() != seek {
+			t.Errorf("Seek(%d): resumed at %d", seek, d.Pos())
+		}
+		got := make([][]int32, 2)
+		for {
+			samples, err := d.NextSamples()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("Seek(%d): NextSamples: %v", seek, err)
+			}
+			for ch := range got {
+				got[ch] = append(got[ch], samples[ch]...)
+			}
+		}
+		for ch := range got {
+			if !reflect.DeepEqual(got[ch], data[ch][seek:]) {
+				t.Errorf("Seek(%d): channel %d differs", seek, ch)
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+// Flacffp generates and verifies .ffp FLAC fingerprint files.
+//
+// Given FLAC files, directories, or glob patterns, flacffp decodes each file
+// and writes an .ffp entry with the MD5 of its audio to standard output.
+// With -c, flacffp instead verifies the files listed in an .ffp file.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/eaburns/flac"
+	"github.com/eaburns/flac/internal/batch"
+)
+
+var (
+	check = flag.String("c", "", "verify the files listed in this .ffp file, or - for standard input")
+	crc   = flag.Bool("crc", false, "include the CRC-32 of the audio as a comment after each entry")
+	jobs  = flag.Int("j", runtime.NumCPU(), "the number of files to decode concurrently")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: flacffp [-j N] [-crc] files, directories, or patterns...")
+		fmt.Fprintln(os.Stderr, "       flacffp [-j N] -c file.ffp")
+		fmt.Fprintln(os.Stderr, "A file named - is read from standard input.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var err error
+	if *check != "" {
+		err = verify(*check)
+	} else {
+		err = generate(flag.Args())
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+func generate(args []string) error {
+	if len(args) == 0 {
+		args = []string{"-"}
+	}
+	files, err := batch.Files(args, ".flac")
+	if err != nil {
+		return err
+	}
+	fps := make([]flac.Fingerprint, len(files))
+	errs := batch.Run(files, *jobs, func(i int, path string) error {
+		var err error
+		fps[i], err = fingerprint(path)
+		return err
+	})
+
+	failed := make(map[string]bool)
+	for _, err := range errs {
+		failed[err.Path] = true
+	}
+	out := bufio.NewWriter(os.Stdout)
+	for i, path := range files {
+		if failed[path] {
+			continue
+		}
+		flac.WriteFFP(out, []flac.FFPEntry{{Name: filepath.ToSlash(path), MD5: fps[i].MD5}})
+		if *crc {
+			fmt.Fprintf(out, "; CRC32 %08X\n", fps[i].CRC32)
+		}
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		batch.Summarize(os.Stderr, len(files), errs)
+		return errors.New("failed to fingerprint some files")
+	}
+	return nil
+}
+
+func verify(ffp string) error {
+	in, err := open(ffp)
+	if err != nil {
+		return err
+	}
+	es, err := flac.ReadFFP(in)
+	in.Close()
+	if err != nil {
+		return err
+	}
+
+	// Entry names are relative to the directory of the .ffp file.
+	dir := "."
+	if ffp != "-" {
+		dir = filepath.Dir(ffp)
+	}
+	files := make([]string, len(es))
+	for i, e := range es {
+		files[i] = filepath.Join(dir, filepath.FromSlash(e.Name))
+	}
+	errs := batch.Run(files, *jobs, func(i int, path string) error {
+		e := es[i]
+		fp, err := fingerprint(path)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(fp.MD5[:], e.MD5[:]) {
+			return errors.New("MD5 mismatch: expected " + hex.EncodeToString(e.MD5[:]) + ", got " + hex.EncodeToString(fp.MD5[:]))
+		}
+		return nil
+	})
+	batch.Summarize(os.Stderr, len(files), errs)
+	if len(errs) > 0 {
+		return errors.New("verification failed")
+	}
+	return nil
+}
+
+func fingerprint(path string) (flac.Fingerprint, error) {
+	f, err := open(path)
+	if err != nil {
+		return flac.Fingerprint{}, err
+	}
+	defer f.Close()
+	return flac.ComputeFingerprint(bufio.NewReader(f))
+}
+
+func open(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return os.Stdin, nil
+	}
+	return os.Open(path)
+}
@@ -0,0 +1,222 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+// Flacfix repairs common problems in FLAC files.
+//
+// For each file, flacfix converts Vorbis comments that are not valid UTF-8
+// from Latin-1; recomputes the STREAMINFO block sizes, frame sizes,
+// total samples, and MD5 checksum from the audio; adds a SEEKTABLE if there
+// is none; and reorders the metadata blocks into the conventional layout,
+// merging PADDING blocks.  With -pictures, cover art stored in
+// METADATA_BLOCK_PICTURE comments is moved to PICTURE blocks.
+// Every change is reported.  The audio frames are never modified.
+// With -n, the changes are reported but the files are left untouched.
+//
+// A file named - is read from standard input, and the repaired stream
+// is written to standard output, with its changes reported to standard error.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+
+	"github.com/eaburns/flac"
+	"github.com/eaburns/flac/internal/batch"
+)
+
+var (
+	dryRun = flag.Bool("n", false, "report the changes without modifying any files")
+	seek   = flag.Float64("seek", 10, "the interval in seconds between added seek points, or 0 to add no SEEKTABLE")
+	pics   = flag.Bool("pictures", false, "move pictures from METADATA_BLOCK_PICTURE comments to PICTURE blocks")
+	jobs   = flag.Int("j", runtime.NumCPU(), "the number of files to fix concurrently")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: flacfix [flags] files, directories, or patterns...")
+		fmt.Fprintln(os.Stderr, "A file named - is read from standard input and written to standard output.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	files, err := batch.Files(flag.Args(), ".flac")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if len(files) == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	changes := make([][]string, len(files))
+	errs := batch.Run(files, *jobs, func(i int, path string) error {
+		var err error
+		changes[i], err = fix(path)
+		return err
+	})
+	for i, path := range files {
+		report := os.Stdout
+		if path == "-" {
+			report = os.Stderr
+		}
+		for _, c := range changes[i] {
+			fmt.Fprintf(report, "%s: %s\n", path, c)
+		}
+	}
+	if len(errs) > 0 {
+		batch.Summarize(os.Stderr, len(files), errs)
+		os.Exit(1)
+	}
+}
+
+// Fix repairs the file at path, returning descriptions of the changes.
+// The file named - is read from standard input and written to standard output.
+func fix(path string) ([]string, error) {
+	if path == "-" {
+		return fixStdin()
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var tmp *os.File
+	w := ioutil.Discard
+	if !*dryRun {
+		if tmp, err = ioutil.TempFile(filepath.Dir(path), ".flacfix"); err != nil {
+			return nil, err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		w = tmp
+	}
+	bw := bufio.NewWriter(w)
+	changes, err := fixStream(bw, f)
+	if err != nil {
+		return nil, err
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	if *dryRun || len(changes) == 0 {
+		return changes, nil
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if fi, err := f.Stat(); err == nil {
+		os.Chmod(tmp.Name(), fi.Mode())
+	}
+	return changes, os.Rename(tmp.Name(), path)
+}
+
+// FixStdin repairs the stream read from standard input, writing it to
+// standard output, unless -n is given, and returning descriptions of the changes.
+// The stream is read whole, since it is read in several passes.
+func fixStdin() ([]string, error) {
+	stream, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, err
+	}
+	w := ioutil.Discard
+	if !*dryRun {
+		w = os.Stdout
+	}
+	bw := bufio.NewWriter(w)
+	changes, err := fixStream(bw, bytes.NewReader(stream))
+	if err != nil {
+		return nil, err
+	}
+	return changes, bw.Flush()
+}
+
+// FixStream writes the repaired stream read from f to w,
+// returning descriptions of the changes.
+func fixStream(w io.Writer, f io.ReadSeeker) ([]string, error) {
+	// The interval is only known once the sample rate is known,
+	// so first read just the STREAMINFO.
+	d, err := flac.NewDecoderOpts(f, flac.Options{DeferMetaData: true})
+	if err != nil {
+		return nil, err
+	}
+	interval := int64(*seek * float64(d.SampleRate))
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	info, points, err := flac.ScanStream(bufio.NewReader(f), interval)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, err
+	}
+
+	var changes []string
+	edit := func(m *flac.MetaData) error {
+		changes = append(changes, streamInfoChanges(m.StreamInfo, info)...)
+		m.StreamInfo = info
+		if m.VorbisComment != nil {
+			for _, i := range m.FixUTF8() {
+				if i < 0 {
+					changes = append(changes, "converted the vendor string from Latin-1")
+				} else {
+					changes = append(changes, "converted comment "+strconv.Quote(m.Comments[i])+" from Latin-1")
+				}
+			}
+		}
+		if *pics {
+			n := len(m.Pictures)
+			if err := m.MigratePictures(); err != nil {
+				return err
+			}
+			if n < len(m.Pictures) {
+				changes = append(changes, "moved "+strconv.Itoa(len(m.Pictures)-n)+" pictures from comments to PICTURE blocks")
+			}
+		}
+		if m.SeekTable == nil && len(points) > 0 {
+			m.SeekTable = points
+			changes = append(changes, "added a SEEKTABLE with "+strconv.Itoa(len(points))+" seek points")
+		}
+		return nil
+	}
+
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() { pw.CloseWithError(flac.Retag(pw, bufio.NewReader(f), edit)) }()
+	relaid, err := flac.NormalizeLayout(w, pr)
+	if err != nil {
+		return nil, err
+	}
+	if relaid {
+		changes = append(changes, "normalized the metadata layout")
+	}
+	return changes, nil
+}
+
+func streamInfoChanges(old, new *flac.StreamInfo) []string {
+	var changes []string
+	check := func(name string, o, n int64) {
+		if o != n {
+			changes = append(changes, "set "+name+" from "+strconv.FormatInt(o, 10)+" to "+strconv.FormatInt(n, 10))
+		}
+	}
+	check("minimum block size", int64(old.MinBlock), int64(new.MinBlock))
+	check("maximum block size", int64(old.MaxBlock), int64(new.MaxBlock))
+	check("minimum frame size", int64(old.MinFrame), int64(new.MinFrame))
+	check("maximum frame size", int64(old.MaxFrame), int64(new.MaxFrame))
+	check("total samples", old.TotalSamples, new.TotalSamples)
+	if old.MD5 != new.MD5 {
+		changes = append(changes, "set MD5 from "+hex.EncodeToString(old.MD5[:])+" to "+hex.EncodeToString(new.MD5[:]))
+	}
+	return changes
+}
@@ -0,0 +1,226 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+// Flacnorm measures the loudness of FLAC files and computes ReplayGain 2.0
+// gains normalizing them to a target loudness.
+//
+// The integrated loudness (ITU-R BS.1770 / EBU R 128), true peak, and gain of
+// each file are printed.  With -album, the files are also treated as an album
+// and album gains are computed.  With -w, REPLAYGAIN_ tags are written to each
+// file, replacing any existing ones; only the metadata is rewritten, the audio
+// frames are copied unchanged.
+//
+// A file named - is read from standard input.  With -w, its retagged stream
+// is written to standard output, and the measurements to standard error.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/eaburns/flac"
+	"github.com/eaburns/flac/internal/batch"
+)
+
+var (
+	album   = flag.Bool("album", false, "also compute album gain, treating all files as one album")
+	target  = flag.Float64("target", -18, "the target loudness in LUFS")
+	write   = flag.Bool("w", false, "write REPLAYGAIN_ tags to the files")
+	limit   = flag.Bool("limit", false, "limit gains so that the true peak does not exceed -ceiling")
+	ceiling = flag.Float64("ceiling", -1, "the maximum true peak in dBTP when limiting")
+	jobs    = flag.Int("j", runtime.NumCPU(), "the number of files to measure concurrently")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: flacnorm [flags] files, directories, or patterns...")
+		fmt.Fprintln(os.Stderr, "A file named - is read from standard input; with -w, it is written to standard output.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	files, err := batch.Files(flag.Args(), ".flac")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if len(files) == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	// Standard input is read whole, since with -w it is read again to retag it.
+	var stdin []byte
+	report := os.Stdout
+	for _, path := range files {
+		if path != "-" {
+			continue
+		}
+		if stdin, err = ioutil.ReadAll(os.Stdin); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		if *write {
+			report = os.Stderr
+		}
+	}
+
+	meters := make([]*flac.LoudnessMeter, len(files))
+	errs := batch.Run(files, *jobs, func(i int, path string) error {
+		if path == "-" {
+			var err error
+			meters[i], err = flac.MeasureLoudness(bytes.NewReader(stdin))
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		meters[i], err = flac.MeasureLoudness(bufio.NewReader(f))
+		return err
+	})
+	if len(errs) > 0 {
+		batch.Summarize(os.Stderr, len(files), errs)
+		os.Exit(1)
+	}
+
+	var albumGain, albumPeak float64
+	if *album {
+		for _, m := range meters {
+			albumPeak = math.Max(albumPeak, m.TruePeak())
+		}
+		albumGain = gain(flac.AlbumLoudness(meters...), albumPeak)
+		fmt.Fprintf(report, "album: %.2f LUFS, true peak %.2f dBTP, gain %.2f dB\n",
+			flac.AlbumLoudness(meters...), db(albumPeak), albumGain)
+	}
+
+	for i, path := range files {
+		m := meters[i]
+		g := gain(m.Integrated(), m.TruePeak())
+		fmt.Fprintf(report, "%s: %.2f LUFS, true peak %.2f dBTP, gain %.2f dB\n", path, m.Integrated(), db(m.TruePeak()), g)
+
+		if !*write {
+			continue
+		}
+		tags := []string{
+			"REPLAYGAIN_TRACK_GAIN=" + strconv.FormatFloat(g, 'f', 2, 64) + " dB",
+			"REPLAYGAIN_TRACK_PEAK=" + strconv.FormatFloat(m.SamplePeak(), 'f', 6, 64),
+			"REPLAYGAIN_REFERENCE_LOUDNESS=" + strconv.FormatFloat(*target, 'f', 2, 64) + " LUFS",
+		}
+		if *album {
+			tags = append(tags,
+				"REPLAYGAIN_ALBUM_GAIN="+strconv.FormatFloat(albumGain, 'f', 2, 64)+" dB",
+				"REPLAYGAIN_ALBUM_PEAK="+strconv.FormatFloat(albumPeakSample(meters), 'f', 6, 64))
+		}
+		if path == "-" {
+			err = writeStdout(stdin, tags)
+		} else {
+			err = writeTags(path, tags)
+		}
+		if err != nil {
+			errs = append(errs, batch.Error{Path: path, Err: err})
+		}
+	}
+	if len(errs) > 0 {
+		batch.Summarize(os.Stderr, len(files), errs)
+		os.Exit(1)
+	}
+}
+
+// Gain returns the gain normalizing the loudness to the target,
+// limited by the -limit and -ceiling flags.
+func gain(loudness, truePeak float64) float64 {
+	if math.IsInf(loudness, -1) {
+		return 0
+	}
+	g := *target - loudness
+	if *limit && truePeak > 0 {
+		if max := *ceiling - db(truePeak); g > max {
+			g = max
+		}
+	}
+	return g
+}
+
+func db(x float64) float64 {
+	return 20 * math.Log10(x)
+}
+
+func albumPeakSample(meters []*flac.LoudnessMeter) float64 {
+	var p float64
+	for _, m := range meters {
+		p = math.Max(p, m.SamplePeak())
+	}
+	return p
+}
+
+// WriteTags rewrites the FLAC file at path, replacing its REPLAYGAIN_ comments
+// with tags.  All other metadata blocks and the audio frames are copied unchanged.
+func writeTags(path string, tags []string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".flacnorm")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	err = retag(w, bufio.NewReader(f), tags)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if info, err := f.Stat(); err == nil {
+		os.Chmod(tmp.Name(), info.Mode())
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// WriteStdout writes the FLAC stream to standard output,
+// replacing its REPLAYGAIN_ comments with tags.
+func writeStdout(stream []byte, tags []string) error {
+	w := bufio.NewWriter(os.Stdout)
+	if err := retag(w, bytes.NewReader(stream), tags); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// Retag copies the FLAC stream from r to w, replacing its REPLAYGAIN_ comments
+// with tags.  All other metadata blocks and the audio frames are copied unchanged.
+func retag(w io.Writer, r io.Reader, tags []string) error {
+	return flac.Retag(w, r, func(m *flac.MetaData) error {
+		cmnt := &flac.VorbisComment{Vendor: "github.com/eaburns/flac"}
+		if m.VorbisComment != nil {
+			cmnt.Vendor = m.Vendor
+			for _, c := range m.Comments {
+				if !strings.HasPrefix(strings.ToUpper(c), "REPLAYGAIN_") {
+					cmnt.Comments = append(cmnt.Comments, c)
+				}
+			}
+		}
+		cmnt.Comments = append(cmnt.Comments, tags...)
+		m.VorbisComment = cmnt
+		return cmnt.Validate()
+	})
+}
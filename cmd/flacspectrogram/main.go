@@ -0,0 +1,225 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+// Flacspectrogram renders a spectrogram of a FLAC file as a PNG image.
+//
+// Time runs left to right and frequency bottom to top, from 0 Hz to the Nyquist
+// frequency.  Each column is the magnitude of a Hann-windowed FFT, mapped to a
+// color between the -min and -max decibel levels relative to full scale.
+// A sharp cut-off well below the Nyquist frequency (typically near 16 kHz or
+// 19-20 kHz) suggests that the audio was transcoded from a lossy source.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"math/cmplx"
+	"os"
+
+	"github.com/eaburns/flac"
+)
+
+var (
+	out     = flag.String("o", "spectrogram.png", "the output PNG file, or - for standard output")
+	width   = flag.Int("width", 1024, "the width of the image in pixels")
+	height  = flag.Int("height", 0, "the height of the image in pixels, or 0 for one pixel per frequency bin")
+	fftSize = flag.Int("fft", 2048, "the FFT size in samples; must be a power of two")
+	minDB   = flag.Float64("min", -120, "the level in dBFS drawn as the darkest color")
+	maxDB   = flag.Float64("max", 0, "the level in dBFS drawn as the brightest color")
+	channel = flag.Int("channel", -1, "the channel to analyze, or -1 to mix all channels")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: flacspectrogram [flags] [file.flac]")
+		fmt.Fprintln(os.Stderr, "Standard input is read if no file is given.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	if *fftSize < 2 || *fftSize&(*fftSize-1) != 0 {
+		return errors.New("FFT size must be a power of two")
+	}
+	if *width < 1 || *height < 0 {
+		return errors.New("Bad image size")
+	}
+	if *maxDB <= *minDB {
+		return errors.New("-max must be greater than -min")
+	}
+
+	var r io.Reader = os.Stdin
+	if flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(1)
+	} else if flag.NArg() == 1 && flag.Arg(0) != "-" {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	samples, err := readSamples(bufio.NewReader(r), *channel)
+	if err != nil {
+		return err
+	}
+	img := spectrogram(samples, *fftSize, *width, *height, *minDB, *maxDB)
+
+	w := os.Stdout
+	if *out != "-" {
+		if w, err = os.Create(*out); err != nil {
+			return err
+		}
+	}
+	if err := png.Encode(w, img); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// ReadSamples returns the samples of the given channel, or all channels mixed
+// if ch is negative, scaled to the range [-1, 1].
+func readSamples(r io.Reader, ch int) ([]float64, error) {
+	d, err := flac.NewDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	if ch >= d.NChannels {
+		return nil, errors.New("No such channel")
+	}
+	scale := 1 / float64(int64(1)<<uint(d.BitsPerSample-1))
+	var samples []float64
+	for {
+		data, err := d.NextSamples()
+		if err == io.EOF {
+			return samples, nil
+		} else if err != nil {
+			return nil, err
+		}
+		for i := range data[0] {
+			var s float64
+			if ch >= 0 {
+				s = float64(data[ch][i])
+			} else {
+				for _, c := range data {
+					s += float64(c[i])
+				}
+				s /= float64(len(data))
+			}
+			samples = append(samples, s*scale)
+		}
+	}
+}
+
+// Spectrogram returns the spectrogram image of the samples.
+func spectrogram(samples []float64, n, w, h int, lo, hi float64) image.Image {
+	bins := n / 2
+	if h == 0 {
+		h = bins
+	}
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	window := make([]float64, n)
+	var gain float64
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n-1))
+		gain += window[i]
+	}
+	// A full-scale sine has magnitude gain/2 in its bin.
+	norm := 2 / gain
+
+	buf := make([]complex128, n)
+	mag := make([]float64, bins)
+	for x := 0; x < w; x++ {
+		start := 0
+		if w > 1 && len(samples) > n {
+			start = x * (len(samples) - n) / (w - 1)
+		}
+		for i := range buf {
+			var s float64
+			if start+i < len(samples) {
+				s = samples[start+i]
+			}
+			buf[i] = complex(s*window[i], 0)
+		}
+		fft(buf)
+		for i := range mag {
+			mag[i] = cmplx.Abs(buf[i]) * norm
+		}
+		for y := 0; y < h; y++ {
+			// Each pixel shows the loudest of the bins it covers.
+			b0, b1 := (h-1-y)*bins/h, (h-y)*bins/h
+			if b1 <= b0 {
+				b1 = b0 + 1
+			}
+			var m float64
+			for _, v := range mag[b0:b1] {
+				m = math.Max(m, v)
+			}
+			img.Set(x, y, heat((20*math.Log10(m)-lo)/(hi-lo)))
+		}
+	}
+	return img
+}
+
+// Fft computes the discrete Fourier transform of x in place.
+// The length of x must be a power of two.
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j |= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], x[start+k+size/2]*w
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+}
+
+// Heat returns the color for a level between 0 and 1:
+// black, through blue, red, and yellow, to white.
+func heat(v float64) color.Color {
+	if math.IsNaN(v) || v < 0 {
+		v = 0
+	} else if v > 1 {
+		v = 1
+	}
+	stops := []struct{ r, g, b float64 }{
+		{0, 0, 0}, {0, 0, 0.5}, {0.8, 0, 0.4}, {1, 0.6, 0}, {1, 1, 0.2}, {1, 1, 1},
+	}
+	p := v * float64(len(stops)-1)
+	i := int(p)
+	if i >= len(stops)-1 {
+		i = len(stops) - 2
+	}
+	f := p - float64(i)
+	c0, c1 := stops[i], stops[i+1]
+	lerp := func(a, b float64) uint8 { return uint8(255 * (a + (b-a)*f)) }
+	return color.RGBA{lerp(c0.r, c1.r), lerp(c0.g, c1.g), lerp(c0.b, c1.b), 255}
+}
@@ -0,0 +1,307 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+//go:build js && wasm
+// +build js,wasm
+
+// Flacwasm exposes the decoder to JavaScript when built for WebAssembly:
+//
+//	GOOS=js GOARCH=wasm go build -o flac.wasm github.com/eaburns/flac/cmd/flacwasm
+//
+// and loaded with the wasm_exec.js shipped with Go.
+//
+// It defines a global flac object with two functions returning decoders.
+// flac.newDecoder() returns a decoder that is fed bytes as they arrive,
+// for example from a fetch stream:
+//
+//	write(bytes)  appends a Uint8Array of the stream.
+//	end()         marks the end of the stream.
+//
+// flac.openDecoder(size, readAt) returns a seekable decoder of a stream of
+// size bytes, where readAt(offset, length) synchronously returns a Uint8Array
+// of up to length bytes of the stream at offset:
+//
+//	seek(sample)  moves to the inter-channel sample number.
+//
+// Both kinds of decoder have the methods:
+//
+//	metadata()    returns an object with sampleRate, channels, bitsPerSample,
+//	              totalSamples, vendor, and comments,
+//	              or null if more input is needed.
+//	read()        returns the next audio as an array of Float32Arrays,
+//	              one per channel, with samples between -1 and 1;
+//	              null if more input is needed; or undefined at the end.
+//
+// Methods return an Error object on failure.
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+
+	"syscall/js"
+
+	"github.com/eaburns/flac"
+)
+
+func main() {
+	js.Global().Set("flac", js.ValueOf(map[string]interface{}{
+		"newDecoder": js.FuncOf(func(js.Value, []js.Value) interface{} {
+			return newPushDecoder()
+		}),
+		"openDecoder": js.FuncOf(func(_ js.Value, args []js.Value) interface{} {
+			if len(args) != 2 {
+				return jsError(errors.New("openDecoder(size, readAt) takes two arguments"))
+			}
+			return openSeekDecoder(int64(args[0].Float()), args[1])
+		}),
+	}))
+	select {}
+}
+
+// ErrNeedMore is returned by a feed that has no bytes, but has not ended.
+var errNeedMore = errors.New("Need more input")
+
+// A feed is an io.Reader of the bytes written to a push decoder.
+type feed struct {
+	buf   []byte
+	ended bool
+}
+
+func (f *feed) Read(p []byte) (int, error) {
+	if len(f.buf) == 0 {
+		if f.ended {
+			return 0, io.EOF
+		}
+		return 0, errNeedMore
+	}
+	n := copy(p, f.buf)
+	f.buf = f.buf[n:]
+	return n, nil
+}
+
+func newPushDecoder() js.Value {
+	f := &feed{}
+	var d *flac.Decoder
+	// Ready returns whether the decoder has been created,
+	// creating it once all of the metadata has arrived.
+	ready := func() (bool, error) {
+		if d != nil {
+			return true, nil
+		}
+		if !f.ended && !haveMetaData(f.buf) {
+			return false, nil
+		}
+		var err error
+		d, err = flac.NewDecoder(f)
+		return err == nil, err
+	}
+	return js.ValueOf(map[string]interface{}{
+		"write": js.FuncOf(func(_ js.Value, args []js.Value) interface{} {
+			b := make([]byte, args[0].Get("length").Int())
+			js.CopyBytesToGo(b, args[0])
+			f.buf = append(f.buf, b...)
+			return nil
+		}),
+		"end": js.FuncOf(func(js.Value, []js.Value) interface{} {
+			f.ended = true
+			return nil
+		}),
+		"metadata": js.FuncOf(func(js.Value, []js.Value) interface{} {
+			if ok, err := ready(); err != nil {
+				return jsError(err)
+			} else if !ok {
+				return nil
+			}
+			return metaData(d.MetaData)
+		}),
+		"read": js.FuncOf(func(js.Value, []js.Value) interface{} {
+			if ok, err := ready(); err != nil {
+				return jsError(err)
+			} else if !ok {
+				return nil
+			}
+			// Only decode once the whole frame has arrived;
+			// the decoder cannot resume a partially read frame.
+			if !f.ended {
+				if len(f.buf) < 16 {
+					return nil
+				}
+				h, err := d.PeekFrameHeader()
+				if err != nil {
+					return jsError(err)
+				}
+				if len(f.buf) < maxFrameSize(d.StreamInfo, h) {
+					return nil
+				}
+			}
+			data, err := d.NextSamples()
+			if err == io.EOF {
+				return js.Undefined()
+			} else if err != nil {
+				return jsError(err)
+			}
+			return channels(data, d.BitsPerSample)
+		}),
+	})
+}
+
+// HaveMetaData returns whether b begins with all of the metadata of a FLAC stream.
+func haveMetaData(b []byte) bool {
+	i := 4
+	for {
+		if len(b) < i+4 {
+			return false
+		}
+		last := b[i]&0x80 != 0
+		i += 4 + (int(b[i+1])<<16 | int(b[i+2])<<8 | int(b[i+3]))
+		if last {
+			return len(b) >= i
+		}
+	}
+}
+
+// MaxFrameSize returns an upper bound on the size of the frame with header h.
+func maxFrameSize(info *flac.StreamInfo, h *flac.FrameHeader) int {
+	if info.MaxFrame > 0 {
+		return info.MaxFrame
+	}
+	// A verbatim subframe per channel, with an extra bit for side channels,
+	// plus the frame header, subframe headers, and footer.
+	return 16 + h.NChannels*(1+(h.BlockSize*(h.BitsPerSample+1)+7)/8) + 2
+}
+
+// A callbackReader is an io.ReadSeeker calling a JavaScript readAt function,
+// buffering to reduce the number of calls.
+type callbackReader struct {
+	readAt js.Value
+	size   int64
+	off    int64
+	// Buf holds the stream's bytes starting at bufOff.
+	buf    []byte
+	bufOff int64
+}
+
+const callbackChunk = 64 << 10
+
+func (r *callbackReader) Read(p []byte) (int, error) {
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	if r.off < r.bufOff || r.off >= r.bufOff+int64(len(r.buf)) {
+		v := r.readAt.Invoke(float64(r.off), callbackChunk)
+		r.buf = make([]byte, v.Get("length").Int())
+		js.CopyBytesToGo(r.buf, v)
+		r.bufOff = r.off
+		if len(r.buf) == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+	}
+	n := copy(p, r.buf[r.off-r.bufOff:])
+	r.off += int64(n)
+	return n, nil
+}
+
+func (r *callbackReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case 1:
+		offset += r.off
+	case 2:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("Seek to a negative offset")
+	}
+	r.off = offset
+	return offset, nil
+}
+
+func openSeekDecoder(size int64, readAt js.Value) js.Value {
+	d, err := flac.NewDecoder(&callbackReader{readAt: readAt, size: size})
+	if err != nil {
+		return jsError(err)
+	}
+	p := flac.NewPCMStream(d)
+	sampleSize := int64(d.NChannels * ((d.BitsPerSample + 7) / 8))
+	buf := make([]byte, 4096*sampleSize)
+	return js.ValueOf(map[string]interface{}{
+		"metadata": js.FuncOf(func(js.Value, []js.Value) interface{} {
+			return metaData(d.MetaData)
+		}),
+		"seek": js.FuncOf(func(_ js.Value, args []js.Value) interface{} {
+			if _, err := p.Seek(int64(args[0].Float())*sampleSize, 0); err != nil {
+				return jsError(err)
+			}
+			return nil
+		}),
+		"read": js.FuncOf(func(js.Value, []js.Value) interface{} {
+			n, err := io.ReadFull(p, buf)
+			if n == 0 && err == io.EOF {
+				return js.Undefined()
+			} else if err != nil && err != io.ErrUnexpectedEOF {
+				return jsError(err)
+			}
+			return channels(deinterleave(buf[:n], d.NChannels, d.BitsPerSample), d.BitsPerSample)
+		}),
+	})
+}
+
+// Deinterleave converts interleaved little-endian samples to samples per channel.
+func deinterleave(b []byte, nchannels, bps int) [][]int32 {
+	size := bps / 8
+	n := len(b) / (size * nchannels)
+	data := make([][]int32, nchannels)
+	for ch := range data {
+		data[ch] = make([]int32, n)
+		for i := range data[ch] {
+			s := b[(i*nchannels+ch)*size:]
+			var v int32
+			for j := size - 1; j >= 0; j-- {
+				v = v<<8 | int32(s[j])
+			}
+			data[ch][i] = v << uint(32-bps) >> uint(32-bps)
+		}
+	}
+	return data
+}
+
+// Channels returns an array of Float32Arrays of the samples of each channel.
+func channels(data [][]int32, bps int) js.Value {
+	scale := 1 / float32(int64(1)<<uint(bps-1))
+	arr := js.Global().Get("Array").New(len(data))
+	for ch, samples := range data {
+		b := make([]byte, 4*len(samples))
+		for i, s := range samples {
+			binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(float32(s)*scale))
+		}
+		u8 := js.Global().Get("Uint8Array").New(len(b))
+		js.CopyBytesToJS(u8, b)
+		arr.SetIndex(ch, js.Global().Get("Float32Array").New(u8.Get("buffer")))
+	}
+	return arr
+}
+
+func metaData(m flac.MetaData) js.Value {
+	obj := map[string]interface{}{
+		"sampleRate":    m.SampleRate,
+		"channels":      m.NChannels,
+		"bitsPerSample": m.BitsPerSample,
+		"totalSamples":  float64(m.TotalSamples),
+		"vendor":        "",
+		"comments":      []interface{}{},
+	}
+	if m.VorbisComment != nil {
+		obj["vendor"] = m.Vendor
+		var cmnts []interface{}
+		for _, c := range m.Comments {
+			cmnts = append(cmnts, c)
+		}
+		obj["comments"] = cmnts
+	}
+	return js.ValueOf(obj)
+}
+
+func jsError(err error) js.Value {
+	return js.Global().Get("Error").New(err.Error())
+}
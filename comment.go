@@ -0,0 +1,97 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A CommentError describes an invalid Vorbis comment.
+type CommentError struct {
+	// Index is the index of the comment in VorbisComment.Comments.
+	Index   int
+	Comment string
+	Reason  string
+}
+
+func (e *CommentError) Error() string {
+	return "Invalid Vorbis comment " + strconv.Itoa(e.Index) + " (" + strconv.Quote(e.Comment) + "): " + e.Reason
+}
+
+// ValidateComment returns an error describing why a KEY=value comment is
+// invalid, or nil if it is valid.
+// Keys must be non-empty and consist of the ASCII characters 0x20 through 0x7D,
+// excluding '='.  Values must be valid UTF-8 without NUL characters.
+func ValidateComment(cmnt string) error {
+	i := strings.IndexByte(cmnt, '=')
+	if i < 0 {
+		return &CommentError{Comment: cmnt, Reason: "missing '='"}
+	}
+	if i == 0 {
+		return &CommentError{Comment: cmnt, Reason: "empty key"}
+	}
+	for j := 0; j < i; j++ {
+		if c := cmnt[j]; c < 0x20 || c > 0x7D {
+			return &CommentError{Comment: cmnt, Reason: "invalid key character " + strconv.QuoteRune(rune(c))}
+		}
+	}
+	value := cmnt[i+1:]
+	if !utf8.ValidString(value) {
+		return &CommentError{Comment: cmnt, Reason: "value is not valid UTF-8"}
+	}
+	if strings.IndexByte(value, 0) >= 0 {
+		return &CommentError{Comment: cmnt, Reason: "value contains NUL"}
+	}
+	return nil
+}
+
+// Validate returns a *CommentError for the first invalid comment,
+// or nil if all of the comments and the vendor string are valid.
+// Writers of Vorbis comments call Validate before writing, so as not to create
+// files that other tools cannot read.
+func (c *VorbisComment) Validate() error {
+	if !utf8.ValidString(c.Vendor) {
+		return &CommentError{Index: -1, Comment: c.Vendor, Reason: "vendor is not valid UTF-8"}
+	}
+	for i, cmnt := range c.Comments {
+		if err := ValidateComment(cmnt); err != nil {
+			err.(*CommentError).Index = i
+			return err
+		}
+	}
+	return nil
+}
+
+// A KeyCase is a policy for the case of Vorbis comment keys.
+// Keys are case-insensitive, so the policy does not change their meaning.
+type KeyCase int
+
+const (
+	// KeepCase leaves keys unchanged.
+	KeepCase KeyCase = iota
+	// UpperCase converts keys to upper case, the conventional form.
+	UpperCase
+	// LowerCase converts keys to lower case.
+	LowerCase
+)
+
+// Normalize validates the comments and converts their keys according to
+// the KeyCase policy.  If a comment is invalid, a *CommentError is returned
+// and the comments are unchanged.
+func (c *VorbisComment) Normalize(kc KeyCase) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	for i, cmnt := range c.Comments {
+		j := strings.IndexByte(cmnt, '=')
+		switch kc {
+		case UpperCase:
+			c.Comments[i] = strings.ToUpper(cmnt[:j]) + cmnt[j:]
+		case LowerCase:
+			c.Comments[i] = strings.ToLower(cmnt[:j]) + cmnt[j:]
+		}
+	}
+	return nil
+}
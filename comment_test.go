@@ -0,0 +1,66 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestValidateComment(t *testing.T) {
+	tests := []struct {
+		cmnt string
+		ok   bool
+	}{
+		{"TITLE=Hello", true},
+		{"title=", true},
+		{"A B=c=d", true},
+		{"=value", false},
+		{"TITLE", false},
+		{"TI~TLE=x", false},
+		{"TI\x01TLE=x", false},
+		{"TITLE=a\x00b", false},
+		{"TITLE=\xff", false},
+	}
+	for _, test := range tests {
+		if err := ValidateComment(test.cmnt); (err == nil) != test.ok {
+			t.Errorf("ValidateComment(%q)=%v, expected ok=%v", test.cmnt, err, test.ok)
+		}
+	}
+
+	c := &VorbisComment{Comments: []string{"Title=x", "artist=y"}}
+	if err := c.Normalize(UpperCase); err != nil || c.Comments[0] != "TITLE=x" || c.Comments[1] != "ARTIST=y" {
+		t.Errorf("Unexpected normalized comments %v, %v", c.Comments, err)
+	}
+
+	// Invalid comments are never written.
+	bad := &VorbisComment{Vendor: "test", Comments: []string{"TITLE"}}
+	if _, err := bad.MarshalBinary(); err == nil {
+		t.Errorf("MarshalBinary: expected an error")
+	}
+	stream := encodeFile(t, testSignal(1, 1000, 16), EncoderOptions{})
+	setBad := func(m *MetaData) error {
+		m.VorbisComment = bad
+		return nil
+	}
+	if err := Retag(ioutil.Discard, bytes.NewReader(stream), setBad); err == nil {
+		t.Errorf("Retag: expected an error")
+	}
+	if err := Remux(ioutil.Discard, bytes.NewReader(stream), MetaData{VorbisComment: bad}); err == nil {
+		t.Errorf("Remux: expected an error")
+	}
+	f, err := ioutil.TempFile("", "flac-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write(stream); err != nil {
+		t.Fatal(err)
+	}
+	if err := EditMetaData(f, setBad); err == nil {
+		t.Errorf("EditMetaData: expected an error")
+	}
+}
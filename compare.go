@@ -0,0 +1,126 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"io"
+)
+
+// A DiffReport describes the differences between the audio of two FLAC streams.
+type DiffReport struct {
+	// Equal is true if the streams have the same format and identical samples.
+	Equal bool
+
+	// FormatDiffers is true if the streams have different sample rates,
+	// numbers of channels, or bits per sample.
+	// In that case, samples are not compared.
+	FormatDiffers bool
+
+	// Diff is the first differing sample, or nil if there is none.
+	Diff *SampleDiff
+
+	// ASamples and BSamples are the number of inter-channel samples in each stream.
+	ASamples, BSamples int64
+}
+
+// A SampleDiff is a sample that differs between two streams.
+type SampleDiff struct {
+	// Sample is the inter-channel sample number.
+	Sample int64
+	// Channel is the channel number.
+	Channel int
+	// A and B are the values of the sample in each stream.
+	A, B int32
+}
+
+// Compare decodes two FLAC streams in lockstep and reports whether their audio
+// is the same.  The streams may use different block sizes and encodings;
+// only the decoded samples are compared.
+// An error is returned if either stream cannot be decoded.
+func Compare(a, b io.Reader) (*DiffReport, error) {
+	da, err := NewDecoder(a)
+	if err != nil {
+		return nil, err
+	}
+	db, err := NewDecoder(b)
+	if err != nil {
+		return nil, err
+	}
+
+	rep := new(DiffReport)
+	if da.SampleRate != db.SampleRate || da.NChannels != db.NChannels || da.BitsPerSample != db.BitsPerSample {
+		rep.FormatDiffers = true
+		return rep, nil
+	}
+
+	// Fa and fb are the not-yet-compared samples of the current frame of each stream.
+	var fa, fb [][]int32
+	var aDone, bDone bool
+	for {
+		if len(fa) == 0 || len(fa[0]) == 0 {
+			if fa, err = nextSamples(da, &aDone); err != nil {
+				return nil, err
+			}
+			rep.ASamples += samplesIn(fa)
+		}
+		if len(fb) == 0 || len(fb[0]) == 0 {
+			if fb, err = nextSamples(db, &bDone); err != nil {
+				return nil, err
+			}
+			rep.BSamples += samplesIn(fb)
+		}
+		if aDone && bDone {
+			break
+		}
+		if aDone || bDone || rep.Diff != nil {
+			// Keep decoding to count the samples.
+			fa, fb = nil, nil
+			continue
+		}
+
+		n := len(fa[0])
+		if len(fb[0]) < n {
+			n = len(fb[0])
+		}
+		start := rep.ASamples - samplesIn(fa)
+		for ch := range fa {
+			for i := 0; i < n; i++ {
+				if fa[ch][i] == fb[ch][i] {
+					continue
+				}
+				s := start + int64(i)
+				if rep.Diff == nil || s < rep.Diff.Sample {
+					rep.Diff = &SampleDiff{Sample: s, Channel: ch, A: fa[ch][i], B: fb[ch][i]}
+				}
+				break
+			}
+		}
+		for ch := range fa {
+			fa[ch] = fa[ch][n:]
+			fb[ch] = fb[ch][n:]
+		}
+	}
+
+	rep.Equal = rep.Diff == nil && rep.ASamples == rep.BSamples
+	return rep, nil
+}
+
+// NextSamples returns the next frame of samples, or nil and sets *done at the end of the stream.
+func nextSamples(d *Decoder, done *bool) ([][]int32, error) {
+	if *done {
+		return nil, nil
+	}
+	data, err := d.NextSamples()
+	if err == io.EOF {
+		*done = true
+		return nil, nil
+	}
+	return data, err
+}
+
+func samplesIn(data [][]int32) int64 {
+	if len(data) == 0 {
+		return 0
+	}
+	return int64(len(data[0]))
+}
@@ -0,0 +1,43 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b  []byte
+		equal bool
+		diff  *SampleDiff
+		na    int64
+		nb    int64
+	}{
+		{constantStream, constantStream, true, nil, 192, 192},
+		{twoFrameStream, constantStream, false, nil, 384, 192},
+		{constantStream, twoFrameStream, false, nil, 192, 384},
+	}
+	for _, test := range tests {
+		rep, err := Compare(bytes.NewReader(test.a), bytes.NewReader(test.b))
+		if err != nil {
+			t.Errorf("Unexpected error comparing: %v", err)
+			continue
+		}
+		if rep.Equal != test.equal || rep.ASamples != test.na || rep.BSamples != test.nb || (rep.Diff == nil) != (test.diff == nil) {
+			t.Errorf("Unexpected report: %+v", rep)
+		}
+	}
+
+	// The second frame of twoFrameStream differs from a stream of a single repeated frame.
+	repeated := append(append([]byte{}, twoFrameStream[:42]...), constantStream[42:]...)
+	repeated = append(repeated, constantStream[42:]...)
+	rep, err := Compare(bytes.NewReader(twoFrameStream), bytes.NewReader(repeated))
+	if err != nil {
+		t.Fatalf("Unexpected error comparing: %v", err)
+	}
+	if rep.Equal || rep.Diff == nil || *rep.Diff != (SampleDiff{Sample: 192, Channel: 0, A: 6, B: 5}) {
+		t.Errorf("Unexpected report: %+v, diff %+v", rep, rep.Diff)
+	}
+}
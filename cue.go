@@ -0,0 +1,429 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A CueSheet describes the tracks of an album stored as a single file.
+type CueSheet struct {
+	Title     string
+	Performer string
+	Catalog   string
+	// File is the name of the audio file.
+	File string
+	// Rem holds the REM comments that have a key, such as GENRE and DATE,
+	// keyed by the upper-case key.
+	Rem    map[string]string
+	Tracks []CueTrack
+}
+
+// A CueTrack is a track of a CueSheet.
+type CueTrack struct {
+	Number    int
+	Title     string
+	Performer string
+	ISRC      string
+	// Indexes are the track's indexes, in order.
+	// Index 0, if present, begins the pregap; index 1 begins the track.
+	Indexes []CueIndex
+}
+
+// A CueIndex is a position within the audio file.
+type CueIndex struct {
+	Number int
+	// Frame is the position in CD frames, 1/75th of a second.
+	Frame int64
+}
+
+// ParseCueSheet parses a cue sheet.  Only cue sheets describing a single
+// audio file are supported.
+func ParseCueSheet(r io.Reader) (*CueSheet, error) {
+	c := &CueSheet{Rem: make(map[string]string)}
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		fields, err := cueFields(strings.TrimPrefix(s.Text(), "\ufeff"))
+		if err != nil || len(fields) == 0 {
+			if err != nil {
+				return nil, cueError(line, err.Error())
+			}
+			continue
+		}
+		var track *CueTrack
+		if len(c.Tracks) > 0 {
+			track = &c.Tracks[len(c.Tracks)-1]
+		}
+		arg := func(i int) string {
+			if i < len(fields) {
+				return fields[i]
+			}
+			return ""
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "REM":
+			if len(fields) >= 3 {
+				c.Rem[strings.ToUpper(fields[1])] = strings.Join(fields[2:], " ")
+			}
+		case "CATALOG":
+			c.Catalog = arg(1)
+		case "FILE":
+			if c.File != "" {
+				return nil, cueError(line, "multiple FILE commands are not supported")
+			}
+			c.File = arg(1)
+		case "TITLE":
+			if track != nil {
+				track.Title = arg(1)
+			} else {
+				c.Title = arg(1)
+			}
+		case "PERFORMER":
+			if track != nil {
+				track.Performer = arg(1)
+			} else {
+				c.Performer = arg(1)
+			}
+		case "TRACK":
+			n, err := strconv.Atoi(arg(1))
+			if err != nil {
+				return nil, cueError(line, "bad track number "+strconv.Quote(arg(1)))
+			}
+			c.Tracks = append(c.Tracks, CueTrack{Number: n})
+		case "ISRC":
+			if track != nil {
+				track.ISRC = arg(1)
+			}
+		case "INDEX":
+			if track == nil {
+				return nil, cueError(line, "INDEX outside of a TRACK")
+			}
+			n, err := strconv.Atoi(arg(1))
+			if err != nil {
+				return nil, cueError(line, "bad index number "+strconv.Quote(arg(1)))
+			}
+			f, err := parseCueTime(arg(2))
+			if err != nil {
+				return nil, cueError(line, err.Error())
+			}
+			track.Indexes = append(track.Indexes, CueIndex{Number: n, Frame: f})
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	for _, t := range c.Tracks {
+		if _, ok := t.index(1); !ok {
+			return nil, errors.New("Track " + strconv.Itoa(t.Number) + " has no INDEX 01")
+		}
+	}
+	return c, nil
+}
+
+func cueError(line int, msg string) error {
+	return errors.New("Cue sheet line " + strconv.Itoa(line) + ": " + msg)
+}
+
+// CueFields splits a cue sheet line into fields separated by spaces,
+// where a field may be a double-quoted string.
+func cueFields(line string) ([]string, error) {
+	var fields []string
+	for {
+		line = strings.TrimLeft(line, " \t\r")
+		if line == "" {
+			return fields, nil
+		}
+		if line[0] == '"' {
+			i := strings.IndexByte(line[1:], '"')
+			if i < 0 {
+				return nil, errors.New("unterminated string")
+			}
+			fields = append(fields, line[1:i+1])
+			line = line[i+2:]
+			continue
+		}
+		i := strings.IndexAny(line, " \t\r")
+		if i < 0 {
+			i = len(line)
+		}
+		fields = append(fields, line[:i])
+		line = line[i:]
+	}
+}
+
+// ParseCueTime parses a time of the form mm:ss:ff, returning CD frames.
+func parseCueTime(s string) (int64, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, errors.New("bad time " + strconv.Quote(s))
+	}
+	var n [3]int64
+	for i, p := range parts {
+		v, err := strconv.ParseInt(p, 10, 64)
+		if err != nil || v < 0 || (i > 0 && v >= [3]int64{0, 60, 75}[i]) {
+			return 0, errors.New("bad time " + strconv.Quote(s))
+		}
+		n[i] = v
+	}
+	return (n[0]*60+n[1])*75 + n[2], nil
+}
+
+func (t CueTrack) index(n int) (int64, bool) {
+	for _, i := range t.Indexes {
+		if i.Number == n {
+			return i.Frame, true
+		}
+	}
+	return 0, false
+}
+
+// CueSheet returns the cue sheet embedded in the CUESHEET Vorbis comment,
+// as written by many CD rippers to single-file albums.
+// The boolean is false if there is no such comment or it cannot be parsed.
+func (m MetaData) CueSheet() (*CueSheet, bool) {
+	s, ok := m.VorbisComment.first("CUESHEET")
+	if !ok {
+		return nil, false
+	}
+	c, err := ParseCueSheet(strings.NewReader(s))
+	return c, err == nil
+}
+
+// A GapMode says which track the gap before a track, from its index 0 to
+// its index 1, belongs to when splitting an album into tracks.
+type GapMode int
+
+const (
+	// GapsAppend appends each gap to the end of the previous track,
+	// so tracks start at index 1.  Gaps before the first track are discarded.
+	GapsAppend GapMode = iota
+	// GapsPrepend prepends each gap to the start of its track,
+	// so tracks start at index 0.
+	GapsPrepend
+	// GapsDiscard discards the gaps.
+	GapsDiscard
+)
+
+// A TrackRange is a range of samples [Start, End) of a track.
+type TrackRange struct {
+	Start, End int64
+}
+
+// Ranges returns the sample ranges of each track, for audio with the given
+// sample rate and total number of samples.
+func (c *CueSheet) Ranges(sampleRate int, totalSamples int64, gaps GapMode) ([]TrackRange, error) {
+	sample := func(frame int64) int64 { return frame * int64(sampleRate) / 75 }
+	ranges := make([]TrackRange, len(c.Tracks))
+	for i, t := range c.Tracks {
+		start, _ := t.index(1)
+		if pregap, ok := t.index(0); ok && gaps == GapsPrepend {
+			start = pregap
+		}
+		ranges[i].Start = sample(start)
+		if i > 0 {
+			end := ranges[i].Start
+			if pregap, ok := t.index(0); ok && gaps == GapsDiscard {
+				end = sample(pregap)
+			}
+			ranges[i-1].End = end
+		}
+	}
+	if len(ranges) > 0 {
+		ranges[len(ranges)-1].End = totalSamples
+	}
+	for i, r := range ranges {
+		if r.Start > r.End || r.End > totalSamples {
+			return nil, errors.New("Track " + strconv.Itoa(c.Tracks[i].Number) + " is out of range")
+		}
+	}
+	return ranges, nil
+}
+
+// TrackComments returns the Vorbis comments for track i of the cue sheet:
+// the album and track titles and performers, the track number and total,
+// the ISRC, and the DATE and GENRE REM comments.
+func (c *CueSheet) TrackComments(i int) *VorbisComment {
+	t := c.Tracks[i]
+	cmnt := &VorbisComment{Vendor: vendor}
+	add := func(key, value string) {
+		if value != "" {
+			cmnt.Comments = append(cmnt.Comments, key+"="+value)
+		}
+	}
+	add("ALBUM", c.Title)
+	add("ALBUMARTIST", c.Performer)
+	add("TITLE", t.Title)
+	if t.Performer != "" {
+		add("ARTIST", t.Performer)
+	} else {
+		add("ARTIST", c.Performer)
+	}
+	add("TRACKNUMBER", strconv.Itoa(t.Number))
+	add("TRACKTOTAL", strconv.Itoa(len(c.Tracks)))
+	add("ISRC", t.ISRC)
+	add("DATE", c.Rem["DATE"])
+	add("GENRE", c.Rem["GENRE"])
+	add("CATALOGNUMBER", c.Catalog)
+	return cmnt
+}
+
+// EncodeCueSheet returns the body of a CUESHEET block for the cue sheet,
+// describing audio with the given sample rate and total number of samples.
+// At 44100 Hz the block describes a CD, with the standard 2 second lead-in;
+// otherwise it has no lead-in.
+func encodeCueSheet(c *CueSheet, sampleRate int, totalSamples int64) ([]byte, error) {
+	cd := sampleRate == 44100
+	maxTrack := 254
+	if cd {
+		maxTrack = 99
+	}
+	switch {
+	case len(c.Catalog) > 128:
+		return nil, errors.New("Catalog number is too long: " + strconv.Quote(c.Catalog))
+	case len(c.Tracks) == 0:
+		return nil, errors.New("Cue sheet has no tracks")
+	case len(c.Tracks) > maxTrack:
+		return nil, errors.New("Too many tracks: " + strconv.Itoa(len(c.Tracks)))
+	}
+
+	b := bytes.NewBuffer(nil)
+	put := func(v interface{}) { binary.Write(b, binary.BigEndian, v) }
+	var catalog [128]byte
+	copy(catalog[:], c.Catalog)
+	put(catalog)
+	var leadIn uint64
+	var flags [259]byte
+	if cd {
+		leadIn = 2 * 44100
+		flags[0] = 0x80
+	}
+	put(leadIn)
+	put(flags)
+	put(uint8(len(c.Tracks) + 1))
+
+	sample := func(frame int64) int64 { return frame * int64(sampleRate) / 75 }
+	for _, t := range c.Tracks {
+		name := "Track " + strconv.Itoa(t.Number)
+		switch {
+		case t.Number < 1 || t.Number > maxTrack:
+			return nil, errors.New("Bad track number " + strconv.Itoa(t.Number))
+		case t.ISRC != "" && len(t.ISRC) != 12:
+			return nil, errors.New(name + " has a bad ISRC " + strconv.Quote(t.ISRC))
+		case len(t.Indexes) == 0 || len(t.Indexes) > 255:
+			return nil, errors.New(name + " has " + strconv.Itoa(len(t.Indexes)) + " indexes")
+		}
+		offset := sample(t.Indexes[0].Frame)
+		if offset > totalSamples {
+			return nil, errors.New(name + " is out of range")
+		}
+		put(uint64(offset))
+		put(uint8(t.Number))
+		var isrc [12]byte
+		copy(isrc[:], t.ISRC)
+		put(isrc)
+		put([14]byte{}) // Audio, no pre-emphasis.
+		put(uint8(len(t.Indexes)))
+		prev := int64(-1)
+		for _, i := range t.Indexes {
+			if i.Number < 0 || i.Number > 255 || i.Frame <= prev {
+				return nil, errors.New(name + " has a bad INDEX " + strconv.Itoa(i.Number))
+			}
+			prev = i.Frame
+			put(uint64(sample(i.Frame) - offset))
+			put(uint8(i.Number))
+			put([3]byte{})
+		}
+	}
+
+	// The lead-out track.
+	put(uint64(totalSamples))
+	if cd {
+		put(uint8(170))
+	} else {
+		put(uint8(255))
+	}
+	put([12 + 14]byte{})
+	put(uint8(0))
+	return b.Bytes(), nil
+}
+
+// A trackStart is the first sample of a track, at its index 1.
+type trackStart struct {
+	number int
+	start  int64
+}
+
+// TrackStarts returns the start of each track of the CUESHEET block,
+// or if there is none, of the cue sheet of the CUESHEET Vorbis comment,
+// followed by the end of the audio, as the start of the lead-out track.
+func (m MetaData) trackStarts() ([]trackStart, error) {
+	for _, b := range m.Others {
+		if BlockType(b.Type) == BlockCueSheet {
+			return cueSheetBlockStarts(b.Data)
+		}
+	}
+	c, ok := m.CueSheet()
+	if !ok {
+		return nil, errors.New("No cue sheet")
+	}
+	if m.StreamInfo == nil || m.TotalSamples == 0 {
+		return nil, errors.New("Cue sheet tracks need the total number of samples")
+	}
+	ranges, err := c.Ranges(m.SampleRate, m.TotalSamples, GapsAppend)
+	if err != nil {
+		return nil, err
+	}
+	starts := make([]trackStart, len(ranges)+1)
+	for i, r := range ranges {
+		starts[i] = trackStart{number: c.Tracks[i].Number, start: r.Start}
+	}
+	starts[len(ranges)] = trackStart{number: 0, start: m.TotalSamples}
+	return starts, nil
+}
+
+// CueSheetBlockStarts returns the start of each track of the body
+// of a CUESHEET block, including the lead-out track, which is last.
+func cueSheetBlockStarts(body []byte) ([]trackStart, error) {
+	bad := errors.New("Bad CUESHEET block")
+	const (
+		headerSize = 128 + 8 + 259 + 1
+		trackSize  = 8 + 1 + 12 + 14 + 1
+		indexSize  = 8 + 1 + 3
+	)
+	if len(body) < headerSize {
+		return nil, bad
+	}
+	n := int(body[headerSize-1])
+	body = body[headerSize:]
+	starts := make([]trackStart, 0, n)
+	for i := 0; i < n; i++ {
+		if len(body) < trackSize {
+			return nil, bad
+		}
+		offset := int64(binary.BigEndian.Uint64(body))
+		t := trackStart{number: int(body[8]), start: offset}
+		nIndexes := int(body[trackSize-1])
+		body = body[trackSize:]
+		if len(body) < nIndexes*indexSize {
+			return nil, bad
+		}
+		for j := 0; j < nIndexes; j++ {
+			index := body[j*indexSize:]
+			if j == 0 || index[8] == 1 {
+				t.start = offset + int64(binary.BigEndian.Uint64(index))
+			}
+		}
+		body = body[nIndexes*indexSize:]
+		starts = append(starts, t)
+	}
+	if len(starts) == 0 {
+		return nil, bad
+	}
+	return starts, nil
+}
@@ -0,0 +1,186 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCueSheet(t *testing.T) {
+	const cue = `REM GENRE Jazz
+REM DATE 1959
+PERFORMER "Miles Davis"
+TITLE "Kind of Blue"
+FILE "album.flac" WAVE
+  TRACK 01 AUDIO
+    TITLE "So What"
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    TITLE "Freddie Freeloader"
+    INDEX 00 09:20:00
+    INDEX 01 09:22:00
+  TRACK 03 AUDIO
+    TITLE "Blue in Green"
+    PERFORMER "Miles Davis & Bill Evans"
+    INDEX 01 19:00:74
+`
+	c, err := ParseCueSheet(strings.NewReader(cue))
+	if err != nil {
+		t.Fatalf("ParseCueSheet failed: %v", err)
+	}
+	if c.Title != "Kind of Blue" || c.File != "album.flac" || len(c.Tracks) != 3 || c.Rem["DATE"] != "1959" {
+		t.Fatalf("Bad cue sheet: %+v", c)
+	}
+	const rate, total = 44100, 44100 * 60 * 25
+	tests := []struct {
+		gaps GapMode
+		want []TrackRange
+	}{
+		{GapsAppend, []TrackRange{{0, 44100 * 562}, {44100 * 562, 44100*1140 + 43512}, {44100*1140 + 43512, total}}},
+		{GapsPrepend, []TrackRange{{0, 44100 * 560}, {44100 * 560, 44100*1140 + 43512}, {44100*1140 + 43512, total}}},
+		{GapsDiscard, []TrackRange{{0, 44100 * 560}, {44100 * 562, 44100*1140 + 43512}, {44100*1140 + 43512, total}}},
+	}
+	for _, test := range tests {
+		rs, err := c.Ranges(rate, total, test.gaps)
+		if err != nil {
+			t.Errorf("Gaps %d: %v", test.gaps, err)
+			continue
+		}
+		for i := range rs {
+			if rs[i] != test.want[i] {
+				t.Errorf("Gaps %d, track %d: got %v, want %v", test.gaps, i+1, rs[i], test.want[i])
+			}
+		}
+	}
+	cmnt := c.TrackComments(2)
+	if a := cmnt.Get("ARTIST"); len(a) != 1 || a[0] != "Miles Davis & Bill Evans" {
+		t.Errorf("Bad ARTIST: %v", a)
+	}
+	if n, ok := cmnt.TrackNumber(); !ok || n.Number != 3 || n.Total != 3 {
+		t.Errorf("Bad track number: %+v", n)
+	}
+
+	meta := MetaData{VorbisComment: &VorbisComment{Comments: []string{"CUESHEET=" + cue}}}
+	if c, ok := meta.CueSheet(); !ok || len(c.Tracks) != 3 {
+		t.Errorf("Embedded cue sheet not found")
+	}
+}
+
+func TestEncodeCueSheet(t *testing.T) {
+	const cue = `CATALOG 0123456789012
+FILE "album.flac" WAVE
+  TRACK 01 AUDIO
+    ISRC USABC0000001
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    INDEX 00 00:00:01
+    INDEX 01 00:00:02
+`
+	c, err := ParseCueSheet(strings.NewReader(cue))
+	if err != nil {
+		t.Fatalf("ParseCueSheet failed: %v", err)
+	}
+	type index struct {
+		offset uint64
+		number byte
+	}
+	type track struct {
+		offset  uint64
+		number  byte
+		isrc    string
+		indexes []index
+	}
+	tests := []struct {
+		rate   int
+		leadIn uint64
+		cd     bool
+		tracks []track
+	}{
+		{44100, 88200, true, []track{
+			{0, 1, "USABC0000001", []index{{0, 1}}},
+			{588, 2, "", []index{{0, 0}, {588, 1}}},
+			{5000, 170, "", nil},
+		}},
+		{48000, 0, false, []track{
+			{0, 1, "USABC0000001", []index{{0, 1}}},
+			{640, 2, "", []index{{0, 0}, {640, 1}}},
+			{5000, 255, "", nil},
+		}},
+	}
+	for _, test := range tests {
+		info := &StreamInfo{SampleRate: test.rate, NChannels: 1, BitsPerSample: 16, TotalSamples: 5000}
+		var buf bytes.Buffer
+		e, err := NewEncoderOpts(&buf, MetaData{StreamInfo: info}, EncoderOptions{CueSheet: c})
+		if err != nil {
+			t.Fatalf("%d Hz: NewEncoderOpts failed: %v", test.rate, err)
+		}
+		if err := e.Write(testSignal(1, 5000, 16)); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := decodeAll(buf.Bytes()); err != nil {
+			t.Fatalf("%d Hz: decoding failed: %v", test.rate, err)
+		}
+
+		r := bytes.NewReader(buf.Bytes()[len(magic):])
+		var body []byte
+		for body == nil {
+			block, err := readRawMetaDataBlock(r)
+			if err != nil {
+				t.Fatalf("%d Hz: no CUESHEET block: %v", test.rate, err)
+			}
+			if BlockType(block[0]&0x7F) == BlockCueSheet {
+				body = block[4:]
+			}
+		}
+		if catalog := string(bytes.TrimRight(body[:128], "\x00")); catalog != "0123456789012" {
+			t.Errorf("%d Hz: got catalog %q", test.rate, catalog)
+		}
+		if leadIn := binary.BigEndian.Uint64(body[128:]); leadIn != test.leadIn {
+			t.Errorf("%d Hz: got lead-in %d, want %d", test.rate, leadIn, test.leadIn)
+		}
+		if cd := body[136]&0x80 != 0; cd != test.cd {
+			t.Errorf("%d Hz: got CD %t, want %t", test.rate, cd, test.cd)
+		}
+		if n := int(body[395]); n != len(test.tracks) {
+			t.Fatalf("%d Hz: got %d tracks, want %d", test.rate, n, len(test.tracks))
+		}
+		body = body[396:]
+		for _, want := range test.tracks {
+			got := track{
+				offset: binary.BigEndian.Uint64(body),
+				number: body[8],
+				isrc:   string(bytes.TrimRight(body[9:21], "\x00")),
+			}
+			n := int(body[35])
+			body = body[36:]
+			for i := 0; i < n; i++ {
+				got.indexes = append(got.indexes, index{binary.BigEndian.Uint64(body), body[8]})
+				body = body[12:]
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%d Hz: got track %+v, want %+v", test.rate, got, want)
+			}
+		}
+		if len(body) != 0 {
+			t.Errorf("%d Hz: %d trailing bytes", test.rate, len(body))
+		}
+	}
+
+	info := &StreamInfo{SampleRate: 44100, NChannels: 1, BitsPerSample: 16}
+	if _, err := NewEncoderOpts(ioutil.Discard, MetaData{StreamInfo: info}, EncoderOptions{CueSheet: c}); err == nil {
+		t.Errorf("No TotalSamples: expected an error")
+	}
+	info.TotalSamples = 5000
+	c.Tracks[0].ISRC = "short"
+	if _, err := NewEncoderOpts(ioutil.Discard, MetaData{StreamInfo: info}, EncoderOptions{CueSheet: c}); err == nil {
+		t.Errorf("Bad ISRC: expected an error")
+	}
+}
@@ -11,20 +11,25 @@ import (
 	"io"
 	"io/ioutil"
 	"strconv"
+	"time"
 
 	"github.com/eaburns/bit"
 )
 
 var magic = [4]byte{'f', 'L', 'a', 'C'}
 
+// NoMD5 is the STREAMINFO MD5 checksum of a stream whose encoder did not compute one.
+var noMD5 [md5.Size]byte
+
 // Decode reads a FLAC file, decodes it, verifies its MD5 checksum, and returns the data and metadata.
+// The checksum is not verified if it is all zeros, which means that it is unknown.
 func Decode(r io.Reader) ([]byte, MetaData, error) {
 	d, err := NewDecoder(r)
 	if err != nil {
 		return nil, MetaData{}, err
 	}
 
-	data := make([]byte, 0, d.TotalSamples*int64(d.NChannels)*int64(d.BitsPerSample/8))
+	data := make([]byte, 0, d.TotalSamples*int64(d.NChannels)*int64((d.BitsPerSample+7)/8))
 	for {
 		frame, err := d.Next()
 		if err == io.EOF {
@@ -39,27 +44,225 @@ func Decode(r io.Reader) ([]byte, MetaData, error) {
 	if _, err := h.Write(data); err != nil {
 		return nil, MetaData{}, err
 	}
-	if !bytes.Equal(h.Sum(nil), d.MD5[:]) {
+	if d.MD5 != noMD5 && !bytes.Equal(h.Sum(nil), d.MD5[:]) {
 		return nil, MetaData{}, errors.New("Bad MD5 checksum")
 	}
 	return data, d.MetaData, nil
 }
 
+// DecodeFrames is like Decode, but returns the samples of each channel,
+// instead of interleaved bytes.
+func DecodeFrames(r io.Reader) ([][]int32, MetaData, error) {
+	d, err := NewDecoder(r)
+	if err != nil {
+		return nil, MetaData{}, err
+	}
+
+	chs := make([][]int32, d.NChannels)
+	for i := range chs {
+		chs[i] = make([]int32, 0, d.TotalSamples)
+	}
+	h := md5.New()
+	for {
+		frame, err := d.NextSamples()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, MetaData{}, err
+		}
+		if len(frame) != len(chs) {
+			return nil, MetaData{}, errors.New("Frame channels do not match STREAMINFO")
+		}
+		for i := range chs {
+			chs[i] = append(chs[i], frame[i]...)
+		}
+		data, err := interleave(frame, d.BitsPerSample)
+		if err != nil {
+			return nil, MetaData{}, err
+		}
+		h.Write(data)
+	}
+
+	if d.MD5 != noMD5 && !bytes.Equal(h.Sum(nil), d.MD5[:]) {
+		return nil, MetaData{}, errors.New("Bad MD5 checksum")
+	}
+	return chs, d.MetaData, nil
+}
+
 // A Decoder decodes a FLAC audio file.
 // Unlike the Decode function, a decoder can decode the file incrementally,
 // one frame at a time.
+//
+// A Decoder is not safe for use by multiple goroutines; each call, including
+// reads of its MetaData, must complete before the next begins.
+// Use a LockedDecoder to share a Decoder between goroutines.
 type Decoder struct {
-	r io.Reader
+	// Src is the reader given to NewDecoder.
+	src io.Reader
+	r   *countingReader
+	// Seeker is src if it supports seeking, otherwise nil.
+	seeker io.Seeker
+	// Base is the offset of the start of the stream in seeker.
+	base int64
+	// FramesOffset is the offset of the first frame, relative to the start of the stream.
+	framesOffset int64
 	// N is the next frame number.
 	n int
 
+	opts Options
+	// MetaOffset is the offset of the first metadata block following STREAMINFO.
+	metaOffset int64
+	// Deferred is true if metadata following STREAMINFO has not been parsed.
+	deferred bool
+	// Skipped is true if the deferred metadata has been skipped over by Next.
+	skipped bool
+	// Samples is the number of inter-channel samples decoded so far.
+	samples int64
+	// Stream is the number of the current stream when decoding concatenated streams.
+	stream int
+	// Pending, if non-nil, is the remainder of a partially consumed frame,
+	// which is returned before the next frame is decoded.
+	pending [][]int32
+	// FrameStart is the offset of the most recently decoded frame.
+	frameStart int64
+	// PreviewEnd is the sample at which the audio ends with the Preview option.
+	previewEnd int64
+
+	// Bounds are the block and frame size bounds observed in decoded frames.
+	bounds Bounds
+	// LastBlock is the block size of the most recently decoded frame,
+	// which is not yet accounted for in bounds.MinBlock.
+	lastBlock int
+	// WarnedBounds is true if a frame outside of the STREAMINFO bounds has been reported.
+	warnedBounds bool
+
 	MetaData
 }
 
+// Options control optional behavior of a Decoder.
+type Options struct {
+	// DeferMetaData stops reading the header immediately after the STREAMINFO
+	// block.  The remaining metadata blocks are parsed by ReadMetaData, or,
+	// if ReadMetaData has not been called, skipped over by the first call to Next.
+	DeferMetaData bool
+
+	// ChannelOrder, if non-nil, specifies the order in which channels are
+	// emitted: output channel i is FLAC channel ChannelOrder[i].
+	// It must be a permutation of the channel numbers 0 through NChannels-1.
+	// For example, FLAC orders 5.1 audio as FL, FR, FC, LFE, BL, BR;
+	// ChannelOrder []int{0, 1, 4, 5, 2, 3} emits FL, FR, BL, BR, FC, LFE.
+	ChannelOrder []int
+
+	// LeftJustify causes Next to return 32-bit samples scaled to the full
+	// int32 range, regardless of the stream's bits per sample.
+	// Each sample is shifted left by the amount returned by Decoder.Shift.
+	LeftJustify bool
+
+	// Concatenated allows the stream to be followed immediately by another
+	// FLAC stream, as written by some capture tools that append recordings.
+	// When the fLaC marker of a new stream is found where a frame was
+	// expected, the Decoder reads the new stream's metadata, replacing its
+	// MetaData, and continues decoding frames from the new stream.
+	// Stream reports the number of the stream currently being decoded.
+	Concatenated bool
+
+	// Retries is the maximum number of times a read is retried after it fails
+	// with a temporary error, such as a network timeout or io.ErrNoProgress.
+	// If Retries is 0 then reads are not retried.
+	Retries int
+	// RetryDelay is the delay before the first retry of a read.
+	// The delay doubles with each subsequent retry up to MaxRetryDelay.
+	// If they are zero, RetryDelay defaults to 100ms and MaxRetryDelay to 5s.
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+
+	// SkipJunk, if positive, is the maximum number of bytes of junk before
+	// the fLaC marker that are skipped, as libFLAC does.  The junk ends at
+	// the first fLaC marker that is followed by a valid STREAMINFO block.
+	SkipJunk int
+
+	// RawMetaData keeps the undecoded metadata blocks in MetaData.Raw.
+	RawMetaData bool
+
+	// OnBlock, if non-nil, is called with the type and body of each metadata
+	// block other than STREAMINFO as it is read, before it is decoded.
+	// If OnBlock returns SkipBlock, the rest of the block is skipped without
+	// being read into memory, and the block is not decoded into the MetaData,
+	// so OnBlock can stream large blocks, such as PICTUREs, itself.
+	// If it returns nil, the block is decoded as usual, so OnBlock must not
+	// read from r.  Any other error is returned by the Decoder.
+	OnBlock func(kind BlockType, r io.Reader) error
+
+	// Warn, if non-nil, is called with non-fatal problems found in the stream,
+	// such as zero or inconsistent block size bounds in STREAMINFO.
+	Warn func(error)
+
+	// Preview, if positive, ends the audio returned by Next after that much
+	// audio from the position at which the Decoder was created or last sought,
+	// for cheaply previewing a stream.  The rest of the stream is not read.
+	Preview time.Duration
+	// FrameStride, if greater than 1, causes Next to return only every
+	// FrameStride-th frame, starting with the next frame, skipping the
+	// frames between as Skip does, without decoding them.  Combined with
+	// Preview, it limits the audio of the stream that is examined, not
+	// that which is returned.
+	FrameStride int
+}
+
+// SkipBlock is returned by an OnBlock function to skip a metadata block.
+var SkipBlock = errors.New("Skip this block")
+
 // MetaData contains metadata header information from a FLAC file header.
 type MetaData struct {
 	*StreamInfo
 	*VorbisComment
+	// Applications are the APPLICATION blocks, in the order they appear.
+	Applications []Application
+	// SeekTable is the SEEKTABLE block's seek points, or nil if there is none.
+	SeekTable []SeekPoint
+	// Pictures are the PICTURE blocks, in the order they appear.
+	Pictures []Picture
+	// Others are the blocks that are not decoded into the other fields,
+	// such as CUESHEET and blocks of unknown types, in the order they appear.
+	// PADDING blocks are not kept.
+	Others []RawBlock
+	// Padding are the positions and sizes of the PADDING blocks,
+	// in the order they appear.  It is ignored by the Encoder.
+	Padding []PaddingBlock
+	// Raw, with the RawMetaData option, holds every metadata block,
+	// including STREAMINFO and PADDING, undecoded and in the order they
+	// appear, so that they can be copied exactly.  The header of each block
+	// is given by its Type and the length of its Data, apart from
+	// the last-block flag, which depends on where it is written.
+	// It is ignored by the Encoder.
+	Raw []RawBlock
+}
+
+// A PaddingBlock describes a PADDING block, whose space can be used by
+// a rewrite of the metadata blocks without moving the audio frames.
+type PaddingBlock struct {
+	// Offset is the byte offset of the block's header from the start of the stream,
+	// including any ID3v2 tag before the fLaC marker.
+	Offset int64
+	// Size is the number of bytes of padding, not counting the 4-byte header.
+	Size int
+}
+
+// PaddingSize returns the total number of bytes of padding in the PADDING
+// blocks, not counting their 4-byte headers.
+func (m MetaData) PaddingSize() int {
+	var n int
+	for _, p := range m.Padding {
+		n += p.Size
+	}
+	return n
+}
+
+// A RawBlock is an undecoded metadata block.
+type RawBlock struct {
+	// Type is the block type, from 0 to 126.
+	Type int
+	Data []byte
 }
 
 // StreamInfo contains information about the FLAC stream.
@@ -75,6 +278,28 @@ type StreamInfo struct {
 	MD5           [md5.Size]byte
 }
 
+// Duration returns the duration of the stream,
+// or 0 if the total number of samples is unknown.
+func (s StreamInfo) Duration() time.Duration {
+	if s.SampleRate <= 0 {
+		return 0
+	}
+	secs, rem := s.TotalSamples/int64(s.SampleRate), s.TotalSamples%int64(s.SampleRate)
+	return time.Duration(secs)*time.Second + time.Duration(rem)*time.Second/time.Duration(s.SampleRate)
+}
+
+// Bitrate returns an estimate of the average bitrate of the stream's frames,
+// in bits per second, from the average of the minimum and maximum frame sizes
+// and block sizes.  It returns 0 if these bounds are unknown.
+// The bitrate of a stream whose size is known is better computed from its
+// size and Duration.
+func (s StreamInfo) Bitrate() int {
+	if s.MinFrame == 0 || s.MaxFrame == 0 || s.MinBlock+s.MaxBlock == 0 {
+		return 0
+	}
+	return int(int64(s.MinFrame+s.MaxFrame) * 8 * int64(s.SampleRate) / int64(s.MinBlock+s.MaxBlock))
+}
+
 // VorbisComment (a.k.a. FLAC tags) contains Vorbis-style comments that are
 // human-readable textual information.
 type VorbisComment struct {
@@ -86,62 +311,202 @@ type VorbisComment struct {
 // If an error is encountered while reading the header information then nil is
 // returned along with the error.
 func NewDecoder(r io.Reader) (*Decoder, error) {
-	err := checkMagic(r)
+	return NewDecoderOpts(r, Options{})
+}
+
+// NewReaderAtDecoder returns a new Decoder for the FLAC stream of the given size
+// read from r.  The Decoder reads with r.ReadAt, keeping its own position
+// in the stream, so any number of Decoders can decode different parts of
+// the same stream at once, for example to serve several ranges of one file.
+// Each Decoder itself is still only safe for use by one goroutine at a time.
+// Since the stream can seek, so can the Decoder.
+func NewReaderAtDecoder(r io.ReaderAt, size int64) (*Decoder, error) {
+	return NewDecoder(io.NewSectionReader(r, 0, size))
+}
+
+// NewDecoderOpts is like NewDecoder, but the Decoder's behavior is controlled
+// by the given Options.
+func NewDecoderOpts(r io.Reader, opts Options) (*Decoder, error) {
+	if opts.Retries > 0 {
+		r = newRetryReader(r, opts)
+	}
+	d := &Decoder{src: r, r: &countingReader{r: r}, opts: opts}
+	if s, ok := r.(io.Seeker); ok {
+		// Readers such as pipes may implement Seek, but fail.
+		if base, err := s.Seek(0, 1); err == nil {
+			d.base = base
+			d.seeker = s
+			d.r.seeker = s
+		}
+	}
+
+	var err error
+	if opts.SkipJunk > 0 {
+		err = skipJunk(d.r, opts.SkipJunk)
+	} else {
+		err = checkMagic(d.r)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	d := &Decoder{r: r}
-	if d.MetaData, err = readMetaData(d.r); err != nil {
+	if opts.DeferMetaData {
+		err = d.readStreamInfoOnly()
+	} else {
+		d.MetaData, err = readMetaData(d.r, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !d.deferred {
+		d.framesOffset = d.r.n
+	}
+	if err := d.checkStreamInfo(); err != nil {
 		return nil, err
 	}
+	d.startPreview()
+	return d, nil
+}
+
+// CheckStreamInfo returns an error if the STREAMINFO is missing or
+// describes a stream that the Decoder cannot decode with its options.
+func (d *Decoder) checkStreamInfo() error {
 	if d.StreamInfo == nil {
-		return nil, errors.New("Missing STREAMINFO header")
+		return errors.New("Missing STREAMINFO header")
 	}
 
-	if d.BitsPerSample != 8 && d.BitsPerSample != 16 && d.BitsPerSample != 24 {
-		return nil, errors.New("Unsupported bits per sample (" + strconv.Itoa(d.BitsPerSample) + "), supported values are: 8, 16, and 24")
+	if d.BitsPerSample < 4 || d.BitsPerSample > 32 {
+		return errors.New("Unsupported bits per sample (" + strconv.Itoa(d.BitsPerSample) + "), supported values are 4 to 32")
 	}
 
-	return d, nil
+	if d.opts.ChannelOrder != nil {
+		if err := checkChannelOrder(d.opts.ChannelOrder, d.NChannels); err != nil {
+			return err
+		}
+	}
+	d.checkBounds()
+	return nil
+}
+
+// CheckChannelOrder returns an error if order is not a permutation of [0, n).
+func checkChannelOrder(order []int, n int) error {
+	if len(order) != n {
+		return errors.New("Bad channel order: expected " + strconv.Itoa(n) + " channels, got " + strconv.Itoa(len(order)))
+	}
+	seen := make([]bool, n)
+	for _, ch := range order {
+		if ch < 0 || ch >= n || seen[ch] {
+			return errors.New("Bad channel order: not a permutation of the stream's channels")
+		}
+		seen[ch] = true
+	}
+	return nil
 }
 
+// CheckMagic reads the fLaC marker at the start of a stream, first skipping
+// any ID3v2 tags, which some taggers write before the marker.
 func checkMagic(r io.Reader) error {
 	var m [4]byte
 	if _, err := io.ReadFull(r, m[:]); err != nil {
 		return err
 	}
+	for m[0] == 'I' && m[1] == 'D' && m[2] == '3' {
+		if err := skipID3(r); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(r, m[:]); err != nil {
+			return err
+		}
+	}
 	if m != magic {
 		return errors.New("Bad fLaC magic header")
 	}
 	return nil
 }
 
-type blockType int
+// SkipJunk is like checkMagic, but it first skips up to limit bytes before
+// a fLaC marker that is followed by a valid STREAMINFO block.
+// Junk is not searched for if the stream begins with an ID3v2 tag.
+func skipJunk(r *countingReader, limit int) error {
+	const streamInfoBlockSize = 4 + 34
+	buf, err := r.peek(limit + len(magic) + streamInfoBlockSize)
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(buf, []byte("ID3")) {
+		return checkMagic(r)
+	}
+	for i := 0; i <= limit && i+len(magic)+streamInfoBlockSize <= len(buf); i++ {
+		b := buf[i:]
+		if !bytes.HasPrefix(b, magic[:]) || b[4]&0x7F != byte(BlockStreamInfo) ||
+			b[5] != 0 || b[6] != 0 || b[7] != 34 {
+			continue
+		}
+		if _, err := readStreamInfo(bytes.NewReader(b[8 : 8+34])); err == nil {
+			if err := r.skip(int64(i)); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	return checkMagic(r)
+}
 
+// SkipID3 skips the rest of an ID3v2 tag, following the 4 bytes
+// "ID3" and the major version that have already been read.
+// The rest of the header is the minor version, the flags, and the size
+// of the tag following the header as a 28-bit "syncsafe" integer,
+// with 7 bits in each of 4 bytes.  If the flags have bit 4 set,
+// the tag is followed by a 10-byte footer.
+func skipID3(r io.Reader) error {
+	var h [6]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return errors.New("Failed to read ID3v2 header: " + err.Error())
+	}
+	var n int64
+	for _, b := range h[2:] {
+		if b&0x80 != 0 {
+			return errors.New("Bad ID3v2 tag size")
+		}
+		n = n<<7 | int64(b)
+	}
+	if h[1]&0x10 != 0 {
+		n += 10
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, n); err != nil {
+		return errors.New("Failed to skip ID3v2 tag: " + err.Error())
+	}
+	return nil
+}
+
+// A BlockType is the type of a metadata block.
+type BlockType int
+
+// The types of metadata blocks defined by the FLAC format.
+// Types 7 to 126 are reserved.
 const (
-	streamInfoType    blockType = 0
-	paddingType       blockType = 1
-	applicationType   blockType = 2
-	seekTableType     blockType = 3
-	vorbisCommentType blockType = 4
-	cueSheetType      blockType = 5
-	pictureType       blockType = 6
+	BlockStreamInfo    BlockType = 0
+	BlockPadding       BlockType = 1
+	BlockApplication   BlockType = 2
+	BlockSeekTable     BlockType = 3
+	BlockVorbisComment BlockType = 4
+	BlockCueSheet      BlockType = 5
+	BlockPicture       BlockType = 6
 
 	invalidBlockType = 127
 )
 
-var blockTypeNames = map[blockType]string{
-	streamInfoType:    "STREAMINFO",
-	paddingType:       "PADDING",
-	applicationType:   "APPLICATION",
-	seekTableType:     "SEEKTABLE",
-	vorbisCommentType: "VORBIS_COMMENT",
-	cueSheetType:      "CUESHEET",
-	pictureType:       "PICTURE",
+var blockTypeNames = map[BlockType]string{
+	BlockStreamInfo:    "STREAMINFO",
+	BlockPadding:       "PADDING",
+	BlockApplication:   "APPLICATION",
+	BlockSeekTable:     "SEEKTABLE",
+	BlockVorbisComment: "VORBIS_COMMENT",
+	BlockCueSheet:      "CUESHEET",
+	BlockPicture:       "PICTURE",
 }
 
-func (t blockType) String() string {
+func (t BlockType) String() string {
 	if n, ok := blockTypeNames[t]; ok {
 		return n
 	}
@@ -151,51 +516,225 @@ func (t blockType) String() string {
 	return "Unknown(" + strconv.Itoa(int(t)) + ")"
 }
 
-func readMetaData(r io.Reader) (MetaData, error) {
+// ReadStreamInfoOnly reads the first metadata block, which must be STREAMINFO,
+// and records the offset of any metadata blocks that follow it.
+func (d *Decoder) readStreamInfoOnly() error {
+	last, kind, err := readBlock(d.r, &d.MetaData, d.opts)
+	if err != nil {
+		return err
+	}
+	if kind != BlockStreamInfo {
+		return errors.New("Missing STREAMINFO header")
+	}
+	if !last {
+		d.metaOffset = d.r.n
+		d.deferred = true
+	}
+	return nil
+}
+
+// MetaDataOffset returns the byte offset, relative to the start of the stream,
+// of the metadata blocks following STREAMINFO that were deferred by the
+// DeferMetaData option.  If no metadata was deferred then 0 is returned.
+func (d *Decoder) MetaDataOffset() int64 {
+	return d.metaOffset
+}
+
+// ReadMetaData parses the metadata blocks that were deferred by the
+// DeferMetaData option, adding them to the Decoder's MetaData.
+// If Next has already skipped over the deferred blocks then the reader given to
+// NewDecoderOpts must implement io.Seeker; the blocks are re-read and
+// the reader is returned to its current position.
+// If there is no deferred metadata then ReadMetaData does nothing.
+func (d *Decoder) ReadMetaData() error {
+	if !d.deferred {
+		return nil
+	}
+	if !d.skipped {
+		if err := readMetaDataBlocks(d.r, d.metaOffset, d.opts, &d.MetaData); err != nil {
+			return err
+		}
+		d.deferred = false
+		return nil
+	}
+
+	s, ok := d.src.(io.Seeker)
+	if !ok {
+		return errors.New("Deferred metadata was skipped and the reader cannot seek")
+	}
+	cur, err := s.Seek(0, 1)
+	if err != nil {
+		return err
+	}
+	if _, err = s.Seek(cur-(d.r.n+int64(d.r.buffered())-d.metaOffset), 0); err != nil {
+		return err
+	}
+	if err = readMetaDataBlocks(d.src, d.metaOffset, d.opts, &d.MetaData); err != nil {
+		return err
+	}
+	if _, err = s.Seek(cur, 0); err != nil {
+		return err
+	}
+	d.deferred = false
+	return nil
+}
+
+// SkipMetaData skips over deferred metadata blocks without parsing them.
+func (d *Decoder) skipMetaData() error {
+	for {
+		last, _, n, err := readMetaDataHeader(d.r)
+		if err != nil {
+			return errors.New("Failed to read metadata header: " + err.Error())
+		}
+		if err := d.r.skip(int64(n)); err != nil {
+			return errors.New("Failed to skip metadata: " + err.Error())
+		}
+		if last {
+			d.skipped = true
+			d.framesOffset = d.r.n
+			return nil
+		}
+	}
+}
+
+// ReadMetaData reads the metadata blocks following the fLaC marker,
+// as controlled by the RawMetaData and OnBlock options.
+func readMetaData(r *countingReader, opts Options) (MetaData, error) {
 	var meta MetaData
+	err := readMetaDataBlocks(r, r.n, opts, &meta)
+	return meta, err
+}
+
+// ReadMetaDataBlocks reads metadata blocks into meta up to and including the last block.
+// Offset is the offset of the first block from the start of the stream.
+func readMetaDataBlocks(r io.Reader, offset int64, opts Options, meta *MetaData) error {
+	cr := &countingReader{r: r, n: offset}
 	for {
-		last, kind, n, err := readMetaDataHeader(r)
+		start := cr.n
+		last, kind, err := readBlock(cr, meta, opts)
 		if err != nil {
-			return meta, errors.New("Failed to read metadata header: " + err.Error())
+			return err
+		}
+		if kind == BlockPadding {
+			meta.Padding = append(meta.Padding, PaddingBlock{Offset: start, Size: int(cr.n - start - 4)})
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// ReadBlock is like readMetaDataBlock, but it first calls the OnBlock option,
+// and with the RawMetaData option, it also adds the undecoded block to meta.Raw.
+func readBlock(r io.Reader, meta *MetaData, opts Options) (bool, BlockType, error) {
+	if opts.OnBlock != nil {
+		var h [4]byte
+		if _, err := io.ReadFull(r, h[:]); err != nil {
+			return false, 0, errors.New("Failed to read metadata header: " + err.Error())
 		}
+		last, kind := h[0]&0x80 != 0, BlockType(h[0]&0x7F)
+		if kind != BlockStreamInfo && kind != invalidBlockType {
+			n := int64(h[1])<<16 | int64(h[2])<<8 | int64(h[3])
+			body := &io.LimitedReader{R: r, N: n}
+			switch err := opts.OnBlock(kind, body); {
+			case err == SkipBlock:
+				if _, err := io.Copy(ioutil.Discard, body); err != nil {
+					return false, 0, errors.New("Failed to skip metadata: " + err.Error())
+				}
+				return last, kind, nil
+			case err != nil:
+				return false, 0, err
+			case body.N != n:
+				return false, 0, errors.New("OnBlock read a " + kind.String() + " block that it did not skip")
+			}
+		}
+		r = io.MultiReader(bytes.NewReader(h[:]), r)
+	}
+	if !opts.RawMetaData {
+		return readMetaDataBlock(r, meta)
+	}
+	block, err := readRawMetaDataBlock(r)
+	if err != nil {
+		return false, 0, err
+	}
+	meta.Raw = append(meta.Raw, RawBlock{Type: int(block[0] & 0x7F), Data: block[4:]})
+	return readMetaDataBlock(bytes.NewReader(block), meta)
+}
 
-		header := &io.LimitedReader{R: r, N: int64(n)}
+// ReadMetaDataBlock reads a single metadata block into meta.
+func readMetaDataBlock(r io.Reader, meta *MetaData) (last bool, kind BlockType, err error) {
+	last, kind, n, err := readMetaDataHeader(r)
+	if err != nil {
+		return false, 0, errors.New("Failed to read metadata header: " + err.Error())
+	}
 
-		switch kind {
-		case invalidBlockType:
-			return meta, errors.New("Invalid metadata block type (127)")
+	header := &io.LimitedReader{R: r, N: int64(n)}
 
-		case streamInfoType:
-			meta.StreamInfo, err = readStreamInfo(header)
+	switch kind {
+	case invalidBlockType:
+		return false, 0, errors.New("Invalid metadata block type (127)")
 
-		case vorbisCommentType:
-			meta.VorbisComment, err = readVorbisComment(header)
+	case BlockStreamInfo:
+		if meta.StreamInfo != nil {
+			return false, 0, errors.New("Multiple STREAMINFO blocks")
+		}
+		var info *StreamInfo
+		if info, err = readStreamInfo(header); err == nil {
+			meta.StreamInfo = info
 		}
 
-		if err != nil {
-			return meta, err
+	case BlockVorbisComment:
+		var cmnt *VorbisComment
+		if cmnt, err = readVorbisComment(header); err == nil {
+			meta.VorbisComment = cmnt
 		}
 
-		// Junk any unread bytes.
-		if _, err = io.Copy(ioutil.Discard, header); err != nil {
-			return meta, errors.New("Failed to discard metadata: " + err.Error())
+	case BlockApplication:
+		var app Application
+		if app, err = readApplication(header); err == nil {
+			meta.Applications = append(meta.Applications, app)
 		}
 
-		if last {
-			break
+	case BlockPicture:
+		var pic Picture
+		if pic, err = readPicture(header); err == nil {
+			meta.Pictures = append(meta.Pictures, pic)
+		}
+
+	case BlockSeekTable:
+		var points []SeekPoint
+		if points, err = readSeekTable(header); err == nil {
+			meta.SeekTable = points
+		}
+
+	case BlockPadding:
+
+	default:
+		var data []byte
+		if data, err = ioutil.ReadAll(header); err == nil {
+			meta.Others = append(meta.Others, RawBlock{Type: int(kind), Data: data})
 		}
 	}
-	return meta, nil
+
+	if err != nil {
+		return false, 0, err
+	}
+
+	// Junk any unread bytes.
+	if _, err = io.Copy(ioutil.Discard, header); err != nil {
+		return false, 0, errors.New("Failed to discard metadata: " + err.Error())
+	}
+	return last, kind, nil
 }
 
-func readMetaDataHeader(r io.Reader) (last bool, kind blockType, n int32, err error) {
+func readMetaDataHeader(r io.Reader) (last bool, kind BlockType, n int32, err error) {
 	const headerSize = 32 // bits
 	br := bit.NewReader(&io.LimitedReader{R: r, N: headerSize})
 	fs, err := br.ReadFields(1, 7, 24)
 	if err != nil {
 		return false, 0, 0, err
 	}
-	return fs[0] == 1, blockType(fs[1]), int32(fs[2]), nil
+	return fs[0] == 1, BlockType(fs[1]), int32(fs[2]), nil
 }
 
 func readStreamInfo(r io.Reader) (*StreamInfo, error) {
@@ -270,24 +809,256 @@ func vorbisString(data []byte) (string, []byte, error) {
 	return string(data[:n]), data[n:], nil
 }
 
+// ErrTruncated is reported when a stream ends in the middle of a frame, or
+// before STREAMINFO's TotalSamples samples have been decoded.
+// Next returns it wrapped in a *TruncatedError.
+var ErrTruncated = errors.New("Truncated FLAC stream")
+
+// A TruncatedError is returned by Next when the stream is truncated.
+type TruncatedError struct {
+	// Samples is the number of inter-channel samples decoded before the
+	// truncation.
+	Samples int64
+}
+
+func (e *TruncatedError) Error() string {
+	return ErrTruncated.Error() + " after " + strconv.FormatInt(e.Samples, 10) + " samples"
+}
+
+// Unwrap returns ErrTruncated.
+func (e *TruncatedError) Unwrap() error {
+	return ErrTruncated
+}
+
 // Next returns the audio data from the next frame.
+// At the end of the stream, Next returns io.EOF if the stream ended on a frame
+// boundary with all of the samples given by STREAMINFO decoded.
+// Otherwise it returns a *TruncatedError.
 func (d *Decoder) Next() ([]byte, error) {
+	data, err := d.NextSamples()
+	if err != nil {
+		return nil, err
+	}
+	if d.opts.LeftJustify {
+		return interleave(data, 32)
+	}
+	return interleave(data, d.BitsPerSample)
+}
+
+// NextSamples is like Next, but returns the samples of the next frame as
+// a slice for each channel, instead of interleaved bytes.
+func (d *Decoder) NextSamples() ([][]int32, error) {
+	if d.opts.FrameStride > 1 && d.pending == nil {
+		if err := d.skipStride(); err != nil {
+			return nil, err
+		}
+	}
+	if d.opts.Preview > 0 && d.Pos() >= d.previewEnd {
+		return nil, io.EOF
+	}
+	data, err := d.decodeFrame()
+	if err != nil {
+		return nil, err
+	}
+	// The samples returned by decodeFrame end at d.samples.
+	if d.opts.Preview > 0 && d.samples > d.previewEnd {
+		n := int64(len(data[0])) - (d.samples - d.previewEnd)
+		for ch := range data {
+			data[ch] = data[ch][:n]
+		}
+	}
+	if d.opts.ChannelOrder != nil {
+		data = reorderChannels(data, d.opts.ChannelOrder)
+	}
+	if d.opts.LeftJustify {
+		leftJustify(data, d.Shift())
+	}
+	return data, nil
+}
+
+// A FrameHeader describes a frame of audio.
+type FrameHeader struct {
+	// BlockSize is the number of inter-channel samples in the frame.
+	BlockSize  int
+	SampleRate int
+	NChannels  int
+	// ChannelAssignment is NChannels-1 for independent channels,
+	// or 8, 9, or 10 for stereo coded as left and side channels,
+	// side and right, or mid and side.
+	ChannelAssignment int
+	BitsPerSample     int
+	// VariableSize is true if the stream uses variable block sizes,
+	// in which case Number is the frame's first sample number.
+	// Otherwise Number is the frame number.
+	VariableSize bool
+	Number       uint64
+	// Sample is the number of the first sample of the frame in the stream,
+	// as counted by the Decoder, which is the same for fixed and variable
+	// block size streams.  It is set by PeekFrameHeader, and is ignored
+	// by AppendFrameHeader.
+	Sample int64
+}
+
+// maxFrameHeaderSize is the size of the largest possible frame header in bytes:
+// 4 bytes of fixed fields, up to 7 bytes of UTF-8 coded number,
+// 2 bytes of block size, 2 bytes of sample rate, and the CRC-8.
+const maxFrameHeaderSize = 4 + 7 + 2 + 2 + 1
+
+// PeekFrameHeader returns the header of the next frame without consuming it;
+// the frame is still returned by the following call to Next.
+// At the end of the stream, PeekFrameHeader returns the same error that Next would.
+func (d *Decoder) PeekFrameHeader() (*FrameHeader, error) {
+	if err := d.beginFrame(); err != nil {
+		return nil, err
+	}
+	buf, err := d.r.peek(maxFrameHeaderSize)
+	switch {
+	case err == io.EOF:
+		if d.TotalSamples > 0 && d.samples < d.TotalSamples {
+			return nil, &TruncatedError{Samples: d.samples}
+		}
+		return nil, io.EOF
+	case err != nil:
+		return nil, err
+	}
+	h, err := readFrameHeader(bytes.NewReader(buf), d.StreamInfo)
+	if err != nil {
+		return nil, d.frameError("Failed to read the frame header: ", err)
+	}
+	return &FrameHeader{
+		BlockSize:         h.blockSize,
+		SampleRate:        h.sampleRate,
+		NChannels:         h.channelAssignment.nChannels(),
+		ChannelAssignment: int(h.channelAssignment),
+		BitsPerSample:     h.sampleSize,
+		VariableSize:      h.variableSize,
+		Number:            h.number,
+		Sample:            d.samples,
+	}, nil
+}
+
+// BeginFrame prepares to read the next frame.
+// It skips over deferred metadata if it has not yet been read or skipped,
+// and, with the Concatenated option, begins the next stream if its fLaC marker
+// is next.
+func (d *Decoder) beginFrame() error {
+	if d.deferred && !d.skipped {
+		if err := d.skipMetaData(); err != nil {
+			return err
+		}
+	}
+	if !d.opts.Concatenated {
+		return nil
+	}
+	if m, err := d.r.peek(len(magic)); err != nil || !bytes.Equal(m, magic[:]) {
+		// Errors are reported when reading the frame header.
+		return nil
+	}
+	return d.nextStream()
+}
+
+// NextStream reads the header of a concatenated stream and resets the Decoder
+// to decode its frames.
+func (d *Decoder) nextStream() error {
+	if err := checkMagic(d.r); err != nil {
+		return err
+	}
+	meta, err := readMetaData(d.r, d.opts)
+	if err != nil {
+		return err
+	}
+	d.MetaData = meta
+	if err := d.checkStreamInfo(); err != nil {
+		return err
+	}
+	d.n = 0
+	d.samples = 0
+	d.bounds = Bounds{}
+	d.lastBlock = 0
+	d.warnedBounds = false
+	d.metaOffset = 0
+	d.deferred = false
+	d.skipped = false
+	d.framesOffset = d.r.n
+	d.stream++
+	return nil
+}
+
+// SeekFrame moves the Decoder to the frame of the current stream
+// given by a seek point.  The reader given to NewDecoder must be an io.Seeker.
+func (d *Decoder) seekFrame(p SeekPoint) error {
+	if d.deferred && !d.skipped {
+		if err := d.skipMetaData(); err != nil {
+			return err
+		}
+	}
+	if _, err := d.seeker.Seek(d.base+d.framesOffset+p.Offset, 0); err != nil {
+		return err
+	}
+	d.r.n = d.framesOffset + p.Offset
+	d.r.peeked = nil
+	d.n = 0
+	d.samples = p.Sample
+	d.pending = nil
+	return nil
+}
+
+// Stream returns the number of the stream being decoded, starting from 0.
+// It is only ever non-zero with the Concatenated option, after the Decoder
+// has moved on to a subsequent stream.  The MetaData then describes
+// the current stream.
+func (d *Decoder) Stream() int {
+	return d.stream
+}
+
+// Pos returns the number of the next sample to be returned by Next
+// or NextSamples in the current stream.  Between frames, this is
+// the first sample of the next frame, but after a Seek it may be
+// within a frame.
+func (d *Decoder) Pos() int64 {
+	return d.samples - d.pendingSamples()
+}
+
+// DecodeFrame returns the decoded samples of each channel of the next frame.
+func (d *Decoder) decodeFrame() ([][]int32, error) {
+	if d.pending != nil {
+		data := d.pending
+		d.pending = nil
+		return data, nil
+	}
+	data, _, _, err := d.readFrame()
+	return data, err
+}
+
+// ReadFrame returns the decoded samples of each channel of the next frame,
+// along with its header and its encoding.  Unlike decodeFrame,
+// it ignores any pending samples.
+func (d *Decoder) readFrame() ([][]int32, *frameHeader, []byte, error) {
+	if err := d.beginFrame(); err != nil {
+		return nil, nil, nil, err
+	}
 	defer func() { d.n++ }()
 
+	start := d.r.n
+	d.frameStart = start
 	raw := bytes.NewBuffer(nil)
 	frame := io.TeeReader(d.r, raw)
 	h, err := readFrameHeader(frame, d.StreamInfo)
-	if err == io.EOF {
-		return nil, err
-	} else if err != nil {
-		return nil, errors.New("Failed to read the frame header: " + err.Error())
+	switch {
+	case err == io.EOF && d.r.n == start:
+		if d.TotalSamples > 0 && d.samples < d.TotalSamples {
+			return nil, nil, nil, &TruncatedError{Samples: d.samples}
+		}
+		return nil, nil, nil, io.EOF
+	case err != nil:
+		return nil, nil, nil, d.frameError("Failed to read the frame header: ", err)
 	}
 
 	br := bit.NewReader(frame)
 	data := make([][]int32, h.channelAssignment.nChannels())
 	for ch := range data {
 		if data[ch], err = readSubFrame(br, h, ch); err != nil {
-			return nil, err
+			return nil, nil, nil, d.frameError("", err)
 		}
 	}
 
@@ -296,24 +1067,76 @@ func (d *Decoder) Next() ([]byte, error) {
 	// next byte.
 	var crc16 [2]byte
 	if _, err := io.ReadFull(frame, crc16[:]); err != nil {
-		return nil, err
+		return nil, nil, nil, d.frameError("", err)
 	}
 	if err = verifyCRC16(raw.Bytes()); err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
 
 	fixChannels(data, h.channelAssignment)
-	return interleave(data, d.BitsPerSample)
+	d.samples += int64(h.blockSize)
+	d.observeFrame(h.blockSize, int(d.r.n-start))
+	return data, h, raw.Bytes(), nil
+}
+
+// FrameError returns a *TruncatedError if err indicates that the stream ended
+// within a frame, otherwise it returns err with the given prefix.
+func (d *Decoder) frameError(prefix string, err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return &TruncatedError{Samples: d.samples}
+	}
+	if prefix == "" {
+		return err
+	}
+	return errors.New(prefix + err.Error())
+}
+
+// Shift returns the number of bits by which samples are shifted left by the
+// LeftJustify option, which is 32 minus the stream's bits per sample.
+// If LeftJustify is not set, Shift returns 0.
+func (d *Decoder) Shift() uint {
+	if !d.opts.LeftJustify {
+		return 0
+	}
+	return uint(32 - d.BitsPerSample)
+}
+
+func leftJustify(data [][]int32, shift uint) {
+	if shift == 0 {
+		return
+	}
+	for _, ch := range data {
+		for i := range ch {
+			ch[i] <<= shift
+		}
+	}
+}
+
+// ReorderChannels returns the channels of data in the given order.
+func reorderChannels(data [][]int32, order []int) [][]int32 {
+	chs := make([][]int32, len(order))
+	for i, ch := range order {
+		chs[i] = data[ch]
+	}
+	return chs
 }
 
 func readSubFrame(br *bit.Reader, h *frameHeader, ch int) ([]int32, error) {
 	var data []int32
 	bps := h.bitsPerSample(ch)
+	if bps > 32 {
+		// The side channel of 32-bit stereo does not fit in an int32.
+		return nil, errors.New("Unsupported 33-bit side channel in a 32-bit stream")
+	}
 
-	kind, order, err := readSubFrameHeader(br)
+	kind, order, wasted, err := readSubFrameHeader(br)
 	if err != nil {
 		return nil, err
 	}
+	if wasted >= bps {
+		return nil, errors.New("Bad number of wasted bits: " + strconv.Itoa(int(wasted)))
+	}
+	bps -= wasted
 	switch kind {
 	case subFrameConstant:
 		v, err := br.Read(bps)
@@ -352,76 +1175,90 @@ func readSubFrame(br *bit.Reader, h *frameHeader, ch int) ([]int32, error) {
 		return nil, errors.New("Unsupported frame kind")
 	}
 
+	if wasted > 0 {
+		for j := range data {
+			data[j] <<= wasted
+		}
+	}
 	return data, nil
 }
 
 func fixChannels(data [][]int32, assign channelAssignment) {
+	if assign < leftSide {
+		return
+	}
+	// Re-slicing to a common length lets the compiler drop
+	// the bounds checks from the loops.
+	left, right := data[0], data[1][:len(data[0])]
 	switch assign {
 	case leftSide:
-		for i, d0 := range data[0] {
-			data[1][i] = d0 - data[1][i]
+		for i, l := range left {
+			right[i] = l - right[i]
 		}
 
 	case rightSide:
-		for i, d1 := range data[1] {
-			data[0][i] += d1
+		for i, r := range right {
+			left[i] += r
 		}
 
 	case midSide:
-		for i, mid := range data[0] {
-			side := data[1][i]
-			mid *= 2
-			mid |= (side & 1) // if side is odd
-			data[0][i] = (mid + side) / 2
-			data[1][i] = (mid - side) / 2
+		for i, mid := range left {
+			side := right[i]
+			mid = mid<<1 | side&1 // if side is odd
+			// mid±side is even, so the shifts divide exactly.
+			left[i] = (mid + side) >> 1
+			right[i] = (mid - side) >> 1
 		}
 	}
 }
 
+// Interleave returns the samples of the channels interleaved, each in the
+// fewest whole bytes that hold it, little-endian and sign-extended,
+// as they are packed for the MD5 checksum.
 func interleave(chs [][]int32, bps int) ([]byte, error) {
-	nSamples := len(chs[0])
-
-	switch bps {
-	case 8:
-		data := make([]byte, nSamples*len(chs))
-		var i int
-		for j := 0; j < nSamples; j++ {
-			for _, ch := range chs {
-				data[i] = byte(ch[j])
-				i++
+	if bps < 4 || bps > 32 {
+		return nil, errors.New("Unsupported bits per sample")
+	}
+	size := (bps + 7) / 8
+	n := len(chs[0])
+	stride := size * len(chs)
+	data := make([]byte, stride*n)
+
+	// Each channel is written in its own pass so that the inner loops
+	// read sequentially and, with the slices re-sliced to known lengths,
+	// compile without bounds checks on each byte.
+	for c, ch := range chs {
+		ch = ch[:n]
+		out := data[c*size:]
+		switch size {
+		case 1:
+			for j, s := range ch {
+				out[j*stride] = byte(s)
 			}
-		}
-		return data, nil
-
-	case 16:
-		data := make([]byte, 2*nSamples*len(chs))
-		var i int
-		for j := 0; j < nSamples; j++ {
-			for _, ch := range chs {
-				s := ch[j]
-				data[i] = byte(s & 0xFF)
-				data[i+1] = byte((s >> 8) & 0xFF)
-				i += 2
+		case 2:
+			for j, s := range ch {
+				b := out[j*stride : j*stride+2]
+				b[0] = byte(s)
+				b[1] = byte(s >> 8)
 			}
-		}
-		return data, nil
-
-	case 24:
-		data := make([]byte, 3*nSamples*len(chs))
-		var i int
-		for j := 0; j < nSamples; j++ {
-			for _, ch := range chs {
-				s := ch[j]
-				data[i] = byte(s & 0xFF)
-				data[i+1] = byte((s >> 8) & 0xFF)
-				data[i+2] = byte((s >> 16) & 0xFF)
-				i += 3
+		case 3:
+			for j, s := range ch {
+				b := out[j*stride : j*stride+3]
+				b[0] = byte(s)
+				b[1] = byte(s >> 8)
+				b[2] = byte(s >> 16)
+			}
+		case 4:
+			for j, s := range ch {
+				b := out[j*stride : j*stride+4]
+				b[0] = byte(s)
+				b[1] = byte(s >> 8)
+				b[2] = byte(s >> 16)
+				b[3] = byte(s >> 24)
 			}
 		}
-		return data, nil
-
 	}
-	return nil, errors.New("Unsupported bits per sample")
+	return data, nil
 }
 
 type frameHeader struct {
@@ -480,7 +1317,7 @@ var (
 		12: 4096,
 		13: 8192,
 		14: 16384,
-		15: 23768,
+		15: 32768,
 	}
 
 	sampleRates = [...]int{
@@ -490,7 +1327,7 @@ var (
 		3:  192000,
 		4:  8000,
 		5:  16000,
-		6:  220500,
+		6:  22050,
 		7:  24000,
 		8:  32000,
 		9:  44100,
@@ -510,7 +1347,7 @@ var (
 		4: 16,
 		5: 20,
 		6: 24,
-		7: -1, // Reserved.
+		7: 32,
 	}
 )
 
@@ -550,7 +1387,7 @@ func readFrameHeader(r io.Reader, info *StreamInfo) (*frameHeader, error) {
 	switch sampleSize := fs[5]; sampleSize {
 	case 0:
 		h.sampleSize = info.BitsPerSample
-	case 3, 7:
+	case 3:
 		return nil, errors.New("Bad sample size in frame header")
 	default:
 		h.sampleSize = sampleSizes[sampleSize]
@@ -587,7 +1424,7 @@ func readFrameHeader(r io.Reader, info *StreamInfo) (*frameHeader, error) {
 		if err != nil {
 			return nil, err
 		}
-		h.sampleRate = int(r)
+		h.sampleRate = int(r * 1000)
 	case 13:
 		r, err := br.Read(16)
 		if err != nil {
@@ -637,17 +1474,19 @@ func (k subFrameKind) String() string {
 	}
 }
 
-func readSubFrameHeader(br *bit.Reader) (kind subFrameKind, order int, err error) {
+// ReadSubFrameHeader returns the kind and predictor order of a subframe,
+// and its number of wasted bits per sample.
+func readSubFrameHeader(br *bit.Reader) (kind subFrameKind, order int, wasted uint, err error) {
 	switch pad, err := br.Read(1); {
 	case err != nil:
-		return 0, 0, err
+		return 0, 0, 0, err
 	case pad != 0:
 		// Do nothing, but this is a bad padding value.
 	}
 
 	switch k, err := br.Read(6); {
 	case err != nil:
-		return 0, 0, err
+		return 0, 0, 0, err
 
 	case k == 0:
 		kind = subFrameConstant
@@ -656,11 +1495,11 @@ func readSubFrameHeader(br *bit.Reader) (kind subFrameKind, order int, err error
 		kind = subFrameVerbatim
 
 	case (k&0x3E == 0x02) || (k&0x3C == 0x04) || (k&0x30 == 0x10):
-		return 0, 0, errors.New("Bad subframe type")
+		return 0, 0, 0, errors.New("Bad subframe type")
 
 	case k&0x38 == 0x08:
 		if order = int(k & 0x07); order > 4 {
-			return 0, 0, errors.New("Bad subframe type")
+			return 0, 0, 0, errors.New("Bad subframe type")
 		}
 		kind = subFrameFixed
 
@@ -669,26 +1508,29 @@ func readSubFrameHeader(br *bit.Reader) (kind subFrameKind, order int, err error
 		kind = subFrameLPC
 
 	default:
-		return 0, 0, errors.New("Invalid subframe type")
+		return 0, 0, 0, errors.New("Invalid subframe type")
 	}
 
-	n := 0
+	// The wasted bits flag is followed by the unary coding of the count minus 1.
 	switch k, err := br.Read(1); {
 	case err != nil:
-		return 0, 0, err
+		return 0, 0, 0, err
 
 	case k == 1:
-		n++
-		k = uint64(0)
-		for k == 0 {
-			if k, err = br.Read(1); err != nil {
-				return 0, 0, err
+		wasted++
+		for {
+			k, err := br.Read(1)
+			if err != nil {
+				return 0, 0, 0, err
 			}
-			n++
+			if k == 1 {
+				break
+			}
+			wasted++
 		}
 	}
 
-	return kind, order, nil
+	return kind, order, wasted, nil
 }
 
 var fixedCoeffs = [...][]int32{
@@ -768,11 +1610,13 @@ func lpcDecode(coeffs, warm, residual []int32, shift uint) []int32 {
 	data := make([]int32, len(warm)+len(residual))
 	copy(data, warm)
 	for i := len(warm); i < len(data); i++ {
-		var sum int32
+		// The sum can exceed 32 bits for 24-bit audio with high precision
+		// coefficients, so it is computed in 64 bits.
+		var sum int64
 		for j, c := range coeffs {
-			sum += c * data[i-j-1]
-			data[i] = residual[i-len(warm)] + (sum >> shift)
+			sum += int64(c) * int64(data[i-j-1])
 		}
+		data[i] = residual[i-len(warm)] + int32(sum>>shift)
 	}
 	return data
 }
@@ -795,14 +1639,15 @@ func decodeResiduals(br *bit.Reader, blkSize int, predO int) ([]int32, error) {
 	if err != nil {
 		return nil, err
 	}
+	if blkSize%(1<<partO) != 0 || blkSize>>partO < predO {
+		return nil, errors.New("Bad residual partition order")
+	}
 
 	var residue []int32
 	for i := 0; i < 1<<partO; i++ {
 		M, err := br.Read(bits)
 		if err != nil {
 			return nil, err
-		} else if (bits == 4 && M == 0xF) || (bits == 5 && M == 0x1F) {
-			return nil, errors.New("Unsupported, unencoded residuals")
 		}
 
 		n := 0
@@ -815,6 +1660,15 @@ func decodeResiduals(br *bit.Reader, blkSize int, predO int) ([]int32, error) {
 			n = (blkSize / (1 << partO)) - predO
 		}
 
+		if M == 1<<bits-1 {
+			// An escaped partition of unencoded residuals.
+			r, err := readEscaped(br, n)
+			if err != nil {
+				return nil, err
+			}
+			residue = append(residue, r...)
+			continue
+		}
 		r, err := riceDecode(br, n, uint(M))
 		if err != nil {
 			return nil, err
@@ -824,6 +1678,27 @@ func decodeResiduals(br *bit.Reader, blkSize int, predO int) ([]int32, error) {
 	return residue, nil
 }
 
+// ReadEscaped reads the size in bits of the n residuals of an escaped
+// partition, followed by the residuals in two's complement.
+func readEscaped(br *bit.Reader, n int) ([]int32, error) {
+	size, err := br.Read(5)
+	if err != nil {
+		return nil, err
+	}
+	ns := make([]int32, n)
+	if size == 0 {
+		return ns, nil
+	}
+	for i := range ns {
+		v, err := br.Read(uint(size))
+		if err != nil {
+			return nil, err
+		}
+		ns[i] = signExtend(v, uint(size))
+	}
+	return ns, nil
+}
+
 func signExtend(v uint64, bits uint) int32 {
 	if v&(1<<(bits-1)) != 0 {
 		return int32(v | (^uint64(0))<<bits)
@@ -4,7 +4,19 @@ package flac
 
 import (
 	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/eaburns/bit"
 )
@@ -277,9 +289,9 @@ func TestReadFrameHeaderError(t *testing.T) {
 				// 0001 · 1001
 				0x19,
 
-				// 2 channels · bad bits per sample · 0 reserved
-				// 0010 · 111 · 0
-				0x2E,
+				// 2 channels · reserved bits per sample · 0 reserved
+				// 0010 · 011 · 0
+				0x26,
 
 				// UTF8 frame number 0—frame number since fixed size
 				0x00,
@@ -505,3 +517,1100 @@ func TestReadFrameHeaderError(t *testing.T) {
 		}
 	}
 }
+
+func TestDeferMetaData(t *testing.T) {
+	data := []byte{
+		'f', 'L', 'a', 'C',
+		0x00, 0, 0, 34, // metadata header: stream info.
+
+		// STREAMINFO
+		0, 0, // min block size
+		0, 0, // max block size
+		0, 0, 0, // min frame size
+		0, 0, 0, // max frame size
+		0, 0, 0x14, 0x70, 0, 0, 0, 0, // rate 1, 2 channels, 8 bits/sample, 0 samples
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // MD5, obviously not the true value.
+
+		0x84, 0, 0, 18, // last metadata header: vorbis comment.
+
+		// VORBIS_COMMENT
+		3, 0, 0, 0, 'a', 'b', 'c', // vendor
+		1, 0, 0, 0, // 1 comment
+		3, 0, 0, 0, 'A', '=', 'b',
+	}
+
+	d, err := NewDecoderOpts(bytes.NewReader(data), Options{DeferMetaData: true})
+	if err != nil {
+		t.Fatalf("Unexpected error making a new decoder: %v", err)
+	}
+	if d.VorbisComment != nil {
+		t.Errorf("Expected deferred VORBIS_COMMENT, got %v", d.VorbisComment)
+	}
+	if off := d.MetaDataOffset(); off != 42 {
+		t.Errorf("Expected metadata offset 42, got %d", off)
+	}
+	if _, err := d.Next(); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+	if err := d.ReadMetaData(); err != nil {
+		t.Fatalf("Unexpected error reading deferred metadata: %v", err)
+	}
+	if d.VorbisComment == nil || d.Vendor != "abc" || len(d.Comments) != 1 || d.Comments[0] != "A=b" {
+		t.Errorf("Expected vendor abc with comment A=b, got %v", d.VorbisComment)
+	}
+}
+
+func TestCheckChannelOrder(t *testing.T) {
+	tests := []struct {
+		order []int
+		n     int
+		ok    bool
+	}{
+		{[]int{0}, 1, true},
+		{[]int{1, 0}, 2, true},
+		{[]int{0, 1, 4, 5, 2, 3}, 6, true},
+		{[]int{0}, 2, false},
+		{[]int{0, 0}, 2, false},
+		{[]int{0, 2}, 2, false},
+		{[]int{-1, 0}, 2, false},
+	}
+	for _, test := range tests {
+		if err := checkChannelOrder(test.order, test.n); (err == nil) != test.ok {
+			t.Errorf("checkChannelOrder(%v, %d)=%v, expected ok=%v", test.order, test.n, err, test.ok)
+		}
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	streamInfo := []byte{
+		'f', 'L', 'a', 'C',
+		0x80, 0, 0, 34, // last metadata header: stream info.
+
+		// STREAMINFO
+		0, 0, // min block size
+		0, 0, // max block size
+		0, 0, 0, // min frame size
+		0, 0, 0, // max frame size
+		0, 0, 0x14, 0x70, 0, 0, 0, 1, // rate 1, 2 channels, 8 bits/sample, 1 sample
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // MD5, obviously not the true value.
+	}
+	tests := [][]byte{
+		// No frames.
+		{},
+
+		// Sync code and nothing else.
+		{0xFF, 0xF8},
+	}
+
+	for _, test := range tests {
+		d, err := NewDecoder(bytes.NewReader(append(streamInfo[:len(streamInfo):len(streamInfo)], test...)))
+		if err != nil {
+			t.Fatalf("Unexpected error making a new decoder: %v", err)
+		}
+		_, err = d.Next()
+		terr, ok := err.(*TruncatedError)
+		if !ok {
+			t.Errorf("Expected a *TruncatedError with frame data %v, got %v", test, err)
+			continue
+		}
+		if terr.Samples != 0 {
+			t.Errorf("Expected truncation after 0 samples, got %d", terr.Samples)
+		}
+	}
+}
+
+// TestFrame returns a frame with the given header and body,
+// adding the CRC-8 to the header and the CRC-16 to the end of the frame.
+func testFrame(header, body []byte) []byte {
+	var crc8 byte
+	for _, b := range header {
+		crc8 = crc8Table[crc8^b]
+	}
+	frame := append(append(append([]byte{}, header...), crc8), body...)
+	var crc16 uint16
+	for _, b := range frame {
+		crc16 = (crc16 << 8) ^ crc16Table[uint8(crc16>>8)^b]
+	}
+	return append(frame, byte(crc16>>8), byte(crc16))
+}
+
+// ConstantStream is a stream of a single frame of 192 constant 8-bit samples
+// on each of two channels.
+var constantStream = append([]byte{
+	'f', 'L', 'a', 'C',
+	0x80, 0, 0, 34, // last metadata header: stream info.
+
+	// STREAMINFO
+	0, 192, // min block size
+	0, 192, // max block size
+	0, 0, 0, // min frame size
+	0, 0, 0, // max frame size
+	0x0A, 0xC4, 0x42, 0x70, 0, 0, 0, 192, // rate 44100, 2 channels, 8 bits/sample, 192 samples
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // MD5, obviously not the true value.
+}, testFrame(
+	[]byte{
+		// Sync code · 0 reserved · fixed blocking
+		0xFF, 0xF8,
+		// 192 block size · 44.1 kHz sample rate
+		0x19,
+		// 2 independent channels · 8 bits per sample · 0 reserved
+		0x12,
+		// UTF8 frame number 0
+		0x00,
+	},
+	[]byte{
+		0x00, 5, // SUBFRAME_CONSTANT 5
+		0x00, 7, // SUBFRAME_CONSTANT 7
+	})...)
+
+func TestPeekFrameHeader(t *testing.T) {
+	d, err := NewDecoder(bytes.NewReader(constantStream))
+	if err != nil {
+		t.Fatalf("Unexpected error making a new decoder: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		h, err := d.PeekFrameHeader()
+		if err != nil {
+			t.Fatalf("Unexpected error peeking the frame header: %v", err)
+		}
+		if h.BlockSize != 192 || h.NChannels != 2 || h.BitsPerSample != 8 || h.SampleRate != 44100 || h.Number != 0 {
+			t.Errorf("Unexpected frame header: %+v", h)
+		}
+	}
+	data, err := d.Next()
+	if err != nil {
+		t.Fatalf("Unexpected error decoding the frame: %v", err)
+	}
+	if len(data) != 2*192 || data[0] != 5 || data[1] != 7 {
+		t.Errorf("Expected 192 samples of 5, 7, got %v", data)
+	}
+	if _, err := d.PeekFrameHeader(); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+	if _, err := d.Next(); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+}
+
+func TestConcatenated(t *testing.T) {
+	stream := append(append([]byte{}, constantStream...), constantStream...)
+	d, err := NewDecoderOpts(bytes.NewReader(stream), Options{Concatenated: true})
+	if err != nil {
+		t.Fatalf("Unexpected error making a new decoder: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := d.Next(); err != nil {
+			t.Fatalf("Unexpected error decoding frame %d: %v", i, err)
+		}
+		if s := d.Stream(); s != i {
+			t.Errorf("Expected stream %d, got %d", i, s)
+		}
+	}
+	if _, err := d.Next(); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+}
+
+func TestLargeBlocks(t *testing.T) {
+	const blockSize = 32768
+	streamInfo := []byte{
+		'f', 'L', 'a', 'C',
+		0x80, 0, 0, 34, // last metadata header: stream info.
+
+		// STREAMINFO
+		0x80, 0x00, // min block size
+		0x80, 0x00, // max block size
+		0, 0, 0, // min frame size
+		0, 0, 0, // max frame size
+		0x0A, 0xC4, 0x40, 0x70, 0, 0, 0x80, 0x00, // rate 44100, 1 channel, 8 bits/sample, 32768 samples
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // MD5, obviously not the true value.
+	}
+
+	// SUBFRAME_FIXED, order 0, with 32768 Rice-coded residuals of 0.
+	fixed := []byte{
+		0x10,       // SUBFRAME_FIXED · order 0
+		0x00, 0x3F, // Rice method 0 · partition order 0 · parameter 0 · first 6 residuals
+	}
+	fixed = append(fixed, bytes.Repeat([]byte{0xFF}, (blockSize-6)/8)...)
+	fixed = append(fixed, 0xC0) // last 2 residuals, padding
+
+	tests := []struct {
+		header []byte
+		body   []byte
+	}{
+		{
+			[]byte{
+				// Sync code · 0 reserved · fixed blocking
+				0xFF, 0xF8,
+				// 32768 block size · 44.1 kHz sample rate
+				0xF9,
+				// 1 channel · 8 bits per sample · 0 reserved
+				0x02,
+				// UTF8 frame number 0
+				0x00,
+			},
+			fixed,
+		},
+		{
+			[]byte{
+				// Sync code · 0 reserved · fixed blocking
+				0xFF, 0xF8,
+				// 16-bit block size at end of header · 44.1 kHz sample rate
+				0x79,
+				// 1 channel · 8 bits per sample · 0 reserved
+				0x02,
+				// UTF8 frame number 0
+				0x00,
+				// 32768-1 block size
+				0x7F, 0xFF,
+			},
+			[]byte{0x00, 0x00}, // SUBFRAME_CONSTANT 0
+		},
+	}
+
+	for _, test := range tests {
+		stream := append(append([]byte{}, streamInfo...), testFrame(test.header, test.body)...)
+		d, err := NewDecoder(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatalf("Unexpected error making a new decoder: %v", err)
+		}
+		data, err := d.Next()
+		if err != nil {
+			t.Errorf("Unexpected error decoding frame with header %v: %v", test.header, err)
+			continue
+		}
+		if len(data) != blockSize {
+			t.Errorf("Expected %d samples, got %d", blockSize, len(data))
+		}
+		if !bytes.Equal(data, make([]byte, blockSize)) {
+			t.Errorf("Expected all zero samples")
+		}
+		if _, err := d.Next(); err != io.EOF {
+			t.Errorf("Expected io.EOF, got %v", err)
+		}
+	}
+}
+
+func TestZeroBounds(t *testing.T) {
+	stream := append([]byte{}, constantStream...)
+	copy(stream[8:18], make([]byte, 10)) // Zero the block and frame size bounds.
+
+	var warnings []error
+	d, err := NewDecoderOpts(bytes.NewReader(stream), Options{Warn: func(err error) { warnings = append(warnings, err) }})
+	if err != nil {
+		t.Fatalf("Unexpected error making a new decoder: %v", err)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("Expected 1 warning, got %v", warnings)
+	}
+	if _, err := d.Next(); err != nil {
+		t.Fatalf("Unexpected error decoding a frame: %v", err)
+	}
+	// 6 bytes of header, 4 bytes of subframes, and 2 bytes of CRC-16.
+	if b := d.Bounds(); b != (Bounds{MinBlock: 192, MaxBlock: 192, MinFrame: 12, MaxFrame: 12}) {
+		t.Errorf("Unexpected bounds: %+v", b)
+	}
+}
+
+// TwoFrameStream is a stream of two frames of 192 constant 8-bit samples on
+// each of two channels: 5 and 7 in the first frame, and 6 and 8 in the second.
+var twoFrameStream = append(append([]byte{
+	'f', 'L', 'a', 'C',
+	0x80, 0, 0, 34, // last metadata header: stream info.
+
+	// STREAMINFO
+	0, 192, // min block size
+	0, 192, // max block size
+	0, 0, 0, // min frame size
+	0, 0, 0, // max frame size
+	0x0A, 0xC4, 0x42, 0x70, 0, 0, 0x01, 0x80, // rate 44100, 2 channels, 8 bits/sample, 384 samples
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // MD5, obviously not the true value.
+}, constantStream[42:]...), testFrame(
+	[]byte{
+		// Sync code · 0 reserved · fixed blocking
+		0xFF, 0xF8,
+		// 192 block size · 44.1 kHz sample rate
+		0x19,
+		// 2 independent channels · 8 bits per sample · 0 reserved
+		0x12,
+		// UTF8 frame number 1
+		0x01,
+	},
+	[]byte{
+		0x00, 6, // SUBFRAME_CONSTANT 6
+		0x00, 8, // SUBFRAME_CONSTANT 8
+	})...)
+
+// A readCounter is an io.ReadSeeker counting the bytes read from it.
+type readCounter struct {
+	r io.ReadSeeker
+	n int64
+}
+
+func (r *readCounter) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (r *readCounter) Seek(offset int64, whence int) (int64, error) {
+	return r.r.Seek(offset, whence)
+}
+
+func TestDecodeFrames(t *testing.T) {
+	stream := append([]byte{}, twoFrameStream...)
+	var pcm []byte
+	for i := 0; i < 384; i++ {
+		if i < 192 {
+			pcm = append(pcm, 5, 7)
+		} else {
+			pcm = append(pcm, 6, 8)
+		}
+	}
+	sum := md5.Sum(pcm)
+	copy(stream[26:42], sum[:])
+
+	chs, meta, err := DecodeFrames(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("Unexpected error decoding: %v", err)
+	}
+	if meta.TotalSamples != 384 || len(chs) != 2 || len(chs[0]) != 384 || len(chs[1]) != 384 {
+		t.Fatalf("Expected 2 channels of 384 samples")
+	}
+	if chs[0][0] != 5 || chs[1][0] != 7 || chs[0][383] != 6 || chs[1][383] != 8 {
+		t.Errorf("Unexpected samples: %v, %v", chs[0][0], chs[1][383])
+	}
+}
+
+func TestInterleave(t *testing.T) {
+	for _, bps := range []int{8, 16, 24, 32} {
+		for nch := 1; nch <= 8; nch++ {
+			chs := make([][]int32, nch)
+			for c := range chs {
+				chs[c] = make([]int32, 37)
+				for i := range chs[c] {
+					chs[c][i] = int32(uint32(c*1000+i) * 2654435761 >> uint(32-bps))
+				}
+			}
+			data, err := interleave(chs, bps)
+			if err != nil {
+				t.Fatalf("%d bits: %v", bps, err)
+			}
+			var want []byte
+			for i := range chs[0] {
+				for _, ch := range chs {
+					for b := 0; b < bps; b += 8 {
+						want = append(want, byte(ch[i]>>uint(b)))
+					}
+				}
+			}
+			if !bytes.Equal(data, want) {
+				t.Errorf("%d bits, %d channels: got %v, want %v", bps, nch, data, want)
+			}
+		}
+	}
+}
+
+func TestFixChannels(t *testing.T) {
+	left := []int32{0, 1, -1, 100, -32768, 32767, 5, -6}
+	right := []int32{0, -1, 1, 99, 32767, -32768, 5, 7}
+	tests := []struct {
+		assign channelAssignment
+		a, b   func(l, r int32) int32
+	}{
+		{leftSide, func(l, r int32) int32 { return l }, func(l, r int32) int32 { return l - r }},
+		{rightSide, func(l, r int32) int32 { return l - r }, func(l, r int32) int32 { return r }},
+		{midSide, func(l, r int32) int32 { return (l + r) >> 1 }, func(l, r int32) int32 { return l - r }},
+	}
+	for _, test := range tests {
+		data := [][]int32{make([]int32, len(left)), make([]int32, len(left))}
+		for i := range left {
+			data[0][i], data[1][i] = test.a(left[i], right[i]), test.b(left[i], right[i])
+		}
+		fixChannels(data, test.assign)
+		for i := range left {
+			if data[0][i] != left[i] || data[1][i] != right[i] {
+				t.Errorf("Assignment %d, sample %d: got %d,%d, want %d,%d",
+					test.assign, i, data[0][i], data[1][i], left[i], right[i])
+			}
+		}
+	}
+}
+
+func BenchmarkInterleave(b *testing.B) {
+	chs := [][]int32{make([]int32, 4096), make([]int32, 4096)}
+	b.SetBytes(2 * 2 * 4096)
+	for i := 0; i < b.N; i++ {
+		interleave(chs, 16)
+	}
+}
+
+func BenchmarkFixChannels(b *testing.B) {
+	data := [][]int32{make([]int32, 4096), make([]int32, 4096)}
+	b.SetBytes(2 * 4 * 4096)
+	for i := 0; i < b.N; i++ {
+		fixChannels(data, midSide)
+	}
+}
+
+// Inputs found by fuzzing that used to panic.
+func TestMalformed(t *testing.T) {
+	tests := []struct {
+		hex  string
+		opts Options
+	}{
+		// A residual partition shorter than the predictor order.
+		{hex: "664c61438000002200c000c00000000000000ac45570000000c0000000000000000000000000" +
+			"00000000fff81912006fc20500070d4d"},
+		// A deferred STREAMINFO block that fails to parse.
+		{hex: "664c61430000002200c000c00000000000000ac44270000000c000000000000000000000000000" +
+			"0000000000001c0400000076656e640200000005000000413d626b6403000000583d598200000c72" +
+			"696666524946",
+			opts: Options{DeferMetaData: true}},
+	}
+	for i, test := range tests {
+		in, err := hex.DecodeString(test.hex)
+		if err != nil {
+			panic(err)
+		}
+		d, err := NewDecoderOpts(bytes.NewReader(in), test.opts)
+		if err != nil {
+			continue
+		}
+		if test.opts.DeferMetaData {
+			if err := d.ReadMetaData(); err == nil {
+				t.Errorf("%d: expected a metadata error", i)
+			}
+		}
+		for err == nil {
+			_, err = d.Next()
+		}
+		if err == io.EOF {
+			t.Errorf("%d: expected an error, got io.EOF", i)
+		}
+	}
+}
+
+func mustDecoder(t *testing.T, in []byte) *Decoder {
+	d, err := NewDecoder(bytes.NewReader(in))
+	if err != nil {
+		t.Fatalf("NewDecoder failed: %v", err)
+	}
+	return d
+}
+
+// RawBlocks returns the raw metadata blocks of a FLAC stream.
+func rawBlocks(t *testing.T, stream []byte) [][]byte {
+	r := bytes.NewReader(stream)
+	if err := checkMagic(r); err != nil {
+		t.Fatal(err)
+	}
+	var blocks [][]byte
+	for last := false; !last; {
+		block, err := readRawMetaDataBlock(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		last = block[0]&0x80 != 0
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+func TestLeftJustify(t *testing.T) {
+	tests := []struct {
+		bps     int
+		samples []int32
+		want    []int32
+	}{
+		{8, []int32{0, 1, -1, 127, -128}, []int32{0, 1 << 24, -1 << 24, 127 << 24, math.MinInt32}},
+		{12, []int32{0, 1, -1, 2047, -2048}, []int32{0, 1 << 20, -1 << 20, 2047 << 20, math.MinInt32}},
+		{16, []int32{0, 1, -1, 32767, -32768}, []int32{0, 1 << 16, -1 << 16, 32767 << 16, math.MinInt32}},
+		{24, []int32{0, 1, -1, 1<<23 - 1, -1 << 23}, []int32{0, 1 << 8, -1 << 8, (1<<23 - 1) << 8, math.MinInt32}},
+	}
+	for _, test := range tests {
+		// Repeat the samples to fill a block of more than the minimum size.
+		var data []int32
+		for len(data) < 100 {
+			data = append(data, test.samples...)
+		}
+		info := &StreamInfo{SampleRate: 44100, NChannels: 1, BitsPerSample: test.bps, TotalSamples: int64(len(data))}
+		var buf bytes.Buffer
+		e, err := NewEncoder(&buf, MetaData{StreamInfo: info})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Write([][]int32{data}); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		d, err := NewDecoderOpts(bytes.NewReader(buf.Bytes()), Options{LeftJustify: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := d.Shift(); s != uint(32-test.bps) {
+			t.Errorf("%d bits: Shift()=%d, want %d", test.bps, s, 32-test.bps)
+		}
+		frame, err := d.Next()
+		if err != nil {
+			t.Fatalf("%d bits: %v", test.bps, err)
+		}
+		if len(frame) != 4*len(data) {
+			t.Fatalf("%d bits: got %d bytes, want %d", test.bps, len(frame), 4*len(data))
+		}
+		for i, want := range test.want {
+			if got := int32(binary.LittleEndian.Uint32(frame[4*i:])); got != want {
+				t.Errorf("%d bits: sample %d (%d) is %#x, want %#x", test.bps, i, test.samples[i], got, want)
+			}
+		}
+		if size := NewPCMStream(d).Size(); size != int64(len(frame)) {
+			t.Errorf("%d bits: PCMStream size %d, want %d", test.bps, size, len(frame))
+		}
+	}
+
+	// Without LeftJustify, samples are not shifted.
+	d, err := NewDecoder(bytes.NewReader(twoFrameStream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := d.Shift(); s != 0 {
+		t.Errorf("Shift()=%d without LeftJustify, want 0", s)
+	}
+}
+
+func TestDecode33BitSide(t *testing.T) {
+	info := StreamInfo{SampleRate: 44100, NChannels: 2, BitsPerSample: 32, MinBlock: 16, MaxBlock: 16}
+	block, err := info.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stream bytes.Buffer
+	if err := writeBlocks(&stream, [][]byte{block}); err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEncoder(ioutil.Discard, MetaData{StreamInfo: &info})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A mid-side frame of left 2147483647 and right -2147483648,
+	// with VERBATIM subframes: mid is -1 and side is 2^32-1, in 33 bits.
+	e.bw.reset()
+	if err := e.writeFrameHeader(16, midSide, 0); err != nil {
+		t.Fatal(err)
+	}
+	e.bw.write(0x02, 8)
+	for i := 0; i < 16; i++ {
+		e.bw.write(1<<32-1, 32)
+	}
+	e.bw.write(0x02, 8)
+	for i := 0; i < 16; i++ {
+		e.bw.write(1<<32-1, 33)
+	}
+	e.bw.align()
+	e.bw.write(uint64(CRC16(e.bw.buf)), 16)
+	stream.Write(e.bw.buf)
+
+	d, err := NewDecoder(&stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := d.NextSamples(); err == nil {
+		t.Errorf("Got %v, expected an error", data)
+	}
+}
+
+func decodeAll(stream []byte) ([][]int32, MetaData, error) {
+	d, err := NewDecoder(bytes.NewReader(stream))
+	if err != nil {
+		return nil, MetaData{}, err
+	}
+	data := make([][]int32, d.NChannels)
+	for {
+		frame, err := d.NextSamples()
+		if err == io.EOF {
+			return data, d.MetaData, nil
+		} else if err != nil {
+			return nil, MetaData{}, err
+		}
+		for ch := range data {
+			data[ch] = append(data[ch], frame[ch]...)
+		}
+	}
+}
+
+func TestNewReaderAtDecoder(t *testing.T) {
+	const n = 100000
+	data := testSignal(2, n, 16)
+	stream := encodeFile(t, data, EncoderOptions{BlockSize: 4096})
+	r := bytes.NewReader(stream)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			d, err := NewReaderAtDecoder(r, int64(len(stream)))
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			for j := 0; j < 10; j++ {
+				sample := int64((i*7919 + j*10007) % n)
+				if _, err := d.Seek(sample, 0); err != nil {
+					errs[i] = err
+					return
+				}
+				samples, err := d.NextSamples()
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				for ch := range samples {
+					if !reflect.DeepEqual(samples[ch], data[ch][sample:sample+int64(len(samples[ch]))]) {
+						errs[i] = errors.New("sample " + strconv.FormatInt(sample, 10) + ": channel " + strconv.Itoa(ch) + " differs")
+						return
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("decoder %d: %v", i, err)
+		}
+	}
+}
+
+func TestDecoderPos(t *testing.T) {
+	data := testSignal(2, 20000, 16)
+	for _, opts := range []EncoderOptions{
+		{BlockSize: 4096},
+		{Level: 8, VariableBlockSize: true},
+	} {
+		d, err := NewDecoder(bytes.NewReader(encodeFile(t, data, opts)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pos int64
+		for {
+			if got := d.Pos(); got != pos {
+				t.Errorf("%+v: got Pos()=%d, want %d", opts, got, pos)
+			}
+			h, err := d.PeekFrameHeader()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if h.Sample != pos {
+				t.Errorf("%+v: got frame Sample %d, want %d", opts, h.Sample, pos)
+			}
+			samples, err := d.NextSamples()
+			if err != nil {
+				t.Fatal(err)
+			}
+			pos += int64(len(samples[0]))
+		}
+		if pos != 20000 {
+			t.Errorf("%+v: decoded %d samples, want 20000", opts, pos)
+		}
+		if _, err := d.Seek(12345, 0); err != nil {
+			t.Fatal(err)
+		}
+		if got := d.Pos(); got != 12345 {
+			t.Errorf("%+v: after Seek(12345), got Pos()=%d", opts, got)
+		}
+	}
+}
+
+func TestDecodePreview(t *testing.T) {
+	const n = 100000
+	data := testSignal(1, n, 16)
+	stream := encodeFile(t, data, EncoderOptions{BlockSize: 1000})
+	decode := func(opts Options, seek int64) [][]int32 {
+		d, err := NewDecoderOpts(bytes.NewBuffer(stream), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seek > 0 {
+			if _, err := d.Seek(seek, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var frames [][]int32
+		for {
+			samples, err := d.NextSamples()
+			if err == io.EOF {
+				return frames
+			} else if err != nil {
+				t.Fatalf("%+v: %v", opts, err)
+			}
+			frames = append(frames, samples[0])
+		}
+	}
+
+	// 0.5s at 44100Hz is 22050 samples.
+	frames := decode(Options{Preview: time.Second / 2}, 0)
+	var got []int32
+	for _, f := range frames {
+		got = append(got, f...)
+	}
+	if !reflect.DeepEqual(got, data[0][:22050]) {
+		t.Errorf("Preview: got %d samples, want the first 22050", len(got))
+	}
+	frames = decode(Options{Preview: time.Second / 2}, 50500)
+	got = nil
+	for _, f := range frames {
+		got = append(got, f...)
+	}
+	if !reflect.DeepEqual(got, data[0][50500:50500+22050]) {
+		t.Errorf("Preview after Seek: got %d samples, want 22050 from 50500", len(got))
+	}
+
+	frames = decode(Options{FrameStride: 10}, 0)
+	if len(frames) != 10 {
+		t.Errorf("FrameStride: got %d frames, want 10", len(frames))
+	}
+	for i, f := range frames {
+		if start := i * 10 * 1000; !reflect.DeepEqual(f, data[0][start:start+1000]) {
+			t.Errorf("FrameStride: frame %d is not frame %d of the stream", i, i*10)
+		}
+	}
+	if frames := decode(Options{FrameStride: 10, Preview: time.Second}, 0); len(frames) != 5 {
+		t.Errorf("FrameStride with Preview: got %d frames, want 5", len(frames))
+	}
+}
+
+func TestPaddingBlocks(t *testing.T) {
+	data := testSignal(1, 1000, 16)
+	meta := MetaData{
+		StreamInfo:    &StreamInfo{SampleRate: 44100, NChannels: 1, BitsPerSample: 16},
+		VorbisComment: &VorbisComment{Vendor: "test", Comments: []string{"TITLE=padding"}},
+	}
+	var buf bytes.Buffer
+	e, err := NewEncoderOpts(&buf, meta, EncoderOptions{Padding: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stream := buf.Bytes()
+
+	for _, opts := range []Options{{}, {DeferMetaData: true}} {
+		d, err := NewDecoderOpts(bytes.NewReader(stream), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.ReadMetaData(); err != nil {
+			t.Fatal(err)
+		}
+		if len(d.Padding) != 1 || d.PaddingSize() != 1000 {
+			t.Fatalf("%+v: got padding %+v, want one block of 1000 bytes", opts, d.Padding)
+		}
+		off := d.Padding[0].Offset
+		if kind := BlockType(stream[off] & 0x7F); kind != BlockPadding {
+			t.Errorf("%+v: block at offset %d is %v, want PADDING", opts, off, kind)
+		}
+		if size := int(stream[off+1])<<16 | int(stream[off+2])<<8 | int(stream[off+3]); size != 1000 {
+			t.Errorf("%+v: block at offset %d has size %d, want 1000", opts, off, size)
+		}
+	}
+}
+
+func TestRawMetaData(t *testing.T) {
+	data := testSignal(1, 1000, 16)
+	meta := MetaData{
+		StreamInfo:    &StreamInfo{SampleRate: 44100, NChannels: 1, BitsPerSample: 16},
+		VorbisComment: &VorbisComment{Vendor: "test", Comments: []string{"TITLE=raw"}},
+		Others:        []RawBlock{{Type: 100, Data: []byte("unknown")}},
+	}
+	var buf bytes.Buffer
+	e, err := NewEncoderOpts(&buf, meta, EncoderOptions{Padding: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stream := buf.Bytes()
+
+	var want []RawBlock
+	for _, b := range rawBlocks(t, stream) {
+		want = append(want, RawBlock{Type: int(b[0] & 0x7F), Data: b[4:]})
+	}
+	for _, opts := range []Options{{RawMetaData: true}, {RawMetaData: true, DeferMetaData: true}} {
+		d, err := NewDecoderOpts(bytes.NewReader(stream), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.ReadMetaData(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(d.Raw, want) {
+			t.Errorf("%+v: got raw blocks %v, want %v", opts, d.Raw, want)
+		}
+		if d.VorbisComment == nil || len(d.Padding) != 1 {
+			t.Errorf("%+v: raw blocks were not also decoded", opts)
+		}
+	}
+	d, err := NewDecoder(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Raw != nil {
+		t.Errorf("got raw blocks without RawMetaData")
+	}
+}
+
+func TestOnBlock(t *testing.T) {
+	data := testSignal(1, 1000, 16)
+	meta := MetaData{
+		StreamInfo:    &StreamInfo{SampleRate: 44100, NChannels: 1, BitsPerSample: 16},
+		VorbisComment: &VorbisComment{Vendor: "test", Comments: []string{"TITLE=blocks"}},
+	}
+	pic := bytes.Repeat([]byte{0x89, 'P', 'N', 'G'}, 1000)
+	if err := meta.AddPicture(PictureFrontCover, "image/png", "cover", pic); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	e, err := NewEncoderOpts(&buf, meta, EncoderOptions{Padding: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stream := buf.Bytes()
+
+	for _, deferred := range []bool{false, true} {
+		var kinds []BlockType
+		var n int64
+		opts := Options{
+			DeferMetaData: deferred,
+			OnBlock: func(kind BlockType, r io.Reader) error {
+				kinds = append(kinds, kind)
+				if kind != BlockPicture {
+					return nil
+				}
+				var err error
+				n, err = io.Copy(ioutil.Discard, io.LimitReader(r, 100))
+				if err != nil {
+					return err
+				}
+				return SkipBlock
+			},
+		}
+		d, err := NewDecoderOpts(bytes.NewReader(stream), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.ReadMetaData(); err != nil {
+			t.Fatal(err)
+		}
+		if n != 100 || len(d.Pictures) != 0 {
+			t.Errorf("deferred=%v: picture was not skipped: read %d bytes, %d pictures", deferred, n, len(d.Pictures))
+		}
+		if title, ok := d.Title(); !ok || title != "blocks" {
+			t.Errorf("deferred=%v: got title %q, want blocks", deferred, title)
+		}
+		want := []BlockType{BlockVorbisComment, BlockPicture, BlockPadding}
+		if !reflect.DeepEqual(kinds, want) {
+			t.Errorf("deferred=%v: got blocks %v, want %v", deferred, kinds, want)
+		}
+		if frame, err := d.NextSamples(); err != nil || !reflect.DeepEqual(frame[0], data[0][:len(frame[0])]) {
+			t.Errorf("deferred=%v: failed to decode the first frame after skipping a block: %v", deferred, err)
+		}
+	}
+
+	veto := errors.New("vetoed")
+	_, err = NewDecoderOpts(bytes.NewReader(stream), Options{OnBlock: func(kind BlockType, r io.Reader) error {
+		if kind == BlockPicture {
+			return veto
+		}
+		return nil
+	}})
+	if err != veto {
+		t.Errorf("got error %v, want %v", err, veto)
+	}
+	_, err = NewDecoderOpts(bytes.NewReader(stream), Options{OnBlock: func(kind BlockType, r io.Reader) error {
+		_, err := r.Read(make([]byte, 1))
+		return err
+	}})
+	if err == nil {
+		t.Errorf("expected an error when OnBlock reads a block that it does not skip")
+	}
+}
+
+func TestID3Prefix(t *testing.T) {
+	data := testSignal(1, 20000, 16)
+	stream := encodeFile(t, data, EncoderOptions{BlockSize: 4096, Padding: 10})
+	want, err := NewDecoder(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A 300-byte ID3v2.4 tag, with 300 written as the syncsafe 0x00 0x00 0x02 0x2C.
+	tag := append([]byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0x02, 0x2C}, make([]byte, 300)...)
+	footer := append([]byte{'I', 'D', '3', 4, 0, 0x10, 0, 0, 0x02, 0x2C}, make([]byte, 310)...)
+	tests := [][]byte{
+		tag,
+		footer,
+		append(append([]byte{}, tag...), footer...),
+	}
+	for _, prefix := range tests {
+		file := append(append([]byte{}, prefix...), stream...)
+		d, err := NewDecoder(bytes.NewReader(file))
+		if err != nil {
+			t.Errorf("%d-byte ID3v2 prefix: %v", len(prefix), err)
+			continue
+		}
+		if len(d.Padding) != 1 || d.Padding[0].Offset != want.Padding[0].Offset+int64(len(prefix)) {
+			t.Errorf("%d-byte ID3v2 prefix: got padding %+v, want offset %d", len(prefix), d.Padding, want.Padding[0].Offset+int64(len(prefix)))
+		}
+		got, err := d.DecodeRange(10000, 10100)
+		if err != nil || !reflect.DeepEqual(got[0], data[0][10000:10100]) {
+			t.Errorf("%d-byte ID3v2 prefix: failed to seek: %v", len(prefix), err)
+		}
+	}
+
+	bad := append([]byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0x80, 0}, stream...)
+	if _, err := NewDecoder(bytes.NewReader(bad)); err == nil {
+		t.Errorf("expected an error for a bad ID3v2 tag size")
+	}
+}
+
+func TestSkipJunk(t *testing.T) {
+	data := testSignal(1, 5000, 16)
+	stream := encodeFile(t, data, EncoderOptions{})
+	// The junk holds a fLaC marker that is not followed by a STREAMINFO.
+	junk := append([]byte("garbage fLaC garbage"), make([]byte, 100)...)
+	file := append(append([]byte{}, junk...), stream...)
+
+	tests := []struct {
+		skip int
+		ok   bool
+	}{
+		{0, false},
+		{len(junk) - 1, false},
+		{len(junk), true},
+		{1 << 10, true},
+	}
+	for _, test := range tests {
+		d, err := NewDecoderOpts(bytes.NewReader(file), Options{SkipJunk: test.skip})
+		if (err == nil) != test.ok {
+			t.Errorf("SkipJunk=%d: got error %v, want ok=%v", test.skip, err, test.ok)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		got, err := d.DecodeRange(1000, 1100)
+		if err != nil || !reflect.DeepEqual(got[0], data[0][1000:1100]) {
+			t.Errorf("SkipJunk=%d: failed to decode: %v", test.skip, err)
+		}
+	}
+	if _, err := NewDecoderOpts(bytes.NewReader(stream), Options{SkipJunk: 1 << 10}); err != nil {
+		t.Errorf("SkipJunk without junk: %v", err)
+	}
+}
+
+func TestDurationBitrate(t *testing.T) {
+	tests := []struct {
+		info     StreamInfo
+		duration time.Duration
+		bitrate  int
+	}{
+		{StreamInfo{SampleRate: 44100, TotalSamples: 44100 * 60}, time.Minute, 0},
+		{StreamInfo{SampleRate: 48000, TotalSamples: 48000 + 24000}, 1500 * time.Millisecond, 0},
+		{StreamInfo{SampleRate: 44100}, 0, 0},
+		{StreamInfo{SampleRate: 44100, TotalSamples: 1 << 35}, 779132*time.Second + 389297052, 0},
+		{StreamInfo{SampleRate: 44100, MinBlock: 4096, MaxBlock: 4096, MinFrame: 8000, MaxFrame: 12000}, 0, 861328},
+		{StreamInfo{SampleRate: 44100, MinBlock: 4096, MaxBlock: 4096, MaxFrame: 12000}, 0, 0},
+	}
+	for _, test := range tests {
+		if d := test.info.Duration(); d != test.duration {
+			t.Errorf("%+v: got duration %v, want %v", test.info, d, test.duration)
+		}
+		if b := test.info.Bitrate(); b != test.bitrate {
+			t.Errorf("%+v: got bitrate %d, want %d", test.info, b, test.bitrate)
+		}
+	}
+
+	data := testSignal(2, 44100*2, 16)
+	d, err := NewDecoder(bytes.NewReader(encodeFile(t, data, EncoderOptions{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Duration() != 2*time.Second {
+		t.Errorf("got duration %v, want 2s", d.Duration())
+	}
+	if b := d.Bitrate(); b <= 0 || b > 2*16*44100 {
+		t.Errorf("got bitrate %d, want between 0 and %d", b, 2*16*44100)
+	}
+}
+
+func TestPipe(t *testing.T) {
+	data := testSignal(1, 50000, 16)
+	stream := encodeFile(t, data, EncoderOptions{BlockSize: 4096})
+	junk := append([]byte("junk"), stream...)
+	tests := []struct {
+		name   string
+		stream []byte
+		opts   Options
+		skip   int64
+	}{
+		{"DeferMetaData", stream, Options{DeferMetaData: true}, 0},
+		{"SkipJunk", junk, Options{SkipJunk: 100}, 0},
+		{"Skip", stream, Options{}, 20000},
+		{"FrameStride", stream, Options{FrameStride: 2}, 0},
+	}
+	for _, test := range tests {
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func(b []byte) {
+			pw.Write(b)
+			pw.Close()
+		}(test.stream)
+		d, err := NewDecoderOpts(pr, test.opts)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			pr.Close()
+			continue
+		}
+		if err := d.Skip(test.skip); err != nil {
+			t.Errorf("%s: Skip failed: %v", test.name, err)
+		}
+		var n int64
+		for {
+			frame, err := d.NextSamples()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+				break
+			}
+			n += int64(len(frame[0]))
+		}
+		if n == 0 {
+			t.Errorf("%s: decoded no samples", test.name)
+		}
+		pr.Close()
+	}
+}
@@ -0,0 +1,112 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+const (
+	// MaxPadding is the largest body of a PADDING block.
+	maxPadding = 1<<24 - 1
+	// ShiftChunk is the number of bytes moved at a time by shiftFrames.
+	shiftChunk = 1 << 20
+)
+
+// EditMetaData edits the metadata of the FLAC file f in place, as Retag does,
+// rewriting only the metadata blocks at the start of the file, so that
+// the audio frames are untouched if the edited metadata fits in the space
+// of the original blocks.  The PADDING blocks are replaced by one that fills
+// the remaining space.  If the edited metadata does not fit, the audio frames
+// are moved toward the end of the file to make room for the metadata and
+// a PADDING block of DefaultPadding bytes; an error while moving them may
+// leave the file corrupt.  Any ID3v2 tag before the fLaC marker is kept.
+//
+// The file must be open for both reading and writing.
+// If edit returns an error, EditMetaData returns it without writing anything.
+func EditMetaData(f *os.File, edit func(*MetaData) error) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+	r := &countingReader{r: io.NewSectionReader(f, 0, size)}
+	if err := checkMagic(r); err != nil {
+		return err
+	}
+	start := r.n - int64(len(magic))
+	meta, blocks, err := readRetagBlocks(r)
+	if err != nil {
+		return err
+	}
+	end := r.n
+	if err := edit(&meta); err != nil {
+		return err
+	}
+	out, err := retagBlocks(blocks, meta)
+	if err != nil {
+		return err
+	}
+
+	n := int64(len(magic))
+	var kept [][]byte
+	for _, b := range out {
+		if BlockType(b[0]&0x7F) != BlockPadding {
+			kept = append(kept, b)
+			n += int64(len(b))
+		}
+	}
+	slack := end - start - n
+	if slack < 0 || slack > 0 && slack < 4 {
+		slack = 4 + DefaultPadding
+		if err := shiftFrames(f, end, size, start+n+slack-end); err != nil {
+			return err
+		}
+	}
+	for slack > 0 {
+		m := slack - 4
+		if m > maxPadding {
+			// Leave room for the header of another PADDING block.
+			if m = maxPadding; slack-4-m < 4 {
+				m -= 4
+			}
+		}
+		pad, err := metaDataBlock(BlockPadding, make([]byte, m))
+		if err != nil {
+			return err
+		}
+		kept = append(kept, pad)
+		slack -= int64(len(pad))
+	}
+
+	var buf bytes.Buffer
+	if err := writeBlocks(&buf, kept); err != nil {
+		return err
+	}
+	_, err = f.WriteAt(buf.Bytes(), start)
+	return err
+}
+
+// ShiftFrames moves the bytes of f from offset start to size
+// toward the end of the file by delta bytes, beginning with the last bytes,
+// so that they are not overwritten before they are moved.
+func shiftFrames(f *os.File, start, size, delta int64) error {
+	buf := make([]byte, shiftChunk)
+	for end := size; end > start; {
+		n := end - start
+		if n > shiftChunk {
+			n = shiftChunk
+		}
+		b := buf[:n]
+		if _, err := f.ReadAt(b, end-n); err != nil {
+			return err
+		}
+		if _, err := f.WriteAt(b, end-n+delta); err != nil {
+			return err
+		}
+		end -= n
+	}
+	return nil
+}
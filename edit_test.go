@@ -0,0 +1,118 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestEditMetaData(t *testing.T) {
+	data := testSignal(2, 20000, 16)
+	stream := encodeFile(t, data, EncoderOptions{BlockSize: 4096})
+	id3 := append([]byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 10}, make([]byte, 10)...)
+	d, err := NewDecoder(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := stream[d.framesOffset:]
+	tmp, err := ioutil.TempFile("", "flac-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := tmp.Write(append(append([]byte{}, id3...), stream...)); err != nil {
+		t.Fatal(err)
+	}
+
+	pic := bytes.Repeat([]byte{0xAB}, 3*DefaultPadding)
+	// OldComment is the original VORBIS_COMMENT block, if any.
+	var oldComment []byte
+	if d.VorbisComment != nil {
+		if oldComment, err = d.VorbisComment.MarshalBinary(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	title := VorbisComment{Vendor: "test", Comments: []string{"TITLE=edited"}}
+	newComment, err := title.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		edit func(*MetaData) error
+		// Grow is whether the file grows.
+		grow    bool
+		padding int
+	}{
+		{
+			name: "title",
+			edit: func(m *MetaData) error {
+				m.VorbisComment = &title
+				return nil
+			},
+			padding: d.PaddingSize() + len(oldComment) - len(newComment),
+		},
+		{
+			name: "picture",
+			edit: func(m *MetaData) error {
+				return m.AddPicture(PictureFrontCover, "image/x-test", "", pic)
+			},
+			grow:    true,
+			padding: DefaultPadding,
+		},
+		{
+			name: "remove picture",
+			edit: func(m *MetaData) error {
+				m.Pictures = nil
+				return nil
+			},
+			padding: DefaultPadding + len(encodePicture(Picture{Type: PictureFrontCover, MIME: "image/x-test", Data: pic})) + 4,
+		},
+	}
+	for _, test := range tests {
+		before, err := tmp.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := EditMetaData(tmp, test.edit); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		file, err := ioutil.ReadFile(tmp.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if grew := int64(len(file)) > before.Size(); grew != test.grow {
+			t.Errorf("%s: file grew from %d to %d bytes", test.name, before.Size(), len(file))
+		}
+		if !bytes.HasPrefix(file, id3) || !bytes.HasSuffix(file, frames) {
+			t.Errorf("%s: the ID3v2 tag or audio frames changed", test.name)
+		}
+		_, meta, err := Decode(bytes.NewReader(file))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if title, _ := meta.Title(); title != "edited" {
+			t.Errorf("%s: got title %q", test.name, title)
+		}
+		if meta.PaddingSize() != test.padding {
+			t.Errorf("%s: got %d bytes of padding, want %d", test.name, meta.PaddingSize(), test.padding)
+		}
+	}
+
+	before, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fail := errors.New("edit failed")
+	if err := EditMetaData(tmp, func(m *MetaData) error { m.Pictures = nil; return fail }); err != fail {
+		t.Errorf("got error %v, want %v", err, fail)
+	}
+	if after, err := ioutil.ReadFile(tmp.Name()); err != nil || !bytes.Equal(after, before) {
+		t.Errorf("the file changed after a failed edit: %v", err)
+	}
+}
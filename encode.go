@@ -0,0 +1,1638 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"hash"
+	"io"
+	"math"
+	"strconv"
+	"sync"
+
+	"github.com/eaburns/bit"
+)
+
+func init() {
+	features = append(features, FeatureEncoder)
+}
+
+// EncoderOptions are options for an Encoder.
+type EncoderOptions struct {
+	// Level is the compression level, from 0, the fastest,
+	// to 8, the smallest, following the presets of the reference flac tool.
+	// NewEncoder uses DefaultLevel.
+	Level int
+
+	// BlockSize, if non-zero, overrides the level's block size.
+	// It must be between 16 and 65535.
+	BlockSize int
+
+	// MaxLPCOrder, if non-zero, overrides the level's maximum order
+	// of linear prediction.  It must be between 1 and 32.
+	// Higher orders compress better, but encode more slowly.
+	MaxLPCOrder int
+
+	// MinPartitionOrder, if non-zero, overrides the level's minimum Rice
+	// partition order, which is 0, and MaxPartitionOrder, if non-zero,
+	// overrides the level's maximum.  They must be at most 15,
+	// and the minimum at most the maximum.
+	// The residual of each subframe is split into 2^order partitions,
+	// each with its own Rice parameter, of the order in the range
+	// that codes it in the fewest bits.  Orders whose partitions would not
+	// divide the block evenly or would be shorter than the warm-up are skipped.
+	MinPartitionOrder int
+	MaxPartitionOrder int
+
+	// FixedOnly restricts subframes to CONSTANT, VERBATIM, and FIXED,
+	// skipping LPC analysis at any level.
+	// This trades compression for a small, steady cost per sample,
+	// suitable for encoding in real time on slow devices.
+	// It cannot be combined with MaxLPCOrder.
+	FixedOnly bool
+
+	// Windows, if non-empty, overrides the level's apodization windows,
+	// applied to each block before LPC analysis.
+	// With more than one window, each subframe uses whichever
+	// window's coefficients code it in the fewest bits.
+	Windows []Window
+
+	// SeekInterval, if positive, adds a SEEKTABLE to the stream,
+	// with a seek point for the first frame starting at or after
+	// every multiple of SeekInterval samples.
+	// SeekSeconds is the same, but in seconds of audio.
+	// At most one of them may be set.
+	// The seek points are reserved as placeholders, which Close fills in,
+	// so the writer must be an io.WriteSeeker, and the STREAMINFO
+	// must give the TotalSamples to reserve enough of them.
+	// The generated SEEKTABLE replaces that of the MetaData.
+	SeekInterval int64
+	SeekSeconds  float64
+
+	// VorbisComment, if non-nil, is written as the stream's VORBIS_COMMENT,
+	// in place of that of the MetaData.
+	// If its Vendor is empty, the Encoder's vendor string is used.
+	VorbisComment *VorbisComment
+
+	// CueSheet, if non-nil, is written as a CUESHEET block,
+	// in place of any in the Others of the MetaData.
+	// The STREAMINFO must give the TotalSamples, which is the offset
+	// of the lead-out track.
+	CueSheet *CueSheet
+
+	// Padding is the size in bytes of a PADDING block written after the
+	// other metadata, reserving space for editing it in place later.
+	// If Padding is 0, DefaultPadding is used; if it is negative,
+	// no PADDING block is written.
+	Padding int
+
+	// VariableBlockSize is whether to write a stream with a variable block size,
+	// in which case Flush writes any buffered samples as a shorter frame.
+	// Otherwise, all frames but the last have the block size,
+	// so Flush must hold samples that do not fill a block until Close.
+	VariableBlockSize bool
+
+	// AdaptiveBlockSize is whether to choose the size of each frame to fit
+	// the signal, splitting a block into halves, down to an eighth of the
+	// block size, wherever they code in fewer bits than the whole,
+	// as around transients.  The stream then has a variable block size,
+	// as with VariableBlockSize.
+	// Otherwise, every frame but the last has the block size, as some
+	// hardware decoders require, unless VariableBlockSize is set.
+	AdaptiveBlockSize bool
+
+	// Subset is whether to reject settings that would make a stream
+	// outside of the streamable subset of FLAC, which some hardware
+	// decoders and streaming servers require: block sizes over 4608 samples,
+	// or over 16384 above 48 kHz; LPC orders over 12 at up to 48 kHz;
+	// and sample rates that cannot be coded in frame headers.
+	// The compression levels are all within the subset.
+	Subset bool
+
+	// Workers, if greater than 1, is the number of frames to encode
+	// concurrently, each on its own goroutine.  Write buffers blocks
+	// until there is one for each worker, and frames are still written
+	// in order.  Otherwise frames are encoded by the calling goroutine.
+	Workers int
+
+	// Verify is whether to decode each frame after encoding it, before writing it,
+	// and compare the decoded samples to the input, failing on any mismatch.
+	Verify bool
+
+	// RiceSearch is how to choose the Rice parameter of each residual partition.
+	// The partition order is always chosen to minimize the coded size.
+	RiceSearch RiceSearch
+
+	// Exhaustive is whether to search every model for each subframe,
+	// keeping whichever codes it in the fewest bits: every FIXED order,
+	// and every LPC order up to the maximum, with every coefficient
+	// precision, rather than only the orders and precision estimated
+	// to be best.  This compresses slightly better, but is many times slower.
+	Exhaustive bool
+
+	// Progress, if non-nil, is called after each frame is written with the
+	// number of samples written so far and the TotalSamples of the STREAMINFO,
+	// which is 0 if unknown.  It is called by the goroutine calling the
+	// Encoder's methods, even with Workers.  The Encoder's Stats method
+	// gives the frames and bytes written so far.
+	Progress func(samplesDone, totalSamples int64)
+
+	// KeepForeign is whether EncodePCMOpts keeps the Foreign chunks of
+	// its PCMFormat in APPLICATION blocks, as the reference encoder's
+	// --keep-foreign-metadata option does, so that the original WAV or AIFF
+	// file can be restored when decoding.  If the PCM reader can seek,
+	// the chunks following the audio are kept too.
+	// Other encoders ignore it.
+	KeepForeign bool
+
+	// Ogg is whether to write the stream in an Ogg container, as in .oga files,
+	// following the FLAC-to-Ogg mapping: each metadata block and each frame
+	// is an Ogg packet.  A VORBIS_COMMENT block is always written, as the
+	// mapping requires, and a SEEKTABLE never is, since its offsets
+	// would not account for the Ogg pages, so SeekInterval and SeekSeconds
+	// must not be set.
+	Ogg bool
+}
+
+// A Window is an apodization window for LPC analysis.
+type Window struct {
+	Shape WindowShape
+	// P is the fraction of a WindowTukey window that is tapered,
+	// from 0, a rectangle, to 1, a Hann window.
+	P float64
+}
+
+// A WindowShape is the shape of an apodization window.
+type WindowShape int
+
+const (
+	WindowTukey WindowShape = iota
+	WindowHann
+	WindowRectangle
+)
+
+// Weights returns the weights of the window for a block of n samples.
+func (w Window) weights(n int) []float64 {
+	switch w.Shape {
+	case WindowHann:
+		return tukey(n, 1)
+	case WindowRectangle:
+		return tukey(n, 0)
+	}
+	return tukey(n, w.P)
+}
+
+// A RiceSearch is a strategy for choosing Rice parameters.
+type RiceSearch int
+
+const (
+	// RiceEstimate estimates each parameter from the mean of the partition's residual.
+	RiceEstimate RiceSearch = iota
+	// RiceExhaustive tries every parameter for each partition,
+	// choosing the one that codes it in the fewest bits.
+	RiceExhaustive
+)
+
+// MinBlockSize is the smallest block size allowed for any but the last frame.
+const minBlockSize = 16
+
+// MaxPartitionOrder is the largest Rice partition order, which is coded in 4 bits.
+const maxPartitionOrder = 15
+
+// AdaptiveSplits is the number of times that AdaptiveBlockSize may halve a block.
+const adaptiveSplits = 3
+
+// DefaultPadding is the size of the PADDING block written by NewEncoder,
+// the same as that written by the reference flac tool.
+const DefaultPadding = 8192
+
+// Vendor is the vendor string of VORBIS_COMMENT blocks written by this package.
+const vendor = "github.com/eaburns/flac"
+
+// DefaultLevel is the compression level used by NewEncoder,
+// the same as the reference flac tool's default.
+const DefaultLevel = 5
+
+// A level is the encoder settings for a compression level.
+type level struct {
+	blockSize int
+	// Stereo is how to decorrelate the channels of stereo frames.
+	stereo stereoMode
+	// MaxLPCOrder is the maximum LPC order, or 0 to use only fixed predictors.
+	maxLPCOrder int
+	// MinPartitionOrder and maxPartitionOrder are the range of Rice partition orders.
+	minPartitionOrder, maxPartitionOrder int
+	// Windows are the apodization windows for LPC analysis.
+	windows []Window
+	// Exhaustive is whether to plan each subframe with every predictor order
+	// and precision, instead of those estimated to be best.
+	exhaustive bool
+}
+
+// A stereoMode is a strategy for decorrelating the channels of stereo frames.
+type stereoMode int
+
+const (
+	// StereoIndependent codes the left and right channels as they are.
+	stereoIndependent stereoMode = iota
+	// StereoMidSide chooses between independent and mid-side coding.
+	stereoMidSide
+	// StereoFull chooses the cheapest of all of the channel assignments.
+	stereoFull
+)
+
+var (
+	tukey5     = []Window{{Shape: WindowTukey, P: 0.5}}
+	tukey5Hann = []Window{{Shape: WindowTukey, P: 0.5}, {Shape: WindowHann}}
+	// Tukey5HannMore adds windows tapering more and less than tukey5.
+	tukey5HannMore = []Window{{Shape: WindowTukey, P: 0.5}, {Shape: WindowHann}, {Shape: WindowTukey, P: 0.1}, {Shape: WindowTukey, P: 0.25}}
+)
+
+// The levels follow the presets of the reference flac tool, in which
+// levels 0 and 3 code stereo independently, 1 and 4 choose between that
+// and mid-side, and level 8 differs from 7 only in its windows.
+// They are all within the streamable subset,
+// so no LPC order is above 12 and no partition order is above 8.
+var levels = [...]level{
+	0: {blockSize: 1152, stereo: stereoIndependent, maxLPCOrder: 0, maxPartitionOrder: 3, windows: tukey5},
+	1: {blockSize: 1152, stereo: stereoMidSide, maxLPCOrder: 0, maxPartitionOrder: 3, windows: tukey5},
+	2: {blockSize: 1152, stereo: stereoFull, maxLPCOrder: 0, maxPartitionOrder: 3, windows: tukey5},
+	3: {blockSize: 4096, stereo: stereoIndependent, maxLPCOrder: 6, maxPartitionOrder: 4, windows: tukey5},
+	4: {blockSize: 4096, stereo: stereoMidSide, maxLPCOrder: 8, maxPartitionOrder: 4, windows: tukey5},
+	5: {blockSize: 4096, stereo: stereoFull, maxLPCOrder: 8, maxPartitionOrder: 5, windows: tukey5},
+	6: {blockSize: 4096, stereo: stereoFull, maxLPCOrder: 8, maxPartitionOrder: 6, windows: tukey5},
+	7: {blockSize: 4096, stereo: stereoFull, maxLPCOrder: 12, maxPartitionOrder: 6, windows: tukey5Hann},
+	8: {blockSize: 4096, stereo: stereoFull, maxLPCOrder: 12, maxPartitionOrder: 6, windows: tukey5HannMore},
+}
+
+// An Encoder writes a FLAC stream.
+//
+// Like the Decoder, an Encoder is not safe for concurrent use.
+type Encoder struct {
+	w io.Writer
+	// Start is the offset of the stream in w if w is an io.WriteSeeker,
+	// so that STREAMINFO can be updated by Close, or -1 otherwise.
+	start int64
+	info  StreamInfo
+	level level
+	// Verify is whether to decode and check each frame before writing it.
+	verify bool
+	// Workers are copies of the Encoder, with their own scratch space,
+	// that encode frames concurrently, or nil to encode frames serially.
+	workers []*Encoder
+	// Variable is whether the stream has a variable block size,
+	// with frame headers giving sample numbers instead of frame numbers.
+	variable bool
+	// Adaptive is whether to split blocks where that codes them in fewer bits.
+	adaptive bool
+	// MinBlock is the smallest block size of the frames written,
+	// excluding the last, which may be short, maxBlock is the largest,
+	// and lastBlock is the block size of the last frame written.
+	minBlock, maxBlock, lastBlock int
+	// Samples is the number of inter-channel samples encoded.
+	samples int64
+	frame   uint64
+	// Buf holds the samples of each channel that do not yet fill a block.
+	buf [][]int32
+	bw  bitWriter
+	// Weights are the weights of the level's LPC analysis windows,
+	// by block size.
+	weights map[int][][]float64
+	// RiceParam chooses the Rice parameter of a partition,
+	// given its folded residuals and their sum.
+	riceParam func([]uint32, uint64) (uint, int)
+	// MD5 is the checksum of the audio written so far,
+	// packed as for STREAMINFO.
+	md5 hash.Hash
+	// FrameBytes is the number of bytes of frames written,
+	// and metaDataBytes is that of the fLaC marker and metadata blocks.
+	frameBytes    int64
+	metaDataBytes int64
+	// SeekInterval is the number of samples between seek points, or 0 for no SEEKTABLE.
+	seekInterval int64
+	// SeekTable holds the reserved seek points, the first seekPoints
+	// of which have been filled in, and seekTableOffset is the offset
+	// of its body in w.  NextSeek is the sample for the next seek point.
+	seekTable       []SeekPoint
+	seekPoints      int
+	seekTableOffset int64
+	nextSeek        int64
+	// Ogg writes the stream's packets in Ogg pages, or is nil for a native FLAC stream.
+	ogg      *oggWriter
+	progress func(samplesDone, totalSamples int64)
+	closed   bool
+}
+
+// NewEncoder returns an Encoder writing a FLAC stream to w,
+// compressing with DefaultLevel.  See NewEncoderOpts.
+func NewEncoder(w io.Writer, meta MetaData) (*Encoder, error) {
+	return NewEncoderOpts(w, meta, EncoderOptions{Level: DefaultLevel})
+}
+
+// NewEncoderOpts returns an Encoder writing a FLAC stream to w, and writes
+// the metadata.  The StreamInfo gives the sample rate, number of channels,
+// and bits per sample, which must be 8, 12, 16, 20, 24, or 32.  Its TotalSamples may be 0
+// if unknown; the remaining StreamInfo fields are set by the Encoder.
+// The SeekTable, VorbisComment, Applications, Pictures, and Others
+// of the metadata are also written, so metadata read by a Decoder
+// passes through re-encoding.
+//
+// If w is an io.WriteSeeker, Close updates STREAMINFO with the total number
+// of samples, the frame sizes, and the MD5 checksum of the audio.
+// Otherwise, the MD5 checksum is left as zeros, meaning that it is unknown.
+func NewEncoderOpts(w io.Writer, meta MetaData, opts EncoderOptions) (*Encoder, error) {
+	return newEncoder(w, meta, opts, nil, nil)
+}
+
+// NewEncoder is like NewEncoderOpts, but if extra is non-nil, its metadata blocks
+// are written after STREAMINFO and any SEEKTABLE in place of those given by
+// meta and opts, and the MD5 checksum of meta's StreamInfo is kept
+// until Close updates it.  If analysis is non-nil, it is a closed Encoder
+// that encoded the same samples with the same options, and its STREAMINFO
+// and SEEKTABLE are written in place of those that Close would update.
+func newEncoder(w io.Writer, meta MetaData, opts EncoderOptions, extra [][]byte, analysis *Encoder) (*Encoder, error) {
+	if meta.StreamInfo == nil {
+		return nil, errors.New("Missing STREAMINFO")
+	}
+	if opts.Level < 0 || opts.Level >= len(levels) {
+		return nil, errors.New("Bad compression level " + strconv.Itoa(opts.Level))
+	}
+	e := &Encoder{w: w, start: -1, info: *meta.StreamInfo, level: levels[opts.Level], verify: opts.Verify}
+	e.variable = opts.VariableBlockSize || opts.AdaptiveBlockSize
+	e.adaptive = opts.AdaptiveBlockSize
+	e.level.exhaustive = opts.Exhaustive
+	e.progress = opts.Progress
+	if opts.BlockSize != 0 {
+		if opts.BlockSize < minBlockSize || opts.BlockSize > 65535 {
+			return nil, errors.New("Bad block size " + strconv.Itoa(opts.BlockSize))
+		}
+		e.level.blockSize = opts.BlockSize
+	}
+	if opts.MaxLPCOrder != 0 {
+		if opts.MaxLPCOrder < 1 || opts.MaxLPCOrder > maxLPCOrder {
+			return nil, errors.New("Bad maximum LPC order " + strconv.Itoa(opts.MaxLPCOrder))
+		}
+		e.level.maxLPCOrder = opts.MaxLPCOrder
+	}
+	if opts.MaxPartitionOrder != 0 {
+		if opts.MaxPartitionOrder < 0 || opts.MaxPartitionOrder > maxPartitionOrder {
+			return nil, errors.New("Bad maximum partition order " + strconv.Itoa(opts.MaxPartitionOrder))
+		}
+		e.level.maxPartitionOrder = opts.MaxPartitionOrder
+	}
+	if opts.MinPartitionOrder != 0 {
+		if opts.MinPartitionOrder < 0 || opts.MinPartitionOrder > e.level.maxPartitionOrder {
+			return nil, errors.New("Bad minimum partition order " + strconv.Itoa(opts.MinPartitionOrder))
+		}
+		e.level.minPartitionOrder = opts.MinPartitionOrder
+	}
+	if len(opts.Windows) > 0 {
+		for _, w := range opts.Windows {
+			if w.Shape < WindowTukey || w.Shape > WindowRectangle {
+				return nil, errors.New("Bad window shape " + strconv.Itoa(int(w.Shape)))
+			}
+			if w.Shape == WindowTukey && !(w.P >= 0 && w.P <= 1) {
+				return nil, errors.New("Bad Tukey window fraction " + strconv.FormatFloat(w.P, 'g', -1, 64))
+			}
+		}
+		e.level.windows = append([]Window{}, opts.Windows...)
+	}
+	switch opts.RiceSearch {
+	case RiceEstimate:
+		e.riceParam = riceParam
+	case RiceExhaustive:
+		e.riceParam = riceParamExhaustive
+	default:
+		return nil, errors.New("Bad Rice search " + strconv.Itoa(int(opts.RiceSearch)))
+	}
+	if opts.Workers < 0 {
+		return nil, errors.New("Bad number of workers " + strconv.Itoa(opts.Workers))
+	}
+	if opts.FixedOnly {
+		if opts.MaxLPCOrder != 0 {
+			return nil, errors.New("MaxLPCOrder with FixedOnly")
+		}
+		e.level.maxLPCOrder = 0
+	}
+	switch {
+	case SampleSizeCode(e.info.BitsPerSample) == 0:
+		return nil, errors.New("Unsupported bits per sample: " + strconv.Itoa(e.info.BitsPerSample))
+	case e.info.NChannels < 1 || e.info.NChannels > 8:
+		return nil, errors.New("Bad number of channels: " + strconv.Itoa(e.info.NChannels))
+	case e.info.SampleRate < 1 || e.info.SampleRate >= 1<<20:
+		return nil, errors.New("Bad sample rate: " + strconv.Itoa(e.info.SampleRate))
+	}
+	if opts.Subset {
+		if err := e.checkSubset(); err != nil {
+			return nil, err
+		}
+	}
+	e.info.MinBlock = e.level.blockSize
+	e.info.MaxBlock = e.level.blockSize
+	if e.variable {
+		// Flush may write shorter blocks, so, until Close can
+		// update it, the minimum is the smallest block size allowed.
+		e.info.MinBlock = minBlockSize
+	}
+	e.info.MinFrame = 0
+	e.info.MaxFrame = 0
+	if extra == nil {
+		e.info.MD5 = [16]byte{}
+	}
+	e.buf = make([][]int32, e.info.NChannels)
+	e.md5 = md5.New()
+
+	if s, ok := w.(io.WriteSeeker); ok {
+		if off, err := s.Seek(0, 1); err == nil {
+			e.start = off
+		}
+	}
+	seekTable := meta.SeekTable
+	if opts.Ogg {
+		if opts.SeekInterval != 0 || opts.SeekSeconds != 0 {
+			return nil, errors.New("SEEKTABLE is not supported in Ogg")
+		}
+		seekTable = nil
+	}
+	if analysis != nil {
+		e.info = analysis.info
+		e.seekInterval = analysis.seekInterval
+		e.seekTable = analysis.seekTable
+		// The seek points are all filled in.
+		e.seekPoints = len(e.seekTable)
+	} else if err := e.reserveSeekTable(opts); err != nil {
+		return nil, err
+	}
+	if e.seekTable != nil {
+		seekTable = e.seekTable
+	}
+	var blocks [][]byte
+	var err error
+	if extra != nil {
+		blocks, err = encodeMetaData(MetaData{StreamInfo: &e.info, SeekTable: seekTable})
+		blocks = append(blocks, extra...)
+	} else {
+		blocks, err = e.metaDataBlocks(meta, opts, seekTable)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if e.seekTable != nil {
+		// The SEEKTABLE follows STREAMINFO.
+		e.seekTableOffset = e.start + int64(len(magic)+len(blocks[0])) + 4
+	}
+	if opts.Ogg {
+		e.ogg = newOggWriter(w)
+		if err := e.ogg.writeHeaders(blocks); err != nil {
+			return nil, err
+		}
+	} else if err := writeBlocks(w, blocks); err != nil {
+		return nil, err
+	}
+	e.metaDataBytes = int64(len(magic))
+	for _, b := range blocks {
+		e.metaDataBytes += int64(len(b))
+	}
+	if opts.Workers > 1 {
+		e.workers = make([]*Encoder, opts.Workers)
+		for i := range e.workers {
+			w := *e
+			w.bw = bitWriter{}
+			w.weights = nil
+			e.workers[i] = &w
+		}
+	}
+	return e, nil
+}
+
+// MetaDataBlocks returns the metadata blocks to write for the given
+// metadata and options, with the given SEEKTABLE.
+func (e *Encoder) metaDataBlocks(meta MetaData, opts EncoderOptions, seekTable []SeekPoint) ([][]byte, error) {
+	cmnt := meta.VorbisComment
+	if opts.VorbisComment != nil {
+		cmnt = opts.VorbisComment
+	}
+	if cmnt != nil && cmnt.Vendor == "" {
+		cmnt = &VorbisComment{Vendor: vendor, Comments: cmnt.Comments}
+	} else if cmnt == nil && opts.Ogg {
+		cmnt = &VorbisComment{Vendor: vendor}
+	}
+	others := meta.Others
+	if opts.CueSheet != nil {
+		others = nil
+		for _, b := range meta.Others {
+			if BlockType(b.Type) != BlockCueSheet {
+				others = append(others, b)
+			}
+		}
+	}
+	blocks, err := encodeMetaData(MetaData{
+		StreamInfo:    &e.info,
+		VorbisComment: cmnt,
+		Applications:  meta.Applications,
+		SeekTable:     seekTable,
+		Pictures:      meta.Pictures,
+		Others:        others,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if opts.CueSheet != nil {
+		if e.info.TotalSamples <= 0 {
+			return nil, errors.New("CUESHEET requires TotalSamples")
+		}
+		body, err := encodeCueSheet(opts.CueSheet, e.info.SampleRate, e.info.TotalSamples)
+		if err != nil {
+			return nil, err
+		}
+		block, err := metaDataBlock(BlockCueSheet, body)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	padding := opts.Padding
+	if padding == 0 {
+		padding = DefaultPadding
+	}
+	if padding > 0 {
+		block, err := metaDataBlock(BlockPadding, make([]byte, padding))
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// CheckSubset returns an error if the Encoder's settings
+// would make a stream outside of the streamable subset.
+func (e *Encoder) checkSubset() error {
+	maxBlock, maxOrder := 16384, maxLPCOrder
+	if e.info.SampleRate <= 48000 {
+		maxBlock, maxOrder = 4608, 12
+	}
+	rate := strconv.Itoa(e.info.SampleRate)
+	switch c, _, _ := SampleRateCode(e.info.SampleRate); {
+	case e.level.blockSize > maxBlock:
+		return errors.New("Block size " + strconv.Itoa(e.level.blockSize) + " at " + rate + " Hz is not in the streamable subset")
+	case e.level.maxLPCOrder > maxOrder:
+		return errors.New("LPC order " + strconv.Itoa(e.level.maxLPCOrder) + " at " + rate + " Hz is not in the streamable subset")
+	case c == 0:
+		return errors.New("Sample rate " + rate + " Hz is not in the streamable subset")
+	}
+	return nil
+}
+
+// ReserveSeekTable sets up the placeholder seek points requested by opts.
+func (e *Encoder) reserveSeekTable(opts EncoderOptions) error {
+	switch {
+	case opts.SeekInterval < 0:
+		return errors.New("Bad seek interval " + strconv.FormatInt(opts.SeekInterval, 10))
+	case !(opts.SeekSeconds >= 0):
+		return errors.New("Bad seek interval " + strconv.FormatFloat(opts.SeekSeconds, 'g', -1, 64) + "s")
+	case opts.SeekInterval > 0 && opts.SeekSeconds > 0:
+		return errors.New("SeekInterval with SeekSeconds")
+	}
+	e.seekInterval = opts.SeekInterval
+	if opts.SeekSeconds > 0 {
+		e.seekInterval = int64(opts.SeekSeconds * float64(e.info.SampleRate))
+		if e.seekInterval < 1 {
+			e.seekInterval = 1
+		}
+	}
+	if e.seekInterval == 0 {
+		return nil
+	}
+	if e.start < 0 {
+		return errors.New("SEEKTABLE requires an io.WriteSeeker")
+	}
+	if e.info.TotalSamples <= 0 {
+		return errors.New("SEEKTABLE requires TotalSamples")
+	}
+	n := (e.info.TotalSamples + e.seekInterval - 1) / e.seekInterval
+	if n*seekPointSize >= 1<<24 {
+		return errors.New("Too many seek points: " + strconv.FormatInt(n, 10))
+	}
+	e.seekTable = make([]SeekPoint, n)
+	for i := range e.seekTable {
+		e.seekTable[i].Sample = PlaceholderPoint
+	}
+	return nil
+}
+
+// EncodeMetaData returns the metadata blocks of meta.
+func encodeMetaData(meta MetaData) ([][]byte, error) {
+	var blocks [][]byte
+	add := func(kind BlockType, body []byte) error {
+		block, err := metaDataBlock(kind, body)
+		blocks = append(blocks, block)
+		return err
+	}
+	add(BlockStreamInfo, encodeStreamInfo(meta.StreamInfo))
+	if meta.SeekTable != nil {
+		if err := add(BlockSeekTable, encodeSeekTable(meta.SeekTable)); err != nil {
+			return nil, err
+		}
+	}
+	if meta.VorbisComment != nil {
+		body, err := encodeVorbisComment(meta.VorbisComment)
+		if err != nil {
+			return nil, err
+		}
+		if err := add(BlockVorbisComment, body); err != nil {
+			return nil, err
+		}
+	}
+	for _, app := range meta.Applications {
+		if err := add(BlockApplication, encodeApplication(app)); err != nil {
+			return nil, err
+		}
+	}
+	for _, pic := range meta.Pictures {
+		if err := add(BlockPicture, encodePicture(pic)); err != nil {
+			return nil, err
+		}
+	}
+	for _, b := range meta.Others {
+		if b.Type <= int(BlockStreamInfo) || b.Type >= invalidBlockType {
+			return nil, errors.New("Bad metadata block type " + strconv.Itoa(b.Type))
+		}
+		if err := add(BlockType(b.Type), b.Data); err != nil {
+			return nil, err
+		}
+	}
+	return blocks, nil
+}
+
+// Write encodes samples, given per channel as returned by Decoder.NextSamples.
+// Each sample must fit in the stream's bits per sample.
+// Samples are buffered until they fill a block; Close encodes the remainder.
+func (e *Encoder) Write(samples [][]int32) error {
+	if e.closed {
+		return errors.New("Write after Close")
+	}
+	if len(samples) != e.info.NChannels {
+		return errors.New("Wrong number of channels: " + strconv.Itoa(len(samples)))
+	}
+	for _, ch := range samples[1:] {
+		if len(ch) != len(samples[0]) {
+			return errors.New("Channels have different numbers of samples")
+		}
+	}
+	data, err := interleave(samples, e.info.BitsPerSample)
+	if err != nil {
+		return err
+	}
+	e.md5.Write(data)
+	for ch := range e.buf {
+		e.buf[ch] = append(e.buf[ch], samples[ch]...)
+	}
+	return e.encodeBuffered(false)
+}
+
+// EncodeBuffered encodes the full blocks of buffered samples.
+// Unless all is true, the blocks are encoded only in batches,
+// one block for each worker.
+func (e *Encoder) encodeBuffered(all bool) error {
+	batch := e.level.blockSize
+	if len(e.workers) > 0 && !all {
+		batch *= len(e.workers)
+	}
+	n := len(e.buf[0]) / batch * batch
+	var blocks [][][]int32
+	for i := 0; i+e.level.blockSize <= n; i += e.level.blockSize {
+		block := make([][]int32, len(e.buf))
+		for ch := range block {
+			block[ch] = e.buf[ch][i : i+e.level.blockSize]
+		}
+		if e.adaptive {
+			blocks = append(blocks, e.split(block, adaptiveSplits)...)
+		} else {
+			blocks = append(blocks, block)
+		}
+	}
+	if err := e.encodeFrames(blocks); err != nil {
+		return err
+	}
+	for ch := range e.buf {
+		e.buf[ch] = e.buf[ch][:copy(e.buf[ch], e.buf[ch][n:])]
+	}
+	return nil
+}
+
+// Split returns the block as is or split into smaller blocks,
+// halving it up to the given number of times, whichever codes in the fewest bits.
+func (e *Encoder) split(block [][]int32, splits int) [][][]int32 {
+	n := len(block[0])
+	if splits == 0 || n%2 != 0 || n/2 < minBlockSize {
+		return [][][]int32{block}
+	}
+	first, second := make([][]int32, len(block)), make([][]int32, len(block))
+	for ch, x := range block {
+		first[ch], second[ch] = x[:n/2], x[n/2:]
+	}
+	halves := append(e.split(first, splits-1), e.split(second, splits-1)...)
+	bits := 0
+	for _, h := range halves {
+		bits += e.frameBits(h)
+	}
+	if bits < e.frameBits(block) {
+		return halves
+	}
+	return [][][]int32{block}
+}
+
+// FrameBits returns an estimate of the size of a frame of the data in bits.
+// The headers of frames of a variable block size vary by a few bytes
+// with the sample number, so a 7-byte number is assumed.
+func (e *Encoder) frameBits(data [][]int32) int {
+	bps := uint(e.info.BitsPerSample)
+	subFrames := make([]subFrame, len(data))
+	for i, x := range data {
+		subFrames[i] = e.planSubFrame(x, bps)
+	}
+	if len(data) == 2 && e.level.stereo != stereoIndependent && bps < 32 {
+		_, _, subFrames = e.decorrelate(data, subFrames)
+	}
+	// The sync code, the block size, sample rate, channels and bits per sample,
+	// the number, the extra block size and sample rate bytes, and the CRCs.
+	bits := 8 * (4 + 7 + 2 + 2 + 1 + 2)
+	for _, sf := range subFrames {
+		bits += sf.bits
+	}
+	return bits
+}
+
+// EncodeFrames encodes and writes frames of the blocks,
+// concurrently if there are workers.
+func (e *Encoder) encodeFrames(blocks [][][]int32) error {
+	if len(e.workers) == 0 {
+		for _, block := range blocks {
+			if err := e.encodeFrame(block); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for len(blocks) > 0 {
+		batch := blocks
+		if len(batch) > len(e.workers) {
+			batch = batch[:len(e.workers)]
+		}
+		blocks = blocks[len(batch):]
+		frames := make([][]byte, len(batch))
+		errs := make([]error, len(batch))
+		var wg sync.WaitGroup
+		frame, sample := e.frame, e.samples
+		for i, block := range batch {
+			wg.Add(1)
+			go func(i int, w *Encoder, block [][]int32, frame uint64, sample int64) {
+				defer wg.Done()
+				frames[i], errs[i] = w.encode(block, frame, sample)
+			}(i, e.workers[i], block, frame, sample)
+			frame++
+			sample += int64(len(block[0]))
+		}
+		wg.Wait()
+		for i, block := range batch {
+			if errs[i] != nil {
+				return errs[i]
+			}
+			if err := e.writeFrame(frames[i], len(block[0])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Flush writes the samples buffered by Write as a frame, if the stream has
+// a variable block size, and then flushes the underlying writer if it has
+// a Flush method, such as that of a *bufio.Writer.
+// With a fixed block size, samples that do not fill a block stay buffered,
+// as do fewer than 16 samples, the smallest block size but for the last.
+func (e *Encoder) Flush() error {
+	if e.closed {
+		return errors.New("Flush after Close")
+	}
+	if err := e.encodeBuffered(true); err != nil {
+		return err
+	}
+	if e.variable && len(e.buf[0]) >= minBlockSize {
+		if err := e.encodeFrame(e.buf); err != nil {
+			return err
+		}
+		for ch := range e.buf {
+			e.buf[ch] = e.buf[ch][:0]
+		}
+	}
+	if e.ogg != nil {
+		if err := e.ogg.flush(0); err != nil {
+			return err
+		}
+	}
+	if f, ok := e.w.(interface {
+		Flush() error
+	}); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close encodes any buffered samples and, if the writer is an io.WriteSeeker,
+// updates STREAMINFO.  It does not close the underlying writer.
+func (e *Encoder) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	if err := e.encodeBuffered(true); err != nil {
+		return err
+	}
+	if len(e.buf[0]) > 0 {
+		if err := e.encodeFrame(e.buf); err != nil {
+			return err
+		}
+	}
+	if e.ogg != nil {
+		if err := e.ogg.flush(oggEOS); err != nil {
+			return err
+		}
+	}
+	if e.start < 0 {
+		// STREAMINFO cannot be updated, so it had better be right.
+		if e.info.TotalSamples > 0 && e.info.TotalSamples != e.samples {
+			return errors.New("Wrote " + strconv.FormatInt(e.samples, 10) +
+				" samples, but STREAMINFO gives " + strconv.FormatInt(e.info.TotalSamples, 10))
+		}
+		return nil
+	}
+	s := e.w.(io.WriteSeeker)
+	e.info.TotalSamples = e.samples
+	if e.variable && e.maxBlock > 0 {
+		e.info.MinBlock = e.minBlock
+		e.info.MaxBlock = e.maxBlock
+		if e.minBlock == 0 || e.minBlock > e.maxBlock {
+			e.info.MinBlock = e.maxBlock
+		}
+	}
+	copy(e.info.MD5[:], e.md5.Sum(nil))
+	if e.ogg != nil {
+		// The first page is rewritten whole, since its CRC changes.
+		block, err := metaDataBlock(BlockStreamInfo, encodeStreamInfo(&e.info))
+		if err != nil {
+			return err
+		}
+		page, err := e.ogg.firstPage(block)
+		if err != nil {
+			return err
+		}
+		if _, err := s.Seek(e.start, 0); err != nil {
+			return err
+		}
+		if _, err := s.Write(page); err != nil {
+			return err
+		}
+	} else {
+		if _, err := s.Seek(e.start+int64(len(magic))+4, 0); err != nil {
+			return err
+		}
+		if _, err := s.Write(encodeStreamInfo(&e.info)); err != nil {
+			return err
+		}
+	}
+	if e.seekTable != nil {
+		if _, err := s.Seek(e.seekTableOffset, 0); err != nil {
+			return err
+		}
+		if _, err := s.Write(encodeSeekTable(e.seekTable)); err != nil {
+			return err
+		}
+	}
+	_, err := s.Seek(0, 2)
+	return err
+}
+
+// EncoderStats are statistics of the stream written by an Encoder so far.
+type EncoderStats struct {
+	// Samples is the number of inter-channel samples encoded,
+	// and Frames is the number of frames written.
+	// Samples buffered by Write that do not yet fill a frame are not counted.
+	Samples int64
+	Frames  int64
+	// BytesIn is the size of the encoded samples as PCM audio, with each
+	// sample taking the fewest whole bytes that hold it, as in a WAV file.
+	BytesIn int64
+	// BytesOut is the number of bytes written, including the metadata.
+	// In an Ogg container, the bytes of the Ogg pages are not counted.
+	BytesOut int64
+}
+
+// Ratio returns the compression ratio, BytesOut divided by BytesIn,
+// or 0 if no samples have been encoded.
+func (s EncoderStats) Ratio() float64 {
+	if s.BytesIn == 0 {
+		return 0
+	}
+	return float64(s.BytesOut) / float64(s.BytesIn)
+}
+
+// Stats returns the statistics of the stream written so far.
+// It can be called from the Progress function of the EncoderOptions
+// for more detail than its arguments give.
+func (e *Encoder) Stats() EncoderStats {
+	size := int64(e.info.NChannels * ((e.info.BitsPerSample + 7) / 8))
+	return EncoderStats{
+		Samples:  e.samples,
+		Frames:   int64(e.frame),
+		BytesIn:  e.samples * size,
+		BytesOut: e.metaDataBytes + e.frameBytes,
+	}
+}
+
+// EncodeFrame encodes and writes a frame of the data.
+func (e *Encoder) encodeFrame(data [][]int32) error {
+	frame, err := e.encode(data, e.frame, e.samples)
+	if err != nil {
+		return err
+	}
+	return e.writeFrame(frame, len(data[0]))
+}
+
+// Encode returns the encoding of a frame of the data, the given frame
+// of the stream, starting at the given sample.  The returned slice is
+// valid until the next call to encode.
+// Encode uses only the Encoder's settings and scratch space,
+// so workers can encode frames concurrently.
+func (e *Encoder) encode(data [][]int32, frame uint64, sample int64) ([]byte, error) {
+	input := data
+	n := len(data[0])
+	bps := uint(e.info.BitsPerSample)
+	assign := channelAssignment(len(data) - 1)
+	subFrames := make([]subFrame, len(data))
+	for i, x := range data {
+		subFrames[i] = e.planSubFrame(x, bps)
+	}
+	// The side channel of 32-bit stereo would need 33 bits.
+	if len(data) == 2 && e.level.stereo != stereoIndependent && bps < 32 {
+		assign, data, subFrames = e.decorrelate(data, subFrames)
+	}
+
+	e.bw.reset()
+	number := frame
+	if e.variable {
+		number = uint64(sample)
+	}
+	if err := e.writeFrameHeader(n, assign, number); err != nil {
+		return nil, err
+	}
+	h := frameHeader{channelAssignment: assign, sampleSize: e.info.BitsPerSample}
+	for i, x := range data {
+		e.writeSubFrame(subFrames[i], x, h.bitsPerSample(i))
+	}
+	e.bw.align()
+	e.bw.write(uint64(CRC16(e.bw.buf)), 16)
+	if e.verify {
+		if err := e.verifyFrame(e.bw.buf, input, frame); err != nil {
+			return nil, err
+		}
+	}
+	return e.bw.buf, nil
+}
+
+// WriteFrame writes an encoded frame of n samples, the next of the stream.
+func (e *Encoder) writeFrame(frame []byte, n int) error {
+	if e.ogg != nil {
+		if err := e.ogg.writePacket(frame, e.samples+int64(n)); err != nil {
+			return err
+		}
+	} else if _, err := e.w.Write(frame); err != nil {
+		return err
+	}
+
+	if e.seekPoints < len(e.seekTable) && e.samples >= e.nextSeek {
+		e.seekTable[e.seekPoints] = SeekPoint{Sample: e.samples, Offset: e.frameBytes, Samples: n}
+		e.seekPoints++
+		e.nextSeek = (e.samples/e.seekInterval + 1) * e.seekInterval
+	}
+	if e.lastBlock > 0 && (e.minBlock == 0 || e.lastBlock < e.minBlock) {
+		e.minBlock = e.lastBlock
+	}
+	e.lastBlock = n
+	if n > e.maxBlock {
+		e.maxBlock = n
+	}
+	size := len(frame)
+	e.frameBytes += int64(size)
+	if e.info.MinFrame == 0 || size < e.info.MinFrame {
+		e.info.MinFrame = size
+	}
+	if size > e.info.MaxFrame {
+		e.info.MaxFrame = size
+	}
+	e.samples += int64(n)
+	e.frame++
+	if e.progress != nil {
+		e.progress(e.samples, e.info.TotalSamples)
+	}
+	return nil
+}
+
+// VerifyFrame decodes an encoded frame and compares it to the samples that it encodes.
+func (e *Encoder) verifyFrame(frame []byte, data [][]int32, number uint64) error {
+	prefix := "Verify failed: frame " + strconv.FormatUint(number, 10) + ": "
+	r := bytes.NewReader(frame)
+	h, err := readFrameHeader(r, &e.info)
+	if err != nil {
+		return errors.New(prefix + err.Error())
+	}
+	br := bit.NewReader(r)
+	got := make([][]int32, h.channelAssignment.nChannels())
+	for ch := range got {
+		if got[ch], err = readSubFrame(br, h, ch); err != nil {
+			return errors.New(prefix + err.Error())
+		}
+	}
+	if err := verifyCRC16(frame); err != nil {
+		return errors.New(prefix + err.Error())
+	}
+	fixChannels(got, h.channelAssignment)
+	if len(got) != len(data) || h.blockSize != len(data[0]) {
+		return errors.New(prefix + "wrong size")
+	}
+	for ch := range got {
+		for i, s := range got[ch] {
+			if s != data[ch][i] {
+				return errors.New(prefix + "channel " + strconv.Itoa(ch) + ", sample " + strconv.Itoa(i) +
+					": got " + strconv.Itoa(int(s)) + ", want " + strconv.Itoa(int(data[ch][i])))
+			}
+		}
+	}
+	return nil
+}
+
+// Decorrelate returns the cheapest channel assignment allowed by the level's
+// stereo mode for a frame of stereo data, given the planned subframes
+// of its left and right channels, along with the channels and subframes to encode.
+func (e *Encoder) decorrelate(data [][]int32, subFrames []subFrame) (channelAssignment, [][]int32, []subFrame) {
+	left, right := data[0], data[1]
+	mid := make([]int32, len(left))
+	side := make([]int32, len(left))
+	for i := range left {
+		mid[i] = (left[i] + right[i]) >> 1
+		side[i] = left[i] - right[i]
+	}
+	bps := uint(e.info.BitsPerSample)
+	l, r := subFrames[0], subFrames[1]
+	m, s := e.planSubFrame(mid, bps), e.planSubFrame(side, bps+1)
+
+	assign, bits := channelAssignment(1), l.bits+r.bits // Independent left and right.
+	if e.level.stereo == stereoFull {
+		if b := l.bits + s.bits; b < bits {
+			assign, bits = leftSide, b
+		}
+		if b := s.bits + r.bits; b < bits {
+			assign, bits = rightSide, b
+		}
+	}
+	if b := m.bits + s.bits; b < bits {
+		assign, bits = midSide, b
+	}
+	switch assign {
+	case leftSide:
+		return assign, [][]int32{left, side}, []subFrame{l, s}
+	case rightSide:
+		return assign, [][]int32{side, right}, []subFrame{s, r}
+	case midSide:
+		return assign, [][]int32{mid, side}, []subFrame{m, s}
+	}
+	return assign, data, subFrames
+}
+
+// WriteFrameHeader writes a frame header.  The number is the frame number,
+// or, for streams with a variable block size, the number of the first sample.
+func (e *Encoder) writeFrameHeader(blockSize int, assign channelAssignment, number uint64) error {
+	// The header begins the frame, so the bitWriter is byte-aligned.
+	buf, err := AppendFrameHeader(e.bw.buf, FrameHeader{
+		BlockSize:         blockSize,
+		SampleRate:        e.info.SampleRate,
+		NChannels:         e.info.NChannels,
+		ChannelAssignment: int(assign),
+		BitsPerSample:     e.info.BitsPerSample,
+		VariableSize:      e.variable,
+		Number:            number,
+	})
+	e.bw.buf = buf
+	return err
+}
+
+// A subFrame is a planned encoding of a subframe.
+type subFrame struct {
+	kind subFrameKind
+	// Order is the number of warm-up samples of FIXED and LPC subframes.
+	order int
+	// Coeffs are the quantized LPC coefficients, with their precision
+	// in bits and the shift of the prediction.
+	coeffs    []int32
+	precision uint
+	shift     uint
+	residual  []int32
+	rice      ricePlan
+	// Wasted is the number of low bits that are zero in every sample,
+	// and samples are the samples shifted right by wasted, if it is non-zero.
+	wasted  uint
+	samples []int32
+	// Bits is the size of the subframe.
+	bits int
+}
+
+// WriteSubFrame writes the planned subframe encoding x with the given bits per sample.
+func (e *Encoder) writeSubFrame(sf subFrame, x []int32, bps uint) {
+	bw := &e.bw
+	var kind uint64 // CONSTANT.
+	switch sf.kind {
+	case subFrameVerbatim:
+		kind = 0x01
+	case subFrameFixed:
+		kind = 0x08 | uint64(sf.order)
+	case subFrameLPC:
+		kind = 0x20 | uint64(sf.order-1)
+	}
+	bw.write(kind, 7) // Zero padding and the subframe type.
+	if sf.wasted > 0 {
+		bw.write(1, 1)
+		bw.writeUnary(uint64(sf.wasted - 1))
+		x, bps = sf.samples, bps-sf.wasted
+	} else {
+		bw.write(0, 1)
+	}
+
+	switch sf.kind {
+	case subFrameConstant:
+		bw.writeSigned(x[0], bps)
+
+	case subFrameVerbatim:
+		for _, s := range x {
+			bw.writeSigned(s, bps)
+		}
+
+	case subFrameFixed:
+		for _, s := range x[:sf.order] {
+			bw.writeSigned(s, bps)
+		}
+		sf.rice.write(bw, sf.residual)
+
+	case subFrameLPC:
+		for _, s := range x[:sf.order] {
+			bw.writeSigned(s, bps)
+		}
+		bw.write(uint64(sf.precision-1), 4)
+		bw.write(uint64(sf.shift), 5)
+		for _, c := range sf.coeffs {
+			bw.writeSigned(c, sf.precision)
+		}
+		sf.rice.write(bw, sf.residual)
+	}
+}
+
+// PlanSubFrame returns the smallest encoding of x.
+func (e *Encoder) planSubFrame(x []int32, bps uint) subFrame {
+	if isConstant(x) {
+		return subFrame{kind: subFrameConstant, bits: 8 + int(bps)}
+	}
+	if w := wastedBits(x, bps); w > 0 {
+		// Coding the count of wasted bits takes w more bits.
+		shifted := make([]int32, len(x))
+		for i, s := range x {
+			shifted[i] = s >> w
+		}
+		sf := e.planSubFrame(shifted, bps-w)
+		sf.wasted, sf.samples = w, shifted
+		sf.bits += int(w)
+		return sf
+	}
+	best := subFrame{kind: subFrameVerbatim, bits: 8 + len(x)*int(bps)}
+
+	if e.level.exhaustive {
+		for order, res := range fixedResiduals(x) {
+			if fixed := e.fixedSubFrame(res[order:], order, bps); fixed.bits < best.bits {
+				best = fixed
+			}
+		}
+	} else {
+		order, residual := bestFixed(x)
+		if fixed := e.fixedSubFrame(residual, order, bps); fixed.bits < best.bits {
+			best = fixed
+		}
+	}
+	if lpc, ok := e.planLPC(x, bps); ok && lpc.bits < best.bits {
+		best = lpc
+	}
+	return best
+}
+
+// FixedSubFrame returns the FIXED encoding of a block of samples
+// with the given order and residual.
+func (e *Encoder) fixedSubFrame(residual []int32, order int, bps uint) subFrame {
+	rice := e.planRice(residual, order, len(residual)+order)
+	return subFrame{
+		kind:     subFrameFixed,
+		order:    order,
+		residual: residual,
+		rice:     rice,
+		bits:     8 + order*int(bps) + rice.bits,
+	}
+}
+
+// PlanLPC returns an LPC encoding of x, or false if there is none.
+func (e *Encoder) planLPC(x []int32, bps uint) (subFrame, bool) {
+	maxOrder := e.level.maxLPCOrder
+	if maxOrder >= len(x) {
+		maxOrder = len(x) - 1
+	}
+	if maxOrder < 1 {
+		return subFrame{}, false
+	}
+	weights, ok := e.weights[len(x)]
+	if !ok {
+		for _, w := range e.level.windows {
+			weights = append(weights, w.weights(len(x)))
+		}
+		if e.weights == nil || len(e.weights) > adaptiveSplits+1 {
+			// Blocks written by Flush and Close may have any size.
+			e.weights = make(map[int][][]float64)
+		}
+		e.weights[len(x)] = weights
+	}
+	var best subFrame
+	for _, w := range weights {
+		sf, ok := e.planLPCWindow(x, bps, maxOrder, w)
+		if ok && (best.coeffs == nil || sf.bits < best.bits) {
+			best = sf
+		}
+	}
+	return best, best.coeffs != nil
+}
+
+// PlanLPCWindow returns an LPC encoding of x, of order up to maxOrder,
+// analyzed with the given window weights, or false if there is none.
+func (e *Encoder) planLPCWindow(x []int32, bps uint, maxOrder int, window []float64) (subFrame, bool) {
+	r := autocorrelate(x, window, maxOrder)
+	if r[0] == 0 {
+		return subFrame{}, false
+	}
+	lpc, errs := levinsonDurbin(r)
+	if len(lpc) == 0 {
+		return subFrame{}, false
+	}
+	precision := lpcPrecision(len(x))
+	if e.level.exhaustive {
+		var best subFrame
+		for order := 1; order <= len(lpc); order++ {
+			for p := uint(minLPCPrecision); p <= maxLPCPrecision; p++ {
+				sf, ok := e.lpcSubFrame(x, bps, lpc[order-1], p)
+				if ok && (best.coeffs == nil || sf.bits < best.bits) {
+					best = sf
+				}
+			}
+		}
+		return best, best.coeffs != nil
+	}
+
+	// Estimate the size of each order's encoding from its prediction error:
+	// the residuals take about half the log2 of the error per sample,
+	// plus the warm-up samples and coefficients.
+	order, bestBits := 0, math.Inf(1)
+	n := float64(len(x))
+	for o := 1; o <= len(lpc); o++ {
+		perSample := 0.5 * math.Log2(math.Max(errs[o-1]/n, 1e-10))
+		bits := math.Max(perSample, 0)*(n-float64(o)) + float64(o)*float64(precision+bps)
+		if bits < bestBits {
+			order, bestBits = o, bits
+		}
+	}
+	return e.lpcSubFrame(x, bps, lpc[order-1], precision)
+}
+
+// LPCSubFrame returns the LPC encoding of x with the given coefficients
+// quantized to precision bits, or false if its residual does not fit in 32 bits.
+func (e *Encoder) lpcSubFrame(x []int32, bps uint, lpc []float64, precision uint) (subFrame, bool) {
+	order := len(lpc)
+	coeffs, shift := quantizeLPC(lpc, precision)
+	residual, ok := lpcResidual(x, coeffs, shift)
+	if !ok {
+		return subFrame{}, false
+	}
+	rice := e.planRice(residual, order, len(x))
+	return subFrame{
+		kind:      subFrameLPC,
+		order:     order,
+		coeffs:    coeffs,
+		precision: precision,
+		shift:     shift,
+		residual:  residual,
+		rice:      rice,
+		bits:      8 + order*int(bps) + 4 + 5 + order*int(precision) + rice.bits,
+	}, true
+}
+
+// WastedBits returns the number of low bits that are zero in every sample of x,
+// less than bps.
+func wastedBits(x []int32, bps uint) uint {
+	var or int32
+	for _, s := range x {
+		or |= s
+	}
+	var w uint
+	for w < bps-1 && or&(1<<w) == 0 {
+		w++
+	}
+	return w
+}
+
+func isConstant(x []int32) bool {
+	for _, s := range x[1:] {
+		if s != x[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// BestFixed returns the fixed predictor order with the smallest residual,
+// and the residual of the samples following the warm-up samples.
+func bestFixed(x []int32) (int, []int32) {
+	res := fixedResiduals(x)
+	maxOrder := len(res) - 1
+	best, bestSum := 0, int64(-1)
+	for o := 0; o <= maxOrder; o++ {
+		var sum int64
+		for _, r := range res[o][maxOrder:] {
+			if r < 0 {
+				sum -= int64(r)
+			} else {
+				sum += int64(r)
+			}
+		}
+		if bestSum < 0 || sum < bestSum {
+			best, bestSum = o, sum
+		}
+	}
+	return best, res[best][best:]
+}
+
+// FixedResiduals returns the residuals of x for each fixed predictor order
+// that can code it, where res[o][i] is the order o residual of x[i],
+// for i of at least o.
+func fixedResiduals(x []int32) [][]int32 {
+	maxOrder := 4
+	if len(x) <= maxOrder {
+		maxOrder = len(x) - 1
+	}
+	// Each order's residual is the difference of the previous order's.
+	res := [][]int32{x}
+	for o := 1; o <= maxOrder; o++ {
+		r := make([]int32, len(x))
+		for i := o; i < len(x); i++ {
+			d := int64(res[o-1][i]) - int64(res[o-1][i-1])
+			if d < math.MinInt32 || d > math.MaxInt32 {
+				// The residuals of 32-bit samples may overflow,
+				// in which case this and higher orders cannot be used.
+				return res
+			}
+			r[i] = int32(d)
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+// A ricePlan is a Rice coding of a residual.
+type ricePlan struct {
+	// Warm is the number of warm-up samples preceding the residual.
+	warm  int
+	order uint
+	// Params are the Rice parameters of the partitions, or riceEscape
+	// for partitions whose residuals are written unencoded,
+	// each in the number of bits given by raw.
+	params []uint
+	raw    []uint
+	// Bits is the size of the coded residual, including its header.
+	bits int
+}
+
+// PlanRice returns the smallest Rice coding of the residual, following
+// predOrder warm-up samples in a block of blockSize samples, with a
+// partition order in the level's range.
+func (e *Encoder) planRice(residual []int32, predOrder, blockSize int) ricePlan {
+	maxOrder := uint(e.level.maxPartitionOrder)
+	for maxOrder > 0 && (blockSize%(1<<maxOrder) != 0 || blockSize>>maxOrder < predOrder) {
+		maxOrder--
+	}
+	minOrder := uint(e.level.minPartitionOrder)
+	if minOrder > maxOrder {
+		minOrder = maxOrder
+	}
+	u := make([]uint32, len(residual))
+	for i, r := range residual {
+		u[i] = fold(r)
+	}
+	// The sums of the partitions of the maximum order are summed
+	// in pairs for each lower order.
+	parts := make([]partitionSums, 1<<maxOrder)
+	start := 0
+	for p := range parts {
+		end := start + blockSize>>maxOrder
+		if p == 0 {
+			end -= predOrder
+		}
+		parts[p] = sumPartition(u, residual, start, end)
+		start = end
+	}
+	var best ricePlan
+	for o := maxOrder; ; o-- {
+		if 1<<o < len(parts) {
+			for p := range parts[:1<<o] {
+				parts[p] = parts[2*p].add(parts[2*p+1])
+			}
+			parts = parts[:1<<o]
+		}
+		plan := ricePlan{
+			warm:   predOrder,
+			order:  o,
+			params: make([]uint, 1<<o),
+			raw:    make([]uint, 1<<o),
+			bits:   2 + 4,
+		}
+		for p, part := range parts {
+			n := part.end - part.start
+			k, bits := e.riceParam(u[part.start:part.end], part.sum)
+			// Incompressible residuals are cheaper to escape,
+			// at the cost of 5 bits for their size.
+			if raw := part.rawBits(); raw <= 31 && 5+n*int(raw) < bits {
+				k, bits = riceEscape, 5+n*int(raw)
+				plan.raw[p] = raw
+			}
+			plan.params[p] = k
+			plan.bits += bits
+		}
+		plan.bits += len(plan.params) * int(plan.paramBits())
+		// Ties go to the lower order.
+		if best.params == nil || plan.bits <= best.bits {
+			best = plan
+		}
+		if o == minOrder {
+			break
+		}
+	}
+	return best
+}
+
+// PartitionSums are sums over the residuals of a Rice partition.
+type partitionSums struct {
+	// Start and end are the bounds of the partition in the residual.
+	start, end int
+	// Sum is the sum of the folded residuals.
+	sum uint64
+	// Or is the bitwise or of the magnitudes of the residuals, counting
+	// each negative r as ^r, and nonZero is whether any residual is non-zero.
+	or      uint32
+	nonZero bool
+}
+
+// SumPartition returns the sums of the partition from start to end
+// of the residual, of which u holds the folded residuals.
+func sumPartition(u []uint32, residual []int32, start, end int) partitionSums {
+	s := partitionSums{start: start, end: end}
+	for i, r := range residual[start:end] {
+		s.sum += uint64(u[start+i])
+		if r != 0 {
+			s.nonZero = true
+		}
+		if r < 0 {
+			r = ^r
+		}
+		s.or |= uint32(r)
+	}
+	return s
+}
+
+// Add returns the sums of the partition followed by the partition t.
+func (s partitionSums) add(t partitionSums) partitionSums {
+	return partitionSums{
+		start:   s.start,
+		end:     t.end,
+		sum:     s.sum + t.sum,
+		or:      s.or | t.or,
+		nonZero: s.nonZero || t.nonZero,
+	}
+}
+
+// RawBits returns the number of bits needed to write each of the
+// partition's residuals in two's complement, which is 0 if they are all 0.
+func (s partitionSums) rawBits() uint {
+	if s.or == 0 {
+		if s.nonZero {
+			return 1
+		}
+		return 0
+	}
+	n := uint(1)
+	for or := s.or; or != 0; or >>= 1 {
+		n++
+	}
+	return n
+}
+
+// MaxRiceParam is the largest Rice parameter, which requires method 1 coding.
+const maxRiceParam = 30
+
+// RiceEscape is the Rice parameter of escaped partitions, in ricePlan.params.
+// It is written as all ones: 15 with method 0, or 31 with method 1.
+const riceEscape = maxRiceParam + 1
+
+// RiceParam returns a Rice parameter for the folded residuals,
+// whose sum is given, and the number of bits they take to code with it.
+func riceParam(u []uint32, sum uint64) (uint, int) {
+	// The best parameter is about log2 of the mean.
+	n := uint64(len(u))
+	k := uint(0)
+	for k < maxRiceParam && n<<(k+1) <= sum {
+		k++
+	}
+	bits := len(u) * int(k+1)
+	for _, v := range u {
+		bits += int(v >> k)
+	}
+	return k, bits
+}
+
+// RiceParamExhaustive returns the Rice parameter that codes
+// the folded residuals in the fewest bits, and the number of bits.
+// Their sum is not needed.
+func riceParamExhaustive(u []uint32, _ uint64) (uint, int) {
+	best, bestBits := uint(0), -1
+	for k := uint(0); k <= maxRiceParam; k++ {
+		bits := len(u) * int(k+1)
+		high := false
+		for _, v := range u {
+			bits += int(v >> k)
+			high = high || v>>k > 0
+		}
+		if bestBits < 0 || bits < bestBits {
+			best, bestBits = k, bits
+		}
+		if !high {
+			// Larger parameters only add bits.
+			break
+		}
+	}
+	return best, bestBits
+}
+
+// ParamBits returns the size of each partition's Rice parameter:
+// 4 bits with method 0, or 5 with method 1 for parameters over 14.
+func (p ricePlan) paramBits() uint {
+	for _, k := range p.params {
+		if k > 14 && k != riceEscape {
+			return 5
+		}
+	}
+	return 4
+}
+
+func (p ricePlan) write(bw *bitWriter, residual []int32) {
+	pbits := p.paramBits()
+	bw.write(uint64(pbits-4), 2) // Coding method.
+	bw.write(uint64(p.order), 4)
+	start := 0
+	size := (len(residual) + p.warm) >> p.order
+	for i, k := range p.params {
+		end := start + size
+		if i == 0 {
+			end -= p.warm
+		}
+		if k == riceEscape {
+			bw.write(1<<pbits-1, pbits)
+			bw.write(uint64(p.raw[i]), 5)
+			for _, r := range residual[start:end] {
+				bw.writeSigned(r, p.raw[i])
+			}
+			start = end
+			continue
+		}
+		bw.write(uint64(k), pbits)
+		for _, r := range residual[start:end] {
+			bw.writeRice(fold(r), k)
+		}
+		start = end
+	}
+}
+
+// Fold maps signed residuals to unsigned: 0, -1, 1, -2, 2, ... to 0, 1, 2, 3, 4, ....
+func fold(r int32) uint32 {
+	return uint32(r<<1) ^ uint32(r>>31)
+}
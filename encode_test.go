@@ -0,0 +1,1272 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/eaburns/bit"
+)
+
+// TestSignal returns n samples of each of nch channels of a noisy sine wave
+// with the given bits per sample, with a stretch of silence.
+func testSignal(nch, n, bps int) [][]int32 {
+	amp := float64(int64(1)<<uint(bps-1)) * 0.7
+	seed := uint32(1)
+	data := make([][]int32, nch)
+	for ch := range data {
+		data[ch] = make([]int32, n)
+		for i := range data[ch] {
+			if i > n/3 && i < n/2 {
+				continue
+			}
+			seed = seed*1664525 + 1013904223
+			noise := float64(int32(seed)>>20) / 2048 * amp / 100
+			data[ch][i] = int32(math.Sin(float64(i*(ch+1))/20)*amp + noise)
+		}
+	}
+	return data
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		nch, bps, n int
+		opts        EncoderOptions
+	}{
+		{nch: 2, bps: 16, n: 10000, opts: EncoderOptions{Level: 5}},
+		{nch: 2, bps: 16, n: 10000, opts: EncoderOptions{Level: 0}},
+		{nch: 2, bps: 16, n: 10000, opts: EncoderOptions{Level: 8}},
+		{nch: 1, bps: 8, n: 3000, opts: EncoderOptions{BlockSize: 192}},
+		{nch: 3, bps: 24, n: 3000, opts: EncoderOptions{BlockSize: 100}},
+		{nch: 2, bps: 16, n: 3000, opts: EncoderOptions{BlockSize: 1000}},
+		{nch: 1, bps: 16, n: 10, opts: EncoderOptions{}},
+		{nch: 2, bps: 16, n: 10000, opts: EncoderOptions{MaxLPCOrder: 32}},
+		{nch: 2, bps: 24, n: 10000, opts: EncoderOptions{MaxLPCOrder: 12}},
+		{nch: 1, bps: 8, n: 3000, opts: EncoderOptions{MaxLPCOrder: 1, BlockSize: 192}},
+		{nch: 2, bps: 16, n: 10000, opts: EncoderOptions{Level: 8, FixedOnly: true}},
+		{nch: 2, bps: 16, n: 10000, opts: EncoderOptions{Level: 8, Exhaustive: true, Verify: true}},
+		{nch: 1, bps: 24, n: 3000, opts: EncoderOptions{Level: 0, Exhaustive: true, Verify: true}},
+		{nch: 2, bps: 24, n: 10000, opts: EncoderOptions{Level: 8, RiceSearch: RiceExhaustive}},
+		{nch: 2, bps: 16, n: 10000, opts: EncoderOptions{Level: 8, Verify: true}},
+		{nch: 1, bps: 8, n: 3000, opts: EncoderOptions{Level: 0, Verify: true}},
+		{nch: 2, bps: 16, n: 50000, opts: EncoderOptions{Level: 5, Workers: 4, Verify: true}},
+		{nch: 2, bps: 16, n: 10000, opts: EncoderOptions{Level: 5, Workers: 16}},
+		{nch: 1, bps: 16, n: 10000, opts: EncoderOptions{Windows: []Window{{Shape: WindowRectangle}}}},
+		{nch: 2, bps: 16, n: 10000, opts: EncoderOptions{Windows: []Window{{Shape: WindowTukey, P: 0.1}, {Shape: WindowHann}}}},
+	}
+	for _, test := range tests {
+		data := testSignal(test.nch, test.n, test.bps)
+		info := &StreamInfo{SampleRate: 44100, NChannels: test.nch, BitsPerSample: test.bps}
+		var buf bytes.Buffer
+		e, err := NewEncoderOpts(&buf, MetaData{StreamInfo: info}, test.opts)
+		if err != nil {
+			t.Fatalf("%+v: NewEncoderOpts failed: %v", test, err)
+		}
+		// Write in uneven pieces.
+		for i := 0; i < test.n; i += 777 {
+			end := i + 777
+			if end > test.n {
+				end = test.n
+			}
+			piece := make([][]int32, test.nch)
+			for ch := range piece {
+				piece[ch] = data[ch][i:end]
+			}
+			if err := e.Write(piece); err != nil {
+				t.Fatalf("%+v: Write failed: %v", test, err)
+			}
+		}
+		if err := e.Close(); err != nil {
+			t.Fatalf("%+v: Close failed: %v", test, err)
+		}
+		// Don't count the PADDING block.
+		if raw, n := test.n*test.nch*test.bps/8, buf.Len()-DefaultPadding; test.n > 1000 && n >= raw {
+			t.Errorf("%+v: encoded %d bytes, raw audio is %d bytes", test, n, raw)
+		}
+
+		got, _, err := decodeAll(buf.Bytes())
+		if err != nil {
+			t.Fatalf("%+v: decoding failed: %v", test, err)
+		}
+		if !reflect.DeepEqual(got, data) {
+			t.Errorf("%+v: decoded audio differs", test)
+		}
+	}
+}
+
+func TestEncodeLPC(t *testing.T) {
+	data := testSignal(2, 20000, 16)
+	info := &StreamInfo{SampleRate: 44100, NChannels: 2, BitsPerSample: 16}
+	size := func(opts EncoderOptions) int {
+		var buf bytes.Buffer
+		e, err := NewEncoderOpts(&buf, MetaData{StreamInfo: info}, opts)
+		if err != nil {
+			t.Fatalf("%+v: NewEncoderOpts failed: %v", opts, err)
+		}
+		if err := e.Write(data); err != nil {
+			t.Fatalf("%+v: Write failed: %v", opts, err)
+		}
+		if err := e.Close(); err != nil {
+			t.Fatalf("%+v: Close failed: %v", opts, err)
+		}
+		return buf.Len()
+	}
+	fixed := size(EncoderOptions{Level: 0, BlockSize: 4096})
+	lpc := size(EncoderOptions{Level: 0, BlockSize: 4096, MaxLPCOrder: 12})
+	if lpc >= fixed {
+		t.Errorf("LPC encoded %d bytes, fixed encoded %d bytes", lpc, fixed)
+	}
+	if fixedOnly := size(EncoderOptions{Level: 8, FixedOnly: true}); fixedOnly <= size(EncoderOptions{Level: 8}) {
+		t.Errorf("FixedOnly encoded %d bytes, expected more than level 8", fixedOnly)
+	}
+	for _, level := range []int{0, 3, 5, 8} {
+		def := size(EncoderOptions{Level: level})
+		if ex := size(EncoderOptions{Level: level, Exhaustive: true}); ex > def {
+			t.Errorf("level %d: Exhaustive encoded %d bytes, expected at most %d", level, ex, def)
+		}
+	}
+
+	windows := []Window{{Shape: WindowTukey, P: 0.25}, {Shape: WindowHann}, {Shape: WindowRectangle}}
+	all := size(EncoderOptions{Windows: windows})
+	for _, w := range windows {
+		if one := size(EncoderOptions{Windows: []Window{w}}); one < all {
+			t.Errorf("%+v encoded %d bytes, all windows encoded %d bytes", w, one, all)
+		}
+	}
+
+	for _, opts := range []EncoderOptions{
+		{FixedOnly: true, MaxLPCOrder: 8},
+		{Windows: []Window{{Shape: WindowTukey, P: 1.5}}},
+		{Windows: []Window{{Shape: WindowTukey, P: math.NaN()}}},
+		{Windows: []Window{{Shape: 3}}},
+	} {
+		if _, err := NewEncoderOpts(ioutil.Discard, MetaData{StreamInfo: info}, opts); err == nil {
+			t.Errorf("%+v: expected an error", opts)
+		}
+	}
+
+	for _, order := range []int{-1, 33} {
+		_, err := NewEncoderOpts(ioutil.Discard, MetaData{StreamInfo: info}, EncoderOptions{MaxLPCOrder: order})
+		if err == nil {
+			t.Errorf("MaxLPCOrder %d: expected an error", order)
+		}
+	}
+}
+
+func TestRiceSearch(t *testing.T) {
+	seed := uint32(1)
+	for n := 1; n < 5000; n *= 3 {
+		for _, scale := range []uint{0, 4, 12, 31} {
+			u := make([]uint32, n)
+			var sum uint64
+			for i := range u {
+				seed = seed*1664525 + 1013904223
+				u[i] = seed >> (31 - scale) >> 1
+				sum += uint64(u[i])
+			}
+			k, bits := riceParamExhaustive(u, sum)
+			for j := uint(0); j <= maxRiceParam; j++ {
+				b := n * int(j+1)
+				for _, v := range u {
+					b += int(v >> j)
+				}
+				if b < bits {
+					t.Errorf("n=%d, scale=%d: parameter %d codes %d bits, parameter %d codes %d bits", n, scale, j, b, k, bits)
+				}
+			}
+			if _, est := riceParam(u, sum); est < bits {
+				t.Errorf("n=%d, scale=%d: estimate codes %d bits, exhaustive codes %d bits", n, scale, est, bits)
+			}
+		}
+	}
+
+	_, err := NewEncoderOpts(ioutil.Discard, MetaData{StreamInfo: &StreamInfo{SampleRate: 44100, NChannels: 1, BitsPerSample: 16}}, EncoderOptions{RiceSearch: 2})
+	if err == nil {
+		t.Errorf("RiceSearch 2: expected an error")
+	}
+}
+
+func TestLevels(t *testing.T) {
+	for i := 1; i < len(levels); i++ {
+		if reflect.DeepEqual(levels[i], levels[i-1]) {
+			t.Errorf("Levels %d and %d are the same", i-1, i)
+		}
+	}
+	data := testSignal(2, 50000, 16)
+	if l8, l7 := len(encodeFile(t, data, EncoderOptions{Level: 8})), len(encodeFile(t, data, EncoderOptions{Level: 7})); l8 > l7 {
+		t.Errorf("Level 8 encoded %d bytes, level 7 encoded %d bytes", l8, l7)
+	}
+}
+
+func TestEncodeStereo(t *testing.T) {
+	tests := []struct {
+		name  string
+		right func(l, noise int32) int32
+	}{
+		{"identical", func(l, noise int32) int32 { return l }},
+		{"close", func(l, noise int32) int32 { return l + noise }},
+		{"inverted", func(l, noise int32) int32 { return -l }},
+		{"quiet", func(l, noise int32) int32 { return noise }},
+		{"half", func(l, noise int32) int32 { return l/2 + noise }},
+	}
+	info := &StreamInfo{SampleRate: 44100, NChannels: 2, BitsPerSample: 16}
+	for _, test := range tests {
+		left := testSignal(1, 10000, 16)[0]
+		right := make([]int32, len(left))
+		seed := uint32(1)
+		for i, l := range left {
+			seed = seed*1664525 + 1013904223
+			right[i] = test.right(l, int32(seed)>>28)
+		}
+		data := [][]int32{left, right}
+
+		// Levels 0 and 3 code the channels independently, levels 1 and 4
+		// choose between that and mid-side, and level 2 tries every assignment.
+		var sizes [5]int
+		for i, level := range []int{0, 1, 2, 3, 4} {
+			var buf bytes.Buffer
+			e, err := NewEncoderOpts(&buf, MetaData{StreamInfo: info}, EncoderOptions{Level: level})
+			if err != nil {
+				t.Fatalf("%s: NewEncoderOpts failed: %v", test.name, err)
+			}
+			if err := e.Write(data); err != nil {
+				t.Fatalf("%s: Write failed: %v", test.name, err)
+			}
+			if err := e.Close(); err != nil {
+				t.Fatalf("%s: Close failed: %v", test.name, err)
+			}
+			got, _, err := decodeAll(buf.Bytes())
+			if err != nil {
+				t.Fatalf("%s: level %d: decoding failed: %v", test.name, level, err)
+			}
+			if !reflect.DeepEqual(got, data) {
+				t.Errorf("%s: level %d: decoded audio differs", test.name, level)
+			}
+			sizes[i] = buf.Len()
+
+			d, err := NewDecoder(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for {
+				h, err := d.PeekFrameHeader()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				switch a := h.ChannelAssignment; {
+				case (level == 0 || level == 3) && a != 1,
+					(level == 1 || level == 4) && a != 1 && a != 10:
+					t.Errorf("%s: level %d: channel assignment %d", test.name, level, a)
+				}
+				if _, err := d.Next(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if sizes[1] > sizes[0] || sizes[2] > sizes[1] {
+			t.Errorf("%s: full stereo search %d bytes, mid-side %d bytes, independent %d bytes", test.name, sizes[2], sizes[1], sizes[0])
+		}
+	}
+}
+
+func TestEncodeSeekTable(t *testing.T) {
+	tests := []struct {
+		opts     EncoderOptions
+		interval int64
+	}{
+		{EncoderOptions{SeekInterval: 10000}, 10000},
+		{EncoderOptions{SeekSeconds: 0.25}, 11025},
+		{EncoderOptions{SeekInterval: 1000}, 1000},
+	}
+	for _, test := range tests {
+		f, err := ioutil.TempFile("", "flac-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		info := &StreamInfo{SampleRate: 44100, NChannels: 2, BitsPerSample: 16, TotalSamples: 50000}
+		e, err := NewEncoderOpts(f, MetaData{StreamInfo: info}, test.opts)
+		if err != nil {
+			t.Fatalf("%+v: NewEncoderOpts failed: %v", test.opts, err)
+		}
+		if err := e.Write(testSignal(2, 50000, 16)); err != nil {
+			t.Fatalf("%+v: Write failed: %v", test.opts, err)
+		}
+		if err := e.Close(); err != nil {
+			t.Fatalf("%+v: Close failed: %v", test.opts, err)
+		}
+
+		if _, err := f.Seek(0, 0); err != nil {
+			t.Fatal(err)
+		}
+		_, want, err := ScanStream(f, test.interval)
+		if err != nil {
+			t.Fatalf("%+v: ScanStream failed: %v", test.opts, err)
+		}
+		if _, err := f.Seek(0, 0); err != nil {
+			t.Fatal(err)
+		}
+		d, err := NewDecoder(f)
+		if err != nil {
+			t.Fatalf("%+v: NewDecoder failed: %v", test.opts, err)
+		}
+		got := d.SeekTable
+		if n := int((50000 + test.interval - 1) / test.interval); len(got) != n {
+			t.Errorf("%+v: got %d seek points, want %d", test.opts, len(got), n)
+		}
+		for len(got) > 0 && got[len(got)-1].Sample == PlaceholderPoint {
+			got = got[:len(got)-1]
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%+v: got seek points %+v, want %+v", test.opts, got, want)
+		}
+	}
+
+	info := &StreamInfo{SampleRate: 44100, NChannels: 2, BitsPerSample: 16, TotalSamples: 50000}
+	if _, err := NewEncoderOpts(&bytes.Buffer{}, MetaData{StreamInfo: info}, EncoderOptions{SeekInterval: 100}); err == nil {
+		t.Errorf("Not seekable: expected an error")
+	}
+	f, err := ioutil.TempFile("", "flac-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	for _, opts := range []EncoderOptions{
+		{SeekInterval: 100, SeekSeconds: 1},
+		{SeekInterval: -1},
+		{SeekSeconds: math.NaN()},
+	} {
+		if _, err := NewEncoderOpts(f, MetaData{StreamInfo: info}, opts); err == nil {
+			t.Errorf("%+v: expected an error", opts)
+		}
+	}
+	info.TotalSamples = 0
+	if _, err := NewEncoderOpts(f, MetaData{StreamInfo: info}, EncoderOptions{SeekInterval: 100}); err == nil {
+		t.Errorf("No TotalSamples: expected an error")
+	}
+}
+
+func TestEncodeVorbisComment(t *testing.T) {
+	info := &StreamInfo{SampleRate: 44100, NChannels: 1, BitsPerSample: 16}
+	meta := MetaData{StreamInfo: info, VorbisComment: &VorbisComment{Vendor: "meta", Comments: []string{"TITLE=meta"}}}
+	tests := []struct {
+		opts EncoderOptions
+		want *VorbisComment
+	}{
+		{EncoderOptions{}, meta.VorbisComment},
+		{
+			EncoderOptions{VorbisComment: &VorbisComment{Vendor: "opts", Comments: []string{"TITLE=opts", "ARTIST=x"}}},
+			&VorbisComment{Vendor: "opts", Comments: []string{"TITLE=opts", "ARTIST=x"}},
+		},
+		{
+			EncoderOptions{VorbisComment: &VorbisComment{Comments: []string{"TITLE=opts"}}},
+			&VorbisComment{Vendor: vendor, Comments: []string{"TITLE=opts"}},
+		},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		e, err := NewEncoderOpts(&buf, meta, test.opts)
+		if err != nil {
+			t.Fatalf("%+v: NewEncoderOpts failed: %v", test.opts, err)
+		}
+		if err := e.Write(testSignal(1, 1000, 16)); err != nil {
+			t.Fatalf("%+v: Write failed: %v", test.opts, err)
+		}
+		if err := e.Close(); err != nil {
+			t.Fatalf("%+v: Close failed: %v", test.opts, err)
+		}
+		_, got, err := decodeAll(buf.Bytes())
+		if err != nil {
+			t.Fatalf("%+v: decoding failed: %v", test.opts, err)
+		}
+		if !reflect.DeepEqual(got.VorbisComment, test.want) {
+			t.Errorf("%+v: got %+v, want %+v", test.opts, got.VorbisComment, test.want)
+		}
+	}
+
+	opts := EncoderOptions{VorbisComment: &VorbisComment{Comments: []string{"NO EQUALS"}}}
+	if _, err := NewEncoderOpts(ioutil.Discard, meta, opts); err == nil {
+		t.Errorf("Invalid comment: expected an error")
+	}
+}
+
+func TestEncodeOthers(t *testing.T) {
+	c, err := ParseCueSheet(strings.NewReader("FILE \"a.flac\" WAVE\n  TRACK 01 AUDIO\n    INDEX 01 00:00:00\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := testSignal(1, 5000, 16)
+	encode := func(meta MetaData, opts EncoderOptions) MetaData {
+		var buf bytes.Buffer
+		e, err := NewEncoderOpts(&buf, meta, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+		_, got, err := decodeAll(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	info := &StreamInfo{SampleRate: 44100, NChannels: 1, BitsPerSample: 16, TotalSamples: 5000}
+	unknown := RawBlock{Type: 100, Data: []byte("unknown")}
+	meta := encode(MetaData{StreamInfo: info, Others: []RawBlock{unknown}}, EncoderOptions{CueSheet: c})
+	if len(meta.Others) != 2 || !reflect.DeepEqual(meta.Others[0], unknown) || meta.Others[1].Type != int(BlockCueSheet) {
+		t.Fatalf("Got Others %+v, want the unknown block and a CUESHEET", meta.Others)
+	}
+
+	// Re-encoding passes the blocks through.
+	if got := encode(meta, EncoderOptions{}); !reflect.DeepEqual(got.Others, meta.Others) {
+		t.Errorf("Re-encoded Others %+v, want %+v", got.Others, meta.Others)
+	}
+	// A new cue sheet replaces the old one.
+	c.Catalog = "0123456789012"
+	got := encode(meta, EncoderOptions{CueSheet: c})
+	if len(got.Others) != 2 || !reflect.DeepEqual(got.Others[0], unknown) || reflect.DeepEqual(got.Others[1], meta.Others[1]) {
+		t.Errorf("Got Others %+v, want the unknown block and a new CUESHEET", got.Others)
+	}
+
+	for _, typ := range []int{0, 127, -1} {
+		var buf bytes.Buffer
+		meta := MetaData{StreamInfo: info, Others: []RawBlock{{Type: typ}}}
+		if _, err := NewEncoder(&buf, meta); err == nil {
+			t.Errorf("Expected an error for block type %d", typ)
+		}
+	}
+}
+
+func TestEncodePadding(t *testing.T) {
+	tests := []struct {
+		padding, want int
+	}{
+		{0, DefaultPadding},
+		{1, 1},
+		{100000, 100000},
+		{-1, -1},
+	}
+	info := &StreamInfo{SampleRate: 44100, NChannels: 1, BitsPerSample: 16}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		e, err := NewEncoderOpts(&buf, MetaData{StreamInfo: info}, EncoderOptions{Padding: test.padding})
+		if err != nil {
+			t.Fatalf("Padding %d: NewEncoderOpts failed: %v", test.padding, err)
+		}
+		if err := e.Write(testSignal(1, 1000, 16)); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := decodeAll(buf.Bytes()); err != nil {
+			t.Fatalf("Padding %d: decoding failed: %v", test.padding, err)
+		}
+		r := bytes.NewReader(buf.Bytes()[len(magic):])
+		got := -1
+		for {
+			block, err := readRawMetaDataBlock(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if BlockType(block[0]&0x7F) == BlockPadding {
+				got = len(block) - 4
+			}
+			if block[0]&0x80 != 0 {
+				break
+			}
+		}
+		if got != test.want {
+			t.Errorf("Padding %d: got %d bytes of padding, want %d", test.padding, got, test.want)
+		}
+	}
+
+	_, err := NewEncoderOpts(ioutil.Discard, MetaData{StreamInfo: info}, EncoderOptions{Padding: 1 << 24})
+	if err == nil {
+		t.Errorf("Padding %d: expected an error", 1<<24)
+	}
+}
+
+func TestEncodeVerify(t *testing.T) {
+	info := &StreamInfo{SampleRate: 44100, NChannels: 1, BitsPerSample: 16}
+	data := testSignal(1, 10000, 16)
+	// Frame 1 is a CONSTANT subframe of a value that does not fit in 16 bits.
+	for i := 4096; i < 8192; i++ {
+		data[0][i] = 1 << 20
+	}
+	for _, verify := range []bool{false, true} {
+		var buf bytes.Buffer
+		e, err := NewEncoderOpts(&buf, MetaData{StreamInfo: info}, EncoderOptions{BlockSize: 4096, Verify: verify})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = e.Write(data)
+		if err == nil {
+			err = e.Close()
+		}
+		if verify && (err == nil || !strings.HasPrefix(err.Error(), "Verify failed: frame 1: channel 0, sample 0:")) {
+			t.Errorf("Verify: got error %v, want a mismatch at frame 1, channel 0, sample 0", err)
+		}
+		if !verify && err != nil {
+			t.Errorf("No verify: got error %v", err)
+		}
+	}
+}
+
+func TestEncodeFlush(t *testing.T) {
+	data := testSignal(2, 10000, 16)
+	for _, variable := range []bool{false, true} {
+		info := &StreamInfo{SampleRate: 44100, NChannels: 2, BitsPerSample: 16}
+		var out bytes.Buffer
+		w := bufio.NewWriter(&out)
+		e, err := NewEncoderOpts(w, MetaData{StreamInfo: info}, EncoderOptions{BlockSize: 1024, VariableBlockSize: variable})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Feed the audio in pieces, as from a capture device, flushing each.
+		for i := 0; i < len(data[0]); i += 1500 {
+			end := i + 1500
+			if end > len(data[0]) {
+				end = len(data[0])
+			}
+			if err := e.Write([][]int32{data[0][i:end], data[1][i:end]}); err != nil {
+				t.Fatalf("Variable %t: Write failed: %v", variable, err)
+			}
+			if err := e.Flush(); err != nil {
+				t.Fatalf("Variable %t: Flush failed: %v", variable, err)
+			}
+			if w.Buffered() != 0 {
+				t.Errorf("Variable %t: %d bytes buffered after Flush", variable, w.Buffered())
+			}
+		}
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		d, err := NewDecoder(bytes.NewReader(out.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sizes []int
+		got := make([][]int32, 2)
+		for {
+			h, err := d.PeekFrameHeader()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("Variable %t: PeekFrameHeader failed: %v", variable, err)
+			}
+			if h.VariableSize != variable {
+				t.Errorf("Variable %t: got a frame with VariableSize %t", variable, h.VariableSize)
+			}
+			frame, err := d.NextSamples()
+			if err != nil {
+				t.Fatalf("Variable %t: NextSamples failed: %v", variable, err)
+			}
+			sizes = append(sizes, len(frame[0]))
+			for ch := range got {
+				got[ch] = append(got[ch], frame[ch]...)
+			}
+		}
+		if !reflect.DeepEqual(got, data) {
+			t.Errorf("Variable %t: decoded audio differs", variable)
+		}
+		// Each 1500-sample piece is a 1024-sample frame and, when flushed, a 476-sample frame.
+		want := []int{1024, 1024, 1024, 1024, 1024, 1024, 1024, 1024, 1024, 784}
+		if variable {
+			want = []int{1024, 476, 1024, 476, 1024, 476, 1024, 476, 1024, 476, 1024, 476, 1000}
+		}
+		if !reflect.DeepEqual(sizes, want) {
+			t.Errorf("Variable %t: got frame sizes %v, want %v", variable, sizes, want)
+		}
+	}
+}
+
+func TestEncodeAdaptiveBlockSize(t *testing.T) {
+	// A sine broken by a burst of noise near the end of each 4096-sample block.
+	data := make([][]int32, 2)
+	seed := uint32(1)
+	for ch := range data {
+		data[ch] = make([]int32, 8*4096)
+		for i := range data[ch] {
+			data[ch][i] = int32(math.Sin(float64(i)/10) * 8000)
+			if i%4096 >= 3584 {
+				seed = seed*1664525 + 1013904223
+				data[ch][i] = int32(seed) >> 17
+			}
+		}
+	}
+	fixed := encodeFile(t, data, EncoderOptions{Level: 5})
+	adaptive := encodeFile(t, data, EncoderOptions{Level: 5, AdaptiveBlockSize: true})
+	if len(adaptive) >= len(fixed) {
+		t.Errorf("Adaptive block size: got %d bytes, want fewer than the %d of a fixed block size", len(adaptive), len(fixed))
+	}
+	checkRemux(t, "Adaptive block size", adaptive, data)
+
+	d, err := NewDecoder(bytes.NewReader(adaptive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The noise codes best in the smallest blocks and the sine in larger ones.
+	if d.MinBlock != 512 || d.MaxBlock <= 512 {
+		t.Errorf("Got block sizes %d to %d, want 512 to more", d.MinBlock, d.MaxBlock)
+	}
+	for {
+		h, err := d.PeekFrameHeader()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if !h.VariableSize {
+			t.Errorf("Got a frame without VariableSize")
+		}
+		if _, err := d.NextSamples(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Blocks are split the same with workers.
+	if got := encodeFile(t, data, EncoderOptions{Level: 5, AdaptiveBlockSize: true, Workers: 4}); !bytes.Equal(got, adaptive) {
+		t.Errorf("Adaptive block size with workers encoded a different stream")
+	}
+}
+
+func TestEncodeStream(t *testing.T) {
+	data := testSignal(2, 10000, 16)
+	// An os.Pipe is an io.WriteSeeker, but seeking fails.
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+	errc := make(chan error, 1)
+	go func() {
+		defer pw.Close()
+		info := &StreamInfo{SampleRate: 44100, NChannels: 2, BitsPerSample: 16}
+		e, err := NewEncoderOpts(pw, MetaData{StreamInfo: info}, EncoderOptions{VariableBlockSize: true})
+		if err == nil {
+			err = e.Write(data)
+		}
+		if err == nil {
+			err = e.Close()
+		}
+		errc <- err
+	}()
+	stream, err := ioutil.ReadAll(pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("Encoding to a pipe failed: %v", err)
+	}
+	got, meta, err := decodeAll(stream)
+	if err != nil {
+		t.Fatalf("Decoding failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, data) {
+		t.Errorf("Decoded audio differs")
+	}
+	want := StreamInfo{SampleRate: 44100, NChannels: 2, BitsPerSample: 16, MinBlock: 16, MaxBlock: 1152}
+	if *meta.StreamInfo != want {
+		t.Errorf("Got STREAMINFO %+v, want %+v", *meta.StreamInfo, want)
+	}
+
+	// Seekable variable block size streams get the true block sizes.
+	f, err := ioutil.TempFile("", "flac-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	info := &StreamInfo{SampleRate: 44100, NChannels: 2, BitsPerSample: 16}
+	e, err := NewEncoderOpts(f, MetaData{StreamInfo: info}, EncoderOptions{VariableBlockSize: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{1000, 10, 2000, 5000} {
+		if err := e.Write([][]int32{data[0][:n], data[1][:n]}); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	scanned, _, err := ScanStream(f, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDecoder(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *d.StreamInfo != *scanned {
+		t.Errorf("Got STREAMINFO %+v, want %+v", *d.StreamInfo, *scanned)
+	}
+
+	// All frames are shorter than the block size.
+	if err := f.Truncate(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if e, err = NewEncoderOpts(f, MetaData{StreamInfo: info}, EncoderOptions{VariableBlockSize: true}); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{500, 700, 300} {
+		if err := e.Write([][]int32{data[0][:n], data[1][:n]}); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if scanned, _, err = ScanStream(f, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if d, err = NewDecoder(f); err != nil {
+		t.Fatal(err)
+	}
+	if *d.StreamInfo != *scanned || d.MaxBlock != 700 {
+		t.Errorf("Got STREAMINFO %+v, want %+v", *d.StreamInfo, *scanned)
+	}
+
+	// Without seeking, a wrong TotalSamples cannot be fixed.
+	info = &StreamInfo{SampleRate: 44100, NChannels: 2, BitsPerSample: 16, TotalSamples: 20000}
+	if e, err = NewEncoder(ioutil.Discard, MetaData{StreamInfo: info}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err == nil {
+		t.Errorf("Wrong TotalSamples: expected an error")
+	}
+}
+
+func TestEncodeSampleRates(t *testing.T) {
+	tests := []struct {
+		rate, code int
+		subset     bool
+	}{
+		{44100, 9, true},
+		{192000, 3, true},
+		{22000, 12, true},
+		{11111, 13, true},
+		{100010, 14, true},
+		{700001, 0, false},
+	}
+	for _, test := range tests {
+		info := &StreamInfo{SampleRate: test.rate, NChannels: 1, BitsPerSample: 16}
+		var buf bytes.Buffer
+		e, err := NewEncoderOpts(&buf, MetaData{StreamInfo: info}, EncoderOptions{Padding: -1})
+		if err != nil {
+			t.Fatalf("%d Hz: NewEncoderOpts failed: %v", test.rate, err)
+		}
+		if err := e.Write(testSignal(1, 3000, 16)); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+		// The first frame follows the 4-byte magic and the 38-byte STREAMINFO block.
+		if code := int(buf.Bytes()[len(magic)+38+2] & 0xF); code != test.code {
+			t.Errorf("%d Hz: got sample rate code %d, want %d", test.rate, code, test.code)
+		}
+		d, err := NewDecoder(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h, err := d.PeekFrameHeader(); err != nil || h.SampleRate != test.rate {
+			t.Errorf("%d Hz: got frame header %+v, %v", test.rate, h, err)
+		}
+
+		_, err = NewEncoderOpts(ioutil.Discard, MetaData{StreamInfo: info}, EncoderOptions{Subset: true})
+		if test.subset && err != nil {
+			t.Errorf("%d Hz: Subset: got error %v", test.rate, err)
+		} else if !test.subset && err == nil {
+			t.Errorf("%d Hz: Subset: expected an error", test.rate)
+		}
+	}
+}
+
+func TestEncodeSubset(t *testing.T) {
+	tests := []struct {
+		rate   int
+		opts   EncoderOptions
+		subset bool
+	}{
+		{44100, EncoderOptions{Level: 8}, true},
+		{44100, EncoderOptions{BlockSize: 4608}, true},
+		{44100, EncoderOptions{BlockSize: 4609}, false},
+		{48000, EncoderOptions{MaxLPCOrder: 12}, true},
+		{48000, EncoderOptions{MaxLPCOrder: 13}, false},
+		{96000, EncoderOptions{BlockSize: 16384, MaxLPCOrder: 32}, true},
+		{96000, EncoderOptions{BlockSize: 16385}, false},
+	}
+	for _, test := range tests {
+		info := &StreamInfo{SampleRate: test.rate, NChannels: 2, BitsPerSample: 16}
+		_, err := NewEncoderOpts(ioutil.Discard, MetaData{StreamInfo: info}, test.opts)
+		if err != nil {
+			t.Errorf("%d Hz, %+v: got error %v", test.rate, test.opts, err)
+		}
+		test.opts.Subset = true
+		_, err = NewEncoderOpts(ioutil.Discard, MetaData{StreamInfo: info}, test.opts)
+		if test.subset && err != nil {
+			t.Errorf("%d Hz, %+v: got error %v", test.rate, test.opts, err)
+		} else if !test.subset && err == nil {
+			t.Errorf("%d Hz, %+v: expected an error", test.rate, test.opts)
+		}
+	}
+	for level := range levels {
+		info := &StreamInfo{SampleRate: 44100, NChannels: 2, BitsPerSample: 16}
+		if _, err := NewEncoderOpts(ioutil.Discard, MetaData{StreamInfo: info}, EncoderOptions{Level: level, Subset: true}); err != nil {
+			t.Errorf("Level %d: got error %v", level, err)
+		}
+	}
+}
+
+func TestEncodeWorkers(t *testing.T) {
+	data := testSignal(2, 100000, 16)
+	encode := func(opts EncoderOptions) []byte {
+		f, err := ioutil.TempFile("", "flac-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		info := &StreamInfo{SampleRate: 44100, NChannels: 2, BitsPerSample: 16, TotalSamples: 100000}
+		e, err := NewEncoderOpts(f, MetaData{StreamInfo: info}, opts)
+		if err != nil {
+			t.Fatalf("%+v: NewEncoderOpts failed: %v", opts, err)
+		}
+		for i := 0; i < len(data[0]); i += 3000 {
+			end := i + 3000
+			if end > len(data[0]) {
+				end = len(data[0])
+			}
+			if err := e.Write([][]int32{data[0][i:end], data[1][i:end]}); err != nil {
+				t.Fatalf("%+v: Write failed: %v", opts, err)
+			}
+			if i%30000 == 0 {
+				if err := e.Flush(); err != nil {
+					t.Fatalf("%+v: Flush failed: %v", opts, err)
+				}
+			}
+		}
+		if err := e.Close(); err != nil {
+			t.Fatalf("%+v: Close failed: %v", opts, err)
+		}
+		if _, err := f.Seek(0, 0); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	for _, variable := range []bool{false, true} {
+		opts := EncoderOptions{Level: 8, VariableBlockSize: variable, SeekInterval: 10000}
+		want := encode(opts)
+		opts.Workers = 3
+		if got := encode(opts); !bytes.Equal(got, want) {
+			t.Errorf("Variable %t: encoding with workers differs", variable)
+		}
+	}
+	info := &StreamInfo{SampleRate: 44100, NChannels: 2, BitsPerSample: 16}
+	if _, err := NewEncoderOpts(ioutil.Discard, MetaData{StreamInfo: info}, EncoderOptions{Workers: -1}); err == nil {
+		t.Errorf("Workers -1: expected an error")
+	}
+}
+
+func TestEncodeBitDepths(t *testing.T) {
+	for _, bps := range []int{8, 12, 16, 20, 24, 32} {
+		data := testSignal(2, 10000, bps)
+		// Extremes overflow the residuals of 32-bit samples.
+		max, min := int32(1<<uint(bps-1)-1), int32(-1<<uint(bps-1))
+		for i := 5000; i < 5100; i++ {
+			data[0][i], data[1][i] = max, min
+			if i%2 == 0 {
+				data[0][i], data[1][i] = min, max
+			}
+		}
+		for _, level := range []int{0, 5, 8} {
+			f, err := ioutil.TempFile("", "flac-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(f.Name())
+			defer f.Close()
+			info := &StreamInfo{SampleRate: 44100, NChannels: 2, BitsPerSample: bps}
+			opts := EncoderOptions{Level: level, Verify: true, Padding: -1}
+			e, err := NewEncoderOpts(f, MetaData{StreamInfo: info}, opts)
+			if err != nil {
+				t.Fatalf("%d bits, level %d: %v", bps, level, err)
+			}
+			if err := e.Write(data); err != nil {
+				t.Fatalf("%d bits, level %d: %v", bps, level, err)
+			}
+			if err := e.Close(); err != nil {
+				t.Fatalf("%d bits, level %d: %v", bps, level, err)
+			}
+			if _, err := f.Seek(0, 0); err != nil {
+				t.Fatal(err)
+			}
+			stream, err := ioutil.ReadAll(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The MD5 checksum packs each sample in the fewest whole bytes.
+			width := (bps + 7) / 8
+			var pcm []byte
+			for i := range data[0] {
+				for ch := range data {
+					for k := 0; k < width; k++ {
+						pcm = append(pcm, byte(data[ch][i]>>uint(8*k)))
+					}
+				}
+			}
+			blocks := rawBlocks(t, stream)
+			var meta MetaData
+			if _, _, err := readMetaDataBlock(bytes.NewReader(blocks[0]), &meta); err != nil {
+				t.Fatal(err)
+			}
+			if meta.MD5 != md5.Sum(pcm) {
+				t.Errorf("%d bits, level %d: bad MD5 checksum", bps, level)
+			}
+			// Decode verifies the MD5 checksum of its output.
+			got, _, err := Decode(bytes.NewReader(stream))
+			if err != nil {
+				t.Errorf("%d bits, level %d: Decode failed: %v", bps, level, err)
+			} else if !bytes.Equal(got, pcm) {
+				t.Errorf("%d bits, level %d: decoded samples do not match", bps, level)
+			}
+			h, err := readFrameHeader(bytes.NewReader(stream[len(magic)+len(blocks[0]):]), &StreamInfo{})
+			if err != nil {
+				t.Errorf("%d bits, level %d: %v", bps, level, err)
+			} else if h.sampleSize != bps {
+				t.Errorf("%d bits, level %d: frame header gives %d bits", bps, level, h.sampleSize)
+			}
+		}
+	}
+
+	// PCM samples that do not fill their bytes are in the high bits.
+	samples := make([][]int32, 1)
+	deinterleave(samples, []byte{0xF0, 0x7F, 0x00, 0x80, 0x10, 0x00}, PCMFormat{NChannels: 1, BitsPerSample: 12})
+	if want := []int32{0x7FF, -0x800, 1}; !reflect.DeepEqual(samples[0], want) {
+		t.Errorf("Got 12-bit samples %v, want %v", samples[0], want)
+	}
+}
+
+func TestEncodeWastedBits(t *testing.T) {
+	data16 := testSignal(2, 20000, 16)
+	data24 := make([][]int32, len(data16))
+	for ch := range data16 {
+		for _, s := range data16[ch] {
+			data24[ch] = append(data24[ch], s<<8)
+		}
+	}
+	encode := func(data [][]int32, bps int) []byte {
+		var buf bytes.Buffer
+		info := &StreamInfo{SampleRate: 44100, NChannels: 2, BitsPerSample: bps}
+		e, err := NewEncoderOpts(&buf, MetaData{StreamInfo: info}, EncoderOptions{Level: 5, Padding: -1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	stream16, stream24 := encode(data16, 16), encode(data24, 24)
+	got, _, err := decodeAll(stream24)
+	if err != nil {
+		t.Fatalf("Decoding failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, data24) {
+		t.Errorf("Decoded audio differs")
+	}
+	// Each subframe codes its 8 wasted bits in 8 more bits.
+	if len(stream24) > len(stream16)+1000 {
+		t.Errorf("Got %d bytes with wasted bits, %d bytes without", len(stream24), len(stream16))
+	}
+}
+
+func TestRiceEscape(t *testing.T) {
+	// Small residuals with rare large ones, which Rice codes well,
+	// followed by loud noise, which is cheaper to escape.
+	residual := make([]int32, 4096)
+	seed := uint32(1)
+	for i := range residual {
+		seed = seed*1664525 + 1013904223
+		switch {
+		case i >= len(residual)/2:
+			residual[i] = int32(seed) >> 16
+		case i%64 == 0:
+			residual[i] = 1000
+		default:
+			residual[i] = int32(seed) >> 30
+		}
+	}
+	for _, search := range []func([]uint32, uint64) (uint, int){riceParam, riceParamExhaustive} {
+		e := &Encoder{level: levels[8], riceParam: search}
+		plan := e.planRice(residual, 0, len(residual))
+		escaped := 0
+		for _, k := range plan.params {
+			if k == riceEscape {
+				escaped++
+			}
+		}
+		if escaped == 0 || escaped == len(plan.params) {
+			t.Errorf("Escaped %d of %d partitions", escaped, len(plan.params))
+		}
+		var bw bitWriter
+		plan.write(&bw, residual)
+		if bw.len() != plan.bits {
+			t.Errorf("Wrote %d bits, planned %d", bw.len(), plan.bits)
+		}
+		bw.align()
+		got, err := decodeResiduals(bit.NewReader(bytes.NewReader(bw.buf)), len(residual), 0)
+		if err != nil {
+			t.Errorf("Decoding failed: %v", err)
+		} else if !reflect.DeepEqual(got, residual) {
+			t.Errorf("Decoded residuals differ")
+		}
+	}
+}
+
+func TestPartitionOrder(t *testing.T) {
+	// Noise whose loudness changes every 512 samples.
+	residual := make([]int32, 4100)
+	seed := uint32(1)
+	for i := range residual {
+		seed = seed*1664525 + 1013904223
+		residual[i] = int32(seed) >> uint(16+i/512)
+	}
+	tests := []struct {
+		min, max, blockSize, predOrder int
+		want                           uint
+	}{
+		{0, 0, 4096, 0, 0},
+		{0, 8, 4096, 0, 3},
+		{3, 3, 4096, 0, 3},
+		{6, 8, 4096, 0, 6},
+		// Orders that do not divide the block are skipped.
+		{0, 8, 4095, 0, 0},
+		{4, 8, 4100, 0, 2},
+		// As are those with partitions shorter than the warm-up.
+		{8, 8, 4096, 32, 7},
+	}
+	for _, test := range tests {
+		e := &Encoder{riceParam: riceParam}
+		e.level.minPartitionOrder, e.level.maxPartitionOrder = test.min, test.max
+		res := residual[:test.blockSize-test.predOrder]
+		plan := e.planRice(res, test.predOrder, test.blockSize)
+		if plan.order != test.want {
+			t.Errorf("Orders %d to %d, block size %d, warm-up %d: got order %d, want %d",
+				test.min, test.max, test.blockSize, test.predOrder, plan.order, test.want)
+		}
+		var bw bitWriter
+		plan.write(&bw, res)
+		if bw.len() != plan.bits {
+			t.Errorf("Orders %d to %d: wrote %d bits, planned %d", test.min, test.max, bw.len(), plan.bits)
+		}
+	}
+
+	info := &StreamInfo{SampleRate: 44100, NChannels: 1, BitsPerSample: 16}
+	for _, opts := range []EncoderOptions{
+		{MaxPartitionOrder: -1},
+		{MaxPartitionOrder: 16},
+		{MinPartitionOrder: -1},
+		{MinPartitionOrder: 6, MaxPartitionOrder: 5},
+		// Level 0 has a maximum order of 3.
+		{Level: 0, MinPartitionOrder: 4},
+	} {
+		if _, err := NewEncoderOpts(ioutil.Discard, MetaData{StreamInfo: info}, opts); err == nil {
+			t.Errorf("%+v: expected an error", opts)
+		}
+	}
+	data := testSignal(1, 20000, 16)
+	checkRemux(t, "Partition orders 2 to 10", encodeFile(t, data, EncoderOptions{MinPartitionOrder: 2, MaxPartitionOrder: 10}), data)
+}
+
+func TestEncodeProgress(t *testing.T) {
+	for _, workers := range []int{0, 3} {
+		var done []int64
+		var e *Encoder
+		opts := EncoderOptions{
+			BlockSize: 1000,
+			Workers:   workers,
+			Progress: func(samples, total int64) {
+				if total != 10500 {
+					t.Errorf("%d workers: got total %d, want 10500", workers, total)
+				}
+				done = append(done, samples)
+				s := e.Stats()
+				if s.Samples != samples || s.Frames != int64(len(done)) || s.BytesIn != 4*samples {
+					t.Errorf("%d workers: after %d samples, got stats %+v", workers, samples, s)
+				}
+			},
+		}
+		info := &StreamInfo{SampleRate: 44100, NChannels: 2, BitsPerSample: 16, TotalSamples: 10500}
+		var buf bytes.Buffer
+		var err error
+		e, err = NewEncoderOpts(&buf, MetaData{StreamInfo: info}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := e.Stats(); s.BytesOut != int64(buf.Len()) || s.Ratio() != 0 {
+			t.Errorf("%d workers: before writing, got stats %+v, ratio %g", workers, s, s.Ratio())
+		}
+		if err := e.Write(testSignal(2, 10500, 16)); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+		want := []int64{1000, 2000, 3000, 4000, 5000, 6000, 7000, 8000, 9000, 10000, 10500}
+		if !reflect.DeepEqual(done, want) {
+			t.Errorf("%d workers: got progress %v, want %v", workers, done, want)
+		}
+		s := e.Stats()
+		if s.BytesOut != int64(buf.Len()) || s.BytesIn != 42000 || s.Frames != 11 {
+			t.Errorf("%d workers: got stats %+v, want %d bytes out", workers, s, buf.Len())
+		}
+		if r := s.Ratio(); r != float64(buf.Len())/42000 {
+			t.Errorf("%d workers: got ratio %g", workers, r)
+		}
+	}
+}
+
+func TestEncodeStreamInfo(t *testing.T) {
+	f, err := ioutil.TempFile("", "flac-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	info := &StreamInfo{SampleRate: 48000, NChannels: 2, BitsPerSample: 16}
+	e, err := NewEncoder(f, MetaData{StreamInfo: info})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Write(testSignal(2, 5000, 16)); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	want, _, err := ScanStream(f, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDecoder(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *d.StreamInfo != *want {
+		t.Errorf("Got STREAMINFO %+v, want %+v", *d.StreamInfo, *want)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Decode(f); err != nil {
+		t.Errorf("Decode failed: %v", err)
+	}
+
+	// Without seeking, the MD5 is left unknown, and Decode does not check it.
+	var buf bytes.Buffer
+	if e, err = NewEncoder(&buf, MetaData{StreamInfo: info}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Write(testSignal(2, 5000, 16)); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_, meta, err := Decode(&buf)
+	if err != nil {
+		t.Errorf("Decode failed: %v", err)
+	} else if meta.MD5 != [16]byte{} {
+		t.Errorf("Got MD5 %x, want zeros", meta.MD5)
+	}
+}
+
+func encodeFile(t *testing.T, data [][]int32, opts EncoderOptions) []byte {
+	f, err := ioutil.TempFile("", "flac-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	info := &StreamInfo{SampleRate: 44100, NChannels: len(data), BitsPerSample: 16, TotalSamples: int64(len(data[0]))}
+	e, err := NewEncoderOpts(f, MetaData{StreamInfo: info}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	stream, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stream
+}
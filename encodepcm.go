@@ -0,0 +1,444 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+)
+
+// A PCMFormat describes raw interleaved PCM audio.
+// Each sample takes the fewest whole bytes that hold it;
+// samples of sizes that are not a multiple of 8 bits are in the high bits
+// of those bytes, as in WAV and AIFF files.
+type PCMFormat struct {
+	SampleRate, NChannels, BitsPerSample int
+	// BigEndian is whether samples are big-endian, as in AIFF files.
+	// Otherwise they are little-endian, as in WAV files.
+	BigEndian bool
+	// Unsigned is whether samples are unsigned, as are 8-bit samples
+	// in WAV files.  Otherwise they are two's complement.
+	Unsigned bool
+	// TotalSamples is the number of inter-channel samples, or 0 if unknown.
+	// If it is known, only that many samples are read.
+	TotalSamples int64
+	// Foreign holds the chunks of the WAV or AIFF file read by
+	// ReadAudioHeader, in the layout of MetaData.ForeignChunks: the file
+	// header, the chunks before the audio, and the header of the audio chunk.
+	// It is nil for raw PCM, or if a chunk is too large to keep
+	// in an APPLICATION block.  See EncoderOptions.KeepForeign.
+	Foreign [][]byte
+}
+
+// MaxForeignChunk is the size of the largest chunk body that fits in
+// an APPLICATION block, along with its ID, chunk header, and pad byte.
+const maxForeignChunk = 1<<24 - 1 - 4 - 8 - 1
+
+// EncodePCM encodes the PCM audio read from r, in the given format,
+// writing a FLAC stream to w.  It reads until the end of r,
+// or until TotalSamples if it is known.
+func EncodePCM(w io.Writer, r io.Reader, format PCMFormat) error {
+	return EncodePCMOpts(w, r, format, EncoderOptions{Level: DefaultLevel})
+}
+
+// EncodePCMOpts is like EncodePCM, but the encoding is controlled by opts.
+// If w is not an io.WriteSeeker, so that STREAMINFO cannot be updated once
+// the frames are written, but r is an io.Seeker, the audio is read twice,
+// encoding it with EncodeTwoPass.
+func EncodePCMOpts(w io.Writer, r io.Reader, format PCMFormat, opts EncoderOptions) error {
+	meta := MetaData{StreamInfo: &StreamInfo{
+		SampleRate:    format.SampleRate,
+		NChannels:     format.NChannels,
+		BitsPerSample: format.BitsPerSample,
+		TotalSamples:  format.TotalSamples,
+	}}
+	if opts.KeepForeign {
+		apps, err := foreignApplications(r, format)
+		if err != nil {
+			return err
+		}
+		meta.Applications = apps
+	}
+	if s, ok := r.(io.Seeker); ok && !canSeek(w) {
+		if start, err := s.Seek(0, 1); err == nil {
+			pass := 0
+			return EncodeTwoPass(w, meta, opts, func(e *Encoder) error {
+				if pass++; pass > 1 {
+					if _, err := s.Seek(start, 0); err != nil {
+						return err
+					}
+				}
+				return writePCM(e, r, format)
+			})
+		}
+	}
+	e, err := NewEncoderOpts(w, meta, opts)
+	if err != nil {
+		return err
+	}
+	if err := writePCM(e, r, format); err != nil {
+		return err
+	}
+	return e.Close()
+}
+
+// ForeignApplications returns the APPLICATION blocks keeping the foreign
+// chunks of format, followed by any chunks after the audio if r can seek
+// to them and back.
+func foreignApplications(r io.Reader, format PCMFormat) ([]Application, error) {
+	if len(format.Foreign) < 2 {
+		return nil, errors.New("KeepForeign without the foreign chunks of a WAV or AIFF file")
+	}
+	id, order := ForeignRIFF, binary.ByteOrder(binary.LittleEndian)
+	if string(format.Foreign[0][:4]) != "RIFF" {
+		id, order = ForeignAIFF, binary.BigEndian
+	}
+	chunks := format.Foreign
+	if s, ok := r.(io.ReadSeeker); ok {
+		trailing, err := readTrailingChunks(s, format.Foreign[len(format.Foreign)-1], order)
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks[:len(chunks):len(chunks)], trailing...)
+	}
+	apps := make([]Application, len(chunks))
+	for i, c := range chunks {
+		copy(apps[i].ID[:], id)
+		apps[i].Data = c
+	}
+	return apps, nil
+}
+
+// ReadTrailingChunks returns the chunks following the audio chunk, which
+// begins with the given kept chunk header, and which s is at the end of.
+// S is left where it was.  If s cannot seek, or the size of the audio
+// chunk is unknown, there are no trailing chunks.
+func readTrailingChunks(s io.ReadSeeker, audio []byte, order binary.ByteOrder) ([][]byte, error) {
+	size := order.Uint32(audio[4:])
+	if size == 0 || size == 0xFFFFFFFF {
+		return nil, nil
+	}
+	start, err := s.Seek(0, 1)
+	if err != nil {
+		return nil, nil
+	}
+	end := start + int64(size) + int64(size%2) - int64(len(audio)-8)
+	if _, err := s.Seek(end, 0); err != nil {
+		return nil, err
+	}
+	var chunks [][]byte
+	for {
+		id, size, err := readChunkHeader(s, order)
+		if err != nil {
+			break
+		}
+		if size > maxForeignChunk {
+			return nil, errors.New(id + " chunk is too large to keep")
+		}
+		b := make([]byte, size+size%2)
+		if _, err := io.ReadFull(s, b); err != nil {
+			return nil, errors.New("Failed to read the " + id + " chunk: " + err.Error())
+		}
+		chunks = append(chunks, foreignChunk(id, size, b[:size], order))
+	}
+	_, err = s.Seek(start, 0)
+	return chunks, err
+}
+
+// ForeignChunk returns a chunk as kept in an APPLICATION block:
+// its header, its body, and a pad byte if its size is odd.
+func foreignChunk(id string, size uint32, body []byte, order binary.ByteOrder) []byte {
+	c := append(chunkHeader(id, size, order), body...)
+	if size%2 == 1 {
+		c = append(c, 0)
+	}
+	return c
+}
+
+// ChunkHeader returns the header of a chunk with the given ID and size.
+func chunkHeader(id string, size uint32, order binary.ByteOrder) []byte {
+	h := make([]byte, 8)
+	copy(h, id)
+	order.PutUint32(h[4:], size)
+	return h
+}
+
+// CanSeek returns whether w is an io.WriteSeeker that can seek.
+func canSeek(w io.Writer) bool {
+	s, ok := w.(io.WriteSeeker)
+	if !ok {
+		return false
+	}
+	_, err := s.Seek(0, 1)
+	return err == nil
+}
+
+// WritePCM writes the PCM audio read from r, in the given format, to e.
+func writePCM(e *Encoder, r io.Reader, format PCMFormat) error {
+	size := format.NChannels * ((format.BitsPerSample + 7) / 8)
+	if format.TotalSamples > 0 {
+		r = io.LimitReader(r, format.TotalSamples*int64(size))
+	}
+	buf := make([]byte, e.level.blockSize*size)
+	samples := make([][]int32, format.NChannels)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF {
+			break
+		} else if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		if n%size != 0 {
+			return errors.New("PCM audio ends within a sample")
+		}
+		deinterleave(samples, buf[:n], format)
+		if err := e.Write(samples); err != nil {
+			return err
+		}
+		if n < len(buf) {
+			break
+		}
+	}
+	if e.samples+int64(len(e.buf[0])) < format.TotalSamples {
+		return errors.New("PCM audio is truncated")
+	}
+	return nil
+}
+
+// Deinterleave sets each channel of samples to its samples from data.
+func deinterleave(samples [][]int32, data []byte, format PCMFormat) {
+	width := (format.BitsPerSample + 7) / 8
+	stride := width * len(samples)
+	n := len(data) / stride
+	for c := range samples {
+		ch := samples[c][:0]
+		for j := 0; j < n; j++ {
+			b := data[j*stride+c*width : j*stride+(c+1)*width]
+			var v uint32
+			for k := range b {
+				if format.BigEndian {
+					v = v<<8 | uint32(b[k])
+				} else {
+					v |= uint32(b[k]) << uint(8*k)
+				}
+			}
+			if format.Unsigned {
+				v -= 1 << uint(8*width-1)
+			}
+			s := signExtend(uint64(v), uint(8*width))
+			ch = append(ch, s>>uint(8*width-format.BitsPerSample))
+		}
+		samples[c] = ch
+	}
+}
+
+// ReadAudioHeader reads the header of a WAV or AIFF file from r,
+// up to the start of its PCM audio, which can then be encoded
+// by passing r and the returned format to EncodePCM.
+// Chunks before the audio are skipped; r is read no further than needed,
+// so it may be a pipe.
+func ReadAudioHeader(r io.Reader) (PCMFormat, error) {
+	var h [12]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return PCMFormat{}, errors.New("Failed to read the audio header: " + err.Error())
+	}
+	f := PCMFormat{Foreign: [][]byte{h[:]}}
+	switch {
+	case string(h[0:4]) == "RIFF" && string(h[8:12]) == "WAVE":
+		return f, readWAVHeader(r, &f)
+	case string(h[0:4]) == "FORM" && (string(h[8:12]) == "AIFF" || string(h[8:12]) == "AIFC"):
+		f.BigEndian = true
+		return f, readAIFFHeader(r, &f, string(h[8:12]) == "AIFC")
+	}
+	return PCMFormat{}, errors.New("Unknown audio file format")
+}
+
+// ReadChunkHeader reads the ID and size of a WAV or AIFF chunk.
+func readChunkHeader(r io.Reader, order binary.ByteOrder) (string, uint32, error) {
+	var h [8]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		if err == io.EOF {
+			err = errors.New("no audio data")
+		}
+		return "", 0, errors.New("Failed to read a chunk header: " + err.Error())
+	}
+	return string(h[:4]), order.Uint32(h[4:]), nil
+}
+
+// ReadChunk returns the body of a chunk of the given size,
+// and its pad byte if the size is odd.
+func readChunk(r io.Reader, id string, size uint32) ([]byte, error) {
+	if size > 1<<20 {
+		return nil, errors.New(id + " chunk is too large: " + strconv.FormatUint(uint64(size), 10) + " bytes")
+	}
+	b := make([]byte, size+size%2)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, errors.New("Failed to read the " + id + " chunk: " + err.Error())
+	}
+	return b[:size], nil
+}
+
+// KeepChunk reads the body of a chunk of the given size, and its pad byte,
+// adding the chunk to the foreign chunks of f.  If it is too large to keep,
+// it is skipped and f keeps no foreign chunks.
+func keepChunk(r io.Reader, f *PCMFormat, id string, size uint32, order binary.ByteOrder) error {
+	if size > maxForeignChunk || f.Foreign == nil {
+		f.Foreign = nil
+		return skipChunk(r, id, size)
+	}
+	b := make([]byte, size+size%2)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return errors.New("Failed to read the " + id + " chunk: " + err.Error())
+	}
+	f.Foreign = append(f.Foreign, foreignChunk(id, size, b[:size], order))
+	return nil
+}
+
+// SkipChunk skips the body of a chunk of the given size, and its pad byte.
+func skipChunk(r io.Reader, id string, size uint32) error {
+	n := int64(size) + int64(size%2)
+	if m, err := io.CopyN(ioutil.Discard, r, n); err != nil {
+		return errors.New("Failed to skip the " + id + " chunk: read " + strconv.FormatInt(m, 10) + " bytes: " + err.Error())
+	}
+	return nil
+}
+
+func readWAVHeader(r io.Reader, f *PCMFormat) error {
+	le := binary.LittleEndian
+	for {
+		id, size, err := readChunkHeader(r, le)
+		if err != nil {
+			return err
+		}
+		switch id {
+		case "fmt ":
+			b, err := readChunk(r, id, size)
+			if err != nil {
+				return err
+			}
+			if len(b) < 16 {
+				return errors.New("fmt chunk is too short")
+			}
+			tag := le.Uint16(b)
+			if tag == 0xFFFE && len(b) >= 26 {
+				// WAVE_FORMAT_EXTENSIBLE: the tag begins the sub-format GUID.
+				tag = le.Uint16(b[24:])
+			}
+			if tag != 1 {
+				return errors.New("Unsupported WAV format " + strconv.Itoa(int(tag)) + ": only PCM is supported")
+			}
+			f.NChannels = int(le.Uint16(b[2:]))
+			f.SampleRate = int(le.Uint32(b[4:]))
+			f.BitsPerSample = int(le.Uint16(b[14:]))
+			f.Unsigned = f.BitsPerSample == 8
+			if f.Foreign != nil {
+				f.Foreign = append(f.Foreign, foreignChunk(id, size, b, le))
+			}
+
+		case "data":
+			if f.NChannels == 0 {
+				return errors.New("data chunk before the fmt chunk")
+			}
+			// Streamed WAV files may have a size of 0 or 0xFFFFFFFF.
+			if n := int64(f.NChannels * ((f.BitsPerSample + 7) / 8)); size != 0 && size != 0xFFFFFFFF && n > 0 {
+				f.TotalSamples = int64(size) / n
+			}
+			if f.Foreign != nil {
+				f.Foreign = append(f.Foreign, chunkHeader(id, size, le))
+			}
+			return nil
+
+		default:
+			if err := keepChunk(r, f, id, size, le); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func readAIFFHeader(r io.Reader, f *PCMFormat, aifc bool) error {
+	be := binary.BigEndian
+	comm := false
+	for {
+		id, size, err := readChunkHeader(r, be)
+		if err != nil {
+			return err
+		}
+		switch id {
+		case "COMM":
+			b, err := readChunk(r, id, size)
+			if err != nil {
+				return err
+			}
+			if len(b) < 18 || aifc && len(b) < 22 {
+				return errors.New("COMM chunk is too short")
+			}
+			f.NChannels = int(be.Uint16(b))
+			f.TotalSamples = int64(be.Uint32(b[2:]))
+			f.BitsPerSample = int(be.Uint16(b[6:]))
+			f.SampleRate = int(extendedFloat(b[8:18]))
+			if aifc {
+				switch c := string(b[18:22]); c {
+				case "NONE", "twos":
+				case "sowt":
+					f.BigEndian = false
+				default:
+					return errors.New("Unsupported AIFF-C compression " + strconv.Quote(c))
+				}
+			}
+			comm = true
+			if f.Foreign != nil {
+				f.Foreign = append(f.Foreign, foreignChunk(id, size, b, be))
+			}
+
+		case "SSND":
+			if !comm {
+				return errors.New("SSND chunk before the COMM chunk")
+			}
+			h := make([]byte, 8)
+			if _, err := io.ReadFull(r, h); err != nil {
+				return errors.New("Failed to read the SSND chunk: " + err.Error())
+			}
+			off := int64(be.Uint32(h))
+			var pad bytes.Buffer
+			var w io.Writer = &pad
+			if off > maxForeignChunk {
+				w, f.Foreign = ioutil.Discard, nil
+			}
+			if _, err := io.CopyN(w, r, off); err != nil {
+				return errors.New("Failed to read the SSND chunk: " + err.Error())
+			}
+			if f.Foreign != nil {
+				// The header is kept with the offset, block size, and padding.
+				c := append(chunkHeader(id, size, be), h...)
+				f.Foreign = append(f.Foreign, append(c, pad.Bytes()...))
+			}
+			return nil
+
+		default:
+			if err := keepChunk(r, f, id, size, be); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ExtendedFloat returns the value of an 80-bit IEEE 754 extended precision number,
+// as used for the sample rate of AIFF files.
+func extendedFloat(b []byte) float64 {
+	exp := int(binary.BigEndian.Uint16(b) & 0x7FFF)
+	mant := binary.BigEndian.Uint64(b[2:])
+	if exp == 0 && mant == 0 {
+		return 0
+	}
+	v := math.Ldexp(float64(mant), exp-16383-63)
+	if b[0]&0x80 != 0 {
+		v = -v
+	}
+	return v
+}
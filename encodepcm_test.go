@@ -0,0 +1,177 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncodePCM(t *testing.T) {
+	// AudioFile returns a WAV or AIFF file of the samples.
+	audioFile := func(data [][]int32, bps int, kind string) []byte {
+		var pcm bytes.Buffer
+		for i := range data[0] {
+			for _, ch := range data {
+				v := uint32(ch[i])
+				if kind == "wav" && bps == 8 {
+					v += 128
+				}
+				for k := 0; k < bps/8; k++ {
+					shift := uint(8 * k)
+					if kind == "aiff" || kind == "aifc-twos" {
+						shift = uint(bps - 8 - 8*k)
+					}
+					pcm.WriteByte(byte(v >> shift))
+				}
+			}
+		}
+		var b bytes.Buffer
+		be, le := binary.BigEndian, binary.LittleEndian
+		nch, n := uint16(len(data)), uint32(len(data[0]))
+		switch kind {
+		case "wav":
+			b.WriteString("RIFF\x00\x00\x00\x00WAVE")
+			b.WriteString("LIST")
+			binary.Write(&b, le, uint32(3))
+			b.WriteString("abc\x00") // Odd-sized, padded chunk.
+			b.WriteString("fmt ")
+			binary.Write(&b, le, []uint32{16})
+			binary.Write(&b, le, []uint16{1, nch})
+			binary.Write(&b, le, []uint32{44100, 44100 * uint32(nch) * uint32(bps) / 8})
+			binary.Write(&b, le, []uint16{nch * uint16(bps) / 8, uint16(bps)})
+			b.WriteString("data")
+			binary.Write(&b, le, uint32(pcm.Len()))
+		default:
+			form := "AIFF"
+			if kind != "aiff" {
+				form = "AIFC"
+			}
+			b.WriteString("FORM\x00\x00\x00\x00" + form)
+			b.WriteString("COMM")
+			if form == "AIFC" {
+				binary.Write(&b, be, uint32(22))
+			} else {
+				binary.Write(&b, be, uint32(18))
+			}
+			binary.Write(&b, be, nch)
+			binary.Write(&b, be, n)
+			binary.Write(&b, be, uint16(bps))
+			b.Write([]byte{0x40, 0x0E, 0xAC, 0x44, 0, 0, 0, 0, 0, 0}) // 44100.
+			switch kind {
+			case "aifc-sowt":
+				b.WriteString("sowt")
+			case "aifc-twos":
+				b.WriteString("twos")
+			}
+			b.WriteString("SSND")
+			binary.Write(&b, be, []uint32{uint32(pcm.Len()) + 8 + 2, 2, 0})
+			b.WriteString("\x00\x00") // Offset.
+		}
+		b.Write(pcm.Bytes())
+		// Trailing chunks are not audio.
+		if kind == "wav" {
+			b.WriteString("JUNK\x04\x00\x00\x00junk")
+		} else {
+			b.WriteString("JUNK\x00\x00\x00\x04junk")
+		}
+		return b.Bytes()
+	}
+
+	tests := []struct {
+		kind     string
+		nch, bps int
+	}{
+		{"wav", 2, 16},
+		{"wav", 1, 8},
+		{"wav", 3, 24},
+		{"aiff", 2, 16},
+		{"aiff", 1, 8},
+		{"aifc-sowt", 2, 16},
+		{"aifc-twos", 1, 24},
+	}
+	for _, test := range tests {
+		data := testSignal(test.nch, 10000, test.bps)
+		file := audioFile(data, test.bps, test.kind)
+		r := bytes.NewReader(file)
+		format, err := ReadAudioHeader(r)
+		if err != nil {
+			t.Fatalf("%+v: ReadAudioHeader failed: %v", test, err)
+		}
+		start := int64(len(file) - r.Len())
+		if format.SampleRate != 44100 || format.NChannels != test.nch || format.BitsPerSample != test.bps || format.TotalSamples != 10000 {
+			t.Errorf("%+v: got format %+v", test, format)
+		}
+		var buf bytes.Buffer
+		if err := EncodePCM(&buf, r, format); err != nil {
+			t.Fatalf("%+v: EncodePCM failed: %v", test, err)
+		}
+		got, meta, err := decodeAll(buf.Bytes())
+		if err != nil {
+			t.Fatalf("%+v: decoding failed: %v", test, err)
+		}
+		if !reflect.DeepEqual(got, data) {
+			t.Errorf("%+v: decoded audio differs", test)
+		}
+		if meta.TotalSamples != 10000 {
+			t.Errorf("%+v: got %d total samples, want 10000", test, meta.TotalSamples)
+		}
+
+		// The foreign chunks, with the audio, make up the original file.
+		if _, err := r.Seek(start, 0); err != nil {
+			t.Fatal(err)
+		}
+		buf.Reset()
+		if err := EncodePCMOpts(&buf, r, format, EncoderOptions{KeepForeign: true}); err != nil {
+			t.Fatalf("%+v: EncodePCMOpts failed: %v", test, err)
+		}
+		if _, meta, err = decodeAll(buf.Bytes()); err != nil {
+			t.Fatalf("%+v: decoding failed: %v", test, err)
+		}
+		id := ForeignRIFF
+		if test.kind != "wav" {
+			id = ForeignAIFF
+		}
+		chunks := meta.ForeignChunks(id)
+		if len(chunks) != len(format.Foreign)+1 {
+			t.Fatalf("%+v: got %d foreign chunks, want %d", test, len(chunks), len(format.Foreign)+1)
+		}
+		head := bytes.Join(chunks[:len(format.Foreign)], nil)
+		tail := chunks[len(format.Foreign)]
+		audio := len(file) - len(head) - len(tail)
+		if !bytes.HasPrefix(file, head) || !bytes.HasSuffix(file, tail) || audio != 10000*test.nch*test.bps/8 {
+			t.Errorf("%+v: foreign chunks %q do not make up the file", test, chunks)
+		}
+	}
+	if err := EncodePCMOpts(ioutil.Discard, strings.NewReader(""), PCMFormat{SampleRate: 44100, NChannels: 1, BitsPerSample: 16}, EncoderOptions{KeepForeign: true}); err == nil {
+		t.Errorf("KeepForeign with raw PCM: expected an error")
+	}
+
+	// Raw PCM of unknown length, read until EOF.
+	data := testSignal(2, 5000, 16)
+	raw := audioFile(data, 16, "wav")
+	raw = raw[len(raw)-12-5000*4 : len(raw)-12]
+	var buf bytes.Buffer
+	if err := EncodePCM(&buf, bytes.NewReader(raw), PCMFormat{SampleRate: 48000, NChannels: 2, BitsPerSample: 16}); err != nil {
+		t.Fatalf("EncodePCM failed: %v", err)
+	}
+	if got, _, err := decodeAll(buf.Bytes()); err != nil || !reflect.DeepEqual(got, data) {
+		t.Errorf("Raw PCM: decoded audio differs, error %v", err)
+	}
+
+	format := PCMFormat{SampleRate: 48000, NChannels: 2, BitsPerSample: 16}
+	if err := EncodePCM(ioutil.Discard, bytes.NewReader(raw[:len(raw)-1]), format); err == nil {
+		t.Errorf("Partial sample: expected an error")
+	}
+	format.TotalSamples = 6000
+	if err := EncodePCM(ioutil.Discard, bytes.NewReader(raw), format); err == nil {
+		t.Errorf("Truncated: expected an error")
+	}
+	if _, err := ReadAudioHeader(strings.NewReader("OggS and so on")); err == nil {
+		t.Errorf("Unknown format: expected an error")
+	}
+}
@@ -0,0 +1,91 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+// A Fingerprint identifies the decoded audio of a FLAC stream.
+type Fingerprint struct {
+	// MD5 is the MD5 checksum of the decoded audio, computed as for STREAMINFO.
+	MD5 [md5.Size]byte
+	// CRC32 is the IEEE CRC-32 of the decoded audio.
+	CRC32 uint32
+	// Verified is true if MD5 matches the MD5 in STREAMINFO.
+	Verified bool
+}
+
+// ComputeFingerprint decodes the FLAC stream read from r and returns the
+// fingerprint of its audio.
+func ComputeFingerprint(r io.Reader) (Fingerprint, error) {
+	d, err := NewDecoder(r)
+	if err != nil {
+		return Fingerprint{}, err
+	}
+	h := md5.New()
+	c := crc32.NewIEEE()
+	w := io.MultiWriter(h, c)
+	for {
+		data, err := d.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return Fingerprint{}, err
+		}
+		w.Write(data)
+	}
+	var fp Fingerprint
+	copy(fp.MD5[:], h.Sum(nil))
+	fp.CRC32 = c.Sum32()
+	fp.Verified = bytes.Equal(fp.MD5[:], d.MD5[:])
+	return fp, nil
+}
+
+// An FFPEntry is a line of an .ffp file, the FLAC fingerprint file format,
+// associating a file name with the MD5 of its audio.
+type FFPEntry struct {
+	Name string
+	MD5  [md5.Size]byte
+}
+
+// ReadFFP reads the entries of an .ffp file.
+// Blank lines and comment lines, beginning with ';', are ignored.
+func ReadFFP(r io.Reader) ([]FFPEntry, error) {
+	var es []FFPEntry
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == ';' {
+			continue
+		}
+		i := strings.LastIndex(line, ":")
+		if i < 0 {
+			return nil, errors.New("Malformed .ffp line: " + line)
+		}
+		sum, err := hex.DecodeString(strings.TrimSpace(line[i+1:]))
+		if err != nil || len(sum) != md5.Size {
+			return nil, errors.New("Bad MD5 in .ffp line: " + line)
+		}
+		e := FFPEntry{Name: strings.TrimSpace(line[:i])}
+		copy(e.MD5[:], sum)
+		es = append(es, e)
+	}
+	return es, s.Err()
+}
+
+// WriteFFP writes entries in the .ffp file format.
+func WriteFFP(w io.Writer, es []FFPEntry) error {
+	bw := bufio.NewWriter(w)
+	for _, e := range es {
+		bw.WriteString(e.Name + ":" + hex.EncodeToString(e.MD5[:]) + "\n")
+	}
+	return bw.Flush()
+}
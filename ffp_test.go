@@ -0,0 +1,68 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"crypto/md5"
+	"hash/crc32"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	data := testSignal(2, 10000, 16)
+	pcm, err := interleave(data, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := encodeFile(t, data, EncoderOptions{})
+	fp, err := ComputeFingerprint(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Fingerprint{MD5: md5.Sum(pcm), CRC32: crc32.ChecksumIEEE(pcm), Verified: true}
+	if fp != want {
+		t.Errorf("Got fingerprint %+v, want %+v", fp, want)
+	}
+
+	// Clear the MD5 of STREAMINFO, 18 bytes into its body.
+	unknown := append([]byte{}, stream...)
+	copy(unknown[len(magic)+4+18:], make([]byte, md5.Size))
+	if fp, err := ComputeFingerprint(bytes.NewReader(unknown)); err != nil {
+		t.Error(err)
+	} else if fp.Verified || fp.MD5 != want.MD5 {
+		t.Errorf("Unknown MD5: got fingerprint %+v, want MD5 %x unverified", fp, want.MD5)
+	}
+}
+
+func TestFFP(t *testing.T) {
+	es := []FFPEntry{
+		{Name: "01 - Intro.flac", MD5: [md5.Size]byte{1, 2, 3}},
+		{Name: `C:\Music\02: Colons.flac`, MD5: [md5.Size]byte{0xFF, 14: 0xAB}},
+	}
+	var buf bytes.Buffer
+	if err := WriteFFP(&buf, es); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadFFP(strings.NewReader("; generated by a test\n\n" + buf.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, es) {
+		t.Errorf("Read %+v, wrote %+v", got, es)
+	}
+
+	for _, line := range []string{
+		"no colon",
+		"a.flac:not hex",
+		"a.flac:0102030405060708090a0b0c0d0e0f",
+		"a.flac:0102030405060708090a0b0c0d0e0f1011",
+		"a.flac:",
+	} {
+		if _, err := ReadFFP(strings.NewReader(line + "\n")); err == nil {
+			t.Errorf("%q: expected an error", line)
+		}
+	}
+}
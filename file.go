@@ -0,0 +1,70 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// A File is a Decoder of a local FLAC file, which it memory-maps if it can.
+// Reading from the mapping avoids a system call for each read,
+// which makes seeking and building an index of the file fast.
+// Where the file cannot be mapped, it is read with ReadAt instead.
+type File struct {
+	*Decoder
+	f    *os.File
+	r    io.ReaderAt
+	size int64
+	// Data is the mapping of the file, or nil if it is not mapped.
+	data []byte
+}
+
+// OpenFile opens the FLAC file at path and returns a File decoding it.
+// The File must be closed when it is no longer needed.
+func OpenFile(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	file := &File{f: f, r: f, size: st.Size()}
+	if data, err := mmap(f, file.size); err == nil {
+		file.data = data
+		file.r = bytes.NewReader(data)
+	}
+	if file.Decoder, err = NewReaderAtDecoder(file.r, file.size); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Mapped returns whether the file is memory-mapped.
+func (f *File) Mapped() bool {
+	return f.data != nil
+}
+
+// BuildIndex returns the position of every frame of the file, as BuildIndex.
+// It does not change the position of the Decoder.
+func (f *File) BuildIndex() ([]FramePos, error) {
+	return BuildIndex(io.NewSectionReader(f.r, 0, f.size))
+}
+
+// Close unmaps and closes the file.  The File must not be used after Close.
+func (f *File) Close() error {
+	var err error
+	if f.data != nil {
+		err = munmap(f.data)
+		f.data = nil
+	}
+	if cerr := f.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,60 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestOpenFile(t *testing.T) {
+	const n = 100000
+	data := testSignal(2, n, 16)
+	stream := encodeFile(t, data, EncoderOptions{BlockSize: 4096})
+	tmp, err := ioutil.TempFile("", "flac-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(stream); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmp.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := OpenFile(tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if runtime.GOOS == "linux" && !f.Mapped() {
+		t.Errorf("the file is not memory-mapped")
+	}
+	index, err := f.BuildIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (n + 4095) / 4096; len(index) != want {
+		t.Errorf("got %d frames, want %d", len(index), want)
+	}
+	if _, err := f.Seek(77777, 0); err != nil {
+		t.Fatal(err)
+	}
+	samples, err := f.NextSamples()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ch := range samples {
+		if !reflect.DeepEqual(samples[ch], data[ch][77777:77777+len(samples[ch])]) {
+			t.Errorf("channel %d differs", ch)
+		}
+	}
+
+	if _, err := OpenFile(tmp.Name() + ".missing"); err == nil {
+		t.Errorf("missing file: got no error")
+	}
+}
@@ -0,0 +1,58 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"io"
+	"io/ioutil"
+)
+
+// An Application is an APPLICATION metadata block,
+// containing data for a third-party application.
+type Application struct {
+	// ID is the registered application ID.
+	ID [4]byte
+	// Data is the application data.
+	Data []byte
+}
+
+func readApplication(r io.Reader) (Application, error) {
+	var app Application
+	if _, err := io.ReadFull(r, app.ID[:]); err != nil {
+		return app, err
+	}
+	data, err := ioutil.ReadAll(r)
+	app.Data = data
+	return app, err
+}
+
+func encodeApplication(app Application) []byte {
+	return append(app.ID[:], app.Data...)
+}
+
+// These are the application IDs used by the reference encoder's
+// --keep-foreign-metadata option to preserve the non-audio chunks of
+// the file from which a FLAC stream was encoded.
+const (
+	ForeignRIFF = "riff"
+	ForeignAIFF = "aiff"
+	ForeignW64  = "w64 "
+)
+
+// ForeignChunks returns the chunks of an original WAV (ForeignRIFF),
+// AIFF (ForeignAIFF), or Wave64 (ForeignW64) file that were preserved in
+// APPLICATION blocks, in their original order.
+//
+// The first chunk is the file header, for example "RIFF", the size, and "WAVE".
+// The audio data chunk is preserved only as its header, for example "data"
+// and the size; the audio itself must be written following it.
+// If there is no foreign metadata of the given kind then nil is returned.
+func (m MetaData) ForeignChunks(id string) [][]byte {
+	var chunks [][]byte
+	for _, app := range m.Applications {
+		if string(app.ID[:]) == id {
+			chunks = append(chunks, app.Data)
+		}
+	}
+	return chunks
+}
@@ -0,0 +1,30 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestForeignChunks(t *testing.T) {
+	data := append([]byte{}, constantStream[:42]...)
+	data[4] = 0x00 // STREAMINFO is no longer the last metadata block.
+	data = append(data,
+		0x02, 0, 0, 16, // metadata header: application.
+		'r', 'i', 'f', 'f', 'R', 'I', 'F', 'F', 0, 0, 0, 0, 'W', 'A', 'V', 'E',
+		0x82, 0, 0, 12, // last metadata header: application.
+		'r', 'i', 'f', 'f', 'd', 'a', 't', 'a', 0, 0, 0, 0,
+	)
+	d, err := NewDecoder(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Unexpected error making a new decoder: %v", err)
+	}
+	chunks := d.ForeignChunks(ForeignRIFF)
+	if len(chunks) != 2 || string(chunks[0][:4]) != "RIFF" || string(chunks[1][:4]) != "data" {
+		t.Errorf("Unexpected foreign chunks: %q", chunks)
+	}
+	if chunks := d.ForeignChunks(ForeignAIFF); chunks != nil {
+		t.Errorf("Expected no AIFF chunks, got %q", chunks)
+	}
+}
@@ -0,0 +1,102 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"errors"
+	"strconv"
+)
+
+// AppendFrameHeader appends the encoding of a frame header to b,
+// including its CRC-8, and returns the result.
+// A SampleRate or BitsPerSample that is 0 or has no code is left to STREAMINFO.
+func AppendFrameHeader(b []byte, h FrameHeader) ([]byte, error) {
+	switch {
+	case h.BlockSize < 1 || h.BlockSize > 1<<16:
+		return b, errors.New("Bad block size " + strconv.Itoa(h.BlockSize))
+	case h.ChannelAssignment < 0 || h.ChannelAssignment > int(midSide):
+		return b, errors.New("Bad channel assignment " + strconv.Itoa(h.ChannelAssignment))
+	case channelAssignment(h.ChannelAssignment).nChannels() != h.NChannels:
+		return b, errors.New("Channel assignment " + strconv.Itoa(h.ChannelAssignment) +
+			" does not have " + strconv.Itoa(h.NChannels) + " channels")
+	case h.Number >= MaxUTF8 || !h.VariableSize && h.Number >= 1<<31:
+		return b, errors.New("Frame number is too large: " + strconv.FormatUint(h.Number, 10))
+	}
+	start := len(b)
+	var bw bitWriter
+	bw.write(0x3FFE, 14) // Sync code.
+	bw.write(0, 1)       // Reserved.
+	var variable uint64
+	if h.VariableSize {
+		variable = 1
+	}
+	bw.write(variable, 1) // Blocking strategy.
+	bsCode, size, sizeBits := BlockSizeCode(h.BlockSize)
+	bw.write(uint64(bsCode), 4)
+	rateCode, rate, rateBits := 0, uint64(0), uint(0)
+	if h.SampleRate > 0 {
+		rateCode, rate, rateBits = SampleRateCode(h.SampleRate)
+	}
+	bw.write(uint64(rateCode), 4)
+	bw.write(uint64(h.ChannelAssignment), 4)
+	bw.write(uint64(SampleSizeCode(h.BitsPerSample)), 3)
+	bw.write(0, 1) // Reserved.
+	b = append(b, bw.buf...)
+	b = AppendUTF8(b, h.Number)
+	bw.reset()
+	bw.write(size, sizeBits)
+	bw.write(rate, rateBits)
+	b = append(b, bw.buf...)
+	return append(b, CRC8(b[start:])), nil
+}
+
+// BlockSizeCode returns the frame header code for a block size,
+// and the value and size in bits of the block size field at the end
+// of the header, if any.  The block size must be from 1 to 65536.
+func BlockSizeCode(n int) (int, uint64, uint) {
+	if c := code(blockSizes[:], n); c > 0 {
+		return c, 0, 0
+	}
+	if n <= 256 {
+		return 6, uint64(n - 1), 8
+	}
+	return 7, uint64(n - 1), 16
+}
+
+// SampleRateCode returns the frame header code for a sample rate,
+// and the value and size in bits of the rate at the end of the header, if any.
+// Code 0, meaning that the rate is given by STREAMINFO, is returned
+// only for rates that cannot be coded in the header.
+func SampleRateCode(rate int) (int, uint64, uint) {
+	if c := code(sampleRates[:], rate); c > 0 {
+		return c, 0, 0
+	}
+	switch {
+	case rate%1000 == 0 && rate/1000 < 1<<8:
+		return 12, uint64(rate / 1000), 8
+	case rate < 1<<16:
+		return 13, uint64(rate), 16
+	case rate%10 == 0 && rate/10 < 1<<16:
+		return 14, uint64(rate / 10), 16
+	}
+	return 0, 0, 0
+}
+
+// SampleSizeCode returns the frame header code for a number of bits per sample,
+// or 0, meaning that it is given by STREAMINFO, if it has no code.
+func SampleSizeCode(bps int) int {
+	if c := code(sampleSizes[:], bps); c > 0 {
+		return c
+	}
+	return 0
+}
+
+// Code returns the index of v in a frame header code table, or -1.
+func code(table []int, v int) int {
+	for i, w := range table {
+		if w == v {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,57 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAppendFrameHeader(t *testing.T) {
+	info := &StreamInfo{SampleRate: 44100, NChannels: 2, BitsPerSample: 16}
+	tests := []FrameHeader{
+		{BlockSize: 4096, SampleRate: 44100, NChannels: 2, ChannelAssignment: 1, BitsPerSample: 16, Number: 5},
+		{BlockSize: 100, SampleRate: 44100, NChannels: 2, ChannelAssignment: 10, BitsPerSample: 16, Number: 1000},
+		{BlockSize: 1000, SampleRate: 37000, NChannels: 1, ChannelAssignment: 0, BitsPerSample: 8, VariableSize: true, Number: 1 << 35},
+		{BlockSize: 65536, SampleRate: 44101, NChannels: 8, ChannelAssignment: 7, BitsPerSample: 24, Number: 1 << 30},
+		{BlockSize: 16, SampleRate: 655350, NChannels: 2, ChannelAssignment: 8, BitsPerSample: 12, VariableSize: true},
+	}
+	for _, test := range tests {
+		data, err := AppendFrameHeader(nil, test)
+		if err != nil {
+			t.Errorf("AppendFrameHeader(%+v) failed: %v", test, err)
+			continue
+		}
+		h, err := readFrameHeader(bytes.NewReader(data), info)
+		if err != nil {
+			t.Errorf("Reading the header of %+v failed: %v", test, err)
+			continue
+		}
+		got := FrameHeader{
+			BlockSize:         h.blockSize,
+			SampleRate:        h.sampleRate,
+			NChannels:         h.channelAssignment.nChannels(),
+			ChannelAssignment: int(h.channelAssignment),
+			BitsPerSample:     h.sampleSize,
+			VariableSize:      h.variableSize,
+			Number:            h.number,
+		}
+		if got != test {
+			t.Errorf("Got header %+v, want %+v", got, test)
+		}
+	}
+
+	errs := []FrameHeader{
+		{BlockSize: 0, NChannels: 1},
+		{BlockSize: 1<<16 + 1, NChannels: 1},
+		{BlockSize: 4096, NChannels: 2, ChannelAssignment: 11},
+		{BlockSize: 4096, NChannels: 1, ChannelAssignment: 10},
+		{BlockSize: 4096, NChannels: 1, Number: 1 << 31},
+		{BlockSize: 4096, NChannels: 1, VariableSize: true, Number: MaxUTF8},
+	}
+	for _, test := range errs {
+		if _, err := AppendFrameHeader(nil, test); err == nil {
+			t.Errorf("AppendFrameHeader(%+v) succeeded, expected an error", test)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// A FramePos is the position of a frame in a stream.
+// Its fields are those of a SeekPoint, so it converts to one,
+// for example to give a Decoder a seek point for every frame.
+type FramePos struct {
+	// Sample is the number of the first sample in the frame.
+	Sample int64
+	// Offset is the byte offset of the frame from the first frame.
+	Offset int64
+	// Samples is the number of samples in the frame.
+	Samples int
+}
+
+// BuildIndex returns the position of every frame of the FLAC stream read from r.
+// It is much faster than decoding the stream, since it only reads the frame
+// headers, searching for each one after the last; the subframes are not
+// decoded and the CRC-16 of each frame is not checked.  A header is only
+// accepted if its CRC-8 is correct, it matches the STREAMINFO, and its number
+// follows that of the previous frame, so a header-like run of bytes within
+// a frame is not mistaken for the next frame.
+//
+// If the stream ends before the total number of samples in STREAMINFO,
+// the frames found are returned along with a *TruncatedError.
+func BuildIndex(r io.Reader) ([]FramePos, error) {
+	br := bufio.NewReader(r)
+	cr := &countingReader{r: br}
+	if err := checkMagic(cr); err != nil {
+		return nil, err
+	}
+	meta, err := readMetaData(cr, Options{})
+	if err != nil {
+		return nil, err
+	}
+	if meta.StreamInfo == nil {
+		return nil, errors.New("Missing STREAMINFO header")
+	}
+
+	var index []FramePos
+	var offset, next int64
+	// Variable is whether the frame headers hold sample numbers,
+	// rather than frame numbers, as set by the first frame.
+	var variable bool
+	for {
+		b, err := br.Peek(maxFrameHeaderSize)
+		if len(b) < 2 {
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			break
+		}
+		if b[0] != 0xFF {
+			buf, _ := br.Peek(br.Buffered())
+			n := bytes.IndexByte(buf, 0xFF)
+			if n < 0 {
+				n = len(buf)
+			}
+			br.Discard(n)
+			offset += int64(n)
+			continue
+		}
+		hr := bytes.NewReader(b)
+		h, err := readFrameHeader(hr, meta.StreamInfo)
+		ok := err == nil && h.sampleRate == meta.SampleRate && h.sampleSize == meta.BitsPerSample &&
+			h.channelAssignment.nChannels() == meta.NChannels
+		if ok && len(index) == 0 {
+			variable = h.variableSize
+			ok = h.number == 0
+		} else if ok {
+			number := uint64(len(index))
+			if variable {
+				number = uint64(next)
+			}
+			ok = h.variableSize == variable && h.number == number
+		}
+		if !ok {
+			br.Discard(1)
+			offset++
+			continue
+		}
+		index = append(index, FramePos{Sample: next, Offset: offset, Samples: h.blockSize})
+		next += int64(h.blockSize)
+		n := len(b) - hr.Len()
+		br.Discard(n)
+		offset += int64(n)
+	}
+	if meta.TotalSamples > 0 && next < meta.TotalSamples {
+		return index, &TruncatedError{Samples: next}
+	}
+	return index, nil
+}
@@ -0,0 +1,60 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestBuildIndex(t *testing.T) {
+	data := testSignal(2, 100000, 16)
+	for _, opts := range []EncoderOptions{
+		{BlockSize: 4096},
+		{BlockSize: 1152, VariableBlockSize: true},
+		{Level: 8, Padding: 1000},
+	} {
+		stream := encodeFile(t, data, opts)
+		index, err := BuildIndex(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		_, want, err := ScanStream(bytes.NewReader(stream), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]SeekPoint, len(index))
+		for i, p := range index {
+			got[i] = SeekPoint(p)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%+v: got index %+v, want %+v", opts, got, want)
+		}
+
+		// With the index as its SEEKTABLE, a Decoder seeks to the exact frame.
+		d, err := NewDecoder(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.SeekTable = got
+		if _, err := d.Seek(77777, 0); err != nil {
+			t.Fatalf("%+v: Seek: %v", opts, err)
+		}
+		samples, err := d.NextSamples()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if samples[0][0] != data[0][77777] {
+			t.Errorf("%+v: Seek: got sample %d, want %d", opts, samples[0][0], data[0][77777])
+		}
+
+		index, err = BuildIndex(bytes.NewReader(stream[:len(stream)/2]))
+		if _, ok := err.(*TruncatedError); !ok {
+			t.Errorf("%+v: truncated: got error %v, want a *TruncatedError", opts, err)
+		}
+		if len(index) == 0 || len(index) >= len(want) || !reflect.DeepEqual(SeekPoint(index[len(index)-1]), want[len(index)-1]) {
+			t.Errorf("%+v: truncated: got %d frames of %d", opts, len(index), len(want))
+		}
+	}
+}
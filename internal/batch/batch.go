@@ -0,0 +1,119 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+// Package batch implements the batch mode shared by the FLAC command line tools:
+// expanding directories and glob patterns into lists of files, processing the
+// files concurrently, and summarizing the per-file errors.
+package batch
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Files returns the files named by args, in order and without duplicates.
+// Each arg may be a file, a glob pattern, or a directory.
+// Directories are walked recursively for files with the given extension,
+// compared case-insensitively.
+func Files(args []string, ext string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+
+	for _, arg := range args {
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			// Not a pattern, or a pattern without matches; report the
+			// missing file when it's processed.
+			matches = []string{arg}
+		}
+		for _, m := range matches {
+			info, err := os.Stat(m)
+			if err != nil || !info.IsDir() {
+				add(m)
+				continue
+			}
+			var dir []string
+			err = filepath.Walk(m, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if !info.IsDir() && strings.EqualFold(filepath.Ext(path), ext) {
+					dir = append(dir, path)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			sort.Strings(dir)
+			for _, path := range dir {
+				add(path)
+			}
+		}
+	}
+	return files, nil
+}
+
+// An Error is an error processing a file.
+type Error struct {
+	Path string
+	Err  error
+}
+
+func (e Error) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+// Run calls f with the index and path of each file using the given number of
+// concurrent workers, and returns the errors, in the order of the files.
+func Run(files []string, workers int, f func(i int, path string) error) []Error {
+	if workers < 1 {
+		workers = 1
+	}
+	errs := make([]error, len(files))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				errs[i] = f(i, files[i])
+			}
+		}()
+	}
+	for i := range files {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	var es []Error
+	for i, err := range errs {
+		if err != nil {
+			es = append(es, Error{Path: files[i], Err: err})
+		}
+	}
+	return es
+}
+
+// Summarize writes each error and a summary of the run to w.
+func Summarize(w io.Writer, nfiles int, errs []Error) {
+	for _, err := range errs {
+		fmt.Fprintln(w, err.Error())
+	}
+	fmt.Fprintf(w, "%d files: %d succeeded, %d failed\n", nfiles, nfiles-len(errs), len(errs))
+}
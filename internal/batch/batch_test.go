@@ -0,0 +1,147 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package batch
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "batch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{
+		"a.flac",
+		"b.FLAC",
+		"c.wav",
+		"d/e.flac",
+		"d/f.txt",
+		"d/g/h.Flac",
+		"d/g/i.flac",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	join := func(names ...string) []string {
+		paths := make([]string, len(names))
+		for i, name := range names {
+			paths[i] = filepath.Join(dir, filepath.FromSlash(name))
+		}
+		return paths
+	}
+
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		// Files are kept whatever their extension.
+		{join("c.wav", "a.flac"), join("c.wav", "a.flac")},
+		// Patterns expand to their matches, in order,
+		// and matching directories are walked.
+		{join("*.wav", "*"), join("c.wav", "a.flac", "b.FLAC", "d/e.flac", "d/g/h.Flac", "d/g/i.flac")},
+		{join("d/*/*.flac"), join("d/g/i.flac")},
+		// Directories are walked recursively for files with the extension,
+		// compared case-insensitively.
+		{join("d"), join("d/e.flac", "d/g/h.Flac", "d/g/i.flac")},
+		{[]string{dir}, join("a.flac", "b.FLAC", "d/e.flac", "d/g/h.Flac", "d/g/i.flac")},
+		// Duplicates are dropped.
+		{join("d/g/i.flac", "d/g", "*.flac"), join("d/g/i.flac", "d/g/h.Flac", "a.flac")},
+		// Missing files and patterns without matches are kept.
+		{join("missing.flac", "*.ogg"), join("missing.flac", "*.ogg")},
+		{nil, nil},
+	}
+	for _, test := range tests {
+		got, err := Files(test.args, ".flac")
+		if err != nil {
+			t.Errorf("Files(%v): %v", test.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Files(%v)=%v, want %v", test.args, got, test.want)
+		}
+	}
+
+	if _, err := Files([]string{"["}, ".flac"); err == nil {
+		t.Errorf("Files with a bad pattern: expected an error")
+	}
+}
+
+func TestRun(t *testing.T) {
+	files := make([]string, 20)
+	for i := range files {
+		files[i] = "file" + strconv.Itoa(i)
+	}
+	for _, workers := range []int{-1, 0, 1, 4, 50} {
+		var mu sync.Mutex
+		seen := make(map[int]string)
+		errs := Run(files, workers, func(i int, path string) error {
+			// Later files finish first.
+			time.Sleep(time.Duration(len(files)-i) * 100 * time.Microsecond)
+			mu.Lock()
+			seen[i] = path
+			mu.Unlock()
+			if i%3 == 0 {
+				return errors.New("failed " + path)
+			}
+			return nil
+		})
+		if len(seen) != len(files) {
+			t.Errorf("%d workers: ran %d of %d files", workers, len(seen), len(files))
+		}
+		for i, path := range seen {
+			if path != files[i] {
+				t.Errorf("%d workers: file %d is %s, want %s", workers, i, path, files[i])
+			}
+		}
+		// The errors are in the order of the files, not of their completion.
+		var want []Error
+		for i := 0; i < len(files); i += 3 {
+			want = append(want, Error{Path: files[i], Err: errors.New("failed " + files[i])})
+		}
+		if !reflect.DeepEqual(errs, want) {
+			t.Errorf("%d workers: got errors %v, want %v", workers, errs, want)
+		}
+	}
+
+	if errs := Run(nil, 4, func(int, string) error { return errors.New("called") }); len(errs) != 0 {
+		t.Errorf("No files: got errors %v", errs)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		nfiles int
+		errs   []Error
+		want   string
+	}{
+		{3, nil, "3 files: 3 succeeded, 0 failed\n"},
+		{
+			3,
+			[]Error{{"a.flac", errors.New("bad")}, {"c.flac", errors.New("worse")}},
+			"a.flac: bad\nc.flac: worse\n3 files: 1 succeeded, 2 failed\n",
+		},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		Summarize(&buf, test.nfiles, test.errs)
+		if got := buf.String(); got != test.want {
+			t.Errorf("Summarize(%d, %v)=%q, want %q", test.nfiles, test.errs, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+)
+
+// MarshalJSON encodes the metadata as a JSON object with a field for each
+// kind of block, omitting those that are absent, so that it can be dumped
+// by tools.  Unlike the default encoding, StreamInfo and VorbisComment
+// are objects of their own, rather than having their fields promoted.
+// Byte slices, such as the data of pictures, are encoded in base64.
+func (m MetaData) MarshalJSON() ([]byte, error) {
+	// Each field of MetaData must also be added here.
+	return json.Marshal(struct {
+		StreamInfo    *StreamInfo    `json:",omitempty"`
+		VorbisComment *VorbisComment `json:",omitempty"`
+		Applications  []Application  `json:",omitempty"`
+		SeekTable     []SeekPoint    `json:",omitempty"`
+		Pictures      []Picture      `json:",omitempty"`
+		Others        []RawBlock     `json:",omitempty"`
+		Padding       []PaddingBlock `json:",omitempty"`
+		Raw           []RawBlock     `json:",omitempty"`
+	}{
+		StreamInfo:    m.StreamInfo,
+		VorbisComment: m.VorbisComment,
+		Applications:  m.Applications,
+		SeekTable:     m.SeekTable,
+		Pictures:      m.Pictures,
+		Others:        m.Others,
+		Padding:       m.Padding,
+		Raw:           m.Raw,
+	})
+}
+
+// StreamInfoJSON has the fields of a StreamInfo, without its methods.
+type streamInfoJSON StreamInfo
+
+// MarshalJSON encodes the STREAMINFO as a JSON object
+// with the MD5 checksum as a hexadecimal string.
+func (s StreamInfo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		streamInfoJSON
+		MD5 string
+	}{streamInfoJSON(s), hex.EncodeToString(s.MD5[:])})
+}
+
+// UnmarshalJSON decodes a STREAMINFO encoded by MarshalJSON.
+func (s *StreamInfo) UnmarshalJSON(b []byte) error {
+	v := struct {
+		*streamInfoJSON
+		MD5 string
+	}{streamInfoJSON: (*streamInfoJSON)(s)}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	sum, err := hex.DecodeString(v.MD5)
+	if err != nil || len(sum) != len(s.MD5) {
+		return errors.New("Bad STREAMINFO MD5: " + v.MD5)
+	}
+	copy(s.MD5[:], sum)
+	return nil
+}
+
+// MarshalJSON encodes the VORBIS_COMMENT as a JSON object with
+// the vendor string and the list of comments, in their original order.
+func (c VorbisComment) MarshalJSON() ([]byte, error) {
+	comments := c.Comments
+	if comments == nil {
+		comments = []string{}
+	}
+	return json.Marshal(struct {
+		Vendor   string
+		Comments []string
+	}{c.Vendor, comments})
+}
+
+// MarshalJSON encodes the APPLICATION block as a JSON object
+// with its ID as a string and its data in base64.
+func (a Application) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		ID   string
+		Data []byte
+	}{string(a.ID[:]), a.Data})
+}
+
+// MarshalJSON encodes the PICTURE block as a JSON object.
+// The data of a picture is encoded in base64, unless it is a URL,
+// in which case it is omitted and the URL is given instead.
+func (p Picture) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Type                         PictureType
+		MIME                         string
+		Description                  string
+		Width, Height, Depth, Colors int
+		URL                          string `json:",omitempty"`
+		Data                         []byte `json:",omitempty"`
+	}{
+		Type:        p.Type,
+		MIME:        p.MIME,
+		Description: p.Description,
+		Width:       p.Width,
+		Height:      p.Height,
+		Depth:       p.Depth,
+		Colors:      p.Colors,
+		Data:        p.Data,
+	}
+	if p.MIME == "-->" {
+		v.URL, v.Data = string(p.Data), nil
+	}
+	return json.Marshal(v)
+}
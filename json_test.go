@@ -0,0 +1,61 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"testing"
+)
+
+func TestMetaDataJSON(t *testing.T) {
+	meta := MetaData{
+		StreamInfo:    &StreamInfo{SampleRate: 44100, NChannels: 2, BitsPerSample: 16, TotalSamples: 10, MD5: [md5.Size]byte{0xAB, 15: 0x01}},
+		VorbisComment: &VorbisComment{Vendor: "test", Comments: []string{"TITLE=json"}},
+		Applications:  []Application{{ID: [4]byte{'t', 'e', 's', 't'}, Data: []byte{1, 2}}},
+		Pictures: []Picture{
+			{Type: PictureFrontCover, MIME: "image/png", Data: []byte{1, 2, 3}},
+			{Type: PictureBackCover, MIME: "-->", Data: []byte("http://example.com/b.png")},
+		},
+	}
+	b, err := json.Marshal(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got["SampleRate"]; ok {
+		t.Errorf("StreamInfo fields were promoted: %s", b)
+	}
+	if _, ok := got["SeekTable"]; ok {
+		t.Errorf("missing SeekTable was not omitted: %s", b)
+	}
+	info := got["StreamInfo"].(map[string]interface{})
+	if info["MD5"] != "ab000000000000000000000000000001" || info["SampleRate"] != 44100.0 {
+		t.Errorf("got StreamInfo %v", info)
+	}
+	if c := got["VorbisComment"].(map[string]interface{}); c["Vendor"] != "test" {
+		t.Errorf("got VorbisComment %v", c)
+	}
+	if app := got["Applications"].([]interface{})[0].(map[string]interface{}); app["ID"] != "test" || app["Data"] != "AQI=" {
+		t.Errorf("got application %v", app)
+	}
+	pics := got["Pictures"].([]interface{})
+	if p := pics[0].(map[string]interface{}); p["Data"] != "AQID" || p["Type"] != 3.0 {
+		t.Errorf("got picture %v", p)
+	}
+	if p := pics[1].(map[string]interface{}); p["URL"] != "http://example.com/b.png" || p["Data"] != nil {
+		t.Errorf("got URL picture %v", p)
+	}
+
+	var info2 StreamInfo
+	b, err = json.Marshal(meta.StreamInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &info2); err != nil || info2 != *meta.StreamInfo {
+		t.Errorf("got StreamInfo %+v, %v, want %+v", info2, err, *meta.StreamInfo)
+	}
+}
@@ -0,0 +1,88 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"sync"
+)
+
+// A LockedDecoder wraps a Decoder with a mutex, making it safe for use by
+// multiple goroutines.  For example, one goroutine may decode audio while
+// another inspects metadata.
+type LockedDecoder struct {
+	mu sync.Mutex
+	d  *Decoder
+}
+
+// NewLockedDecoder returns a LockedDecoder wrapping d.
+// After this call, d must only be used through the returned LockedDecoder.
+func NewLockedDecoder(d *Decoder) *LockedDecoder {
+	return &LockedDecoder{d: d}
+}
+
+// Next returns the audio data from the next frame.
+// See Decoder.Next.
+func (l *LockedDecoder) Next() ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.d.Next()
+}
+
+// Seek positions the Decoder at the given inter-channel sample.
+// See Decoder.Seek.
+func (l *LockedDecoder) Seek(offset int64, whence int) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.d.Seek(offset, whence)
+}
+
+// PeekFrameHeader returns the header of the next frame without consuming it.
+// See Decoder.PeekFrameHeader.
+func (l *LockedDecoder) PeekFrameHeader() (*FrameHeader, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.d.PeekFrameHeader()
+}
+
+// ReadMetaData parses deferred metadata blocks.
+// See Decoder.ReadMetaData.
+func (l *LockedDecoder) ReadMetaData() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.d.ReadMetaData()
+}
+
+// MetaData returns a copy of the Decoder's MetaData.
+// The metadata blocks are copied, so the result remains valid
+// after subsequent calls to the LockedDecoder.
+func (l *LockedDecoder) MetaData() MetaData {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var meta MetaData
+	if l.d.StreamInfo != nil {
+		info := *l.d.StreamInfo
+		meta.StreamInfo = &info
+	}
+	if l.d.VorbisComment != nil {
+		cmnt := *l.d.VorbisComment
+		cmnt.Comments = append([]string(nil), cmnt.Comments...)
+		meta.VorbisComment = &cmnt
+	}
+	// Each field of MetaData must also be copied here.
+	meta.Applications = append([]Application(nil), l.d.Applications...)
+	meta.SeekTable = append([]SeekPoint(nil), l.d.SeekTable...)
+	meta.Pictures = append([]Picture(nil), l.d.Pictures...)
+	meta.Others = append([]RawBlock(nil), l.d.Others...)
+	meta.Padding = append([]PaddingBlock(nil), l.d.Padding...)
+	meta.Raw = append([]RawBlock(nil), l.d.Raw...)
+	return meta
+}
+
+// Do calls f with the wrapped Decoder while holding the lock, allowing
+// a sequence of calls to be made atomically.
+func (l *LockedDecoder) Do(f func(*Decoder) error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return f(l.d)
+}
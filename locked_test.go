@@ -0,0 +1,90 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestLockedDecoderMetaData(t *testing.T) {
+	// Every field of the MetaData is given a value, so that a field added
+	// to MetaData but not copied by LockedDecoder.MetaData is caught.
+	var meta MetaData
+	v := reflect.ValueOf(&meta).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		switch f := v.Field(i); f.Kind() {
+		case reflect.Ptr:
+			f.Set(reflect.New(f.Type().Elem()))
+		case reflect.Slice:
+			f.Set(reflect.MakeSlice(f.Type(), 1, 1))
+		default:
+			t.Fatalf("MetaData field %s has unexpected kind %s", v.Type().Field(i).Name, f.Kind())
+		}
+	}
+	got := reflect.ValueOf(NewLockedDecoder(&Decoder{MetaData: meta}).MetaData())
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Name
+		want, got := v.Field(i), got.Field(i)
+		if !reflect.DeepEqual(got.Interface(), want.Interface()) {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		} else if got.Pointer() == want.Pointer() {
+			t.Errorf("%s is not copied", name)
+		}
+	}
+}
+
+// TestLockedDecoder is most useful with the race detector: go test -race.
+func TestLockedDecoder(t *testing.T) {
+	data := testSignal(2, 50000, 16)
+	stream := encodeFile(t, data, EncoderOptions{SeekInterval: 4096})
+	d, err := NewDecoder(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewLockedDecoder(d)
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if _, err := l.Next(); err == io.EOF {
+				if _, err := l.Seek(0, 0); err != nil {
+					errs <- err
+					return
+				}
+			} else if err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if _, err := l.Seek(int64(i*487), 0); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if meta := l.MetaData(); meta.TotalSamples != 50000 || len(meta.SeekTable) == 0 {
+				errs <- errors.New("bad metadata")
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
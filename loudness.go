@@ -0,0 +1,260 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"errors"
+	"io"
+	"math"
+)
+
+// A LoudnessMeter measures the loudness of audio as specified by
+// ITU-R BS.1770-4 and EBU R 128: K-weighted, gated, integrated loudness
+// in LUFS, and the sample and true peaks.
+type LoudnessMeter struct {
+	sampleRate int
+	channels   int
+	// Filters are the K-weighting filters of each channel.
+	filters []kFilter
+	// Weights are the channel weights.
+	weights []float64
+
+	// Sum is the sum of the weighted squares of the current 100ms step.
+	sum float64
+	// N is the number of samples in the current step.
+	n int
+	// StepSize is the number of samples in a 100ms step.
+	stepSize int
+	// Steps are the mean squares of the last four steps, which make up a 400ms block.
+	steps  [4]float64
+	nsteps int
+	// Blocks are the mean squares of each 400ms gating block.
+	blocks []float64
+
+	samplePeak float64
+	truePeak   float64
+	// History holds the last samples of each channel for true peak interpolation.
+	history [][]float64
+}
+
+// NewLoudnessMeter returns a LoudnessMeter for audio with the given sample rate
+// and number of channels.  Channels are weighted as in BS.1770 assuming
+// FLAC's channel order: the LFE channel of 5.1 and 7.1 audio is excluded and
+// the surround channels are weighted by 1.41.
+func NewLoudnessMeter(sampleRate, channels int) *LoudnessMeter {
+	m := &LoudnessMeter{
+		sampleRate: sampleRate,
+		channels:   channels,
+		filters:    make([]kFilter, channels),
+		weights:    make([]float64, channels),
+		stepSize:   sampleRate / 10,
+		history:    make([][]float64, channels),
+	}
+	for i := range m.filters {
+		m.filters[i] = newKFilter(float64(sampleRate))
+		m.weights[i] = channelWeight(i, channels)
+		m.history[i] = make([]float64, len(truePeakTaps[0]))
+	}
+	return m
+}
+
+func channelWeight(ch, channels int) float64 {
+	switch {
+	case channels >= 6 && ch == 3:
+		return 0 // LFE
+	case channels >= 5 && ch >= 3:
+		return 1.41 // Surrounds
+	}
+	return 1
+}
+
+// Write adds audio to the measurement.  The samples are given per channel,
+// as returned by Decoder.NextSamples, with the given number of bits per sample.
+func (m *LoudnessMeter) Write(samples [][]int32, bitsPerSample int) {
+	scale := 1 / float64(int64(1)<<uint(bitsPerSample-1))
+	n := len(samples[0])
+	for i := 0; i < n; i++ {
+		for ch := range samples {
+			x := float64(samples[ch][i]) * scale
+			m.peak(ch, x)
+			y := m.filters[ch].filter(x)
+			m.sum += m.weights[ch] * y * y
+		}
+		if m.n++; m.n == m.stepSize {
+			m.endStep()
+		}
+	}
+}
+
+func (m *LoudnessMeter) endStep() {
+	copy(m.steps[:], m.steps[1:])
+	m.steps[3] = m.sum / float64(m.n)
+	m.sum, m.n = 0, 0
+	if m.nsteps++; m.nsteps >= 4 {
+		m.blocks = append(m.blocks, (m.steps[0]+m.steps[1]+m.steps[2]+m.steps[3])/4)
+	}
+}
+
+// Integrated returns the integrated loudness in LUFS.
+// If the audio is shorter than 400ms or silent, -Inf is returned.
+func (m *LoudnessMeter) Integrated() float64 {
+	return gatedLoudness(m.blocks)
+}
+
+// SamplePeak returns the largest absolute sample value, where 1 is full scale.
+func (m *LoudnessMeter) SamplePeak() float64 {
+	return m.samplePeak
+}
+
+// TruePeak returns the largest absolute value of the 4x oversampled audio,
+// where 1 is full scale.  It may exceed 1 for audio that clips on conversion to analog.
+func (m *LoudnessMeter) TruePeak() float64 {
+	return m.truePeak
+}
+
+// AlbumLoudness returns the integrated loudness of the audio measured by all
+// of the meters together, as if it were a single stream.
+func AlbumLoudness(meters ...*LoudnessMeter) float64 {
+	var blocks []float64
+	for _, m := range meters {
+		blocks = append(blocks, m.blocks...)
+	}
+	return gatedLoudness(blocks)
+}
+
+func blockLoudness(z float64) float64 {
+	return -0.691 + 10*math.Log10(z)
+}
+
+// GatedLoudness returns the loudness of the blocks after applying the absolute
+// gate of -70 LUFS and the relative gate of -10 LU.
+func gatedLoudness(blocks []float64) float64 {
+	var sum float64
+	var n int
+	for _, z := range blocks {
+		if blockLoudness(z) > -70 {
+			sum += z
+			n++
+		}
+	}
+	if n == 0 {
+		return math.Inf(-1)
+	}
+	gate := blockLoudness(sum/float64(n)) - 10
+	sum, n = 0, 0
+	for _, z := range blocks {
+		if l := blockLoudness(z); l > -70 && l > gate {
+			sum += z
+			n++
+		}
+	}
+	if n == 0 {
+		return math.Inf(-1)
+	}
+	return blockLoudness(sum / float64(n))
+}
+
+// TruePeakTaps are the four phases of the 48-tap interpolation filter given
+// in ITU-R BS.1770-4 Annex 2 for 4x oversampling.
+var truePeakTaps = [4][12]float64{
+	{0.0017089843750, 0.0109863281250, -0.0196533203125, 0.0332031250000, -0.0594482421875, 0.1373291015625, 0.9721679687500, -0.1022949218750, 0.0476074218750, -0.0266113281250, 0.0148925781250, -0.0083007812500},
+	{-0.0291748046875, 0.0292968750000, -0.0517578125000, 0.0891113281250, -0.1665039062500, 0.4650878906250, 0.7797851562500, -0.2003173828125, 0.1015625000000, -0.0582275390625, 0.0330810546875, -0.0189208984375},
+	{-0.0189208984375, 0.0330810546875, -0.0582275390625, 0.1015625000000, -0.2003173828125, 0.7797851562500, 0.4650878906250, -0.1665039062500, 0.0891113281250, -0.0517578125000, 0.0292968750000, -0.0291748046875},
+	{-0.0083007812500, 0.0148925781250, -0.0266113281250, 0.0476074218750, -0.1022949218750, 0.9721679687500, 0.1373291015625, -0.0594482421875, 0.0332031250000, -0.0196533203125, 0.0109863281250, 0.0017089843750},
+}
+
+func (m *LoudnessMeter) peak(ch int, x float64) {
+	if a := math.Abs(x); a > m.samplePeak {
+		m.samplePeak = a
+	}
+	h := m.history[ch]
+	copy(h, h[1:])
+	h[len(h)-1] = x
+	for _, taps := range truePeakTaps {
+		var y float64
+		for i, t := range taps {
+			y += t * h[len(h)-1-i]
+		}
+		if a := math.Abs(y); a > m.truePeak {
+			m.truePeak = a
+		}
+	}
+}
+
+// A kFilter is the two-stage K-weighting filter of BS.1770:
+// a high shelf followed by a high pass.
+type kFilter struct {
+	stages [2]biquad
+}
+
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	z1, z2             float64
+}
+
+func (f *biquad) filter(x float64) float64 {
+	y := f.b0*x + f.z1
+	f.z1 = f.b1*x - f.a1*y + f.z2
+	f.z2 = f.b2*x - f.a2*y
+	return y
+}
+
+// NewKFilter returns the K-weighting filter for the sample rate,
+// computing the coefficients by the bilinear transform so that rates other
+// than 48 kHz are weighted correctly.
+func newKFilter(rate float64) kFilter {
+	var f kFilter
+
+	// High shelf.
+	f0, g, q := 1681.974450955533, 3.999843853973347, 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / rate)
+	vh := math.Pow(10, g/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	f.stages[0] = biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	// High pass.
+	f0, q = 38.13547087602444, 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / rate)
+	a0 = 1 + k/q + k*k
+	f.stages[1] = biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	return f
+}
+
+func (f *kFilter) filter(x float64) float64 {
+	return f.stages[1].filter(f.stages[0].filter(x))
+}
+
+// MeasureLoudness decodes the FLAC stream read from r and returns a
+// LoudnessMeter that has measured all of its audio.
+func MeasureLoudness(r io.Reader) (*LoudnessMeter, error) {
+	d, err := NewDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	m := NewLoudnessMeter(d.SampleRate, d.NChannels)
+	for {
+		data, err := d.NextSamples()
+		if err == io.EOF {
+			return m, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if len(data) != m.channels {
+			return nil, errors.New("Frame channels do not match STREAMINFO")
+		}
+		m.Write(data, d.BitsPerSample)
+	}
+}
@@ -0,0 +1,31 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLoudness(t *testing.T) {
+	// A full-scale 997 Hz sine wave on one channel measures -3.01 LUFS.
+	const rate = 48000
+	m := NewLoudnessMeter(rate, 2)
+	samples := [][]int32{make([]int32, rate), make([]int32, rate)}
+	for i := range samples[0] {
+		s := int32(math.Sin(2*math.Pi*997*float64(i)/rate) * 32767)
+		samples[0][i] = s
+	}
+	for i := 0; i < 5; i++ {
+		m.Write(samples, 16)
+	}
+	if l := m.Integrated(); math.Abs(l-(-3.01)) > 0.05 {
+		t.Errorf("Expected -3.01 LUFS, got %f", l)
+	}
+	if p := m.SamplePeak(); math.Abs(p-1) > 0.001 {
+		t.Errorf("Expected a sample peak of 1, got %f", p)
+	}
+	if p := m.TruePeak(); p < m.SamplePeak() {
+		t.Errorf("Expected a true peak of at least %f, got %f", m.SamplePeak(), p)
+	}
+}
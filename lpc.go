@@ -0,0 +1,155 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"math"
+)
+
+// MaxLPCOrder is the largest LPC order allowed by the format.
+const maxLPCOrder = 32
+
+const (
+	// MinLPCPrecision and maxLPCPrecision are the bounds of the precision
+	// in bits of quantized LPC coefficients searched by the Encoder;
+	// the format cannot code a precision above maxLPCPrecision.
+	minLPCPrecision = 5
+	maxLPCPrecision = 15
+)
+
+// Autocorrelate returns the autocorrelation of the windowed samples
+// for lags 0 through maxLag.
+func autocorrelate(x []int32, window []float64, maxLag int) []float64 {
+	xw := make([]float64, len(x))
+	for i, s := range x {
+		xw[i] = float64(s) * window[i]
+	}
+	r := make([]float64, maxLag+1)
+	for lag := range r {
+		var sum float64
+		for i := lag; i < len(xw); i++ {
+			sum += xw[i] * xw[i-lag]
+		}
+		r[lag] = sum
+	}
+	return r
+}
+
+// LevinsonDurbin returns the linear prediction coefficients of each order
+// from 1 through len(r)-1, computed from the autocorrelation r, and the
+// prediction error of each order.  The coefficients of order o are lpc[o-1],
+// where lpc[o-1][j] multiplies the sample j+1 before the predicted one.
+func levinsonDurbin(r []float64) (lpc [][]float64, errs []float64) {
+	maxOrder := len(r) - 1
+	// A[j] is the coefficient of the sample j+1 before the predicted one.
+	a := make([]float64, maxOrder)
+	prev := make([]float64, maxOrder)
+	err := r[0]
+	for i := 0; i < maxOrder; i++ {
+		if err <= 0 {
+			// The signal is perfectly predicted; higher orders cannot help.
+			break
+		}
+		acc := r[i+1]
+		for j := 0; j < i; j++ {
+			acc -= a[j] * r[i-j]
+		}
+		k := acc / err
+		copy(prev, a[:i])
+		for j := 0; j < i; j++ {
+			a[j] = prev[j] - k*prev[i-1-j]
+		}
+		a[i] = k
+		err *= 1 - k*k
+		lpc = append(lpc, append([]float64(nil), a[:i+1]...))
+		errs = append(errs, err)
+	}
+	return lpc, errs
+}
+
+// LPCPrecision returns the precision in bits of quantized LPC coefficients
+// for a block size, as chosen by the reference encoder.
+func lpcPrecision(blockSize int) uint {
+	switch {
+	case blockSize <= 192:
+		return 7
+	case blockSize <= 384:
+		return 8
+	case blockSize <= 576:
+		return 9
+	case blockSize <= 1152:
+		return 10
+	case blockSize <= 2304:
+		return 11
+	case blockSize <= 4608:
+		return 12
+	}
+	return 13
+}
+
+// QuantizeLPC returns the coefficients quantized to precision bits and the
+// shift by which the prediction using them must be scaled down.
+func quantizeLPC(lpc []float64, precision uint) ([]int32, uint) {
+	var cmax float64
+	for _, c := range lpc {
+		cmax = math.Max(cmax, math.Abs(c))
+	}
+	if cmax == 0 {
+		return make([]int32, len(lpc)), 0
+	}
+	_, log2cmax := math.Frexp(cmax)
+	// The shift is coded in 5 signed bits, and decoders reject negative shifts.
+	shift := int(precision) - log2cmax - 1
+	if shift > 15 {
+		shift = 15
+	} else if shift < 0 {
+		shift = 0
+	}
+	qmax := float64(int32(1)<<(precision-1) - 1)
+	qmin := -qmax - 1
+	q := make([]int32, len(lpc))
+	// The rounding error of each coefficient is carried into the next.
+	var e float64
+	for i, c := range lpc {
+		e += c * float64(int32(1)<<uint(shift))
+		v := math.Floor(e + 0.5)
+		v = math.Max(qmin, math.Min(qmax, v))
+		q[i] = int32(v)
+		e -= v
+	}
+	return q, uint(shift)
+}
+
+// LPCResidual returns the residual of predicting x with the quantized
+// coefficients, following the len(q) warm-up samples.
+// It returns false if a residual does not fit in 32 bits.
+func lpcResidual(x, q []int32, shift uint) ([]int32, bool) {
+	res := make([]int32, len(x)-len(q))
+	for i := len(q); i < len(x); i++ {
+		var sum int64
+		for j, c := range q {
+			sum += int64(c) * int64(x[i-j-1])
+		}
+		r := int64(x[i]) - sum>>shift
+		if r < math.MinInt32/2 || r > math.MaxInt32/2 {
+			return nil, false
+		}
+		res[i-len(q)] = int32(r)
+	}
+	return res, true
+}
+
+// Tukey returns a Tukey window of n samples, tapered over the fraction p.
+func tukey(n int, p float64) []float64 {
+	w := make([]float64, n)
+	for i := range w {
+		w[i] = 1
+	}
+	taper := int(p / 2 * float64(n))
+	for i := 0; i < taper; i++ {
+		v := 0.5 - 0.5*math.Cos(math.Pi*float64(i)/float64(taper))
+		w[i] = v
+		w[n-1-i] = v
+	}
+	return w
+}
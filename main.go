@@ -1,5 +1,6 @@
 // © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
 
+//go:build ignore
 // +build ignore
 
 package main
@@ -8,19 +9,91 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 
 	"github.com/eaburns/flac"
+	"github.com/eaburns/flac/internal/batch"
+)
+
+var (
+	out     = flag.String("o", "out.wav", "the output WAV file, or - for standard output")
+	foreign = flag.Bool("keep-foreign-metadata", false, "restore WAV chunks preserved by flac --keep-foreign-metadata")
+	bext    = flag.Bool("bext", false, "write a Broadcast Wave bext chunk from BWF_ tags")
+	jobs    = flag.Int("j", runtime.NumCPU(), "the number of files to decode concurrently in batch mode")
 )
 
 func main() {
-	data, meta, err := flac.Decode(bufio.NewReader(os.Stdin))
-	if err != nil {
-		fmt.Println(err.Error())
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: main [-o out.wav] [in.flac]")
+		fmt.Fprintln(os.Stderr, "       main [-j N] files, directories, or patterns...")
+		fmt.Fprintln(os.Stderr, "The input is read from standard input if it is - or not given.")
+		fmt.Fprintln(os.Stderr, "Given multiple inputs or a directory, each .flac file is decoded")
+		fmt.Fprintln(os.Stderr, "to a .wav file beside it.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if !batchMode(flag.Args()) {
+		if err := decodeFile(flag.Arg(0), *out); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
 		return
 	}
-	writeWAV(data, meta)
+
+	files, err := batch.Files(flag.Args(), ".flac")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	errs := batch.Run(files, *jobs, func(_ int, path string) error {
+		return decodeFile(path, strings.TrimSuffix(path, filepath.Ext(path))+".wav")
+	})
+	batch.Summarize(os.Stderr, len(files), errs)
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
+}
+
+// BatchMode returns whether the arguments name more than a single file.
+func batchMode(args []string) bool {
+	if len(args) > 1 {
+		return true
+	}
+	if len(args) == 0 || args[0] == "-" {
+		return false
+	}
+	if info, err := os.Stat(args[0]); err == nil && info.IsDir() {
+		return true
+	}
+	return strings.ContainsAny(args[0], "*?[")
+}
+
+// DecodeFile decodes the FLAC file at path, or standard input if path is - or empty,
+// writing a WAV file to out.
+func decodeFile(path, out string) error {
+	in := io.Reader(os.Stdin)
+	if path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	data, meta, err := flac.Decode(bufio.NewReader(in))
+	if err != nil {
+		return err
+	}
+	return writeWAV(out, data, meta)
 }
 
 type wavFmt struct {
@@ -34,31 +107,104 @@ type wavFmt struct {
 
 const pcmFormat = 1
 
-func writeWAV(data []byte, meta flac.MetaData) {
+// WriteWAV writes a WAV file to path, or to standard output if path is -.
+// The whole file is assembled in memory, so the RIFF header sizes are known
+// before anything is written and the output need not be seekable.
+func writeWAV(path string, data []byte, meta flac.MetaData) error {
+	var wav []byte
+	if chunks := meta.ForeignChunks(flac.ForeignRIFF); *foreign && chunks != nil {
+		var err error
+		if wav, err = restoreWAV(chunks, data); err != nil {
+			return err
+		}
+	} else {
+		var err error
+		if wav, err = makeWAV(data, meta); err != nil {
+			return err
+		}
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = bufio.NewWriter(f)
+	}
+	w.Write(wav)
+	return w.Flush()
+}
+
+// RestoreWAV returns a WAV file made from the original file's chunks,
+// preserved by flac --keep-foreign-metadata, with the audio data following
+// the data chunk header.
+func restoreWAV(chunks [][]byte, data []byte) ([]byte, error) {
+	if len(chunks[0]) != 12 || string(chunks[0][:4]) != "RIFF" || string(chunks[0][8:]) != "WAVE" {
+		return nil, errors.New("bad foreign RIFF header")
+	}
+	wav := bytes.NewBuffer(nil)
+	var sawData bool
+	for _, c := range chunks {
+		wav.Write(c)
+		if len(c) != 8 || string(c[:4]) != "data" {
+			continue
+		}
+		if binary.LittleEndian.Uint32(c[4:]) != uint32(len(data)) {
+			return nil, errors.New("foreign data chunk size does not match the audio")
+		}
+		wav.Write(data)
+		if len(data)%2 == 1 {
+			wav.WriteByte(0)
+		}
+		sawData = true
+	}
+	if !sawData {
+		return nil, errors.New("foreign metadata has no data chunk")
+	}
+	return wav.Bytes(), nil
+}
+
+// MakeWAV returns a WAV file with a fmt chunk and the audio data.
+// With the -bext flag, a bext chunk is included if there are BWF_ tags.
+func makeWAV(data []byte, meta flac.MetaData) ([]byte, error) {
 	wdata := bytes.NewBuffer(nil)
 	wdata.WriteString("WAVE")
 
+	if *bext {
+		b, err := flac.BextFromComments(meta.VorbisComment)
+		if err != nil {
+			return nil, err
+		}
+		if b != nil {
+			chunk := b.Chunk()
+			wdata.WriteString(flac.BextChunkID)
+			binary.Write(wdata, binary.LittleEndian, uint32(len(chunk)))
+			wdata.Write(chunk)
+			if len(chunk)%2 == 1 {
+				wdata.WriteByte(0)
+			}
+		}
+	}
+
 	wdata.WriteString("fmt ")
 	binary.Write(wdata, binary.LittleEndian, uint32(16))
 	binary.Write(wdata, binary.LittleEndian, wavFmt{
 		format:        pcmFormat,
 		channels:      int16(meta.NChannels),
 		sampleRate:    int32(meta.SampleRate),
-		dataRate:      int32(meta.NChannels * meta.SampleRate * (meta.BitsPerSample / 8)),
-		dataBlockSize: int16(meta.NChannels * (meta.BitsPerSample / 8)),
+		dataRate:      int32(meta.NChannels * meta.SampleRate * ((meta.BitsPerSample + 7) / 8)),
+		dataBlockSize: int16(meta.NChannels * ((meta.BitsPerSample + 7) / 8)),
 		bitsPerSample: int16(meta.BitsPerSample),
 	})
 	wdata.WriteString("data")
 	binary.Write(wdata, binary.LittleEndian, uint32(len(data)))
 	wdata.Write(data)
 
-	wav, err := os.Create("out.wav")
-	if err != nil {
-		panic(err)
-	}
-	defer wav.Close()
-
+	wav := bytes.NewBuffer(nil)
 	wav.WriteString("RIFF")
 	binary.Write(wav, binary.LittleEndian, uint32(len(wdata.Bytes())))
 	wav.Write(wdata.Bytes())
+	return wav.Bytes(), nil
 }
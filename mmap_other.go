@@ -0,0 +1,20 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package flac
+
+import (
+	"errors"
+	"os"
+)
+
+// Mmap is not supported on this system, so OpenFile reads from the file.
+func mmap(f *os.File, size int64) ([]byte, error) {
+	return nil, errors.New("Memory mapping is not supported")
+}
+
+func munmap(data []byte) error {
+	return nil
+}
@@ -0,0 +1,207 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"strconv"
+)
+
+func init() {
+	features = append(features, FeatureOggEncode)
+}
+
+// OggPageSize is the size of page data at which an oggWriter starts a new page.
+// Pages may be larger, since a page holds all of a packet up to 255 segments.
+const oggPageSize = 4096
+
+// These are the flags of the header type of an Ogg page.
+const (
+	oggContinued = 1 << iota
+	oggBOS
+	oggEOS
+)
+
+// OggCRCTable is the table for the CRC-32 of Ogg pages,
+// with the polynomial 0x04C11DB7, unreflected.
+var oggCRCTable = func() (t [256]uint32) {
+	for i := range t {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04C11DB7
+			} else {
+				r <<= 1
+			}
+		}
+		t[i] = r
+	}
+	return t
+}()
+
+func oggCRC(data []byte) uint32 {
+	var crc uint32
+	for _, b := range data {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// An oggWriter writes packets to a logical Ogg bitstream,
+// following the FLAC-to-Ogg mapping.
+type oggWriter struct {
+	w      io.Writer
+	serial uint32
+	// Seq is the sequence number of the next page.
+	seq uint32
+	// Lacing and data are the lacing values and the data of the page being built.
+	lacing []byte
+	data   []byte
+	// Granule is the granule position of the page being built:
+	// the number of samples at the end of its last complete packet, or -1.
+	granule int64
+	// Continued is whether the page being built begins with
+	// the continuation of a packet from the previous page.
+	continued bool
+	// Headers is the number of header packets after the first.
+	headers int
+}
+
+func newOggWriter(w io.Writer) *oggWriter {
+	return &oggWriter{w: w, serial: rand.Uint32(), granule: -1}
+}
+
+// OggFirstPacket returns the first packet of an Ogg FLAC stream,
+// which maps the stream and holds the STREAMINFO block.
+// N is the number of header packets that follow it.
+func oggFirstPacket(streamInfo []byte, n int) ([]byte, error) {
+	if n >= 1<<16 {
+		return nil, errors.New("Too many metadata blocks for Ogg: " + strconv.Itoa(n))
+	}
+	p := []byte{0x7F, 'F', 'L', 'A', 'C', 1, 0, byte(n >> 8), byte(n)}
+	p = append(p, magic[:]...)
+	return append(p, streamInfo...), nil
+}
+
+// WriteHeaders writes the header packets of the stream, one for each
+// metadata block, setting the last-block flag of only the final block.
+// The first block must be STREAMINFO and the second VORBIS_COMMENT.
+// The first packet is alone on the first page, and the audio packets
+// begin on a new page.
+func (o *oggWriter) writeHeaders(blocks [][]byte) error {
+	for i, block := range blocks {
+		block[0] &^= 0x80
+		if i == len(blocks)-1 {
+			block[0] |= 0x80
+		}
+	}
+	o.headers = len(blocks) - 1
+	first, err := oggFirstPacket(blocks[0], o.headers)
+	if err != nil {
+		return err
+	}
+	if err := o.writePacket(first, 0); err != nil {
+		return err
+	}
+	if err := o.flush(oggBOS); err != nil {
+		return err
+	}
+	for _, block := range blocks[1:] {
+		if err := o.writePacket(block, 0); err != nil {
+			return err
+		}
+	}
+	return o.flush(0)
+}
+
+// WritePacket adds a packet to the stream, with the granule position
+// at its end, writing pages as they fill.  A full page is written only
+// when the next packet is added, so that the last page can be flushed
+// with the EOS flag.
+func (o *oggWriter) writePacket(p []byte, granule int64) error {
+	if len(o.data) >= oggPageSize {
+		if err := o.flush(0); err != nil {
+			return err
+		}
+	}
+	for {
+		if len(o.lacing) == 255 {
+			if err := o.flush(0); err != nil {
+				return err
+			}
+		}
+		n := len(p)
+		if n > 255 {
+			n = 255
+		}
+		o.lacing = append(o.lacing, byte(n))
+		o.data = append(o.data, p[:n]...)
+		p = p[n:]
+		// A lacing value less than 255, possibly 0, ends the packet.
+		if n < 255 {
+			break
+		}
+		if len(o.lacing) == 255 {
+			if err := o.flush(0); err != nil {
+				return err
+			}
+			o.continued = true
+		}
+	}
+	o.granule = granule
+	return nil
+}
+
+// Flush writes the page being built, if it has any data
+// or if flags is non-zero, with the given header type flags.
+func (o *oggWriter) flush(flags byte) error {
+	if len(o.lacing) == 0 && flags == 0 {
+		return nil
+	}
+	granule := o.granule
+	if flags&oggEOS != 0 && granule < 0 {
+		granule = 0
+	}
+	if o.continued {
+		flags |= oggContinued
+	}
+	page := oggPage(flags, granule, o.serial, o.seq, o.lacing, o.data)
+	if _, err := o.w.Write(page); err != nil {
+		return err
+	}
+	o.seq++
+	o.lacing = o.lacing[:0]
+	o.data = o.data[:0]
+	o.granule = -1
+	o.continued = false
+	return nil
+}
+
+// OggPage returns an Ogg page with the given header fields and contents.
+func oggPage(flags byte, granule int64, serial, seq uint32, lacing, data []byte) []byte {
+	page := make([]byte, 27, 27+len(lacing)+len(data))
+	copy(page, "OggS")
+	page[4] = 0 // Version.
+	page[5] = flags
+	binary.LittleEndian.PutUint64(page[6:], uint64(granule))
+	binary.LittleEndian.PutUint32(page[14:], serial)
+	binary.LittleEndian.PutUint32(page[18:], seq)
+	page[26] = byte(len(lacing))
+	page = append(page, lacing...)
+	page = append(page, data...)
+	binary.LittleEndian.PutUint32(page[22:], oggCRC(page))
+	return page
+}
+
+// FirstPage returns the first page of the stream, as written by writeHeaders,
+// but with the given STREAMINFO block.
+func (o *oggWriter) firstPage(streamInfo []byte) ([]byte, error) {
+	p, err := oggFirstPacket(streamInfo, o.headers)
+	if err != nil {
+		return nil, err
+	}
+	return oggPage(oggBOS, 0, o.serial, 0, []byte{byte(len(p))}, p), nil
+}
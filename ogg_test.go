@@ -0,0 +1,174 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// DecodeAll returns the samples of each channel of a stream, without checking MD5.
+func TestEncodeOgg(t *testing.T) {
+	data := testSignal(2, 20000, 16)
+	// The picture spans several pages.
+	pic := Picture{Type: PictureFrontCover, MIME: "image/png", Data: make([]byte, 100000)}
+	encode := func(w io.Writer) {
+		info := &StreamInfo{SampleRate: 44100, NChannels: 2, BitsPerSample: 16, TotalSamples: 20000}
+		meta := MetaData{StreamInfo: info, SeekTable: []SeekPoint{{}}, Pictures: []Picture{pic}}
+		e, err := NewEncoderOpts(w, meta, EncoderOptions{Ogg: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	encode(&buf)
+	f, err := ioutil.TempFile("", "flac-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	encode(f)
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	seekable, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name   string
+		stream []byte
+		md5    bool
+	}{
+		{"non-seekable", buf.Bytes(), false},
+		{"seekable", seekable, true},
+	} {
+		packets, granule, err := readOgg(test.stream)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if granule != 20000 {
+			t.Errorf("%s: final granule position %d, want 20000", test.name, granule)
+		}
+		first := packets[0]
+		if len(first) != 51 || string(first[:5]) != "\x7FFLAC" || first[5] != 1 || first[6] != 0 {
+			t.Errorf("%s: bad first packet % x", test.name, first)
+			continue
+		}
+		// STREAMINFO, VORBIS_COMMENT, PICTURE, and PADDING.
+		if n := int(binary.BigEndian.Uint16(first[7:])); n != 3 {
+			t.Errorf("%s: %d header packets, want 3", test.name, n)
+		}
+		// Reassemble a native FLAC stream.
+		native := append([]byte{}, first[9:]...)
+		for _, p := range packets[1:] {
+			native = append(native, p...)
+		}
+		got, meta, err := decodeAll(native)
+		if err != nil {
+			t.Errorf("%s: decoding failed: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, data) {
+			t.Errorf("%s: decoded audio differs", test.name)
+		}
+		if meta.SeekTable != nil {
+			t.Errorf("%s: got a SEEKTABLE", test.name)
+		}
+		if meta.VorbisComment == nil || meta.Vendor != vendor {
+			t.Errorf("%s: got VORBIS_COMMENT %+v, want vendor %q", test.name, meta.VorbisComment, vendor)
+		}
+		if len(meta.Pictures) != 1 || !bytes.Equal(meta.Pictures[0].Data, pic.Data) {
+			t.Errorf("%s: picture differs", test.name)
+		}
+		if hasMD5 := meta.MD5 != [16]byte{}; hasMD5 != test.md5 {
+			t.Errorf("%s: has MD5 %v, want %v", test.name, hasMD5, test.md5)
+		}
+	}
+
+	info := &StreamInfo{SampleRate: 44100, NChannels: 2, BitsPerSample: 16, TotalSamples: 20000}
+	opts := EncoderOptions{Ogg: true, SeekInterval: 1000}
+	if _, err := NewEncoderOpts(f, MetaData{StreamInfo: info}, opts); err == nil {
+		t.Errorf("Expected an error for a SEEKTABLE in Ogg")
+	}
+}
+
+// ReadOgg returns the packets of an Ogg stream with a single logical bitstream,
+// and the granule position of its last page, checking the page structure.
+func readOgg(stream []byte) ([][]byte, int64, error) {
+	var packets [][]byte
+	var packet []byte
+	var granule int64
+	partial := false
+	for seq := uint32(0); len(stream) > 0; seq++ {
+		if len(stream) < 27 || string(stream[:4]) != "OggS" {
+			return nil, 0, errors.New("bad page header")
+		}
+		nseg := int(stream[26])
+		if len(stream) < 27+nseg {
+			return nil, 0, errors.New("short page header")
+		}
+		lacing := stream[27 : 27+nseg]
+		size := 27 + nseg
+		for _, l := range lacing {
+			size += int(l)
+		}
+		if len(stream) < size {
+			return nil, 0, errors.New("short page")
+		}
+		page := append([]byte{}, stream[:size]...)
+		stream = stream[size:]
+
+		crc := binary.LittleEndian.Uint32(page[22:])
+		binary.LittleEndian.PutUint32(page[22:], 0)
+		if c := oggCRC(page); c != crc {
+			return nil, 0, errors.New("bad CRC in page " + strconv.Itoa(int(seq)))
+		}
+		flags := page[5]
+		if s := binary.LittleEndian.Uint32(page[18:]); s != seq {
+			return nil, 0, errors.New("page " + strconv.Itoa(int(seq)) + " has sequence number " + strconv.Itoa(int(s)))
+		}
+		if (flags&oggBOS != 0) != (seq == 0) {
+			return nil, 0, errors.New("bad BOS flag in page " + strconv.Itoa(int(seq)))
+		}
+		if (flags&oggEOS != 0) != (len(stream) == 0) {
+			return nil, 0, errors.New("bad EOS flag in page " + strconv.Itoa(int(seq)))
+		}
+		if (flags&oggContinued != 0) != partial {
+			return nil, 0, errors.New("bad continuation flag in page " + strconv.Itoa(int(seq)))
+		}
+		granule = int64(binary.LittleEndian.Uint64(page[6:]))
+		body := page[27+nseg:]
+		for _, l := range lacing {
+			packet = append(packet, body[:l]...)
+			body = body[l:]
+			if partial = l == 255; !partial {
+				packets = append(packets, packet)
+				packet = nil
+			}
+		}
+		if seq == 0 && (len(packets) != 1 || partial) {
+			return nil, 0, errors.New("the first page does not hold exactly the first packet")
+		}
+	}
+	if partial {
+		return nil, 0, errors.New("truncated packet")
+	}
+	return packets, granule, nil
+}
@@ -0,0 +1,112 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"errors"
+	"io"
+)
+
+// A PCMStream is an io.ReadSeeker over the decoded PCM audio of a FLAC
+// stream.  The audio is interleaved little-endian samples in the format
+// returned by Decoder.Next.  Seek is sample accurate, so a PCMStream can back
+// APIs expecting a seekable audio byte stream, such as http.ServeContent.
+type PCMStream struct {
+	d *Decoder
+	// Frame is the remaining audio of the current frame.
+	frame []byte
+	// Pos is the current byte offset in the audio.
+	pos int64
+}
+
+// NewPCMStream returns a PCMStream reading from d, which must be positioned at
+// its first frame.  Seeking requires that the reader given to NewDecoder is an
+// io.Seeker.
+func NewPCMStream(d *Decoder) *PCMStream {
+	return &PCMStream{d: d}
+}
+
+// SampleSize returns the number of bytes in each inter-channel sample.
+func (p *PCMStream) sampleSize() int64 {
+	bps := p.d.BitsPerSample
+	if p.d.opts.LeftJustify {
+		bps = 32
+	}
+	return int64(p.d.NChannels * ((bps + 7) / 8))
+}
+
+// Size returns the size of the audio in bytes, or -1 if it is unknown because
+// STREAMINFO does not specify the total number of samples.
+func (p *PCMStream) Size() int64 {
+	if p.d.TotalSamples == 0 {
+		return -1
+	}
+	return p.d.TotalSamples * p.sampleSize()
+}
+
+// Read reads decoded audio into b.
+func (p *PCMStream) Read(b []byte) (int, error) {
+	if size := p.Size(); size >= 0 && p.pos >= size {
+		return 0, io.EOF
+	}
+	for len(p.frame) == 0 {
+		frame, err := p.d.Next()
+		if err != nil {
+			return 0, err
+		}
+		p.frame = frame
+	}
+	n := copy(b, p.frame)
+	p.frame = p.frame[n:]
+	p.pos += int64(n)
+	return n, nil
+}
+
+// Seek sets the offset for the next Read to offset, interpreted according to whence:
+// 0 means relative to the start of the audio, 1 means relative to the current
+// offset, and 2 means relative to the end of the audio.
+// Seeking relative to the end requires the total number of samples to be known.
+// The offset is found with Decoder.Seek, decoding only the frame containing it;
+// an offset at or past the end, if it is known, decodes nothing,
+// and subsequent reads return io.EOF.
+func (p *PCMStream) Seek(offset int64, whence int) (int64, error) {
+	size := p.Size()
+	switch whence {
+	case 0:
+	case 1:
+		offset += p.pos
+	case 2:
+		if size < 0 {
+			return 0, errors.New("Cannot seek relative to the end: the total number of samples is unknown")
+		}
+		offset += size
+	default:
+		return 0, errors.New("Bad whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("Seek to a negative offset")
+	}
+	if offset == p.pos {
+		return offset, nil
+	}
+	p.frame = nil
+	if size >= 0 && offset >= size {
+		p.pos = offset
+		return offset, nil
+	}
+
+	n := p.sampleSize()
+	if _, err := p.d.Seek(offset/n, 0); err != nil {
+		return 0, err
+	}
+	p.pos = offset
+	if rem := offset % n; rem > 0 {
+		// The offset is within a sample; drop the bytes before it.
+		frame, err := p.d.Next()
+		if err != nil {
+			return 0, err
+		}
+		p.frame = frame[rem:]
+	}
+	return offset, nil
+}
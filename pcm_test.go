@@ -0,0 +1,128 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestPCMStream(t *testing.T) {
+	d, err := NewDecoder(bytes.NewReader(twoFrameStream))
+	if err != nil {
+		t.Fatalf("Unexpected error making a new decoder: %v", err)
+	}
+	p := NewPCMStream(d)
+	if sz := p.Size(); sz != 768 {
+		t.Errorf("Expected size 768, got %d", sz)
+	}
+
+	tests := []struct {
+		offset int64
+		whence int
+		want   []byte
+	}{
+		{0, 0, []byte{5, 7, 5}},
+		{401, 0, []byte{8, 6, 8}},
+		{-3, 2, []byte{8, 6, 8}},
+		{-386, 1, []byte{5, 7, 6}},
+		{768, 0, []byte{}},
+	}
+	for _, test := range tests {
+		if _, err := p.Seek(test.offset, test.whence); err != nil {
+			t.Errorf("Unexpected error seeking to %d, %d: %v", test.offset, test.whence, err)
+			continue
+		}
+		got := make([]byte, len(test.want))
+		if _, err := io.ReadFull(p, got); err != nil {
+			t.Errorf("Unexpected error reading after seeking to %d, %d: %v", test.offset, test.whence, err)
+			continue
+		}
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("After seeking to %d, %d, expected %v, got %v", test.offset, test.whence, test.want, got)
+		}
+	}
+
+	// Seeking uses the total samples and Decoder.Seek, rather than decoding
+	// the stream from the start.
+	data := testSignal(2, 200000, 16)
+	pcm, err := interleave(data, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := encodeFile(t, data, EncoderOptions{SeekInterval: 4096})
+	r := &readCounter{r: bytes.NewReader(stream)}
+	if d, err = NewDecoder(r); err != nil {
+		t.Fatal(err)
+	}
+	p = NewPCMStream(d)
+	n := r.n
+	if _, err := p.Seek(0, 2); err != nil {
+		t.Fatal(err)
+	}
+	if r.n != n {
+		t.Errorf("Seeking to the end read %d bytes", r.n-n)
+	}
+	if m, err := p.Read(make([]byte, 1)); m != 0 || err != io.EOF {
+		t.Errorf("Reading at the end got %d bytes, error %v, want io.EOF", m, err)
+	}
+	for _, off := range []int64{-7, -400001, -int64(len(pcm)) + 3} {
+		n := r.n
+		if _, err := p.Seek(off, 2); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, 5)
+		if _, err := io.ReadFull(p, got); err != nil {
+			t.Fatalf("Reading after seeking to %d from the end: %v", off, err)
+		}
+		want := pcm[int64(len(pcm))+off:][:5]
+		if !bytes.Equal(got, want) {
+			t.Errorf("After seeking to %d from the end, expected %v, got %v", off, want, got)
+		}
+		if r.n-n > int64(len(stream)/4) {
+			t.Errorf("Seeking to %d from the end read %d of %d bytes", off, r.n-n, len(stream))
+		}
+	}
+
+	// Samples of 12 and 20 bits are padded to 2 and 3 bytes.
+	for _, bps := range []int{12, 20} {
+		data := testSignal(2, 10000, bps)
+		pcm, err := interleave(data, bps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info := &StreamInfo{SampleRate: 44100, NChannels: 2, BitsPerSample: bps, TotalSamples: 10000}
+		var buf bytes.Buffer
+		e, err := NewEncoder(&buf, MetaData{StreamInfo: info})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+		d, err := NewDecoder(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := NewPCMStream(d)
+		if size := p.Size(); size != int64(len(pcm)) {
+			t.Errorf("%d bits: got size %d, want %d", bps, size, len(pcm))
+		}
+		for _, off := range []int64{-5, -int64(len(pcm)) / 2} {
+			if _, err := p.Seek(off, 2); err != nil {
+				t.Fatal(err)
+			}
+			got := make([]byte, 5)
+			if _, err := io.ReadFull(p, got); err != nil {
+				t.Fatalf("%d bits: reading after seeking to %d from the end: %v", bps, off, err)
+			}
+			if want := pcm[int64(len(pcm))+off:][:5]; !bytes.Equal(got, want) {
+				t.Errorf("%d bits: after seeking to %d from the end, expected %v, got %v", bps, off, want, got)
+			}
+		}
+	}
+}
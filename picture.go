@@ -0,0 +1,310 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// A Picture is a PICTURE metadata block, usually cover art.
+type Picture struct {
+	Type PictureType
+	// MIME is the MIME type of the data, or "-->" if Data is a URL.
+	MIME        string
+	Description string
+	// Width and Height are the size of the picture in pixels,
+	// Depth is its color depth in bits per pixel,
+	// and Colors is the number of colors of an indexed picture, or 0.
+	Width, Height, Depth, Colors int
+	Data                         []byte
+}
+
+// A PictureType is the kind of a picture, as in the ID3v2 APIC frame.
+type PictureType uint32
+
+// These are the picture types.
+const (
+	PictureOther PictureType = iota
+	PictureFileIcon
+	PictureOtherFileIcon
+	PictureFrontCover
+	PictureBackCover
+	PictureLeaflet
+	PictureMedia
+	PictureLeadArtist
+	PictureArtist
+	PictureConductor
+	PictureBand
+	PictureComposer
+	PictureLyricist
+	PictureRecordingLocation
+	PictureDuringRecording
+	PictureDuringPerformance
+	PictureScreenCapture
+	PictureBrightFish
+	PictureIllustration
+	PictureBandLogo
+	PicturePublisherLogo
+)
+
+// PictureComment is the Vorbis comment key used by some taggers to store
+// a base64-encoded PICTURE block in place of a real one.
+const PictureComment = "METADATA_BLOCK_PICTURE"
+
+func readPicture(r io.Reader) (Picture, error) {
+	var p Picture
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return p, err
+	}
+	u32 := func() uint32 {
+		if len(data) < 4 {
+			err = errors.New("PICTURE block is too short")
+			return 0
+		}
+		n := binary.BigEndian.Uint32(data)
+		data = data[4:]
+		return n
+	}
+	bytes := func() []byte {
+		n := u32()
+		if err == nil && uint32(len(data)) < n {
+			err = errors.New("PICTURE block is too short")
+		}
+		if err != nil {
+			return nil
+		}
+		b := data[:n]
+		data = data[n:]
+		return b
+	}
+	p.Type = PictureType(u32())
+	p.MIME = string(bytes())
+	p.Description = string(bytes())
+	p.Width = int(u32())
+	p.Height = int(u32())
+	p.Depth = int(u32())
+	p.Colors = int(u32())
+	p.Data = bytes()
+	return p, err
+}
+
+func encodePicture(p Picture) []byte {
+	b := bytes.NewBuffer(nil)
+	u32 := func(n uint32) { binary.Write(b, binary.BigEndian, n) }
+	u32(uint32(p.Type))
+	u32(uint32(len(p.MIME)))
+	b.WriteString(p.MIME)
+	u32(uint32(len(p.Description)))
+	b.WriteString(p.Description)
+	u32(uint32(p.Width))
+	u32(uint32(p.Height))
+	u32(uint32(p.Depth))
+	u32(uint32(p.Colors))
+	u32(uint32(len(p.Data)))
+	b.Write(p.Data)
+	return b.Bytes()
+}
+
+// Image decodes the picture, which must be a PNG, JPEG, or GIF image.
+// If the picture's Width and Height are not zero, they must match those
+// of the image; if they do not, the image is returned along with an error,
+// since the image itself is still usable.
+func (p Picture) Image() (image.Image, error) {
+	if p.MIME == "-->" {
+		return nil, errors.New("Picture is a URL: " + string(p.Data))
+	}
+	img, _, err := image.Decode(bytes.NewReader(p.Data))
+	if err != nil {
+		return nil, errors.New("Failed to decode picture: " + err.Error())
+	}
+	if p.Width == 0 && p.Height == 0 {
+		return img, nil
+	}
+	if b := img.Bounds(); b.Dx() != p.Width || b.Dy() != p.Height {
+		return img, errors.New("Picture size " + strconv.Itoa(p.Width) + "x" + strconv.Itoa(p.Height) +
+			" does not match the image size " + strconv.Itoa(b.Dx()) + "x" + strconv.Itoa(b.Dy()))
+	}
+	return img, nil
+}
+
+// CommentPictures returns the pictures stored base64-encoded in
+// METADATA_BLOCK_PICTURE Vorbis comments.
+func (c *VorbisComment) CommentPictures() ([]Picture, error) {
+	var pics []Picture
+	for _, s := range c.Get(PictureComment) {
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+		if err != nil {
+			return nil, errors.New("Bad " + PictureComment + " comment: " + err.Error())
+		}
+		p, err := readPicture(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		pics = append(pics, p)
+	}
+	return pics, nil
+}
+
+// AllPictures returns the pictures of both the PICTURE blocks and the
+// METADATA_BLOCK_PICTURE Vorbis comments, in that order.
+func (m MetaData) AllPictures() ([]Picture, error) {
+	pics := append([]Picture(nil), m.Pictures...)
+	if m.VorbisComment == nil {
+		return pics, nil
+	}
+	cpics, err := m.CommentPictures()
+	return append(pics, cpics...), err
+}
+
+// MigratePictures moves the pictures stored in METADATA_BLOCK_PICTURE
+// Vorbis comments to Pictures, so that they are written as PICTURE blocks
+// by Retag.  If a comment cannot be decoded, an error is returned and
+// the metadata is unchanged.
+func (m *MetaData) MigratePictures() error {
+	if m.VorbisComment == nil {
+		return nil
+	}
+	pics, err := m.CommentPictures()
+	if err != nil || len(pics) == 0 {
+		return err
+	}
+	m.Pictures = append(m.Pictures, pics...)
+	var cmnts []string
+	for _, c := range m.Comments {
+		if i := strings.IndexByte(c, '='); i < 0 || !strings.EqualFold(c[:i], PictureComment) {
+			cmnts = append(cmnts, c)
+		}
+	}
+	m.Comments = cmnts
+	return nil
+}
+
+// AddPicture appends a picture of the given type to Pictures, from the data
+// of an image file.  If mime is empty, it is determined from the data,
+// which must then be a PNG, JPEG, or GIF image.  The Width, Height, Depth,
+// and Colors of the picture are filled in for these formats.
+func (m *MetaData) AddPicture(typ PictureType, mime, desc string, data []byte) error {
+	p := Picture{Type: typ, MIME: mime, Description: desc, Data: data}
+	if mime != "-->" {
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+		switch {
+		case err != nil && mime == "":
+			return errors.New("Unknown picture format: " + err.Error())
+		case err == nil:
+			if mime == "" {
+				p.MIME = "image/" + format
+			}
+			p.Width, p.Height = cfg.Width, cfg.Height
+			p.Depth, p.Colors = pictureDepth(cfg.ColorModel, format, data)
+		}
+	}
+	m.Pictures = append(m.Pictures, p)
+	return nil
+}
+
+// AddImage appends a picture of the given type to Pictures,
+// encoding img as a PNG image.
+func (m *MetaData) AddImage(typ PictureType, desc string, img image.Image) error {
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		return err
+	}
+	return m.AddPicture(typ, "image/png", desc, b.Bytes())
+}
+
+// SetPicture replaces the pictures of p's type with p, putting it in place
+// of the first of them, or appends p to Pictures if there are none.
+// With EditMetaData, it replaces the cover art of a file in place:
+//
+//	err := flac.EditMetaData(f, func(m *flac.MetaData) error {
+//		m.SetPicture(cover)
+//		return nil
+//	})
+//
+// With Retag, it replaces the cover art while copying a stream.
+func (m *MetaData) SetPicture(p Picture) {
+	var pics []Picture
+	set := false
+	for _, q := range m.Pictures {
+		switch {
+		case q.Type != p.Type:
+			pics = append(pics, q)
+		case !set:
+			pics = append(pics, p)
+			set = true
+		}
+	}
+	if !set {
+		pics = append(pics, p)
+	}
+	m.Pictures = pics
+}
+
+// RemovePictures removes the pictures of the given type from Pictures,
+// and returns the number removed.
+func (m *MetaData) RemovePictures(typ PictureType) int {
+	var pics []Picture
+	for _, p := range m.Pictures {
+		if p.Type != typ {
+			pics = append(pics, p)
+		}
+	}
+	n := len(m.Pictures) - len(pics)
+	m.Pictures = pics
+	return n
+}
+
+// PictureDepth returns the depth in bits per pixel and the number of colors
+// of an image with the given color model, format, and data.
+func pictureDepth(model color.Model, format string, data []byte) (depth, colors int) {
+	if pal, ok := model.(color.Palette); ok {
+		colors = len(pal)
+		for 1<<uint(depth) < colors {
+			depth++
+		}
+	}
+	if format == "png" && len(data) >= 26 {
+		// The decoder's color model does not distinguish RGB from RGBA,
+		// so the depth is taken from the bit depth and color type of the IHDR chunk.
+		bits := int(data[24])
+		switch data[25] {
+		case 0, 3: // Gray, indexed.
+			return bits, colors
+		case 2: // RGB.
+			return 3 * bits, colors
+		case 4: // Gray and alpha.
+			return 2 * bits, colors
+		case 6: // RGBA.
+			return 4 * bits, colors
+		}
+	}
+	if colors > 0 {
+		return depth, colors
+	}
+	switch model {
+	case color.GrayModel:
+		depth = 8
+	case color.Gray16Model:
+		depth = 16
+	case color.YCbCrModel:
+		depth = 24
+	case color.RGBAModel, color.NRGBAModel, color.CMYKModel:
+		depth = 32
+	case color.RGBA64Model, color.NRGBA64Model:
+		depth = 64
+	}
+	return depth, colors
+}
@@ -0,0 +1,225 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestPictures(t *testing.T) {
+	pic := Picture{
+		Type:        PictureFrontCover,
+		MIME:        "image/png",
+		Description: "cover",
+		Width:       1,
+		Height:      2,
+		Depth:       24,
+		Data:        []byte{1, 2, 3},
+	}
+	cmnt := "METADATA_BLOCK_PICTURE=" + base64.StdEncoding.EncodeToString(encodePicture(pic))
+	var out bytes.Buffer
+	err := Retag(&out, bytes.NewReader(constantStream), func(m *MetaData) error {
+		m.VorbisComment = &VorbisComment{Comments: []string{"TITLE=x", cmnt}}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Retag failed: %v", err)
+	}
+	d := mustDecoder(t, out.Bytes())
+	pics, err := d.AllPictures()
+	if err != nil || len(pics) != 1 || !reflect.DeepEqual(pics[0], pic) {
+		t.Fatalf("Expected %+v, got %+v, %v", pic, pics, err)
+	}
+
+	// Migrated pictures become PICTURE blocks.
+	var migrated bytes.Buffer
+	err = Retag(&migrated, bytes.NewReader(out.Bytes()), func(m *MetaData) error {
+		return m.MigratePictures()
+	})
+	if err != nil {
+		t.Fatalf("Retag failed: %v", err)
+	}
+	d = mustDecoder(t, migrated.Bytes())
+	if len(d.Pictures) != 1 || !reflect.DeepEqual(d.Pictures[0], pic) {
+		t.Errorf("Expected a PICTURE block %+v, got %+v", pic, d.Pictures)
+	}
+	if len(d.Comments) != 1 || d.Comments[0] != "TITLE=x" {
+		t.Errorf("Expected only TITLE=x, got %v", d.Comments)
+	}
+}
+
+func TestAddPicture(t *testing.T) {
+	rect := image.Rect(0, 0, 3, 2)
+	encode := func(img image.Image, enc func(io.Writer, image.Image) error) []byte {
+		var b bytes.Buffer
+		if err := enc(&b, img); err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}
+	encodePNG := func(w io.Writer, img image.Image) error { return png.Encode(w, img) }
+	encodeJPEG := func(w io.Writer, img image.Image) error { return jpeg.Encode(w, img, nil) }
+	encodeGIF := func(w io.Writer, img image.Image) error { return gif.Encode(w, img, nil) }
+	opaque := image.NewNRGBA(rect)
+	for i := 3; i < len(opaque.Pix); i += 4 {
+		opaque.Pix[i] = 0xFF
+	}
+	pal := color.Palette{color.Black, color.White, color.Gray{0x80}}
+
+	tests := []struct {
+		mime string
+		data []byte
+		want Picture
+	}{
+		{"", encode(image.NewNRGBA(rect), encodePNG), Picture{MIME: "image/png", Width: 3, Height: 2, Depth: 32}},
+		{"", encode(opaque, encodePNG), Picture{MIME: "image/png", Width: 3, Height: 2, Depth: 24}},
+		{"", encode(image.NewGray(rect), encodePNG), Picture{MIME: "image/png", Width: 3, Height: 2, Depth: 8}},
+		{"", encode(image.NewGray16(rect), encodePNG), Picture{MIME: "image/png", Width: 3, Height: 2, Depth: 16}},
+		{"", encode(image.NewPaletted(rect, pal), encodePNG), Picture{MIME: "image/png", Width: 3, Height: 2, Depth: 2, Colors: 3}},
+		{"", encode(opaque, encodeJPEG), Picture{MIME: "image/jpeg", Width: 3, Height: 2, Depth: 24}},
+		{"", encode(image.NewGray(rect), encodeJPEG), Picture{MIME: "image/jpeg", Width: 3, Height: 2, Depth: 8}},
+		{"", encode(image.NewPaletted(rect, pal), encodeGIF), Picture{MIME: "image/gif", Width: 3, Height: 2, Depth: 2, Colors: 4}},
+		{"image/x-unknown", []byte("not an image"), Picture{MIME: "image/x-unknown"}},
+		{"-->", []byte("http://example.com/cover.png"), Picture{MIME: "-->"}},
+	}
+	for _, test := range tests {
+		var m MetaData
+		if err := m.AddPicture(PictureFrontCover, test.mime, "desc", test.data); err != nil {
+			t.Errorf("%+v: AddPicture failed: %v", test.want, err)
+			continue
+		}
+		test.want.Type = PictureFrontCover
+		test.want.Description = "desc"
+		test.want.Data = test.data
+		if len(m.Pictures) != 1 || !reflect.DeepEqual(m.Pictures[0], test.want) {
+			t.Errorf("got %+v, want %+v", m.Pictures, test.want)
+		}
+	}
+
+	var m MetaData
+	if err := m.AddPicture(PictureFrontCover, "", "", []byte("not an image")); err == nil {
+		t.Errorf("Unknown format: expected an error")
+	}
+	if err := m.AddImage(PictureBackCover, "back", opaque); err != nil {
+		t.Fatalf("AddImage failed: %v", err)
+	}
+	m.StreamInfo = &StreamInfo{SampleRate: 44100, NChannels: 1, BitsPerSample: 16}
+	var buf bytes.Buffer
+	e, err := NewEncoder(&buf, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_, got, err := decodeAll(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Pictures, m.Pictures) {
+		t.Errorf("got pictures %+v, want %+v", got.Pictures, m.Pictures)
+	}
+	if p := got.Pictures[0]; p.MIME != "image/png" || p.Width != 3 || p.Height != 2 || p.Depth != 24 {
+		t.Errorf("got picture %+v, want a 3x2 24-bit PNG", p)
+	}
+}
+
+func TestPictureImage(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 3, 2))
+	src.Pix[4] = 0x80
+	var b bytes.Buffer
+	if err := png.Encode(&b, src); err != nil {
+		t.Fatal(err)
+	}
+	var m MetaData
+	if err := m.AddPicture(PictureFrontCover, "", "", b.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	p := m.Pictures[0]
+	img, err := p.Image()
+	if err != nil {
+		t.Fatalf("Image failed: %v", err)
+	}
+	if img.Bounds() != src.Bounds() || img.At(1, 1) != src.At(1, 1) {
+		t.Errorf("got image %v, want %v", img, src)
+	}
+
+	p.Width = 4
+	if img, err := p.Image(); err == nil || img == nil {
+		t.Errorf("wrong width: got %v, %v, want the image and an error", img, err)
+	}
+	p.Width, p.Height = 0, 0
+	if _, err := p.Image(); err != nil {
+		t.Errorf("unknown size: %v", err)
+	}
+	for _, p := range []Picture{{MIME: "-->", Data: []byte("http://example.com/a.png")}, {MIME: "image/png", Data: []byte("junk")}} {
+		if _, err := p.Image(); err == nil {
+			t.Errorf("%s: expected an error", p.Data)
+		}
+	}
+}
+
+func TestSetPicture(t *testing.T) {
+	front := Picture{Type: PictureFrontCover, MIME: "image/x-test", Data: []byte("front")}
+	back := Picture{Type: PictureBackCover, MIME: "image/x-test", Data: []byte("back")}
+	newFront := Picture{Type: PictureFrontCover, MIME: "image/x-test", Data: []byte("new front")}
+
+	m := MetaData{Pictures: []Picture{back, front, front}}
+	m.SetPicture(newFront)
+	if want := []Picture{back, newFront}; !reflect.DeepEqual(m.Pictures, want) {
+		t.Errorf("got pictures %+v, want %+v", m.Pictures, want)
+	}
+	m = MetaData{Pictures: []Picture{back}}
+	m.SetPicture(front)
+	if want := []Picture{back, front}; !reflect.DeepEqual(m.Pictures, want) {
+		t.Errorf("got pictures %+v, want %+v", m.Pictures, want)
+	}
+	if n := m.RemovePictures(PictureBackCover); n != 1 || !reflect.DeepEqual(m.Pictures, []Picture{front}) {
+		t.Errorf("RemovePictures removed %d, left %+v", n, m.Pictures)
+	}
+	if n := m.RemovePictures(PictureBackCover); n != 0 {
+		t.Errorf("RemovePictures removed %d, want 0", n)
+	}
+
+	// Replace the cover of a file in place, using its padding.
+	stream := encodeFile(t, testSignal(1, 5000, 16), EncoderOptions{})
+	tmp, err := ioutil.TempFile("", "flac-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := tmp.Write(stream); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []Picture{front, newFront} {
+		if err := EditMetaData(tmp, func(m *MetaData) error { m.SetPicture(p); return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(file) != len(stream) {
+		t.Errorf("file size changed from %d to %d", len(stream), len(file))
+	}
+	_, meta, err := Decode(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(meta.Pictures, []Picture{newFront}) {
+		t.Errorf("got pictures %+v, want %+v", meta.Pictures, []Picture{newFront})
+	}
+}
@@ -0,0 +1,244 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// A Playlist decodes an ordered sequence of FLAC streams as one continuous,
+// gapless timeline of audio.  All of the streams must have the same sample
+// rate, number of channels, and bits per sample.
+//
+// As the end of a track nears, the Playlist opens the next track and reads its
+// header in the background, so there is no pause between tracks.
+type Playlist struct {
+	open   func(int) (io.ReadSeeker, error)
+	tracks []Track
+
+	// Cur is the index of the current track.
+	cur int
+	r   io.ReadSeeker
+	d   *Decoder
+
+	// Prefetch, if non-nil, receives the opened next track.
+	prefetch chan openedTrack
+}
+
+// A Track is a single stream of a Playlist.
+type Track struct {
+	// Start is the number of the track's first sample on the Playlist's timeline.
+	Start int64
+	// Samples is the number of inter-channel samples in the track.
+	Samples int64
+	// StreamInfo is the track's STREAMINFO.
+	*StreamInfo
+}
+
+type openedTrack struct {
+	r   io.ReadSeeker
+	d   *Decoder
+	err error
+}
+
+// NewPlaylist returns a Playlist of n tracks.
+// The open function is called to open the ith track, and may be called more
+// than once for each track.  If the returned reader implements io.Closer, it
+// is closed when the Playlist is finished with it.
+//
+// The header of each track is read by NewPlaylist to build the timeline,
+// so every track must specify its total number of samples.
+func NewPlaylist(n int, open func(i int) (io.ReadSeeker, error)) (*Playlist, error) {
+	if n == 0 {
+		return nil, errors.New("Empty playlist")
+	}
+	p := &Playlist{open: open, tracks: make([]Track, n)}
+	var start int64
+	for i := range p.tracks {
+		t := p.openTrack(i, Options{DeferMetaData: true})
+		if t.err != nil {
+			return nil, t.err
+		}
+		closeReader(t.r)
+		info := t.d.StreamInfo
+		if info.TotalSamples == 0 {
+			return nil, errors.New("Playlist track has an unknown number of samples")
+		}
+		if i > 0 {
+			first := p.tracks[0]
+			if info.SampleRate != first.SampleRate || info.NChannels != first.NChannels || info.BitsPerSample != first.BitsPerSample {
+				return nil, errors.New("Playlist tracks have differing formats")
+			}
+		}
+		p.tracks[i] = Track{Start: start, Samples: info.TotalSamples, StreamInfo: info}
+		start += info.TotalSamples
+	}
+
+	t := p.openTrack(0, Options{})
+	if t.err != nil {
+		return nil, t.err
+	}
+	p.r, p.d = t.r, t.d
+	return p, nil
+}
+
+// OpenPlaylist returns a Playlist of the FLAC files at the given paths.
+func OpenPlaylist(paths ...string) (*Playlist, error) {
+	return NewPlaylist(len(paths), func(i int) (io.ReadSeeker, error) {
+		return os.Open(paths[i])
+	})
+}
+
+func (p *Playlist) openTrack(i int, opts Options) openedTrack {
+	r, err := p.open(i)
+	if err != nil {
+		return openedTrack{err: err}
+	}
+	d, err := NewDecoderOpts(r, opts)
+	if err != nil {
+		closeReader(r)
+		return openedTrack{err: err}
+	}
+	return openedTrack{r: r, d: d}
+}
+
+func closeReader(r io.Reader) error {
+	if c, ok := r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Tracks returns the tracks of the Playlist, with their positions on the timeline.
+func (p *Playlist) Tracks() []Track {
+	return p.tracks
+}
+
+// Track returns the index of the track containing the next sample returned by Next.
+func (p *Playlist) Track() int {
+	return p.cur
+}
+
+// Pos returns the position on the timeline of the next sample returned by Next.
+func (p *Playlist) Pos() int64 {
+	if p.d == nil {
+		return p.tracks[len(p.tracks)-1].Start + p.tracks[len(p.tracks)-1].Samples
+	}
+	return p.tracks[p.cur].Start + p.d.Pos()
+}
+
+// Next returns the audio data from the next frame on the timeline.
+// At the end of a track, Next continues with the first frame of the next track.
+// At the end of the last track, Next returns io.EOF.
+func (p *Playlist) Next() ([]byte, error) {
+	for p.d != nil {
+		data, err := p.d.Next()
+		if err == io.EOF {
+			if err := p.nextTrack(); err != nil {
+				return nil, err
+			}
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if p.prefetch == nil && p.cur+1 < len(p.tracks) && p.d.samples+int64(p.d.MaxBlock) >= p.tracks[p.cur].Samples {
+			p.startPrefetch()
+		}
+		return data, nil
+	}
+	return nil, io.EOF
+}
+
+// StartPrefetch begins opening the next track in the background.
+func (p *Playlist) startPrefetch() {
+	p.prefetch = make(chan openedTrack, 1)
+	go func(i int, c chan<- openedTrack) {
+		c <- p.openTrack(i, Options{})
+	}(p.cur+1, p.prefetch)
+}
+
+// NextTrack closes the current track and moves to the next,
+// using the prefetched track if there is one.
+func (p *Playlist) nextTrack() error {
+	closeReader(p.r)
+	p.r, p.d = nil, nil
+	p.cur++
+	if p.cur == len(p.tracks) {
+		p.cur--
+		return nil
+	}
+	var t openedTrack
+	if p.prefetch != nil {
+		t = <-p.prefetch
+		p.prefetch = nil
+	} else {
+		t = p.openTrack(p.cur, Options{})
+	}
+	if t.err != nil {
+		return t.err
+	}
+	p.r, p.d = t.r, t.d
+	return nil
+}
+
+// Seek positions the Playlist so that the audio returned by the next call to
+// Next begins with the given sample on the timeline, which may be in any track.
+// The offset is in inter-channel samples, interpreted according to whence:
+// 0 means relative to the start of the timeline, 1 means relative to
+// the current position, and 2 means relative to the end.
+// Seek returns the new position.
+func (p *Playlist) Seek(offset int64, whence int) (int64, error) {
+	last := p.tracks[len(p.tracks)-1]
+	switch whence {
+	case 0:
+	case 1:
+		offset += p.Pos()
+	case 2:
+		offset += last.Start + last.Samples
+	default:
+		return 0, errors.New("Bad whence")
+	}
+	if offset < 0 || offset >= last.Start+last.Samples {
+		return 0, errors.New("Seek outside of the playlist")
+	}
+
+	i := len(p.tracks) - 1
+	for i > 0 && offset < p.tracks[i].Start {
+		i--
+	}
+	if i != p.cur || p.d == nil {
+		p.cancelPrefetch()
+		closeReader(p.r)
+		p.r, p.d = nil, nil
+		t := p.openTrack(i, Options{})
+		if t.err != nil {
+			return 0, t.err
+		}
+		p.cur, p.r, p.d = i, t.r, t.d
+	}
+	if err := p.d.seekSample(offset - p.tracks[i].Start); err != nil {
+		return 0, err
+	}
+	return offset, nil
+}
+
+// CancelPrefetch waits for and closes any prefetched track.
+func (p *Playlist) cancelPrefetch() {
+	if p.prefetch == nil {
+		return
+	}
+	if t := <-p.prefetch; t.err == nil {
+		closeReader(t.r)
+	}
+	p.prefetch = nil
+}
+
+// Close closes any open tracks.
+func (p *Playlist) Close() error {
+	p.cancelPrefetch()
+	err := closeReader(p.r)
+	p.r, p.d = nil, nil
+	return err
+}
@@ -0,0 +1,62 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestPlaylist(t *testing.T) {
+	streams := [][]byte{twoFrameStream, constantStream}
+	p, err := NewPlaylist(len(streams), func(i int) (io.ReadSeeker, error) {
+		return bytes.NewReader(streams[i]), nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error making a new playlist: %v", err)
+	}
+	defer p.Close()
+
+	if ts := p.Tracks(); len(ts) != 2 || ts[1].Start != 384 || ts[1].Samples != 192 {
+		t.Errorf("Unexpected tracks: %+v", ts)
+	}
+
+	var n int
+	for {
+		data, err := p.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Unexpected error decoding the playlist: %v", err)
+		}
+		n += len(data)
+	}
+	if n != 2*(384+192) {
+		t.Errorf("Expected %d bytes, got %d", 2*(384+192), n)
+	}
+
+	if _, err := p.Seek(500, 0); err != nil {
+		t.Fatalf("Unexpected error seeking: %v", err)
+	}
+	if p.Track() != 1 || p.Pos() != 500 {
+		t.Errorf("Expected track 1 at 500, got track %d at %d", p.Track(), p.Pos())
+	}
+	data, err := p.Next()
+	if err != nil {
+		t.Fatalf("Unexpected error decoding after seeking: %v", err)
+	}
+	if len(data) != 2*(576-500) || data[0] != 5 || data[1] != 7 {
+		t.Errorf("Unexpected data after seeking: %v", data)
+	}
+
+	if _, err := p.Seek(-376, 2); err != nil {
+		t.Fatalf("Unexpected error seeking: %v", err)
+	}
+	if data, err = p.Next(); err != nil {
+		t.Fatalf("Unexpected error decoding after seeking: %v", err)
+	}
+	if p.Track() != 0 || len(data) != 2*(384-200) || data[0] != 6 || data[1] != 8 {
+		t.Errorf("Unexpected data after seeking: %v", data)
+	}
+}
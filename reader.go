@@ -0,0 +1,90 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"io"
+	"io/ioutil"
+)
+
+// A countingReader is an io.Reader that counts the bytes read through it.
+// It also supports peeking at upcoming bytes without consuming them.
+type countingReader struct {
+	r io.Reader
+	// N is the number of bytes read.
+	n int64
+	// Peeked holds bytes that have been read from r by peek but not yet consumed by Read.
+	peeked []byte
+	// Seeker, if non-nil, is r, which is known to support seeking.
+	// Readers such as pipes may implement io.Seeker, but fail to seek,
+	// so it is only set once a seek has succeeded.
+	seeker io.Seeker
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	if len(c.peeked) > 0 {
+		n := copy(p, c.peeked)
+		c.peeked = c.peeked[n:]
+		c.n += int64(n)
+		return n, nil
+	}
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Peek returns the next n bytes without consuming them.
+// If fewer than n bytes remain in the stream, then the remaining bytes are
+// returned without an error, unless there are no bytes remaining, in which
+// case io.EOF is returned.
+func (c *countingReader) peek(n int) ([]byte, error) {
+	if len(c.peeked) < n {
+		buf := make([]byte, n)
+		m := copy(buf, c.peeked)
+		k, err := io.ReadFull(c.r, buf[m:])
+		c.peeked = buf[:m+k]
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+	}
+	if len(c.peeked) == 0 {
+		return nil, io.EOF
+	}
+	if len(c.peeked) < n {
+		return c.peeked, nil
+	}
+	return c.peeked[:n], nil
+}
+
+// Buffered returns the number of bytes that have been read from the underlying
+// reader by peek, but not yet consumed.
+func (c *countingReader) buffered() int {
+	return len(c.peeked)
+}
+
+// Skip discards the next n bytes.
+// If the underlying reader is known to seek then the bytes are seeked over
+// instead of being read.
+func (c *countingReader) skip(n int64) error {
+	if m := int64(len(c.peeked)); m > 0 {
+		if m > n {
+			m = n
+		}
+		c.peeked = c.peeked[m:]
+		c.n += m
+		n -= m
+	}
+	if c.seeker != nil {
+		if _, err := c.seeker.Seek(n, 1); err != nil {
+			return err
+		}
+		c.n += n
+		return nil
+	}
+	m, err := io.CopyN(ioutil.Discard, c.r, n)
+	c.n += m
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
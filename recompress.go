@@ -0,0 +1,87 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// Recompress decodes the FLAC stream read from src and re-encodes it to dst
+// with the given options, for example to shrink a stream that was encoded
+// at a fast level or by a poor encoder.
+//
+// The metadata blocks of src are copied byte-for-byte in their original order,
+// except for STREAMINFO, which is updated for the new frames, and SEEKTABLE,
+// whose offsets would no longer be right.  If src has a SEEKTABLE,
+// dst is an io.WriteSeeker, and the total number of samples is known,
+// a new SEEKTABLE is written after STREAMINFO, with a seek point every
+// 10 seconds unless opts gives another interval; otherwise it is removed.
+// The VorbisComment, CueSheet, and Padding options are ignored,
+// and Ogg is not supported.
+//
+// If src has an MD5 checksum, it is verified against the decoded audio.
+func Recompress(dst io.Writer, src io.Reader, opts EncoderOptions) error {
+	if opts.Ogg {
+		return errors.New("Recompress does not support Ogg")
+	}
+	if err := checkMagic(src); err != nil {
+		return err
+	}
+	var meta MetaData
+	header := append([]byte{}, magic[:]...)
+	extra := [][]byte{}
+	hasSeekTable := false
+	for last := false; !last; {
+		block, err := readRawMetaDataBlock(src)
+		if err != nil {
+			return err
+		}
+		if last, _, err = readMetaDataBlock(bytes.NewReader(block), &meta); err != nil {
+			return err
+		}
+		header = append(header, block...)
+		switch BlockType(block[0] & 0x7F) {
+		case BlockStreamInfo:
+		case BlockSeekTable:
+			hasSeekTable = true
+		default:
+			extra = append(extra, block)
+		}
+	}
+	if meta.StreamInfo == nil {
+		return errors.New("Missing STREAMINFO")
+	}
+	d, err := NewDecoder(io.MultiReader(bytes.NewReader(header), src))
+	if err != nil {
+		return err
+	}
+
+	if hasSeekTable && meta.TotalSamples > 0 && opts.SeekInterval == 0 && opts.SeekSeconds == 0 {
+		if s, ok := dst.(io.WriteSeeker); ok {
+			if _, err := s.Seek(0, 1); err == nil {
+				opts.SeekSeconds = 10
+			}
+		}
+	}
+	e, err := newEncoder(dst, MetaData{StreamInfo: meta.StreamInfo}, opts, extra, nil)
+	if err != nil {
+		return err
+	}
+	for {
+		samples, err := d.NextSamples()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if err := e.Write(samples); err != nil {
+			return err
+		}
+	}
+	if meta.MD5 != noMD5 && !bytes.Equal(e.md5.Sum(nil), meta.MD5[:]) {
+		return errors.New("Bad MD5 checksum")
+	}
+	return e.Close()
+}
@@ -0,0 +1,117 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"crypto/md5"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestRecompress(t *testing.T) {
+	data := testSignal(2, 100000, 16)
+	info := &StreamInfo{SampleRate: 44100, NChannels: 2, BitsPerSample: 16, TotalSamples: 100000}
+	meta := MetaData{
+		StreamInfo:    info,
+		VorbisComment: &VorbisComment{Vendor: "test vendor", Comments: []string{"TITLE=test"}},
+		Applications:  []Application{{ID: [4]byte{'t', 'e', 's', 't'}, Data: []byte("data")}},
+	}
+	src, err := ioutil.TempFile("", "flac-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(src.Name())
+	defer src.Close()
+	e, err := NewEncoderOpts(src, meta, EncoderOptions{Level: 0, SeekInterval: 44100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	orig, err := ioutil.ReadAll(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Recompress to a file, which gets a new SEEKTABLE, and to a buffer, which does not.
+	dst, err := ioutil.TempFile("", "flac-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(dst.Name())
+	defer dst.Close()
+	if err := Recompress(dst, bytes.NewReader(orig), EncoderOptions{Level: 8}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dst.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	seekable, err := ioutil.ReadAll(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Recompress(&buf, bytes.NewReader(orig), EncoderOptions{Level: 8}); err != nil {
+		t.Fatal(err)
+	}
+
+	origBlocks := rawBlocks(t, orig)
+	for _, test := range []struct {
+		name      string
+		stream    []byte
+		seekTable bool
+	}{
+		{"seekable", seekable, true},
+		{"non-seekable", buf.Bytes(), false},
+	} {
+		if len(test.stream) >= len(orig) {
+			t.Errorf("%s: recompressed to %d bytes, originally %d", test.name, len(test.stream), len(orig))
+		}
+		got, gotMeta, err := decodeAll(test.stream)
+		if err != nil {
+			t.Errorf("%s: decoding failed: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, data) {
+			t.Errorf("%s: decoded audio differs", test.name)
+		}
+		if pcm, _ := interleave(data, 16); gotMeta.MD5 != md5.Sum(pcm) {
+			t.Errorf("%s: bad MD5 checksum", test.name)
+		}
+		if (gotMeta.SeekTable != nil) != test.seekTable {
+			t.Errorf("%s: has SEEKTABLE %v, want %v", test.name, gotMeta.SeekTable != nil, test.seekTable)
+		}
+		// The other blocks are unchanged, apart from the last-block flag.
+		var want, have [][]byte
+		for _, b := range origBlocks {
+			if kind := BlockType(b[0] & 0x7F); kind != BlockStreamInfo && kind != BlockSeekTable {
+				want = append(want, b[1:])
+			}
+		}
+		for _, b := range rawBlocks(t, test.stream) {
+			if kind := BlockType(b[0] & 0x7F); kind != BlockStreamInfo && kind != BlockSeekTable {
+				have = append(have, b[1:])
+			}
+		}
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("%s: metadata blocks changed", test.name)
+		}
+	}
+
+	// A bad MD5 checksum is an error.
+	bad := append([]byte{}, orig...)
+	bad[len(magic)+4+18] ^= 0xFF
+	if err := Recompress(ioutil.Discard, bytes.NewReader(bad), EncoderOptions{}); err == nil || err.Error() != "Bad MD5 checksum" {
+		t.Errorf("Got error %v, want a bad MD5 checksum", err)
+	}
+}
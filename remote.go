@@ -0,0 +1,189 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// HTTPBlockSize is the number of bytes fetched by each request of an HTTPReaderAt.
+	httpBlockSize = 1 << 16
+	// HTTPCacheBlocks is the number of blocks cached by an HTTPReaderAt.
+	httpCacheBlocks = 16
+)
+
+// An HTTPReaderAt is an io.ReaderAt for a file served over HTTP.
+// It fetches the file in blocks with Range requests, caching the most recently
+// used blocks, so that a Decoder from NewReaderAtDecoder can seek around
+// a remote file without downloading all of it:
+//
+//	r, err := flac.NewHTTPReaderAt(nil, url)
+//	…
+//	d, err := flac.NewReaderAtDecoder(r, r.Size())
+//
+// It is safe for use by multiple goroutines.
+type HTTPReaderAt struct {
+	client *http.Client
+	url    string
+	size   int64
+
+	mu sync.Mutex
+	// Blocks are the cached blocks, and clock counts block uses,
+	// to find the least recently used block.
+	blocks []httpBlock
+	clock  int64
+}
+
+type httpBlock struct {
+	n    int64
+	data []byte
+	used int64
+}
+
+// NewHTTPReaderAt returns an HTTPReaderAt for the file at url,
+// fetching its first block to learn its size.  The server must support
+// Range requests.  If client is nil, http.DefaultClient is used.
+func NewHTTPReaderAt(client *http.Client, url string) (*HTTPReaderAt, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	h := &HTTPReaderAt{client: client, url: url}
+	data, size, err := h.fetch(0)
+	if err != nil {
+		return nil, err
+	}
+	h.size = size
+	h.blocks = append(h.blocks, httpBlock{n: 0, data: data})
+	return h, nil
+}
+
+// Size returns the size of the file in bytes.
+func (h *HTTPReaderAt) Size() int64 {
+	return h.size
+}
+
+// ReadAt reads len(p) bytes of the file starting at byte off,
+// fetching the blocks that are not cached.
+func (h *HTTPReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("Negative offset")
+	}
+	var n int
+	for n < len(p) {
+		if off >= h.size {
+			return n, io.EOF
+		}
+		data, err := h.block(off / httpBlockSize)
+		if err != nil {
+			return n, err
+		}
+		m := copy(p[n:], data[off%httpBlockSize:])
+		n += m
+		off += int64(m)
+	}
+	return n, nil
+}
+
+// Block returns block i of the file, from the cache if it is there.
+func (h *HTTPReaderAt) block(i int64) ([]byte, error) {
+	h.mu.Lock()
+	h.clock++
+	for j := range h.blocks {
+		if b := &h.blocks[j]; b.n == i {
+			b.used = h.clock
+			h.mu.Unlock()
+			return b.data, nil
+		}
+	}
+	h.mu.Unlock()
+
+	// The lock is not held while fetching, so other blocks can be read
+	// meanwhile.  Two goroutines may fetch the same block, which is harmless.
+	data, _, err := h.fetch(i)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clock++
+	b := httpBlock{n: i, data: data, used: h.clock}
+	if len(h.blocks) < httpCacheBlocks {
+		h.blocks = append(h.blocks, b)
+		return data, nil
+	}
+	lru := 0
+	for j := range h.blocks {
+		if h.blocks[j].used < h.blocks[lru].used {
+			lru = j
+		}
+	}
+	h.blocks[lru] = b
+	return data, nil
+}
+
+// Fetch requests block i of the file and returns it, along with the size
+// of the whole file, as given by the Content-Range header of the response.
+func (h *HTTPReaderAt) fetch(i int64) ([]byte, int64, error) {
+	start := i * httpBlockSize
+	end := start + httpBlockSize - 1
+	req, err := http.NewRequest("GET", h.url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(end, 10))
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, 0, errors.New("Range request failed: " + resp.Status)
+	}
+	first, last, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return nil, 0, err
+	}
+	if first != start || last != end && last != size-1 {
+		return nil, 0, errors.New("Range request returned the wrong range")
+	}
+	data := make([]byte, last-first+1)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, 0, err
+	}
+	return data, size, nil
+}
+
+// ParseContentRange returns the first and last byte positions of the range
+// and the size of the whole file from a Content-Range header,
+// such as "bytes 0-65535/1234567".
+func parseContentRange(s string) (int64, int64, int64, error) {
+	bad := errors.New("Bad Content-Range: " + s)
+	if !strings.HasPrefix(s, "bytes ") {
+		return 0, 0, 0, bad
+	}
+	s = s[len("bytes "):]
+	dash, slash := strings.Index(s, "-"), strings.Index(s, "/")
+	if dash < 0 || slash < dash {
+		return 0, 0, 0, bad
+	}
+	first, err := strconv.ParseInt(s[:dash], 10, 64)
+	if err != nil {
+		return 0, 0, 0, bad
+	}
+	last, err := strconv.ParseInt(s[dash+1:slash], 10, 64)
+	if err != nil || last < first {
+		return 0, 0, 0, bad
+	}
+	size, err := strconv.ParseInt(s[slash+1:], 10, 64)
+	if err != nil || size <= last {
+		return 0, 0, 0, bad
+	}
+	return first, last, size, nil
+}
@@ -0,0 +1,77 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHTTPReaderAt(t *testing.T) {
+	const n = 500000
+	data := testSignal(2, n, 16)
+	stream := encodeFile(t, data, EncoderOptions{BlockSize: 4096})
+	var mu sync.Mutex
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		http.ServeContent(w, req, "test.flac", time.Time{}, bytes.NewReader(stream))
+	}))
+	defer server.Close()
+
+	r, err := NewHTTPReaderAt(nil, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Size() != int64(len(stream)) {
+		t.Errorf("got size %d, want %d", r.Size(), len(stream))
+	}
+	d, err := NewReaderAtDecoder(r, r.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sample := range []int64{n - 100, 250000, 0} {
+		if _, err := d.Seek(sample, 0); err != nil {
+			t.Fatalf("Seek(%d): %v", sample, err)
+		}
+		samples, err := d.NextSamples()
+		if err != nil {
+			t.Fatalf("Seek(%d): NextSamples: %v", sample, err)
+		}
+		for ch := range samples {
+			if !reflect.DeepEqual(samples[ch], data[ch][sample:sample+int64(len(samples[ch]))]) {
+				t.Errorf("Seek(%d): channel %d differs", sample, ch)
+			}
+		}
+	}
+	if blocks := len(stream) / httpBlockSize; requests >= blocks {
+		t.Errorf("made %d requests for a file of %d blocks", requests, blocks)
+	}
+
+	got := make([]byte, len(stream))
+	if m, err := r.ReadAt(got, 0); m != len(stream) || err != nil {
+		t.Errorf("ReadAt all=%d, %v, want %d, nil", m, err, len(stream))
+	}
+	if !bytes.Equal(got, stream) {
+		t.Errorf("ReadAt all: bytes differ")
+	}
+	if m, err := r.ReadAt(got[:10], r.Size()-5); m != 5 || err != io.EOF {
+		t.Errorf("ReadAt past the end=%d, %v, want 5, io.EOF", m, err)
+	}
+
+	noRanges := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(stream)
+	}))
+	defer noRanges.Close()
+	if _, err := NewHTTPReaderAt(nil, noRanges.URL); err == nil {
+		t.Errorf("no Range support: got no error")
+	}
+}
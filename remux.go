@@ -0,0 +1,233 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// Trim writes to w a FLAC stream of the samples [start, end) of the FLAC
+// stream read from r, for example to remove silence or to split a long
+// recording.  If end is beyond the end of the stream, the stream is kept
+// to its end.
+//
+// Frames that lie wholly within the range are copied, with only their headers
+// rewritten, so they are not re-encoded; the frames at the edges of the range
+// are re-encoded with opts.  Every frame is still decoded, to compute
+// the MD5 checksum of the audio.  The new stream has a variable block size,
+// since the re-encoded frames may be shorter.  Its metadata is that of r,
+// except for SEEKTABLE and CUESHEET blocks, which would not match the audio.
+func Trim(w io.Writer, r io.Reader, start, end int64, opts EncoderOptions) error {
+	if start < 0 || end < start {
+		return errors.New("Bad range [" + strconv.FormatInt(start, 10) + ", " + strconv.FormatInt(end, 10) + ")")
+	}
+	d, err := NewDecoder(r)
+	if err != nil {
+		return err
+	}
+	info := *d.StreamInfo
+	if info.TotalSamples > 0 {
+		if end > info.TotalSamples {
+			end = info.TotalSamples
+		}
+		if start > end {
+			start = end
+		}
+		info.TotalSamples = end - start
+	}
+	e, err := newRemuxer(w, d.MetaData, &info, opts)
+	if err != nil {
+		return err
+	}
+	if start < end {
+		if err := e.copyFrames(d, start, end); err != nil {
+			return err
+		}
+	}
+	return e.Close()
+}
+
+// Concat writes to w a FLAC stream of the audio of each of the FLAC streams
+// read from rs, in order.  The streams must all have the same sample rate,
+// number of channels, and bits per sample.
+//
+// As with Trim, the frames are copied, not re-encoded, except that the frames
+// that must be merged to keep each frame at least 16 samples long
+// are re-encoded with opts.  The metadata is that of the first stream,
+// except for SEEKTABLE and CUESHEET blocks.
+func Concat(w io.Writer, rs []io.Reader, opts EncoderOptions) error {
+	if len(rs) == 0 {
+		return errors.New("No streams to concatenate")
+	}
+	ds := make([]*Decoder, len(rs))
+	for i, r := range rs {
+		d, err := NewDecoder(r)
+		if err != nil {
+			return errors.New("Stream " + strconv.Itoa(i) + ": " + err.Error())
+		}
+		ds[i] = d
+	}
+	info := *ds[0].StreamInfo
+	for i, d := range ds[1:] {
+		if d.SampleRate != info.SampleRate || d.NChannels != info.NChannels || d.BitsPerSample != info.BitsPerSample {
+			return errors.New("Stream " + strconv.Itoa(i+1) + " has a different format from stream 0")
+		}
+		if d.TotalSamples == 0 {
+			info.TotalSamples = 0
+		} else if info.TotalSamples > 0 {
+			info.TotalSamples += d.TotalSamples
+		}
+	}
+	e, err := newRemuxer(w, ds[0].MetaData, &info, opts)
+	if err != nil {
+		return err
+	}
+	for _, d := range ds {
+		if err := e.copyFrames(d, 0, -1); err != nil {
+			return err
+		}
+	}
+	return e.Close()
+}
+
+// Remux writes to w the FLAC stream read from r with its metadata replaced
+// by meta, for fast retagging.  The audio frames are copied byte-for-byte,
+// without decoding them, and the metadata blocks of r are skipped without
+// reading them into memory.  The metadata is written as by the Encoder,
+// followed by a PADDING block of DefaultPadding bytes; the Padding field
+// is ignored.
+//
+// If meta.StreamInfo is nil, the STREAMINFO of r is used.  Otherwise, it must
+// have the sample rate, number of channels, and bits per sample of r, and the
+// same total number of samples, if both are known, since the frames are not
+// changed.  A SEEKTABLE in meta remains valid if it is from r, since the
+// frames do not move relative to the first frame.
+func Remux(w io.Writer, r io.Reader, meta MetaData) error {
+	cr := &countingReader{r: r}
+	if err := checkMagic(cr); err != nil {
+		return err
+	}
+	skip := func(BlockType, io.Reader) error { return SkipBlock }
+	old, err := readMetaData(cr, Options{OnBlock: skip})
+	if err != nil {
+		return err
+	}
+	if old.StreamInfo == nil {
+		return errors.New("Missing STREAMINFO")
+	}
+	info := meta.StreamInfo
+	switch {
+	case info == nil:
+		meta.StreamInfo = old.StreamInfo
+	case info.SampleRate != old.SampleRate || info.NChannels != old.NChannels || info.BitsPerSample != old.BitsPerSample:
+		return errors.New("STREAMINFO has a different format from the stream")
+	case info.TotalSamples > 0 && old.TotalSamples > 0 && info.TotalSamples != old.TotalSamples:
+		return errors.New("STREAMINFO has a different total number of samples from the stream")
+	}
+	blocks, err := encodeMetaData(meta)
+	if err != nil {
+		return err
+	}
+	pad, err := metaDataBlock(BlockPadding, make([]byte, DefaultPadding))
+	if err != nil {
+		return err
+	}
+	return writeMetaData(w, cr, append(blocks, pad))
+}
+
+// NewRemuxer returns an Encoder for a variable block size stream with the given
+// STREAMINFO and the metadata of meta, apart from SEEKTABLE and CUESHEET blocks.
+func newRemuxer(w io.Writer, meta MetaData, info *StreamInfo, opts EncoderOptions) (*Encoder, error) {
+	meta.StreamInfo = info
+	meta.SeekTable = nil
+	var others []RawBlock
+	for _, b := range meta.Others {
+		if BlockType(b.Type) != BlockCueSheet {
+			others = append(others, b)
+		}
+	}
+	meta.Others = others
+	opts.VariableBlockSize = true
+	return NewEncoderOpts(w, meta, opts)
+}
+
+// CopyFrames writes the samples [start, end) of the stream decoded by d,
+// or from start to the end of the stream if end is negative.
+// Whole frames are copied when nothing is buffered,
+// and the rest of the samples are encoded.
+func (e *Encoder) copyFrames(d *Decoder, start, end int64) error {
+	var pos int64
+	for end < 0 || pos < end {
+		data, h, frame, err := d.readFrame()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		fstart, fend := pos, pos+int64(h.blockSize)
+		pos = fend
+		if fend <= start {
+			continue
+		}
+		// A frame shorter than minBlockSize is the last of its stream,
+		// so it is encoded along with the frames that follow it, if any.
+		if fstart >= start && (end < 0 || fend <= end) && len(e.buf[0]) == 0 && h.blockSize >= minBlockSize {
+			if err := e.copyFrame(frame, h, data); err != nil {
+				return err
+			}
+			continue
+		}
+		lo, hi := int64(0), int64(h.blockSize)
+		if start > fstart {
+			lo = start - fstart
+		}
+		if end >= 0 && end < fend {
+			hi = end - fstart
+		}
+		for ch := range data {
+			data[ch] = data[ch][lo:hi]
+		}
+		if err := e.Write(data); err != nil {
+			return err
+		}
+		// Flush leaves fewer than 16 samples buffered,
+		// which are encoded along with the next frame.
+		if err := e.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CopyFrame writes an encoded frame of the given samples, from another stream
+// of the same format, rewriting its header with the number of its first sample.
+func (e *Encoder) copyFrame(frame []byte, h *frameHeader, data [][]int32) error {
+	pcm, err := interleave(data, e.info.BitsPerSample)
+	if err != nil {
+		return err
+	}
+	e.md5.Write(pcm)
+	r := bytes.NewReader(frame)
+	if _, err := readFrameHeader(r, &e.info); err != nil {
+		return err
+	}
+	body := frame[len(frame)-r.Len() : len(frame)-2]
+	out, err := AppendFrameHeader(nil, FrameHeader{
+		BlockSize:         h.blockSize,
+		SampleRate:        h.sampleRate,
+		NChannels:         h.channelAssignment.nChannels(),
+		ChannelAssignment: int(h.channelAssignment),
+		BitsPerSample:     h.sampleSize,
+		VariableSize:      true,
+		Number:            uint64(e.samples),
+	})
+	if err != nil {
+		return err
+	}
+	out = append(out, body...)
+	crc := CRC16(out)
+	return e.writeFrame(append(out, byte(crc>>8), byte(crc)), h.blockSize)
+}
@@ -0,0 +1,186 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// CheckRemux checks that stream decodes to want, with a valid MD5 checksum
+// and the right total number of samples.
+func checkRemux(t *testing.T, name string, stream []byte, want [][]int32) {
+	if _, _, err := Decode(bytes.NewReader(stream)); err != nil {
+		t.Errorf("%s: %v", name, err)
+		return
+	}
+	got, meta, err := decodeAll(stream)
+	if err != nil {
+		t.Errorf("%s: %v", name, err)
+		return
+	}
+	if meta.TotalSamples != int64(len(want[0])) {
+		t.Errorf("%s: got %d total samples, want %d", name, meta.TotalSamples, len(want[0]))
+	}
+	if meta.MD5 == noMD5 {
+		t.Errorf("%s: no MD5 checksum", name)
+	}
+	for ch := range want {
+		if len(want[ch]) == 0 && len(got[ch]) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got[ch], want[ch]) {
+			t.Errorf("%s: channel %d differs", name, ch)
+		}
+	}
+}
+
+func remuxFile(t *testing.T, remux func(io.Writer) error) []byte {
+	f, err := ioutil.TempFile("", "flac-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := remux(f); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	stream, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stream
+}
+
+func TestTrim(t *testing.T) {
+	const n = 50000
+	data := testSignal(2, n, 16)
+	src := encodeFile(t, data, EncoderOptions{BlockSize: 4096, SeekInterval: 10000})
+	for _, test := range []struct {
+		start, end int64
+	}{
+		{0, n},
+		{0, 2 * n},
+		{4096, 4096 * 3},
+		{1000, 30000},
+		{100, 110},
+		{n - 5, n},
+		{20000, 20000},
+	} {
+		name := "[" + strconv.FormatInt(test.start, 10) + ", " + strconv.FormatInt(test.end, 10) + ")"
+		stream := remuxFile(t, func(w io.Writer) error {
+			return Trim(w, bytes.NewReader(src), test.start, test.end, EncoderOptions{})
+		})
+		end := test.end
+		if end > n {
+			end = n
+		}
+		want := [][]int32{data[0][test.start:end], data[1][test.start:end]}
+		checkRemux(t, name, stream, want)
+		if _, meta, err := decodeAll(stream); err == nil && meta.SeekTable != nil {
+			t.Errorf("%s: got a SEEKTABLE", name)
+		}
+	}
+
+	// Whole frames in the range are copied, not re-encoded.
+	stream := remuxFile(t, func(w io.Writer) error {
+		return Trim(w, bytes.NewReader(src), 0, n, EncoderOptions{})
+	})
+	if len(stream) > len(src) {
+		t.Errorf("trimming nothing grew the stream from %d to %d bytes", len(src), len(stream))
+	}
+
+	if err := Trim(ioutil.Discard, bytes.NewReader(src), 10, 5, EncoderOptions{}); err == nil {
+		t.Errorf("Trim with end before start: got no error")
+	}
+}
+
+func TestConcat(t *testing.T) {
+	a := testSignal(2, 10007, 16)
+	b := testSignal(2, 5003, 16)
+	c := testSignal(2, 20, 16)
+	opts := EncoderOptions{BlockSize: 1000}
+	srcs := [][]byte{encodeFile(t, a, opts), encodeFile(t, b, opts), encodeFile(t, c, opts)}
+	stream := remuxFile(t, func(w io.Writer) error {
+		rs := make([]io.Reader, len(srcs))
+		for i, src := range srcs {
+			rs[i] = bytes.NewReader(src)
+		}
+		return Concat(w, rs, EncoderOptions{})
+	})
+	want := make([][]int32, 2)
+	for ch := range want {
+		want[ch] = append(append(append(want[ch], a[ch]...), b[ch]...), c[ch]...)
+	}
+	checkRemux(t, "concat", stream, want)
+
+	mono := encodeFile(t, testSignal(1, 1000, 16), opts)
+	err := Concat(ioutil.Discard, []io.Reader{bytes.NewReader(srcs[0]), bytes.NewReader(mono)}, EncoderOptions{})
+	if err == nil {
+		t.Errorf("Concat of stereo and mono: got no error")
+	}
+}
+
+func TestRemux(t *testing.T) {
+	data := testSignal(2, 20000, 16)
+	orig := MetaData{
+		StreamInfo:    &StreamInfo{SampleRate: 44100, NChannels: 2, BitsPerSample: 16, TotalSamples: 20000},
+		VorbisComment: &VorbisComment{Vendor: "test", Comments: []string{"TITLE=old"}},
+		Pictures:      []Picture{{Type: PictureFrontCover, MIME: "image/x-test", Data: make([]byte, 1<<16)}},
+	}
+	var buf bytes.Buffer
+	e, err := NewEncoderOpts(&buf, orig, EncoderOptions{BlockSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stream := buf.Bytes()
+	d, err := NewDecoder(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := stream[d.framesOffset:]
+
+	meta := MetaData{VorbisComment: &VorbisComment{Vendor: "test", Comments: []string{"TITLE=new"}}}
+	var out bytes.Buffer
+	if err := Remux(&out, bytes.NewReader(stream), meta); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(out.Bytes(), frames) {
+		t.Errorf("the audio frames changed")
+	}
+	_, got, err := Decode(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if title, _ := got.Title(); title != "new" || len(got.Pictures) != 0 || *got.StreamInfo != *d.StreamInfo {
+		t.Errorf("got metadata %+v, %+v", got, got.VorbisComment)
+	}
+	if got.PaddingSize() != DefaultPadding {
+		t.Errorf("got %d bytes of padding, want %d", got.PaddingSize(), DefaultPadding)
+	}
+
+	bad := []*StreamInfo{
+		{SampleRate: 48000, NChannels: 2, BitsPerSample: 16},
+		{SampleRate: 44100, NChannels: 1, BitsPerSample: 16},
+		{SampleRate: 44100, NChannels: 2, BitsPerSample: 16, TotalSamples: 100},
+	}
+	for _, info := range bad {
+		if err := Remux(ioutil.Discard, bytes.NewReader(stream), MetaData{StreamInfo: info}); err == nil {
+			t.Errorf("%+v: expected an error", *info)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"crypto/md5"
+	"io"
+	"unicode/utf8"
+)
+
+// ScanStream decodes the FLAC stream read from r and returns a StreamInfo
+// computed from its frames: the block and frame size bounds, the total number
+// of samples, and the MD5 checksum of the audio.  It is used to repair
+// STREAMINFO blocks with missing or wrong values.
+//
+// If interval is positive, ScanStream also returns seek points for the first
+// frame starting at or after every multiple of interval samples, suitable
+// for a SEEKTABLE block.
+func ScanStream(r io.Reader, interval int64) (*StreamInfo, []SeekPoint, error) {
+	d, err := NewDecoder(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	info := &StreamInfo{
+		SampleRate:    d.SampleRate,
+		NChannels:     d.NChannels,
+		BitsPerSample: d.BitsPerSample,
+	}
+	var points []SeekPoint
+	var next int64
+	h := md5.New()
+	// The minimum block size excludes the last frame, which may be short.
+	var last int
+	for {
+		start := d.r.n
+		data, err := d.decodeFrame()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		n, size := len(data[0]), int(d.r.n-start)
+		if interval > 0 && info.TotalSamples >= next {
+			points = append(points, SeekPoint{
+				Sample:  info.TotalSamples,
+				Offset:  start - d.framesOffset,
+				Samples: n,
+			})
+			next = (info.TotalSamples/interval + 1) * interval
+		}
+		if last > 0 && (info.MinBlock == 0 || last < info.MinBlock) {
+			info.MinBlock = last
+		}
+		last = n
+		if n > info.MaxBlock {
+			info.MaxBlock = n
+		}
+		if info.MinFrame == 0 || size < info.MinFrame {
+			info.MinFrame = size
+		}
+		if size > info.MaxFrame {
+			info.MaxFrame = size
+		}
+		info.TotalSamples += int64(n)
+
+		pcm, err := interleave(data, d.BitsPerSample)
+		if err != nil {
+			return nil, nil, err
+		}
+		h.Write(pcm)
+	}
+	if info.MinBlock == 0 || info.MinBlock > info.MaxBlock {
+		info.MinBlock = info.MaxBlock
+	}
+	copy(info.MD5[:], h.Sum(nil))
+	return info, points, nil
+}
+
+// FixUTF8 repairs comments and the vendor string that are not valid UTF-8.
+// Such tags were usually written by old taggers in ISO 8859-1 (Latin-1),
+// so each byte that is not part of a valid UTF-8 sequence is converted
+// from Latin-1.  FixUTF8 returns the indices of the repaired comments,
+// with -1 for the vendor string.
+func (c *VorbisComment) FixUTF8() []int {
+	var fixed []int
+	if !utf8.ValidString(c.Vendor) {
+		c.Vendor = latin1ToUTF8(c.Vendor)
+		fixed = append(fixed, -1)
+	}
+	for i, cmnt := range c.Comments {
+		if !utf8.ValidString(cmnt) {
+			c.Comments[i] = latin1ToUTF8(cmnt)
+			fixed = append(fixed, i)
+		}
+	}
+	return fixed
+}
+
+func latin1ToUTF8(s string) string {
+	var rs []rune
+	for len(s) > 0 {
+		r, n := utf8.DecodeRuneInString(s)
+		if r == utf8.RuneError && n == 1 {
+			r = rune(s[0])
+		}
+		rs = append(rs, r)
+		s = s[n:]
+	}
+	return string(rs)
+}
@@ -0,0 +1,58 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"crypto/md5"
+	"testing"
+)
+
+func TestScanStream(t *testing.T) {
+	info, points, err := ScanStream(bytes.NewReader(twoFrameStream), 100)
+	if err != nil {
+		t.Fatalf("ScanStream failed: %v", err)
+	}
+	var pcm []byte
+	for i := 0; i < 192; i++ {
+		pcm = append(pcm, 5, 7)
+	}
+	for i := 0; i < 192; i++ {
+		pcm = append(pcm, 6, 8)
+	}
+	want := StreamInfo{
+		MinBlock:      192,
+		MaxBlock:      192,
+		MinFrame:      12,
+		MaxFrame:      12,
+		SampleRate:    44100,
+		NChannels:     2,
+		BitsPerSample: 8,
+		TotalSamples:  384,
+		MD5:           md5.Sum(pcm),
+	}
+	if *info != want {
+		t.Errorf("Got %+v, want %+v", *info, want)
+	}
+	wantPoints := []SeekPoint{{0, 0, 192}, {192, 12, 192}}
+	if len(points) != len(wantPoints) || points[0] != wantPoints[0] || points[1] != wantPoints[1] {
+		t.Errorf("Got seek points %v, want %v", points, wantPoints)
+	}
+}
+
+func TestFixUTF8(t *testing.T) {
+	c := &VorbisComment{
+		Vendor:   "ok",
+		Comments: []string{"TITLE=Caf\xe9", "ARTIST=Beyoncé", "ALBUM=na\xefve \xe9t\xe9"},
+	}
+	fixed := c.FixUTF8()
+	if len(fixed) != 2 || fixed[0] != 0 || fixed[1] != 2 {
+		t.Errorf("Expected comments 0 and 2 fixed, got %v", fixed)
+	}
+	want := []string{"TITLE=Café", "ARTIST=Beyoncé", "ALBUM=naïve été"}
+	for i := range want {
+		if c.Comments[i] != want[i] {
+			t.Errorf("Comment %d: got %q, want %q", i, c.Comments[i], want[i])
+		}
+	}
+}
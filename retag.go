@@ -0,0 +1,332 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// Retag copies a FLAC stream from r to w, rewriting its metadata.
+//
+// The metadata is read from r and passed to edit, which may change it.
+// The edited STREAMINFO, SEEKTABLE, VORBIS_COMMENT, APPLICATION, and PICTURE
+// blocks are then written to w along with the other metadata blocks,
+// such as padding, which are copied unchanged in their original order;
+// changes to Others are ignored.
+// Finally, the audio frames are copied from r to w without decoding them.
+// Neither r nor w need be seekable, so Retag can tag streams on the fly.
+//
+// If edit sets VorbisComment or SeekTable to nil, the block is removed;
+// if it adds one where there was none, the block is written after STREAMINFO.
+// Applications and Pictures are written in place of the first block of
+// their kind, or at the end if there was none.  If edit returns an error,
+// Retag returns it without writing anything.
+func Retag(w io.Writer, r io.Reader, edit func(*MetaData) error) error {
+	if err := checkMagic(r); err != nil {
+		return err
+	}
+	meta, blocks, err := readRetagBlocks(r)
+	if err != nil {
+		return err
+	}
+	if err := edit(&meta); err != nil {
+		return err
+	}
+	out, err := retagBlocks(blocks, meta)
+	if err != nil {
+		return err
+	}
+	return writeMetaData(w, r, out)
+}
+
+// ReadRetagBlocks reads the metadata blocks following the fLaC marker,
+// returning them both decoded and raw, including their headers.
+func readRetagBlocks(r io.Reader) (MetaData, [][]byte, error) {
+	var meta MetaData
+	var blocks [][]byte
+	for last := false; !last; {
+		block, err := readRawMetaDataBlock(r)
+		if err != nil {
+			return MetaData{}, nil, err
+		}
+		if last, _, err = readMetaDataBlock(bytes.NewReader(block), &meta); err != nil {
+			return MetaData{}, nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	if meta.StreamInfo == nil {
+		return MetaData{}, nil, errors.New("Missing STREAMINFO")
+	}
+	return meta, blocks, nil
+}
+
+// RetagBlocks returns the metadata blocks of an edited stream, as described
+// by Retag, from the stream's original blocks and its edited metadata.
+func retagBlocks(blocks [][]byte, meta MetaData) ([][]byte, error) {
+	if meta.StreamInfo == nil {
+		return nil, errors.New("Missing STREAMINFO")
+	}
+
+	var out [][]byte
+	add := func(kind BlockType, body []byte) error {
+		block, err := metaDataBlock(kind, body)
+		out = append(out, block)
+		return err
+	}
+	add(BlockStreamInfo, encodeStreamInfo(meta.StreamInfo))
+	if meta.SeekTable != nil && !hasBlock(blocks, BlockSeekTable) {
+		if err := add(BlockSeekTable, encodeSeekTable(meta.SeekTable)); err != nil {
+			return nil, err
+		}
+	}
+	if meta.VorbisComment != nil && !hasBlock(blocks, BlockVorbisComment) {
+		body, err := encodeVorbisComment(meta.VorbisComment)
+		if err != nil {
+			return nil, err
+		}
+		if err := add(BlockVorbisComment, body); err != nil {
+			return nil, err
+		}
+	}
+	lists := make(map[BlockType][][]byte)
+	for _, app := range meta.Applications {
+		lists[BlockApplication] = append(lists[BlockApplication], encodeApplication(app))
+	}
+	for _, pic := range meta.Pictures {
+		lists[BlockPicture] = append(lists[BlockPicture], encodePicture(pic))
+	}
+	addList := func(kind BlockType) error {
+		for _, body := range lists[kind] {
+			if err := add(kind, body); err != nil {
+				return err
+			}
+		}
+		delete(lists, kind)
+		return nil
+	}
+	wrote := make(map[BlockType]bool)
+	for _, block := range blocks {
+		switch kind := BlockType(block[0] & 0x7F); kind {
+		case BlockStreamInfo:
+			continue
+		case BlockVorbisComment:
+			if meta.VorbisComment != nil {
+				body, err := encodeVorbisComment(meta.VorbisComment)
+				if err != nil {
+					return nil, err
+				}
+				if err := add(kind, body); err != nil {
+					return nil, err
+				}
+			}
+		case BlockSeekTable:
+			if meta.SeekTable != nil {
+				if err := add(kind, encodeSeekTable(meta.SeekTable)); err != nil {
+					return nil, err
+				}
+			}
+		case BlockApplication, BlockPicture:
+			if !wrote[kind] {
+				wrote[kind] = true
+				if err := addList(kind); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			out = append(out, block)
+		}
+	}
+	for _, kind := range []BlockType{BlockApplication, BlockPicture} {
+		if err := addList(kind); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// NormalizeLayout copies a FLAC stream from r to w, reordering its metadata
+// blocks into the conventional layout: STREAMINFO, SEEKTABLE, VORBIS_COMMENT,
+// the remaining blocks in their original order, and finally PADDING.
+// Multiple PADDING blocks are merged into one, keeping the total size.
+// The audio frames are copied unchanged.  NormalizeLayout reports whether
+// the layout changed.
+func NormalizeLayout(w io.Writer, r io.Reader) (bool, error) {
+	if err := checkMagic(r); err != nil {
+		return false, err
+	}
+	var blocks [][]byte
+	for last := false; !last; {
+		block, err := readRawMetaDataBlock(r)
+		if err != nil {
+			return false, err
+		}
+		last = block[0]&0x80 != 0
+		block[0] &^= 0x80
+		blocks = append(blocks, block)
+	}
+
+	var out [][]byte
+	for _, kind := range []BlockType{BlockStreamInfo, BlockSeekTable, BlockVorbisComment} {
+		for _, b := range blocks {
+			if BlockType(b[0]) == kind {
+				out = append(out, b)
+			}
+		}
+	}
+	npad, padSize := 0, 0
+	for _, b := range blocks {
+		switch BlockType(b[0]) {
+		case BlockStreamInfo, BlockSeekTable, BlockVorbisComment:
+		case BlockPadding:
+			npad++
+			padSize += len(b)
+		default:
+			out = append(out, b)
+		}
+	}
+	if npad > 0 {
+		pad, err := metaDataBlock(BlockPadding, make([]byte, padSize-4))
+		if err != nil {
+			return false, err
+		}
+		out = append(out, pad)
+	}
+
+	changed := len(out) != len(blocks)
+	for i := 0; !changed && i < len(out); i++ {
+		changed = !bytes.Equal(out[i], blocks[i])
+	}
+	return changed, writeMetaData(w, r, out)
+}
+
+// StripMetaData copies a FLAC stream from r to w, keeping only the STREAMINFO
+// block and the metadata blocks of the given types, for example to remove
+// the pictures and padding of a file before distributing it.
+// The kept blocks and the audio frames are copied unchanged.
+func StripMetaData(w io.Writer, r io.Reader, keep ...BlockType) error {
+	if err := checkMagic(r); err != nil {
+		return err
+	}
+	var out [][]byte
+	for last := false; !last; {
+		block, err := readRawMetaDataBlock(r)
+		if err != nil {
+			return err
+		}
+		last = block[0]&0x80 != 0
+		kind := BlockType(block[0] & 0x7F)
+		if kind == BlockStreamInfo && len(out) > 0 {
+			return errors.New("Multiple STREAMINFO blocks")
+		}
+		if kind == BlockStreamInfo {
+			out = append(out, block)
+			continue
+		}
+		for _, k := range keep {
+			if k == kind {
+				out = append(out, block)
+				break
+			}
+		}
+	}
+	if len(out) == 0 || BlockType(out[0][0]&0x7F) != BlockStreamInfo {
+		return errors.New("Missing STREAMINFO")
+	}
+	return writeMetaData(w, r, out)
+}
+
+// WriteMetaData writes the magic header and the metadata blocks to w,
+// and then copies the rest of r, the audio frames, to w.
+func writeMetaData(w io.Writer, r io.Reader, blocks [][]byte) error {
+	if err := writeBlocks(w, blocks); err != nil {
+		return err
+	}
+	_, err := io.Copy(w, r)
+	return err
+}
+
+// WriteBlocks writes the magic header and the metadata blocks to w,
+// setting the last-block flag of only the final block.
+func writeBlocks(w io.Writer, blocks [][]byte) error {
+	if _, err := w.Write(magic[:]); err != nil {
+		return err
+	}
+	for i, block := range blocks {
+		block[0] &^= 0x80
+		if i == len(blocks)-1 {
+			block[0] |= 0x80
+		}
+		if _, err := w.Write(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MetaDataBlock returns a metadata block, including its header, with the given body.
+func metaDataBlock(kind BlockType, body []byte) ([]byte, error) {
+	n := len(body)
+	if n >= 1<<24 {
+		return nil, errors.New(kind.String() + " block is too large: " + strconv.Itoa(n) + " bytes")
+	}
+	return append([]byte{byte(kind), byte(n >> 16), byte(n >> 8), byte(n)}, body...), nil
+}
+
+// ReadRawMetaDataBlock returns the next metadata block, including its header.
+func readRawMetaDataBlock(r io.Reader) ([]byte, error) {
+	var h [4]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return nil, errors.New("Failed to read metadata header: " + err.Error())
+	}
+	block := make([]byte, 4+(int(h[1])<<16|int(h[2])<<8|int(h[3])))
+	copy(block, h[:])
+	if _, err := io.ReadFull(r, block[4:]); err != nil {
+		return nil, errors.New("Failed to read metadata: " + err.Error())
+	}
+	return block, nil
+}
+
+func hasBlock(blocks [][]byte, kind BlockType) bool {
+	for _, b := range blocks {
+		if BlockType(b[0]&0x7F) == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func encodeStreamInfo(info *StreamInfo) []byte {
+	b := make([]byte, 18, 34)
+	binary.BigEndian.PutUint16(b[0:], uint16(info.MinBlock))
+	binary.BigEndian.PutUint16(b[2:], uint16(info.MaxBlock))
+	put24(b[4:], info.MinFrame)
+	put24(b[7:], info.MaxFrame)
+	binary.BigEndian.PutUint64(b[10:], uint64(info.SampleRate)<<44|
+		uint64(info.NChannels-1)<<41|
+		uint64(info.BitsPerSample-1)<<36|
+		uint64(info.TotalSamples)&(1<<36-1))
+	return append(b, info.MD5[:]...)
+}
+
+func put24(b []byte, n int) {
+	b[0], b[1], b[2] = byte(n>>16), byte(n>>8), byte(n)
+}
+
+func encodeVorbisComment(c *VorbisComment) ([]byte, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	b := bytes.NewBuffer(nil)
+	binary.Write(b, binary.LittleEndian, uint32(len(c.Vendor)))
+	b.WriteString(c.Vendor)
+	binary.Write(b, binary.LittleEndian, uint32(len(c.Comments)))
+	for _, s := range c.Comments {
+		binary.Write(b, binary.LittleEndian, uint32(len(s)))
+		b.WriteString(s)
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,137 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestRetag(t *testing.T) {
+	in := append([]byte{}, constantStream...)
+	var out bytes.Buffer
+	err := Retag(&out, bytes.NewReader(in), func(m *MetaData) error {
+		m.VorbisComment = &VorbisComment{Vendor: "test", Comments: []string{"TITLE=x"}}
+		m.Applications = append(m.Applications, Application{ID: [4]byte{'t', 'e', 's', 't'}, Data: []byte{1, 2}})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Retag failed: %v", err)
+	}
+	d, err := NewDecoder(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to decode the retagged stream: %v", err)
+	}
+	if *d.StreamInfo != *mustDecoder(t, constantStream).StreamInfo {
+		t.Errorf("STREAMINFO changed: %+v", d.StreamInfo)
+	}
+	if d.VorbisComment == nil || d.Vendor != "test" || len(d.Comments) != 1 || d.Comments[0] != "TITLE=x" {
+		t.Errorf("Bad Vorbis comments: %+v", d.VorbisComment)
+	}
+	if len(d.Applications) != 1 || !bytes.Equal(d.Applications[0].Data, []byte{1, 2}) {
+		t.Errorf("Bad applications: %+v", d.Applications)
+	}
+	data, err := d.Next()
+	if err != nil || data[0] != 5 || data[1] != 7 {
+		t.Errorf("Bad audio: %v, %v", data[:2], err)
+	}
+
+	// Removing the comments again restores the original stream.
+	var orig bytes.Buffer
+	err = Retag(&orig, bytes.NewReader(out.Bytes()), func(m *MetaData) error {
+		m.VorbisComment = nil
+		m.Applications = nil
+		return nil
+	})
+	if err != nil || !bytes.Equal(orig.Bytes(), constantStream) {
+		t.Errorf("Expected the original stream, got %x, %v", orig.Bytes(), err)
+	}
+}
+
+func TestNormalizeLayout(t *testing.T) {
+	block := func(kind byte, body ...byte) []byte {
+		return append([]byte{kind, 0, 0, byte(len(body))}, body...)
+	}
+	var in []byte
+	in = append(in, constantStream[:4]...)
+	in = append(in, 0, 0, 0, 34)
+	in = append(in, constantStream[8:42]...)
+	in = append(in, block(1, 0, 0)...)
+	in = append(in, block(4, 0, 0, 0, 0, 0, 0, 0, 0)...)
+	in = append(in, block(2, 'a', 'b', 'c', 'd')...)
+	in = append(in, block(0x81)...)
+	in = append(in, constantStream[42:]...)
+
+	var out bytes.Buffer
+	changed, err := NormalizeLayout(&out, bytes.NewReader(in))
+	if err != nil || !changed {
+		t.Fatalf("Expected a change, got %t, %v", changed, err)
+	}
+	var want []byte
+	want = append(want, in[:42]...)
+	want = append(want, block(4, 0, 0, 0, 0, 0, 0, 0, 0)...)
+	want = append(want, block(2, 'a', 'b', 'c', 'd')...)
+	want = append(want, block(0x81, 0, 0, 0, 0, 0, 0)...)
+	want = append(want, constantStream[42:]...)
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Errorf("Got\n%x, want\n%x", out.Bytes(), want)
+	}
+
+	changed, err = NormalizeLayout(ioutil.Discard, bytes.NewReader(want))
+	if err != nil || changed {
+		t.Errorf("Expected no change, got %t, %v", changed, err)
+	}
+}
+
+func TestStripMetaData(t *testing.T) {
+	data := testSignal(1, 5000, 16)
+	meta := MetaData{
+		StreamInfo:    &StreamInfo{SampleRate: 44100, NChannels: 1, BitsPerSample: 16},
+		VorbisComment: &VorbisComment{Vendor: "test", Comments: []string{"TITLE=strip"}},
+		SeekTable:     []SeekPoint{{Sample: 0, Offset: 0, Samples: 4096}},
+		Pictures:      []Picture{{Type: PictureFrontCover, MIME: "image/x-test", Data: []byte("cover")}},
+	}
+	var buf bytes.Buffer
+	e, err := NewEncoderOpts(&buf, meta, EncoderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stream := buf.Bytes()
+
+	tests := []struct {
+		keep []BlockType
+		want []BlockType
+	}{
+		{nil, []BlockType{BlockStreamInfo}},
+		{[]BlockType{BlockVorbisComment, BlockSeekTable}, []BlockType{BlockStreamInfo, BlockSeekTable, BlockVorbisComment}},
+		{[]BlockType{BlockPicture, BlockCueSheet}, []BlockType{BlockStreamInfo, BlockPicture}},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		if err := StripMetaData(&out, bytes.NewReader(stream), test.keep...); err != nil {
+			t.Fatalf("keep %v: %v", test.keep, err)
+		}
+		var kinds []BlockType
+		for _, b := range rawBlocks(t, out.Bytes()) {
+			kinds = append(kinds, BlockType(b[0]&0x7F))
+		}
+		if !reflect.DeepEqual(kinds, test.want) {
+			t.Errorf("keep %v: got blocks %v, want %v", test.keep, kinds, test.want)
+		}
+		d, err := NewDecoder(bytes.NewReader(out.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := d.DecodeRange(2000, 3000); err != nil || !reflect.DeepEqual(got[0], data[0][2000:3000]) {
+			t.Errorf("keep %v: failed to decode the stripped stream: %v", test.keep, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	defaultRetryDelay    = 100 * time.Millisecond
+	defaultMaxRetryDelay = 5 * time.Second
+)
+
+// A retryReader retries reads that fail with a temporary error.
+type retryReader struct {
+	r        io.Reader
+	retries  int
+	delay    time.Duration
+	maxDelay time.Duration
+}
+
+// A retryReadSeeker is a retryReader over an io.ReadSeeker.
+// Seeks are not retried.
+type retryReadSeeker struct {
+	*retryReader
+	io.Seeker
+}
+
+// NewRetryReader returns a reader that retries reads from r according to opts.
+// If r is an io.Seeker then so is the returned reader.
+func newRetryReader(r io.Reader, opts Options) io.Reader {
+	rr := &retryReader{
+		r:        r,
+		retries:  opts.Retries,
+		delay:    opts.RetryDelay,
+		maxDelay: opts.MaxRetryDelay,
+	}
+	if rr.delay <= 0 {
+		rr.delay = defaultRetryDelay
+	}
+	if rr.maxDelay <= 0 {
+		rr.maxDelay = defaultMaxRetryDelay
+	}
+	if s, ok := r.(io.Seeker); ok {
+		return retryReadSeeker{rr, s}
+	}
+	return rr
+}
+
+func (r *retryReader) Read(p []byte) (int, error) {
+	delay := r.delay
+	for i := 0; ; i++ {
+		n, err := r.r.Read(p)
+		switch {
+		case err == nil || !isTemporary(err):
+			return n, err
+		case n > 0:
+			// Progress was made, report the data and retry on the next read.
+			return n, nil
+		case i == r.retries:
+			return n, err
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > r.maxDelay {
+			delay = r.maxDelay
+		}
+	}
+}
+
+// IsTemporary returns whether err is an error after which a read may succeed
+// if retried: network timeouts, errors reporting themselves to be temporary,
+// and io.ErrNoProgress.
+func isTemporary(err error) bool {
+	if err == io.ErrNoProgress {
+		return true
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return true
+	}
+	t, ok := err.(interface {
+		Temporary() bool
+	})
+	return ok && t.Temporary()
+}
@@ -0,0 +1,48 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// A timeoutReader returns timeout errors before each successful read.
+type timeoutReader struct {
+	r        io.Reader
+	timeouts int
+	n        int
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func (r *timeoutReader) Read(p []byte) (int, error) {
+	if r.n < r.timeouts {
+		r.n++
+		return 0, timeoutError{}
+	}
+	r.n = 0
+	return r.r.Read(p)
+}
+
+func TestRetries(t *testing.T) {
+	r := &timeoutReader{r: bytes.NewReader(constantStream), timeouts: 2}
+	if _, err := NewDecoder(r); err == nil {
+		t.Errorf("Expected a timeout error without retries")
+	}
+
+	r = &timeoutReader{r: bytes.NewReader(constantStream), timeouts: 2}
+	d, err := NewDecoderOpts(r, Options{Retries: 2, RetryDelay: time.Nanosecond})
+	if err != nil {
+		t.Fatalf("Unexpected error making a new decoder: %v", err)
+	}
+	if _, err := d.Next(); err != nil {
+		t.Errorf("Unexpected error decoding a frame: %v", err)
+	}
+}
@@ -0,0 +1,326 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/eaburns/bit"
+)
+
+// MinSyncChunk is the smallest number of bytes read at a time
+// when searching for a frame during a binary search.
+const minSyncChunk = 1 << 16
+
+// Seek positions the Decoder so that the audio returned by the next call to
+// Next or NextSamples begins with the given inter-channel sample of the current
+// stream.  The offset is interpreted according to whence: 0 means relative to
+// the start of the stream, 1 means relative to the current position, and 2 means
+// relative to the end, which requires the total number of samples in STREAMINFO.
+// Seek returns the new position.
+//
+// If the reader given to NewDecoder is an io.Seeker, Seek jumps to the seek point
+// of the SEEKTABLE nearest before the sample, or to the first frame if there is
+// none, then decodes frames up to the sample.  If there is no SEEKTABLE,
+// Seek instead finds a frame near the sample with a binary search over
+// the bytes of the stream, synchronizing to the frame headers that it lands in.
+// Otherwise Seek can only move forward, by decoding frames.
+func (d *Decoder) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case 0:
+	case 1:
+		offset += d.Pos()
+	case 2:
+		if d.TotalSamples == 0 {
+			return 0, errors.New("Cannot seek relative to the end: the total number of samples is unknown")
+		}
+		offset += d.TotalSamples
+	default:
+		return 0, errors.New("Bad whence")
+	}
+	if err := d.seekSample(offset); err != nil {
+		return 0, err
+	}
+	d.startPreview()
+	return offset, nil
+}
+
+// StartPreview begins the audio of the Preview option at the current position.
+func (d *Decoder) startPreview() {
+	if d.opts.Preview > 0 {
+		d.previewEnd = d.Pos() + timeSample(d.opts.Preview, d.SampleRate)
+	}
+}
+
+// SeekTime is like Seek, relative to the start of the stream, but to the sample
+// at time t, which is the nearest sample, rounding halfway cases up.
+// The same sample is found for any t from halfway after the previous sample
+// up to, but not including, halfway before the next.
+// SeekTime returns the number of the sample.
+func (d *Decoder) SeekTime(t time.Duration) (int64, error) {
+	if t < 0 {
+		return 0, errors.New("Seek to a negative time")
+	}
+	return d.Seek(timeSample(t, d.SampleRate), 0)
+}
+
+// DecodeRange returns the samples [start, end) of each channel of the current
+// stream, or the samples from start to the end of the stream if it ends first.
+// It seeks to start, as with Seek, and decodes up to end, leaving the rest
+// of the last frame to be returned by the next call to Next or NextSamples,
+// so that the Decoder's position is then end.
+func (d *Decoder) DecodeRange(start, end int64) ([][]int32, error) {
+	if start < 0 || end < start {
+		return nil, errors.New("Bad range [" + strconv.FormatInt(start, 10) + ", " + strconv.FormatInt(end, 10) + ")")
+	}
+	data := make([][]int32, d.NChannels)
+	if start == end {
+		return data, nil
+	}
+	if _, err := d.Seek(start, 0); err != nil {
+		return nil, err
+	}
+	for pos := start; pos < end; {
+		frame, err := d.decodeFrame()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		n := len(frame[0])
+		if int64(n) > end-pos {
+			n = int(end - pos)
+			d.pending = make([][]int32, len(frame))
+			for ch := range frame {
+				d.pending[ch] = frame[ch][n:]
+			}
+		}
+		for ch := range data {
+			data[ch] = append(data[ch], frame[ch][:n]...)
+		}
+		pos += int64(n)
+	}
+	if d.opts.ChannelOrder != nil {
+		data = reorderChannels(data, d.opts.ChannelOrder)
+	}
+	if d.opts.LeftJustify {
+		leftJustify(data, d.Shift())
+	}
+	return data, nil
+}
+
+// TrackBounds returns the first sample of the track with the given number
+// in the stream's cue sheet, at its index 1, and the sample after its end,
+// which is the start of the next track, or the end of the audio.
+// The cue sheet is that of the CUESHEET block or, if there is none,
+// of the CUESHEET Vorbis comment.
+func (d *Decoder) TrackBounds(n int) (int64, int64, error) {
+	starts, err := d.trackStarts()
+	if err != nil {
+		return 0, 0, err
+	}
+	for i, t := range starts[:len(starts)-1] {
+		if t.number == n {
+			return t.start, starts[i+1].start, nil
+		}
+	}
+	return 0, 0, errors.New("No track " + strconv.Itoa(n) + " in the cue sheet")
+}
+
+// SeekTrack seeks to the start of the track with the given number
+// in the stream's cue sheet, as given by TrackBounds.
+func (d *Decoder) SeekTrack(n int) error {
+	start, _, err := d.TrackBounds(n)
+	if err != nil {
+		return err
+	}
+	_, err = d.Seek(start, 0)
+	return err
+}
+
+// TimeSample returns the number of the sample nearest to time t
+// at the given sample rate, rounding halfway cases up.
+// It does not overflow for any non-negative t.
+func timeSample(t time.Duration, rate int) int64 {
+	s, ns := int64(t/time.Second), int64(t%time.Second)
+	return s*int64(rate) + (ns*int64(rate)+int64(time.Second/2))/int64(time.Second)
+}
+
+// SeekSample positions the Decoder so that the audio returned by the next
+// call to Next begins with the inter-channel sample n.
+// If the reader can seek and there is a seek point between the current position
+// and n, or if n is before the current position, the Decoder first jumps
+// to the nearest seek point before n, or to the first frame.
+// Without a SEEKTABLE, it jumps to a frame found by bisect.
+// Then it skips to n, decoding only the frame containing n.
+func (d *Decoder) seekSample(n int64) error {
+	if n < 0 {
+		return errors.New("Seek to a negative sample")
+	}
+	pos := d.Pos()
+	switch {
+	case d.seeker == nil:
+		if n < pos {
+			return errors.New("Cannot seek backward: the reader cannot seek")
+		}
+	case n >= pos && n < d.samples:
+		// N is in the pending samples.
+	case len(d.SeekTable) == 0 && !d.opts.Concatenated:
+		p, err := d.bisect(n)
+		if err != nil {
+			return err
+		}
+		if err := d.seekFrame(p); err != nil {
+			return err
+		}
+	default:
+		if p := d.seekPoint(n); n < pos || p.Sample > pos {
+			if err := d.seekFrame(p); err != nil {
+				return err
+			}
+		}
+	}
+	if err := d.skipTo(n); err != nil {
+		return err
+	}
+	if d.pending == nil {
+		if _, err := d.PeekFrameHeader(); err == io.EOF {
+			return errors.New("Seek past the end of the stream")
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SeekPoint returns the seek point of the SEEKTABLE with the greatest sample
+// number not after n, ignoring placeholders, or the zero SeekPoint,
+// which is the first frame, if there is none.
+func (d *Decoder) seekPoint(n int64) SeekPoint {
+	var best SeekPoint
+	for _, p := range d.SeekTable {
+		if p.Sample != PlaceholderPoint && p.Sample <= n && p.Sample > best.Sample {
+			best = p
+		}
+	}
+	return best
+}
+
+// Bisect returns the position of a frame beginning at or before sample n,
+// found by a binary search over the bytes of the stream.  The search starts
+// from the next frame, if it is not after n, otherwise from the first frame,
+// and it stops once the range is small enough to decode through.
+// Bisect moves the reader, so the Decoder must then seek to a frame.
+func (d *Decoder) bisect(n int64) (SeekPoint, error) {
+	if d.deferred && !d.skipped {
+		if err := d.skipMetaData(); err != nil {
+			return SeekPoint{}, err
+		}
+	}
+	var lo SeekPoint
+	if d.samples <= n {
+		lo = SeekPoint{Sample: d.samples, Offset: d.r.n - d.framesOffset}
+	}
+	size, err := d.seeker.Seek(0, 2)
+	if err != nil {
+		return SeekPoint{}, err
+	}
+	hi := size - d.base - d.framesOffset
+	chunk := int64(2 * d.MaxFrame)
+	if chunk < minSyncChunk {
+		chunk = minSyncChunk
+	}
+	for hi-lo.Offset > chunk {
+		mid := lo.Offset + (hi-lo.Offset)/2
+		p, ok, err := d.syncFrame(mid, chunk)
+		if err != nil {
+			return SeekPoint{}, err
+		}
+		if !ok || p.Sample > n {
+			hi = mid
+			continue
+		}
+		lo = p
+		if n < p.Sample+int64(p.Samples) {
+			break
+		}
+	}
+	return lo, nil
+}
+
+// SyncFrame returns the position of the first frame beginning in the chunk
+// of the stream at offset, and whether there is one.
+func (d *Decoder) syncFrame(offset, chunk int64) (SeekPoint, bool, error) {
+	if _, err := d.seeker.Seek(d.base+d.framesOffset+offset, 0); err != nil {
+		return SeekPoint{}, false, err
+	}
+	buf := make([]byte, chunk)
+	m, err := io.ReadFull(d.src, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return SeekPoint{}, false, err
+	}
+	buf = buf[:m]
+	for i := 0; i+1 < len(buf); i++ {
+		if buf[i] != 0xFF || buf[i+1]&0xFE != 0xF8 {
+			continue
+		}
+		if p, ok := d.checkFrame(buf[i:]); ok {
+			p.Offset = offset + int64(i)
+			return p, true, nil
+		}
+	}
+	return SeekPoint{}, false, nil
+}
+
+// CheckFrame returns the sample number and block size of the frame
+// at the start of b, and whether b begins with a frame of this stream:
+// its header must be valid and match the STREAMINFO, and if b holds
+// the whole frame, its subframes must decode and its CRC-16 must match.
+func (d *Decoder) checkFrame(b []byte) (SeekPoint, bool) {
+	r := bytes.NewReader(b)
+	h, err := readFrameHeader(r, d.StreamInfo)
+	if err != nil || h.sampleRate != d.SampleRate || h.sampleSize != d.BitsPerSample ||
+		h.channelAssignment.nChannels() != d.NChannels {
+		return SeekPoint{}, false
+	}
+	br := bit.NewReader(r)
+	complete := true
+	for ch := 0; ch < d.NChannels && complete; ch++ {
+		switch _, err := readSubFrame(br, h, ch); {
+		case err == io.EOF || err == io.ErrUnexpectedEOF:
+			complete = false
+		case err != nil:
+			return SeekPoint{}, false
+		}
+	}
+	// The bit.Reader buffers up to the next byte boundary,
+	// so the CRC-16 follows the bytes read from r.
+	if n := len(b) - r.Len() + 2; complete && n <= len(b) && verifyCRC16(b[:n]) != nil {
+		return SeekPoint{}, false
+	}
+	p := SeekPoint{Sample: int64(h.number), Samples: h.blockSize}
+	if !h.variableSize {
+		blockSize := d.MinBlock
+		if blockSize == 0 {
+			blockSize = h.blockSize
+		}
+		p.Sample *= int64(blockSize)
+	}
+	if d.TotalSamples > 0 && p.Sample >= d.TotalSamples {
+		return SeekPoint{}, false
+	}
+	return p, true
+}
+
+// PendingSamples returns the number of samples remaining in a partially
+// consumed frame.
+func (d *Decoder) pendingSamples() int64 {
+	if d.pending == nil {
+		return 0
+	}
+	return int64(len(d.pending[0]))
+}
@@ -0,0 +1,325 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A countingReadSeeker counts the bytes read from an io.ReadSeeker.
+type countingReadSeeker struct {
+	io.ReadSeeker
+	n int64
+}
+
+func (r *countingReadSeeker) Read(p []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func TestDecoderSeek(t *testing.T) {
+	const n = 100000
+	data := testSignal(2, n, 16)
+	plain := encodeFile(t, data, EncoderOptions{BlockSize: 4096})
+	withTable := encodeFile(t, data, EncoderOptions{BlockSize: 4096, SeekInterval: 10000})
+
+	tests := []struct {
+		offset int64
+		whence int
+		want   int64
+	}{
+		{90000, 0, 90000},
+		{0, 0, 0},
+		{50000, 0, 50000},
+		{-100, 1, 50000 - 100 + 10},
+		{-17, 2, n - 17},
+		{4096, 0, 4096},
+		{4096 * 3, 1, 4096*4 + 10},
+	}
+	for _, stream := range [][]byte{plain, withTable} {
+		r := &countingReadSeeker{ReadSeeker: bytes.NewReader(stream)}
+		d, err := NewDecoder(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, test := range tests {
+			pos, err := d.Seek(test.offset, test.whence)
+			if err != nil {
+				t.Errorf("Seek(%d, %d): %v", test.offset, test.whence, err)
+				continue
+			}
+			if pos != test.want {
+				t.Errorf("Seek(%d, %d)=%d, want %d", test.offset, test.whence, pos, test.want)
+			}
+			// Read 10 samples to move the position.
+			var got [][]int32
+			for m := 0; m < 10; {
+				samples, err := d.NextSamples()
+				if err != nil {
+					t.Fatalf("Seek(%d, %d): NextSamples: %v", test.offset, test.whence, err)
+				}
+				if got == nil {
+					got = make([][]int32, len(samples))
+				}
+				for ch := range samples {
+					got[ch] = append(got[ch], samples[ch]...)
+				}
+				m += len(samples[0])
+			}
+			for ch := range got {
+				want := data[ch][pos:]
+				if len(want) > len(got[ch]) {
+					want = want[:len(got[ch])]
+				}
+				if !reflect.DeepEqual(got[ch][:len(want)], want) {
+					t.Errorf("Seek(%d, %d): channel %d differs", test.offset, test.whence, ch)
+				}
+			}
+			if _, err := d.Seek(pos+10, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// With a SEEKTABLE, seeking near the end reads only the end of the stream.
+	r := &countingReadSeeker{ReadSeeker: bytes.NewReader(withTable)}
+	d, err := NewDecoder(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := r.n
+	if _, err := d.Seek(95000, 0); err != nil {
+		t.Fatal(err)
+	}
+	if read := r.n - before; read > int64(len(withTable))/5 {
+		t.Errorf("Seek read %d of %d bytes", read, len(withTable))
+	}
+
+	// Without seeking, Seek can only move forward.
+	d, err = NewDecoder(bytes.NewBuffer(plain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Seek(5000, 0); err != nil {
+		t.Errorf("Seek forward: %v", err)
+	}
+	if _, err := d.Seek(0, 0); err == nil {
+		t.Errorf("Seek backward: got no error")
+	}
+	if _, err := d.Seek(n, 0); err == nil {
+		t.Errorf("Seek past the end: got no error")
+	}
+}
+
+func TestTimeSample(t *testing.T) {
+	tests := []struct {
+		t    time.Duration
+		rate int
+		want int64
+	}{
+		{0, 44100, 0},
+		{time.Second, 44100, 44100},
+		{time.Second / 2, 44100, 22050},
+		// A sample is 1/8000s = 125µs.
+		{62499 * time.Nanosecond, 8000, 0},
+		{62500 * time.Nanosecond, 8000, 1},
+		{187499 * time.Nanosecond, 8000, 1},
+		{187500 * time.Nanosecond, 8000, 2},
+		{10 * time.Hour, 192000, 10 * 3600 * 192000},
+		{math.MaxInt64, 655350, 9223372036*655350 + (854775807*655350+500000000)/1000000000},
+	}
+	for _, test := range tests {
+		if got := timeSample(test.t, test.rate); got != test.want {
+			t.Errorf("timeSample(%v, %d)=%d, want %d", test.t, test.rate, got, test.want)
+		}
+	}
+
+	data := testSignal(1, 50000, 16)
+	d, err := NewDecoder(bytes.NewReader(encodeFile(t, data, EncoderOptions{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := d.SeekTime(time.Second / 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 22050 {
+		t.Errorf("SeekTime(0.5s)=%d, want 22050", n)
+	}
+	samples, err := d.NextSamples()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if samples[0][0] != data[0][22050] {
+		t.Errorf("SeekTime(0.5s): got sample %d, want %d", samples[0][0], data[0][22050])
+	}
+	if _, err := d.SeekTime(-time.Second); err == nil {
+		t.Errorf("SeekTime(-1s): got no error")
+	}
+}
+
+func TestDecoderBisect(t *testing.T) {
+	const n = 500000
+	data := testSignal(2, n, 16)
+	for _, opts := range []EncoderOptions{
+		{BlockSize: 4096},
+		{BlockSize: 1152, VariableBlockSize: true},
+	} {
+		stream := encodeFile(t, data, opts)
+		r := &countingReadSeeker{ReadSeeker: bytes.NewReader(stream)}
+		d, err := NewDecoder(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, sample := range []int64{n - 1, 250000, 1, 400000, 123456, 0} {
+			before := r.n
+			if _, err := d.Seek(sample, 0); err != nil {
+				t.Fatalf("%+v: Seek(%d): %v", opts, sample, err)
+			}
+			if read := r.n - before; read > int64(len(stream))/2 {
+				t.Errorf("%+v: Seek(%d) read %d of %d bytes", opts, sample, read, len(stream))
+			}
+			samples, err := d.NextSamples()
+			if err != nil {
+				t.Fatalf("%+v: Seek(%d): NextSamples: %v", opts, sample, err)
+			}
+			for ch := range samples {
+				if !reflect.DeepEqual(samples[ch], data[ch][sample:sample+int64(len(samples[ch]))]) {
+					t.Errorf("%+v: Seek(%d): channel %d differs", opts, sample, ch)
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeRange(t *testing.T) {
+	const n = 50000
+	data := testSignal(2, n, 16)
+	stream := encodeFile(t, data, EncoderOptions{BlockSize: 4096})
+	d, err := NewDecoderOpts(bytes.NewReader(stream), Options{ChannelOrder: []int{1, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		start, end int64
+	}{
+		{1000, 2000},
+		{0, 4096},
+		{4095, 4097},
+		{30000, 30000},
+		{10, 45000},
+		{n - 10, n + 10},
+	} {
+		got, err := d.DecodeRange(test.start, test.end)
+		if err != nil {
+			t.Errorf("DecodeRange(%d, %d): %v", test.start, test.end, err)
+			continue
+		}
+		end := test.end
+		if end > n {
+			end = n
+		}
+		for ch := range got {
+			want := data[1-ch][test.start:end]
+			if len(got[ch]) != len(want) || len(want) > 0 && !reflect.DeepEqual(got[ch], want) {
+				t.Errorf("DecodeRange(%d, %d): channel %d differs", test.start, test.end, ch)
+			}
+		}
+		if end < n && test.start < test.end {
+			if pos := d.Pos(); pos != end {
+				t.Errorf("DecodeRange(%d, %d): got Pos()=%d", test.start, test.end, pos)
+			}
+			samples, err := d.NextSamples()
+			if err != nil || samples[0][0] != data[1][end] {
+				t.Errorf("DecodeRange(%d, %d): the next sample is not %d", test.start, test.end, end)
+			}
+		}
+	}
+	if _, err := d.DecodeRange(10, 5); err == nil {
+		t.Errorf("DecodeRange(10, 5): got no error")
+	}
+}
+
+func TestSeekTrack(t *testing.T) {
+	const cue = `FILE "album.flac" WAVE
+  TRACK 01 AUDIO
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    INDEX 00 00:00:30
+    INDEX 01 00:00:40
+  TRACK 03 AUDIO
+    INDEX 01 00:01:00
+`
+	c, err := ParseCueSheet(strings.NewReader(cue))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 100000
+	data := testSignal(1, n, 16)
+	info := &StreamInfo{SampleRate: 44100, NChannels: 1, BitsPerSample: 16, TotalSamples: n}
+	var block, comment bytes.Buffer
+	e, err := NewEncoderOpts(&block, MetaData{StreamInfo: info}, EncoderOptions{CueSheet: c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	meta := MetaData{StreamInfo: info, VorbisComment: &VorbisComment{Comments: []string{"CUESHEET=" + cue}}}
+	if e, err = NewEncoderOpts(&comment, meta, EncoderOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []TrackRange{{0, 40 * 588}, {40 * 588, 75 * 588}, {75 * 588, n}}
+	for name, stream := range map[string][]byte{"block": block.Bytes(), "comment": comment.Bytes()} {
+		d, err := NewDecoder(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, w := range want {
+			start, end, err := d.TrackBounds(i + 1)
+			if err != nil || start != w.Start || end != w.End {
+				t.Errorf("%s: TrackBounds(%d)=%d, %d, %v, want %d, %d, nil", name, i+1, start, end, err, w.Start, w.End)
+			}
+		}
+		for _, i := range []int{3, 1, 2} {
+			if err := d.SeekTrack(i); err != nil {
+				t.Fatalf("%s: SeekTrack(%d): %v", name, i, err)
+			}
+			samples, err := d.NextSamples()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := want[i-1].Start; samples[0][0] != data[0][s] {
+				t.Errorf("%s: SeekTrack(%d): got sample %d, want %d", name, i, samples[0][0], data[0][s])
+			}
+		}
+		if _, _, err := d.TrackBounds(4); err == nil {
+			t.Errorf("%s: TrackBounds(4): got no error", name)
+		}
+	}
+
+	d, err := NewDecoder(bytes.NewReader(encodeFile(t, data, EncoderOptions{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SeekTrack(1); err == nil {
+		t.Errorf("No cue sheet: got no error")
+	}
+}
@@ -0,0 +1,58 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// A SeekPoint is an entry in a SEEKTABLE block, giving the position of a frame.
+type SeekPoint struct {
+	// Sample is the number of the first sample in the frame,
+	// or PlaceholderPoint for a placeholder point, which is reserved space
+	// for a seek point to be filled in later.
+	Sample int64
+	// Offset is the byte offset of the frame from the first frame.
+	Offset int64
+	// Samples is the number of samples in the frame.
+	Samples int
+}
+
+// PlaceholderPoint is the Sample number of placeholder seek points.
+const PlaceholderPoint = -1
+
+const seekPointSize = 18 // bytes
+
+func readSeekTable(r io.Reader) ([]SeekPoint, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%seekPointSize != 0 {
+		return nil, errors.New("Bad SEEKTABLE size")
+	}
+	points := make([]SeekPoint, len(data)/seekPointSize)
+	for i := range points {
+		b := data[i*seekPointSize:]
+		points[i] = SeekPoint{
+			Sample:  int64(binary.BigEndian.Uint64(b)),
+			Offset:  int64(binary.BigEndian.Uint64(b[8:])),
+			Samples: int(binary.BigEndian.Uint16(b[16:])),
+		}
+	}
+	return points, nil
+}
+
+func encodeSeekTable(points []SeekPoint) []byte {
+	data := make([]byte, len(points)*seekPointSize)
+	for i, p := range points {
+		b := data[i*seekPointSize:]
+		binary.BigEndian.PutUint64(b, uint64(p.Sample))
+		binary.BigEndian.PutUint64(b[8:], uint64(p.Offset))
+		binary.BigEndian.PutUint16(b[16:], uint16(p.Samples))
+	}
+	return data
+}
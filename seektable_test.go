@@ -0,0 +1,65 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestReadSeekTable(t *testing.T) {
+	streamInfo := []byte{
+		0, 0, 34, // STREAMINFO, 34 bytes.
+		0x10, 0, 0x10, 0, // min and max block size 4096
+		0, 0, 0, // min frame size
+		0, 0, 0, // max frame size
+		0x0A, 0xC4, 0x42, 0xF0, 0, 0, 0x27, 0x10, // 44.1 kHz, 2 channels, 16 bits/sample, 10000 samples
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // MD5
+	}
+	points := []byte{
+		// Sample 0 · offset 0 · 4096 samples
+		0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0,
+		0x10, 0,
+
+		// Sample 8192 · offset 0x1234 · 1808 samples
+		0, 0, 0, 0, 0, 0, 0x20, 0,
+		0, 0, 0, 0, 0, 0, 0x12, 0x34,
+		0x07, 0x10,
+
+		// Placeholder
+		0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+		0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0,
+	}
+	stream := func(table []byte) []byte {
+		b := append([]byte{'f', 'L', 'a', 'C', byte(BlockStreamInfo)}, streamInfo...)
+		n := len(table)
+		return append(append(b, 0x80|byte(BlockSeekTable), byte(n>>16), byte(n>>8), byte(n)), table...)
+	}
+
+	d, err := NewDecoder(bytes.NewReader(stream(points)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SeekPoint{
+		{Sample: 0, Offset: 0, Samples: 4096},
+		{Sample: 8192, Offset: 0x1234, Samples: 1808},
+		{Sample: PlaceholderPoint},
+	}
+	if !reflect.DeepEqual(d.SeekTable, want) {
+		t.Errorf("got seek points %+v, want %+v", d.SeekTable, want)
+	}
+
+	d, err = NewDecoder(bytes.NewReader(stream(nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.SeekTable == nil || len(d.SeekTable) != 0 {
+		t.Errorf("got seek points %+v for an empty SEEKTABLE, want none", d.SeekTable)
+	}
+	if _, err := NewDecoder(bytes.NewReader(stream(points[:20]))); err == nil || err.Error() != "Bad SEEKTABLE size" {
+		t.Errorf("got error %v for a truncated seek point, want Bad SEEKTABLE size", err)
+	}
+}
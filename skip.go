@@ -0,0 +1,148 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// FrameChunk is the number of bytes examined at a time by skipFrame.
+const frameChunk = 4096
+
+// Skip advances the Decoder by n inter-channel samples, as if they had been
+// returned by Next, without decoding the frames that are skipped entirely.
+// Instead, the end of each such frame is found by computing its CRC-16
+// until it matches and the next frame header follows, which is much cheaper
+// than decoding, and works on readers that cannot seek.
+// Only the frame holding the new position, if it is within a frame, is decoded.
+func (d *Decoder) Skip(n int64) error {
+	if n < 0 {
+		return errors.New("Skip a negative number of samples")
+	}
+	return d.skipTo(d.Pos() + n)
+}
+
+// SkipTo advances the Decoder to sample n of the current stream,
+// which must not be before Pos.
+func (d *Decoder) skipTo(n int64) error {
+	if p := d.pendingSamples(); p > 0 {
+		if n < d.samples {
+			start := d.samples - p
+			for ch := range d.pending {
+				d.pending[ch] = d.pending[ch][n-start:]
+			}
+			return nil
+		}
+		d.pending = nil
+	}
+	for d.samples < n {
+		h, err := d.PeekFrameHeader()
+		if err == io.EOF {
+			return errors.New("Skip past the end of the stream")
+		} else if err != nil {
+			return err
+		}
+		if d.samples+int64(h.BlockSize) <= n {
+			if err := d.skipFrame(); err != nil {
+				return err
+			}
+			continue
+		}
+		start := d.samples
+		data, err := d.decodeFrame()
+		if err != nil {
+			return err
+		}
+		for ch := range data {
+			data[ch] = data[ch][n-start:]
+		}
+		d.pending = data
+		return nil
+	}
+	return nil
+}
+
+// SkipFrame consumes the next frame without decoding its subframes.
+// The frame ends where its CRC-16 matches and it is followed by the end
+// of the stream or by the header of the frame after it.
+func (d *Decoder) skipFrame() error {
+	if err := d.beginFrame(); err != nil {
+		return err
+	}
+	defer func() { d.n++ }()
+
+	start := d.r.n
+	d.frameStart = start
+	raw := bytes.NewBuffer(nil)
+	h, err := readFrameHeader(io.TeeReader(d.r, raw), d.StreamInfo)
+	if err != nil {
+		return d.frameError("Failed to read the frame header: ", err)
+	}
+	next := h.number + 1
+	if h.variableSize {
+		next = h.number + uint64(h.blockSize)
+	}
+	crc := updateCRC16(0, raw.Bytes())
+	for {
+		buf, err := d.r.peek(frameChunk)
+		if err == io.EOF {
+			if crc != 0 {
+				return d.frameError("", io.ErrUnexpectedEOF)
+			}
+			break
+		} else if err != nil {
+			return err
+		}
+		n := len(buf)
+		for i, b := range buf {
+			if crc = crc<<8 ^ crc16Table[uint8(crc>>8)^b]; crc == 0 {
+				n = i + 1
+				break
+			}
+		}
+		if err := d.r.skip(int64(n)); err != nil {
+			return err
+		}
+		if crc == 0 && d.atFrameEnd(h.variableSize, next) {
+			break
+		}
+	}
+	d.samples += int64(h.blockSize)
+	d.observeFrame(h.blockSize, int(d.r.n-start))
+	return nil
+}
+
+// AtFrameEnd returns whether the next bytes are the end of the stream,
+// the header of a frame with the given blocking strategy and number,
+// or, with the Concatenated option, the start of the next stream.
+func (d *Decoder) atFrameEnd(variable bool, number uint64) bool {
+	b, err := d.r.peek(maxFrameHeaderSize)
+	switch {
+	case err == io.EOF:
+		return true
+	case err != nil:
+		return false
+	case d.opts.Concatenated && bytes.HasPrefix(b, magic[:]):
+		return true
+	}
+	h, err := readFrameHeader(bytes.NewReader(b), d.StreamInfo)
+	return err == nil && h.variableSize == variable && h.number == number &&
+		h.sampleRate == d.SampleRate && h.sampleSize == d.BitsPerSample &&
+		h.channelAssignment.nChannels() == d.NChannels
+}
+
+// SkipStride skips frames until the number of frames read
+// is a multiple of the FrameStride option.
+func (d *Decoder) skipStride() error {
+	for d.n%d.opts.FrameStride != 0 {
+		if _, err := d.PeekFrameHeader(); err != nil {
+			return err
+		}
+		if err := d.skipFrame(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
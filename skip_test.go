@@ -0,0 +1,64 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestSkip(t *testing.T) {
+	const n = 100000
+	data := testSignal(2, n, 16)
+	for _, opts := range []EncoderOptions{
+		{BlockSize: 4096},
+		{Level: 8, VariableBlockSize: true},
+		{Level: 0, BlockSize: 192},
+	} {
+		stream := encodeFile(t, data, opts)
+		d, err := NewDecoder(bytes.NewBuffer(stream))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pos int64
+		for _, skip := range []int64{0, 10, 4096, 30000, 1, 5000} {
+			if err := d.Skip(skip); err != nil {
+				t.Fatalf("%+v: Skip(%d): %v", opts, skip, err)
+			}
+			pos += skip
+			if d.Pos() != pos {
+				t.Errorf("%+v: Skip(%d): got Pos()=%d, want %d", opts, skip, d.Pos(), pos)
+			}
+			samples, err := d.NextSamples()
+			if err != nil {
+				t.Fatalf("%+v: Skip(%d): NextSamples: %v", opts, skip, err)
+			}
+			for ch := range samples {
+				if !reflect.DeepEqual(samples[ch], data[ch][pos:pos+int64(len(samples[ch]))]) {
+					t.Errorf("%+v: Skip(%d): channel %d differs", opts, skip, ch)
+				}
+			}
+			pos += int64(len(samples[0]))
+		}
+		if err := d.Skip(n - pos); err != nil {
+			t.Errorf("%+v: Skip to the end: %v", opts, err)
+		}
+		if _, err := d.NextSamples(); err != io.EOF {
+			t.Errorf("%+v: after Skip to the end: got %v, want io.EOF", opts, err)
+		}
+		if err := d.Skip(1); err == nil {
+			t.Errorf("%+v: Skip past the end: got no error", opts)
+		}
+	}
+
+	stream := encodeFile(t, data, EncoderOptions{})
+	d, err := NewDecoder(bytes.NewBuffer(stream[:len(stream)-100]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Skip(n); err == nil {
+		t.Errorf("Skip over a truncated frame: got no error")
+	}
+}
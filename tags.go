@@ -0,0 +1,247 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Get returns the values of the comments with the given key, which is compared
+// case-insensitively.  It may be called on a nil *VorbisComment.
+func (c *VorbisComment) Get(key string) []string {
+	if c == nil {
+		return nil
+	}
+	var vs []string
+	for _, cmnt := range c.Comments {
+		i := strings.IndexByte(cmnt, '=')
+		if i >= 0 && strings.EqualFold(cmnt[:i], key) {
+			vs = append(vs, cmnt[i+1:])
+		}
+	}
+	return vs
+}
+
+// first returns the first value of the first of the keys that has a comment.
+func (c *VorbisComment) first(keys ...string) (string, bool) {
+	for _, k := range keys {
+		if vs := c.Get(k); len(vs) > 0 {
+			return vs[0], true
+		}
+	}
+	return "", false
+}
+
+// Title returns the first TITLE comment.
+// The boolean is false if there is none.
+func (c *VorbisComment) Title() (string, bool) {
+	return c.first("TITLE")
+}
+
+// Artist returns the first ARTIST comment.
+// The boolean is false if there is none.
+func (c *VorbisComment) Artist() (string, bool) {
+	return c.first("ARTIST")
+}
+
+// Album returns the first ALBUM comment.
+// The boolean is false if there is none.
+func (c *VorbisComment) Album() (string, bool) {
+	return c.first("ALBUM")
+}
+
+// AlbumArtist returns the first ALBUMARTIST comment, or the first
+// ALBUM ARTIST or ALBUM_ARTIST comment, as written by some taggers,
+// if there is no ALBUMARTIST.  The boolean is false if there is none.
+func (c *VorbisComment) AlbumArtist() (string, bool) {
+	return c.first("ALBUMARTIST", "ALBUM ARTIST", "ALBUM_ARTIST")
+}
+
+// Genre returns the first GENRE comment.
+// The boolean is false if there is none.
+func (c *VorbisComment) Genre() (string, bool) {
+	return c.first("GENRE")
+}
+
+// A NumberTag is a number with an optional total, such as a track number
+// written as "3/12".
+type NumberTag struct {
+	Number int
+	// Total is 0 if unknown.
+	Total int
+	// Original is the original comment value.
+	Original string
+}
+
+// ParseNumberTag parses a number tag of the form "n" or "n/total".
+// Surrounding space and leading zeros are allowed.
+func ParseNumberTag(s string) (NumberTag, error) {
+	t := NumberTag{Original: s}
+	num, total := s, ""
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		num, total = s[:i], s[i+1:]
+	}
+	var err error
+	if t.Number, err = strconv.Atoi(strings.TrimSpace(num)); err != nil {
+		return t, errors.New("Bad number tag: " + strconv.Quote(s))
+	}
+	if total = strings.TrimSpace(total); total != "" {
+		if t.Total, err = strconv.Atoi(total); err != nil {
+			return t, errors.New("Bad number tag total: " + strconv.Quote(s))
+		}
+	}
+	return t, nil
+}
+
+// TrackNumber returns the TRACKNUMBER comment, with the total from either
+// the "n/total" form or a TRACKTOTAL or TOTALTRACKS comment.
+// The boolean is false if there is no valid TRACKNUMBER comment.
+func (c *VorbisComment) TrackNumber() (NumberTag, bool) {
+	return c.numberTag("TRACKNUMBER", "TRACKTOTAL", "TOTALTRACKS")
+}
+
+// DiscNumber returns the DISCNUMBER comment, with the total from either
+// the "n/total" form or a DISCTOTAL or TOTALDISCS comment.
+// The boolean is false if there is no valid DISCNUMBER comment.
+func (c *VorbisComment) DiscNumber() (NumberTag, bool) {
+	return c.numberTag("DISCNUMBER", "DISCTOTAL", "TOTALDISCS")
+}
+
+func (c *VorbisComment) numberTag(key string, totalKeys ...string) (NumberTag, bool) {
+	v, ok := c.first(key)
+	if !ok {
+		return NumberTag{}, false
+	}
+	t, err := ParseNumberTag(v)
+	if err != nil {
+		return NumberTag{}, false
+	}
+	if t.Total == 0 {
+		if v, ok := c.first(totalKeys...); ok {
+			t.Total, _ = strconv.Atoi(strings.TrimSpace(v))
+		}
+	}
+	return t, true
+}
+
+// A Date is a date with optional month and day, such as a DATE comment.
+type Date struct {
+	Year int
+	// Month and Day are 0 if unknown.
+	Month int
+	Day   int
+	// Original is the original comment value.
+	Original string
+}
+
+// ParseDate parses dates of the forms YYYY, YYYY-MM, and YYYY-MM-DD.
+// The separators may also be '/' or '.', and anything following the date,
+// such as a time, is ignored.
+func ParseDate(s string) (Date, error) {
+	d := Date{Original: s}
+	bad := errors.New("Bad date: " + strconv.Quote(s))
+	f := strings.FieldsFunc(strings.TrimSpace(s), func(r rune) bool {
+		return r == '-' || r == '/' || r == '.' || r == 'T' || r == ' '
+	})
+	if len(f) == 0 || len(f[0]) != 4 {
+		return d, bad
+	}
+	var err error
+	if d.Year, err = strconv.Atoi(f[0]); err != nil {
+		return d, bad
+	}
+	if len(f) > 1 && len(f[1]) == 2 {
+		if d.Month, err = strconv.Atoi(f[1]); err != nil || d.Month < 1 || d.Month > 12 {
+			return Date{Year: d.Year, Original: s}, nil
+		}
+		if len(f) > 2 && len(f[2]) == 2 {
+			if d.Day, err = strconv.Atoi(f[2]); err != nil || d.Day < 1 || d.Day > 31 {
+				d.Day = 0
+			}
+		}
+	}
+	return d, nil
+}
+
+// Date returns the DATE comment, or the YEAR comment if there is no DATE.
+// The boolean is false if there is no valid date comment.
+func (c *VorbisComment) Date() (Date, bool) {
+	v, ok := c.first("DATE", "YEAR")
+	if !ok {
+		return Date{}, false
+	}
+	d, err := ParseDate(v)
+	return d, err == nil
+}
+
+// ReplayGain is the gain and peak of a track and its album,
+// from REPLAYGAIN_ comments.
+type ReplayGain struct {
+	// TrackGain and AlbumGain are in dB.
+	TrackGain, AlbumGain float64
+	// TrackPeak and AlbumPeak are sample amplitudes, where 1 is full scale.
+	// They are 0 if unknown.
+	TrackPeak, AlbumPeak float64
+	// HasTrack and HasAlbum are whether TrackGain and AlbumGain are known.
+	HasTrack, HasAlbum bool
+}
+
+// ParseGain parses a ReplayGain gain, such as "-6.48 dB".
+// The "dB" suffix is optional, compared case-insensitively,
+// and need not be separated from the number by a space.
+// A decimal comma, as written by some taggers, is also allowed.
+func ParseGain(s string) (float64, error) {
+	v := strings.TrimSpace(s)
+	if n := len(v) - len("dB"); n >= 0 && strings.EqualFold(v[n:], "dB") {
+		v = strings.TrimSpace(v[:n])
+	}
+	if !strings.Contains(v, ".") {
+		v = strings.Replace(v, ",", ".", 1)
+	}
+	g, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, errors.New("Bad gain: " + strconv.Quote(s))
+	}
+	return g, nil
+}
+
+// ReplayGain returns the REPLAYGAIN_TRACK_GAIN, REPLAYGAIN_TRACK_PEAK,
+// REPLAYGAIN_ALBUM_GAIN, and REPLAYGAIN_ALBUM_PEAK comments.
+// Peaks are parsed like gains, but without a suffix.  Comments that
+// do not parse are ignored.  The boolean is false if there is neither
+// a valid track gain nor a valid album gain.
+func (c *VorbisComment) ReplayGain() (ReplayGain, bool) {
+	var rg ReplayGain
+	rg.TrackGain, rg.HasTrack = c.gain("REPLAYGAIN_TRACK_GAIN")
+	rg.AlbumGain, rg.HasAlbum = c.gain("REPLAYGAIN_ALBUM_GAIN")
+	rg.TrackPeak = c.peak("REPLAYGAIN_TRACK_PEAK")
+	rg.AlbumPeak = c.peak("REPLAYGAIN_ALBUM_PEAK")
+	return rg, rg.HasTrack || rg.HasAlbum
+}
+
+func (c *VorbisComment) gain(key string) (float64, bool) {
+	v, ok := c.first(key)
+	if !ok {
+		return 0, false
+	}
+	g, err := ParseGain(v)
+	return g, err == nil
+}
+
+func (c *VorbisComment) peak(key string) float64 {
+	v, ok := c.first(key)
+	if !ok {
+		return 0
+	}
+	v = strings.TrimSpace(v)
+	if !strings.Contains(v, ".") {
+		v = strings.Replace(v, ",", ".", 1)
+	}
+	p, err := strconv.ParseFloat(v, 64)
+	if err != nil || p < 0 {
+		return 0
+	}
+	return p
+}
@@ -0,0 +1,109 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import "testing"
+
+func TestTypedTags(t *testing.T) {
+	c := &VorbisComment{Comments: []string{
+		"TrackNumber=03/12",
+		"DISCNUMBER=1",
+		"DISCTOTAL=2",
+		"DATE=2014-05-06T12:00",
+		"title=So What",
+		"ARTIST=Miles Davis",
+		"ARTIST=John Coltrane",
+		"ALBUM_ARTIST=Miles Davis",
+	}}
+	strs := []struct {
+		get  func() (string, bool)
+		want string
+		ok   bool
+	}{
+		{c.Title, "So What", true},
+		{c.Artist, "Miles Davis", true},
+		{c.AlbumArtist, "Miles Davis", true},
+		{c.Album, "", false},
+		{c.Genre, "", false},
+	}
+	for i, test := range strs {
+		if s, ok := test.get(); s != test.want || ok != test.ok {
+			t.Errorf("%d: got %q, %v, want %q, %v", i, s, ok, test.want, test.ok)
+		}
+	}
+	if n, ok := c.TrackNumber(); !ok || n.Number != 3 || n.Total != 12 || n.Original != "03/12" {
+		t.Errorf("Unexpected track number %+v, %v", n, ok)
+	}
+	if n, ok := c.DiscNumber(); !ok || n.Number != 1 || n.Total != 2 {
+		t.Errorf("Unexpected disc number %+v, %v", n, ok)
+	}
+	if d, ok := c.Date(); !ok || d.Year != 2014 || d.Month != 5 || d.Day != 6 {
+		t.Errorf("Unexpected date %+v, %v", d, ok)
+	}
+
+	dates := []struct {
+		s                string
+		year, month, day int
+		ok               bool
+	}{
+		{"1999", 1999, 0, 0, true},
+		{"1999-12", 1999, 12, 0, true},
+		{"1999.12.31", 1999, 12, 31, true},
+		{"1999-13-01", 1999, 0, 0, true},
+		{"99", 0, 0, 0, false},
+		{"", 0, 0, 0, false},
+	}
+	for _, test := range dates {
+		d, err := ParseDate(test.s)
+		if (err == nil) != test.ok || d.Year != test.year || d.Month != test.month || d.Day != test.day {
+			t.Errorf("ParseDate(%q)=%+v, %v", test.s, d, err)
+		}
+	}
+
+	var nilComment *VorbisComment
+	if _, ok := nilComment.TrackNumber(); ok {
+		t.Errorf("Expected no track number from a nil VorbisComment")
+	}
+	if _, ok := nilComment.Title(); ok {
+		t.Errorf("Expected no title from a nil VorbisComment")
+	}
+}
+
+func TestReplayGain(t *testing.T) {
+	gains := []struct {
+		s    string
+		gain float64
+		ok   bool
+	}{
+		{"-6.48 dB", -6.48, true},
+		{"+3.20 dB", 3.2, true},
+		{"-6.48dB", -6.48, true},
+		{" -6.48 db ", -6.48, true},
+		{"-6,48 dB", -6.48, true},
+		{"2", 2, true},
+		{"dB", 0, false},
+		{"loud", 0, false},
+	}
+	for _, test := range gains {
+		g, err := ParseGain(test.s)
+		if (err == nil) != test.ok || g != test.gain {
+			t.Errorf("ParseGain(%q)=%v, %v", test.s, g, err)
+		}
+	}
+
+	c := &VorbisComment{Comments: []string{
+		"REPLAYGAIN_TRACK_GAIN=-7.03 dB",
+		"replaygain_track_peak=0.988312",
+		"REPLAYGAIN_ALBUM_GAIN=bad",
+		"REPLAYGAIN_ALBUM_PEAK=1.000000",
+	}}
+	rg, ok := c.ReplayGain()
+	want := ReplayGain{TrackGain: -7.03, TrackPeak: 0.988312, AlbumPeak: 1, HasTrack: true}
+	if !ok || rg != want {
+		t.Errorf("ReplayGain()=%+v, %v, want %+v", rg, ok, want)
+	}
+	var nilComment *VorbisComment
+	if _, ok := nilComment.ReplayGain(); ok {
+		t.Errorf("Expected no ReplayGain from a nil VorbisComment")
+	}
+}
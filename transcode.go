@@ -0,0 +1,200 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"errors"
+	"io"
+	"math"
+	"math/cmplx"
+)
+
+// A TranscodeDetector looks for signs that lossless audio was decoded from a
+// lossy source, such as an MP3 or AAC file.  Lossy encoders discard the high
+// frequencies, leaving a sharp cut-off, typically near 16 kHz or 19-20 kHz,
+// above which there is little or no energy.
+//
+// The detector is heuristic.  Audio that genuinely lacks high frequencies,
+// such as old recordings, may look transcoded, and a lossy file encoded at a
+// high bitrate may not.
+type TranscodeDetector struct {
+	sampleRate int
+	// Buf holds the mixed samples of the current window.
+	buf []float64
+	// Power is the sum of the power spectra of each window.
+	power  []float64
+	window []float64
+	fft    []complex128
+	n      int
+}
+
+// A TranscodeReport is the result of transcode detection.
+type TranscodeReport struct {
+	// Cutoff is the frequency in Hz above which the spectrum drops the most.
+	Cutoff float64
+	// Drop is the difference in dB between the average level of the 1kHz band
+	// below the cutoff and that of the 1kHz band above it.
+	Drop float64
+	// Shelf is true if the drop happens abruptly, within a few hundred Hz,
+	// as is typical of a lossy encoder's low-pass filter.
+	Shelf bool
+	// Confidence is between 0 and 1; the higher it is, the more likely
+	// the audio came from a lossy source.
+	Confidence float64
+}
+
+const (
+	transcodeWindow = 4096
+	// TranscodeMinCutoff is the lowest frequency considered as a cut-off.
+	transcodeMinCutoff = 10000
+)
+
+// NewTranscodeDetector returns a TranscodeDetector for audio with the given sample rate.
+func NewTranscodeDetector(sampleRate int) *TranscodeDetector {
+	t := &TranscodeDetector{
+		sampleRate: sampleRate,
+		power:      make([]float64, transcodeWindow/2),
+		window:     make([]float64, transcodeWindow),
+		fft:        make([]complex128, transcodeWindow),
+	}
+	for i := range t.window {
+		t.window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(transcodeWindow-1))
+	}
+	return t
+}
+
+// Write adds audio to the analysis.  The samples are given per channel,
+// as returned by Decoder.NextSamples, with the given number of bits per sample.
+// The channels are mixed together.
+func (t *TranscodeDetector) Write(samples [][]int32, bitsPerSample int) {
+	scale := 1 / float64(int64(1)<<uint(bitsPerSample-1)) / float64(len(samples))
+	for i := range samples[0] {
+		var x float64
+		for ch := range samples {
+			x += float64(samples[ch][i])
+		}
+		t.buf = append(t.buf, x*scale)
+		if len(t.buf) == transcodeWindow {
+			t.analyze()
+			t.buf = t.buf[:0]
+		}
+	}
+}
+
+func (t *TranscodeDetector) analyze() {
+	for i, x := range t.buf {
+		t.fft[i] = complex(x*t.window[i], 0)
+	}
+	fft(t.fft)
+	for i := range t.power {
+		a := cmplx.Abs(t.fft[i])
+		t.power[i] += a * a
+	}
+	t.n++
+}
+
+// Report returns the result of the analysis of the audio written so far.
+// Partial windows at the end of the audio are not analyzed.
+func (t *TranscodeDetector) Report() TranscodeReport {
+	var r TranscodeReport
+	if t.n == 0 {
+		return r
+	}
+	binHz := float64(t.sampleRate) / transcodeWindow
+	nyquist := float64(t.sampleRate) / 2
+	bin := func(hz float64) int { return int(hz / binHz) }
+	for f := bin(transcodeMinCutoff); f < bin(nyquist-1000); f++ {
+		d := t.bandLevel(f-bin(1000), f) - t.bandLevel(f, f+bin(1000))
+		if d > r.Drop {
+			r.Drop = d
+			r.Cutoff = float64(f) * binHz
+		}
+	}
+	if r.Drop == 0 {
+		return r
+	}
+	// The drop is greatest a little above the edge, where the band above
+	// no longer has any leakage from it, so find the edge itself: the highest
+	// bin with a level halfway between those of the bands.
+	f := bin(r.Cutoff)
+	mid := (t.bandLevel(f-bin(1000), f) + t.bandLevel(f, f+bin(1000))) / 2
+	for i := f + bin(1000) - 2; i > f-bin(1000); i-- {
+		if t.bandLevel(i-1, i+2) > mid {
+			f = i + 1
+			break
+		}
+	}
+	r.Cutoff = float64(f) * binHz
+	r.Shelf = t.bandLevel(f-bin(300), f)-t.bandLevel(f, f+bin(300)) >= 15
+
+	// Genuine recordings are low-pass filtered near the Nyquist frequency
+	// before sampling, so cut-offs close to it are not suspicious.
+	c := (r.Drop - 15) / 25
+	c *= (0.95*nyquist - r.Cutoff) / 2000
+	if !r.Shelf {
+		c /= 2
+	}
+	r.Confidence = math.Max(0, math.Min(1, c))
+	return r
+}
+
+// BandLevel returns the average level in dB of the bins [lo, hi).
+func (t *TranscodeDetector) bandLevel(lo, hi int) float64 {
+	if hi > len(t.power) {
+		hi = len(t.power)
+	}
+	var sum float64
+	for _, p := range t.power[lo:hi] {
+		sum += p
+	}
+	// Silence is clamped at -300 dB, well below the noise floor of any sample size.
+	return 10 * math.Log10(sum/float64(hi-lo)/float64(t.n)+1e-30)
+}
+
+// DetectTranscode decodes FLAC audio from the reader and returns its TranscodeReport.
+func DetectTranscode(r io.Reader) (TranscodeReport, error) {
+	d, err := NewDecoder(r)
+	if err != nil {
+		return TranscodeReport{}, err
+	}
+	t := NewTranscodeDetector(d.SampleRate)
+	for {
+		data, err := d.NextSamples()
+		if err == io.EOF {
+			return t.Report(), nil
+		} else if err != nil {
+			return TranscodeReport{}, err
+		}
+		if len(data) != d.NChannels {
+			return TranscodeReport{}, errors.New("Frame channels do not match STREAMINFO")
+		}
+		t.Write(data, d.BitsPerSample)
+	}
+}
+
+// Fft computes the discrete Fourier transform of x in place.
+// The length of x must be a power of two.
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j |= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], x[start+k+size/2]*w
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+}
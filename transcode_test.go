@@ -0,0 +1,46 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTranscodeDetector(t *testing.T) {
+	tests := []struct {
+		cutoff   float64
+		detected bool
+	}{
+		{16000, true},
+		{19000, true},
+		{21950, false},
+	}
+	for _, test := range tests {
+		// Tones every 100 Hz up to the cutoff with pseudo-random phases.
+		const rate = 44100
+		samples := [][]int32{make([]int32, rate)}
+		seed := uint32(1)
+		var phases []float64
+		for f := 100.0; f <= test.cutoff; f += 100 {
+			seed = seed*1664525 + 1013904223
+			phases = append(phases, 2*math.Pi*float64(seed)/(1<<32))
+		}
+		for i := range samples[0] {
+			var x float64
+			for j, p := range phases {
+				x += math.Sin(2*math.Pi*float64(j+1)*100*float64(i)/rate + p)
+			}
+			samples[0][i] = int32(x * 50)
+		}
+		d := NewTranscodeDetector(rate)
+		d.Write(samples, 16)
+		r := d.Report()
+		if detected := r.Confidence > 0.5; detected != test.detected {
+			t.Errorf("Cutoff %.0f Hz: expected detected=%t, got %+v", test.cutoff, test.detected, r)
+		}
+		if test.detected && math.Abs(r.Cutoff-test.cutoff) > 100 {
+			t.Errorf("Expected a cutoff near %.0f Hz, got %+v", test.cutoff, r)
+		}
+	}
+}
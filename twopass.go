@@ -0,0 +1,77 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// EncodeTwoPass encodes a FLAC stream to w in two passes over the same
+// samples, calling write with an Encoder for each pass, to which it must
+// write the same samples both times.  The first pass only analyzes the
+// samples, discarding the frames, so that the second can begin with a
+// STREAMINFO giving the total samples, the MD5 checksum, and the bounds
+// of the frame sizes, and with any SEEKTABLE requested by opts filled in.
+// Unlike Close, this needs no io.WriteSeeker to update them afterward,
+// so the stream can be written to a pipe or a network connection.
+//
+// The Encoders are closed by EncodeTwoPass, not by write.
+// The samples are verified, if opts asks, and Progress is called,
+// only during the second pass.
+func EncodeTwoPass(w io.Writer, meta MetaData, opts EncoderOptions, write func(*Encoder) error) error {
+	first := opts
+	first.Verify, first.Progress = false, nil
+	a, err := newEncoder(&discardSeeker{}, meta, first, nil, nil)
+	if err != nil {
+		return err
+	}
+	if err := write(a); err != nil {
+		return err
+	}
+	if err := a.Close(); err != nil {
+		return err
+	}
+	e, err := newEncoder(w, meta, opts, nil, a)
+	if err != nil {
+		return err
+	}
+	if err := write(e); err != nil {
+		return err
+	}
+	if err := e.Close(); err != nil {
+		return err
+	}
+	if e.samples != a.samples || !bytes.Equal(e.md5.Sum(nil), a.info.MD5[:]) {
+		return errors.New("The samples of the second pass differ from those of the first")
+	}
+	return nil
+}
+
+// A discardSeeker is an io.WriteSeeker that discards what is written to it.
+type discardSeeker struct {
+	off, size int64
+}
+
+func (d *discardSeeker) Write(p []byte) (int, error) {
+	d.off += int64(len(p))
+	if d.off > d.size {
+		d.size = d.off
+	}
+	return len(p), nil
+}
+
+func (d *discardSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case 1:
+		offset += d.off
+	case 2:
+		offset += d.size
+	}
+	if offset < 0 {
+		return d.off, errors.New("Seek to a negative offset")
+	}
+	d.off = offset
+	return d.off, nil
+}
@@ -0,0 +1,62 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+// EncodeFile returns a FLAC stream of data, encoded to a temporary file
+// so that its STREAMINFO is complete.
+func TestEncodeTwoPass(t *testing.T) {
+	data := testSignal(2, 30000, 16)
+	info := &StreamInfo{SampleRate: 44100, NChannels: 2, BitsPerSample: 16, TotalSamples: 30000}
+	for _, opts := range []EncoderOptions{
+		{},
+		{Level: 8, SeekInterval: 4096},
+		{Workers: 4, Verify: true},
+		{VariableBlockSize: true, BlockSize: 1000},
+		{AdaptiveBlockSize: true},
+	} {
+		// The stream must be that written to a file by a single pass.
+		want := encodeFile(t, data, opts)
+		var buf bytes.Buffer
+		err := EncodeTwoPass(&buf, MetaData{StreamInfo: info}, opts, func(e *Encoder) error {
+			return e.Write(data)
+		})
+		if err != nil {
+			t.Errorf("%+v: EncodeTwoPass failed: %v", opts, err)
+		} else if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("%+v: two passes encoded a different stream than one", opts)
+		}
+	}
+
+	pass := 0
+	err := EncodeTwoPass(ioutil.Discard, MetaData{StreamInfo: info}, EncoderOptions{}, func(e *Encoder) error {
+		pass++
+		return e.Write(testSignal(2, 30000, 8*pass))
+	})
+	if err == nil {
+		t.Errorf("Different samples in each pass: expected an error")
+	}
+
+	// EncodePCMOpts reads a seekable reader twice for an unseekable writer.
+	pcm, err := interleave(data, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	format := PCMFormat{SampleRate: 44100, NChannels: 2, BitsPerSample: 16}
+	if err := EncodePCMOpts(&buf, bytes.NewReader(pcm), format, EncoderOptions{Level: 5}); err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDecoder(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.TotalSamples != 30000 || d.MD5 == [16]byte{} || d.MinFrame == 0 || d.MaxFrame == 0 {
+		t.Errorf("Got STREAMINFO %+v, want it complete", *d.StreamInfo)
+	}
+}
@@ -45,6 +45,13 @@ func utf8Decode(br *bit.Reader) (uint64, error) {
 	case b0&0xFE == 0xFC:
 		left = 5
 		v = uint64(b0 & 0x1)
+
+	// 1111 1110	10xx xxxx	10xx xxxx	10xx xxxx	10xx xxxx	10xx xxxx	10xx xxxx
+	case b0 == 0xFE:
+		left = 6
+
+	default:
+		return 0, errors.New("Bad UTF-8 encoding in frame header")
 	}
 
 	for n := 0; n < left; n++ {
@@ -65,3 +72,24 @@ func utf8Decode(br *bit.Reader) (uint64, error) {
 
 	return v, nil
 }
+
+// MaxUTF8 is one more than the largest number that AppendUTF8 can code.
+const MaxUTF8 = 1 << 36
+
+// AppendUTF8 appends v to b in the extended UTF-8 coding used for
+// the frame and sample numbers of frame headers, and returns the result.
+// The coding takes from 1 to 7 bytes; v must be less than MaxUTF8.
+func AppendUTF8(b []byte, v uint64) []byte {
+	if v < 0x80 {
+		return append(b, byte(v))
+	}
+	n := uint(2)
+	for n < 7 && v >= 1<<(5*n+1) {
+		n++
+	}
+	b = append(b, byte(0xFF<<(8-n)|v>>(6*(n-1))))
+	for i := n - 1; i > 0; i-- {
+		b = append(b, byte(0x80|(v>>(6*(i-1)))&0x3F))
+	}
+	return b
+}
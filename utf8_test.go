@@ -0,0 +1,39 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/eaburns/bit"
+)
+
+func TestAppendUTF8(t *testing.T) {
+	tests := []struct {
+		val  uint64
+		data []byte
+	}{
+		{0, []byte{0x00}},
+		{0x7F, []byte{0x7F}},
+		{0x80, []byte{0xC2, 0x80}},
+		{0x7FF, []byte{0xDF, 0xBF}},
+		{0x800, []byte{0xE0, 0xA0, 0x80}},
+		{0x10000, []byte{0xF0, 0x90, 0x80, 0x80}},
+		{0x3FFFFFF, []byte{0xFB, 0xBF, 0xBF, 0xBF, 0xBF}},
+		{0x7FFFFFFF, []byte{0xFD, 0xBF, 0xBF, 0xBF, 0xBF, 0xBF}},
+		{0x80000000, []byte{0xFE, 0x82, 0x80, 0x80, 0x80, 0x80, 0x80}},
+		{MaxUTF8 - 1, []byte{0xFE, 0xBF, 0xBF, 0xBF, 0xBF, 0xBF, 0xBF}},
+	}
+	for _, test := range tests {
+		data := AppendUTF8([]byte{0xAA}, test.val)
+		if !bytes.Equal(data[1:], test.data) || data[0] != 0xAA {
+			t.Errorf("AppendUTF8(%#x) = % x, want % x", test.val, data[1:], test.data)
+			continue
+		}
+		br := bit.NewReader(bytes.NewReader(test.data))
+		if v, err := utf8Decode(br); err != nil || v != test.val {
+			t.Errorf("Decoded % x to %#x, %v, want %#x", test.data, v, err, test.val)
+		}
+	}
+}
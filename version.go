@@ -0,0 +1,53 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"sort"
+)
+
+// version is the version of the package.
+// It should be updated with each tagged release.
+const version = "0.1.0"
+
+// Version returns the version of the package, for applications to include in
+// bug reports.
+func Version() string {
+	return version
+}
+
+// A Feature is an optional capability that a build of the package may support.
+type Feature string
+
+// These are the optional features.
+const (
+	// FeatureEncoder is support for encoding FLAC streams.
+	FeatureEncoder Feature = "encoder"
+	// FeatureOggEncode is support for encoding FLAC streams in Ogg containers.
+	FeatureOggEncode Feature = "ogg-encode"
+	// Feature32Bit is support for decoding 32 bits per sample,
+	// including stereo coded with a 33-bit side channel.
+	Feature32Bit Feature = "32-bit"
+)
+
+// Features are the supported features.
+// Files implementing a feature add it in an init function, which allows
+// features to be controlled by build constraints.
+var features []Feature
+
+// Features returns the optional features supported by this build, sorted by name.
+func Features() []Feature {
+	fs := append([]Feature(nil), features...)
+	sort.Slice(fs, func(i, j int) bool { return fs[i] < fs[j] })
+	return fs
+}
+
+// HasFeature returns whether this build supports the given feature.
+func HasFeature(f Feature) bool {
+	for _, g := range features {
+		if g == f {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+// © 2014 the flac Authors under the MIT license. See AUTHORS for the list of authors.
+
+package flac
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVersion(t *testing.T) {
+	if Version() == "" {
+		t.Errorf("Version is empty")
+	}
+	fs := Features()
+	want := []Feature{FeatureEncoder, FeatureOggEncode}
+	if !reflect.DeepEqual(fs, want) {
+		t.Errorf("Features()=%v, want %v", fs, want)
+	}
+	// The result is a copy.
+	fs[0] = "changed"
+	if !reflect.DeepEqual(Features(), want) {
+		t.Errorf("Changing the result of Features changed it to %v", Features())
+	}
+	for _, f := range want {
+		if !HasFeature(f) {
+			t.Errorf("HasFeature(%q)=false, want true", f)
+		}
+	}
+	if HasFeature(Feature32Bit) {
+		t.Errorf("HasFeature(Feature32Bit)=true, but 33-bit side channels are not decoded")
+	}
+}